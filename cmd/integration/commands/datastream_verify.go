@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spf13/cobra"
+	common2 "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon/zk/datastream"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/client"
+)
+
+var cmdDataStreamVerify = &cobra.Command{
+	Use:   "datastream_verify",
+	Short: "check the entries of the data stream file against the blocks, batches and global exit roots of the db",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := common2.RootContext()
+		db := openDB(dbCfg(kv.ChainDB, chaindata), true)
+		defer db.Close()
+
+		fileName := dataStreamFile
+		if fileName == "" {
+			fileName = filepath.Join(datadirCli, "data-stream.bin")
+		}
+
+		if err := dataStreamVerify(ctx, db, fileName); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Error(err.Error())
+			}
+			return
+		}
+	},
+}
+
+func init() {
+	withDataDir(cmdDataStreamVerify)
+	withDataStreamFile(cmdDataStreamVerify)
+
+	rootCmd.AddCommand(cmdDataStreamVerify)
+}
+
+func dataStreamVerify(ctx context.Context, db kv.RoDB, fileName string) error {
+	tx, err := db.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	file, err := client.OpenStreamFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := datastream.VerifyStream(ctx, tx, file)
+	if err != nil {
+		return fmt.Errorf("verify %s: %w", fileName, err)
+	}
+
+	if report.Ok() {
+		log.Info("Data stream matches the db", "file", fileName, "entries", report.Entries, "blocks", report.Blocks, "lastBlock", report.LastBlock)
+		return nil
+	}
+
+	log.Warn("Data stream diverges from the db", "file", fileName, "divergence", report.Divergence.String(),
+		"blocks", report.Blocks, "lastBlock", report.LastBlock)
+	return nil
+}
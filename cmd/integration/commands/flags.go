@@ -40,6 +40,10 @@ var (
 
 	_forceSetHistoryV3    bool
 	workers, reconWorkers uint64
+
+	smtRepair bool
+
+	dataStreamFile string
 )
 
 func must(err error) {
@@ -132,6 +136,14 @@ func withIntegrityChecks(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&integrityFast, "integrity.fast", false, "enable fast data-integrity checks")
 }
 
+func withSmtRepair(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&smtRepair, "repair", false, "rebuild the broken subtrees and rewrite the mismatched leaves from the plain state")
+}
+
+func withDataStreamFile(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&dataStreamFile, "datastream.file", "", "path to the data stream file, default: <datadir>/data-stream.bin")
+}
+
 func withMigration(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&migration, "migration", "", "action to apply to given migration")
 }
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/spf13/cobra"
+	common2 "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/common/datadir"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	zkStages "github.com/tenderly/zkevm-erigon/zk/stages"
+)
+
+var cmdSmtIntegrity = &cobra.Command{
+	Use:   "smt_integrity",
+	Short: "check the smt hashes, its leaves against the plain state and its root against the header, '--repair' fixes what it can",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, _ := common2.RootContext()
+		db := openDB(dbCfg(kv.ChainDB, chaindata), true)
+		defer db.Close()
+
+		if err := smtIntegrity(ctx, db, smtRepair); err != nil {
+			if !errors.Is(err, context.Canceled) {
+				log.Error(err.Error())
+			}
+			return
+		}
+	},
+}
+
+func init() {
+	withDataDir(cmdSmtIntegrity)
+	withSmtRepair(cmdSmtIntegrity)
+
+	rootCmd.AddCommand(cmdSmtIntegrity)
+}
+
+func smtIntegrity(ctx context.Context, db kv.RwDB, repair bool) error {
+	tx, err := db.BeginRw(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db2.CreateEriDbBuckets(tx); err != nil {
+		return err
+	}
+
+	report, err := zkStages.CheckSmtIntegrity(ctx, "smt_integrity", tx, datadir.New(datadirCli).Tmp, repair)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range report.BrokenNodes {
+		log.Warn("Broken node", "node", b.String())
+	}
+	for _, m := range report.Mismatches {
+		log.Warn("Leaf mismatch", "leaf", m.String())
+	}
+
+	if report.Ok() {
+		log.Info("SMT is consistent", "block", report.BlockNo, "root", report.Root)
+		return nil
+	}
+
+	log.Warn("SMT is not consistent", "block", report.BlockNo, "root", report.Root, "expected", report.ExpectedRoot,
+		"brokenNodes", len(report.BrokenNodes), "mismatches", len(report.Mismatches))
+
+	if !repair {
+		return nil
+	}
+	if report.RepairedRoot != report.ExpectedRoot {
+		return fmt.Errorf("repaired root %s doesn't match the header root %s, not saving the repair", report.RepairedRoot, report.ExpectedRoot)
+	}
+
+	log.Info("SMT repaired", "root", report.RepairedRoot)
+	return tx.Commit()
+}
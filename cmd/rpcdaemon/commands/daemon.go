@@ -9,6 +9,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/cmd/rpcdaemon/cli/httpcfg"
 	"github.com/tenderly/zkevm-erigon/consensus"
 	"github.com/tenderly/zkevm-erigon/rpc"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	"github.com/tenderly/zkevm-erigon/turbo/rpchelper"
 	"github.com/tenderly/zkevm-erigon/turbo/services"
 )
@@ -17,9 +18,10 @@ import (
 func APIList(db kv.RoDB, borDb kv.RoDB, eth rpchelper.ApiBackend, txPool txpool.TxpoolClient, mining txpool.MiningClient,
 	filters *rpchelper.Filters, stateCache kvcache.Cache,
 	blockReader services.FullBlockReader, agg *libstate.AggregatorV3, cfg httpcfg.HttpCfg, engine consensus.EngineReader,
-	l2RpcUrl string,
+	l2RpcUrl string, smtNodeCache *db2.NodeCache,
 ) (list []rpc.API) {
 	base := NewBaseApi(filters, stateCache, blockReader, agg, cfg.WithDatadir, cfg.EvmCallTimeout, engine, cfg.Dirs, l2RpcUrl)
+	base.smtNodeCache = smtNodeCache
 	ethImpl := NewEthAPI(base, db, eth, txPool, mining, cfg.Gascap, cfg.ReturnDataLimit, "")
 	erigonImpl := NewErigonAPI(base, db, eth)
 	txpoolImpl := NewTxPoolAPI(base, db, txPool)
@@ -36,6 +36,7 @@ import (
 	ethFilters "github.com/tenderly/zkevm-erigon/eth/filters"
 	"github.com/tenderly/zkevm-erigon/ethdb/prune"
 	"github.com/tenderly/zkevm-erigon/rpc"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	ethapi2 "github.com/tenderly/zkevm-erigon/turbo/adapter/ethapi"
 	"github.com/tenderly/zkevm-erigon/turbo/rpchelper"
 	"github.com/tenderly/zkevm-erigon/turbo/services"
@@ -97,7 +98,7 @@ type EthAPI interface {
 	SendTransaction(_ context.Context, txObject interface{}) (common.Hash, error)
 	Sign(ctx context.Context, _ common.Address, _ hexutility.Bytes) (hexutility.Bytes, error)
 	SignTransaction(_ context.Context, txObject interface{}) (common.Hash, error)
-	GetProof(ctx context.Context, address common.Address, storageKeys []common.Hash, blockNr rpc.BlockNumberOrHash) (*accounts.AccProofResult, error)
+	GetProof(ctx context.Context, address common.Address, storageKeys []common.Hash, blockNr rpc.BlockNumberOrHash) (*accounts.SMTAccProofResult, error)
 	CreateAccessList(ctx context.Context, args ethapi2.CallArgs, blockNrOrHash *rpc.BlockNumberOrHash, optimizeGas *bool) (*accessListResult, error)
 
 	// Mining related (see ./eth_mining.go)
@@ -126,6 +127,8 @@ type BaseAPI struct {
 	evmCallTimeout time.Duration
 	dirs           datadir.Dirs
 	L2RpcUrl       string
+	// smt nodes shared with the stages when running in the node, may be nil
+	smtNodeCache *db2.NodeCache
 }
 
 func NewBaseApi(f *rpchelper.Filters, stateCache kvcache.Cache, blockReader services.FullBlockReader, agg *libstate.AggregatorV3, singleNodeMode bool, evmCallTimeout time.Duration, engine consensus.EngineReader, dirs datadir.Dirs, rpcUrl string) *BaseAPI {
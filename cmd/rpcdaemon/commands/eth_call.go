@@ -7,6 +7,7 @@ import (
 	"fmt"
 	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/smt"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 	zkStages "github.com/tenderly/zkevm-erigon/zk/stages"
 	"math/big"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/core/vm"
 	"github.com/tenderly/zkevm-erigon/crypto"
 	"github.com/tenderly/zkevm-erigon/eth/stagedsync"
+	"github.com/tenderly/zkevm-erigon/eth/stagedsync/stages"
 	"github.com/tenderly/zkevm-erigon/eth/tracers/logger"
 	"github.com/tenderly/zkevm-erigon/params"
 	"github.com/tenderly/zkevm-erigon/rpc"
@@ -311,20 +313,15 @@ func (api *APIImpl) EstimateGas(ctx context.Context, argsOrNil *ethapi2.CallArgs
 }
 
 // maxGetProofRewindBlockCount limits the number of blocks into the past that
-// GetProof will allow computing proofs.  Because we must rewind the hash state
+// GetWitness will allow computing witnesses.  Because we must rewind the hash state
 // and re-compute the state trie, the further back in time the request, the more
-// computationally intensive the operation becomes.  The staged sync code
-// assumes that if more than 100_000 blocks are skipped, that the entire trie
-// should be re-computed. Re-computing the entire trie will currently take ~15
-// minutes on mainnet.  The current limit has been chosen arbitrarily as
-// 'useful' without likely being overly computationally intense.  This parameter
-// could possibly be made configurable in the future if needed.
+// computationally intensive the operation becomes.  The current limit has been chosen
+// arbitrarily as 'useful' without likely being overly computationally intense.
 var maxGetProofRewindBlockCount uint64 = 1_000
 
-// GetProof is partially implemented; no Storage proofs, and proofs must be for
-// blocks within maxGetProofRewindBlockCount blocks of the head.
-func (api *APIImpl) GetProof(ctx context.Context, address libcommon.Address, storageKeys []libcommon.Hash, blockNrOrHash rpc.BlockNumberOrHash) (*accounts.AccProofResult, error) {
-
+// GetProof implements eth_getProof against the sparse merkle tree. Proofs for older blocks are built from the
+// state root recorded for that block in the hermez db, so the tree nodes under that root must still be present.
+func (api *APIImpl) GetProof(ctx context.Context, address libcommon.Address, storageKeys []libcommon.Hash, blockNrOrHash rpc.BlockNumberOrHash) (*accounts.SMTAccProofResult, error) {
 	tx, err := api.db.BeginRo(ctx)
 	if err != nil {
 		return nil, err
@@ -334,76 +331,129 @@ func (api *APIImpl) GetProof(ctx context.Context, address libcommon.Address, sto
 		return nil, fmt.Errorf("not supported by Erigon3")
 	}
 
-	blockNr, _, _, err := rpchelper.GetBlockNumber(blockNrOrHash, tx, api.filters)
+	blockNr, _, _, err := rpchelper.GetCanonicalBlockNumber(blockNrOrHash, tx, api.filters)
 	if err != nil {
 		return nil, err
 	}
 
-	header, err := api._blockReader.HeaderByNumber(ctx, tx, blockNr)
+	hashedBlockNo, err := stages.GetStageProgress(tx, stages.IntermediateHashes)
 	if err != nil {
 		return nil, err
 	}
 
-	latestBlock, err := rpchelper.GetLatestBlockNumber(tx)
-	if err != nil {
-		return nil, err
+	if blockNr > hashedBlockNo {
+		return nil, fmt.Errorf("block %d has not been hashed yet, the state tree is at block %d", blockNr, hashedBlockNo)
 	}
 
-	if latestBlock < blockNr {
-		// shouldn't happen, but check anyway
-		return nil, fmt.Errorf("block number is in the future latest=%d requested=%d", latestBlock, blockNr)
+	if hashedBlockNo-blockNr > maxGetProofRewindBlockCount {
+		return nil, fmt.Errorf("requested block is too old, block must be within %d blocks of the head block number (currently %d)", maxGetProofRewindBlockCount, hashedBlockNo)
 	}
 
-	rl := trie.NewRetainList(0)
-	var loader *trie.FlatDBTrieLoader
-	if blockNr < latestBlock {
-		if latestBlock-blockNr > maxGetProofRewindBlockCount {
-			return nil, fmt.Errorf("requested block is too old, block must be within %d blocks of the head block number (currently %d)", maxGetProofRewindBlockCount, latestBlock)
-		}
-		batch := memdb.NewMemoryBatch(tx, api.dirs.Tmp)
-		defer batch.Rollback()
+	batch := memdb.NewMemoryBatch(tx, api.dirs.Tmp)
+	defer batch.Rollback()
 
-		unwindState := &stagedsync.UnwindState{UnwindPoint: blockNr}
-		stageState := &stagedsync.StageState{BlockNumber: latestBlock}
+	// Hack for now for the new tables not defined in erigon-lib
+	if err = db2.CreateEriDbBuckets(batch); err != nil {
+		return nil, err
+	}
 
-		hashStageCfg := stagedsync.StageHashStateCfg(nil, api.dirs, api.historyV3(batch), api._agg)
-		if err := stagedsync.UnwindHashStateStage(unwindState, stageState, batch, hashStageCfg, ctx); err != nil {
-			return nil, err
-		}
+	// the tree history is pruned to zkevm.smt-history-blocks, which can be fewer
+	prunedBefore, err := db2.GetHistoryPrunedBefore(batch)
+	if err != nil {
+		return nil, err
+	}
+	if blockNr < hashedBlockNo && blockNr+1 < prunedBefore {
+		return nil, fmt.Errorf("requested block is too old, the state tree history starts at block %d (currently %d)", prunedBefore-1, hashedBlockNo)
+	}
 
-		interHashStageCfg := stagedsync.StageTrieCfg(nil, false, false, false, api.dirs.Tmp, api._blockReader, nil, api.historyV3(batch), api._agg)
-		loader, err = stagedsync.UnwindIntermediateHashesForTrieLoader("eth_getProof", rl, unwindState, stageState, batch, interHashStageCfg, nil, nil, ctx.Done())
+	var dbSmt *smt.SMT
+	if blockNr == hashedBlockNo {
+		eridb := db2.NewEriDb(batch)
+		eridb.SetNodeCache(api.smtNodeCache)
+		dbSmt = smt.NewSMT(eridb)
+	} else {
+		// older blocks are read from the tree history, at the state root recorded for the block
+		rodb, err := eriRoDbAtBlock(batch, blockNr)
 		if err != nil {
 			return nil, err
 		}
-		tx = batch
-	} else {
-		loader = trie.NewFlatDBTrieLoader("eth_getProof", rl, nil, nil, false)
+		rodb.SetNodeCache(api.smtNodeCache)
+		dbSmt = smt.NewSMT(rodb)
 	}
 
-	reader, err := rpchelper.CreateStateReader(ctx, tx, blockNrOrHash, 0, api.filters, api.stateCache, api.historyV3(tx), "")
+	root, err := dbSmt.Db.GetLastRoot()
 	if err != nil {
 		return nil, err
 	}
-	a, err := reader.ReadAccountData(address)
+
+	return smtAccountProofResult(dbSmt, root, address, storageKeys)
+}
+
+// eriRoDbAtBlock opens the tree at the state root stored for the l2 block
+func eriRoDbAtBlock(tx kv.Tx, l2BlockNo uint64) (*db2.EriRoDb, error) {
+	root, err := hermez_db.NewHermezDbReader(tx).GetStateRoot(l2BlockNo)
 	if err != nil {
 		return nil, err
 	}
-	pr, err := trie.NewProofRetainer(address, a, storageKeys, rl)
-	if err != nil {
-		return nil, err
+	if root == (libcommon.Hash{}) {
+		return nil, fmt.Errorf("no state root stored for block %d", l2BlockNo)
 	}
 
-	loader.SetProofRetainer(pr)
-	root, err := loader.CalcTrieRoot(tx, nil)
+	return db2.NewEriRoDb(tx, root.Big()), nil
+}
+
+func smtAccountProofResult(s *smt.SMT, root *big.Int, address libcommon.Address, storageKeys []libcommon.Hash) (*accounts.SMTAccProofResult, error) {
+	keys := make([]string, len(storageKeys))
+	for i, k := range storageKeys {
+		keys[i] = k.Hex()
+	}
+
+	ap, err := s.GetAccountProof(root, address.String(), keys)
 	if err != nil {
 		return nil, err
 	}
 
-	if root != header.Root {
-		return nil, fmt.Errorf("mismatch in expected state root computed %v vs %v indicates bug in proof implementation", root, header.Root)
+	result := &accounts.SMTAccProofResult{
+		Address:         address,
+		StateRoot:       libcommon.BigToHash(root),
+		Balance:         (*hexutil.Big)(ap.Balance.ValueBigInt()),
+		Nonce:           hexutil.Uint64(ap.Nonce.ValueBigInt().Uint64()),
+		CodeHash:        libcommon.BigToHash(ap.CodeHash.ValueBigInt()),
+		CodeLength:      hexutil.Uint64(ap.CodeLength.ValueBigInt().Uint64()),
+		BalanceProof:    smtProofResult(ap.Balance),
+		NonceProof:      smtProofResult(ap.Nonce),
+		CodeHashProof:   smtProofResult(ap.CodeHash),
+		CodeLengthProof: smtProofResult(ap.CodeLength),
+		StorageProof:    make([]accounts.SMTStorProofResult, len(storageKeys)),
+	}
+
+	for i, k := range storageKeys {
+		p := ap.Storage[keys[i]]
+		result.StorageProof[i] = accounts.SMTStorProofResult{
+			Key:   k,
+			Value: (*hexutil.Big)(p.ValueBigInt()),
+			Proof: smtProofResult(p),
+		}
+	}
+
+	return result, nil
+}
+
+func smtProofResult(p *smt.SMTProof) accounts.SMTProofResult {
+	res := accounts.SMTProofResult{
+		Key:      libcommon.BigToHash(p.Key.ToBigInt()),
+		Siblings: make([]libcommon.Hash, len(p.Siblings)),
+	}
+	for i, sibling := range p.Siblings {
+		res.Siblings[i] = libcommon.BigToHash(sibling.ToBigInt())
+	}
+	if p.FoundRKey != nil {
+		rKey := libcommon.BigToHash(p.FoundRKey.ToBigInt())
+		valueHash := libcommon.BigToHash(p.FoundValueHash.ToBigInt())
+		res.FoundRKey = &rKey
+		res.FoundValueHash = &valueHash
 	}
-	return pr.ProofResult()
+	return res
 }
 
 func (api *APIImpl) GetWitness(ctx context.Context, blockNrOrHash rpc.BlockNumberOrHash) (hexutility.Bytes, error) {
@@ -494,7 +544,7 @@ func (api *BaseAPI) getWitness(ctx context.Context, db kv.RoDB, blockNrOrHash rp
 			return nil, err
 		}
 
-		interHashStageCfg := zkStages.StageZkInterHashesCfg(nil, true, true, false, api.dirs.Tmp, api._blockReader, nil, api.historyV3(batch), api._agg, nil)
+		interHashStageCfg := zkStages.StageZkInterHashesCfg(nil, true, true, false, api.dirs.Tmp, api._blockReader, nil, api.historyV3(batch), api._agg, nil, nil)
 
 		err = zkStages.UnwindZkIntermediateHashesStage(unwindState, stageState, batch, interHashStageCfg, ctx)
 		if err != nil {
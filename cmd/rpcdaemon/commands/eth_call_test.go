@@ -2,6 +2,7 @@ package commands
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"testing"
@@ -14,7 +15,6 @@ import (
 	txpool "github.com/tenderly/erigon/erigon-lib/gointerfaces/txpool"
 	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
 	"github.com/tenderly/zkevm-erigon-lib/common/hexutility"
-	"github.com/tenderly/zkevm-erigon-lib/common/length"
 	"github.com/tenderly/zkevm-erigon-lib/kv"
 	"github.com/tenderly/zkevm-erigon-lib/kv/kvcache"
 
@@ -27,15 +27,18 @@ import (
 	"github.com/tenderly/zkevm-erigon/core/types"
 	"github.com/tenderly/zkevm-erigon/core/types/accounts"
 	"github.com/tenderly/zkevm-erigon/crypto"
+	ethStages "github.com/tenderly/zkevm-erigon/eth/stagedsync/stages"
 	"github.com/tenderly/zkevm-erigon/params"
-	"github.com/tenderly/zkevm-erigon/rlp"
 	"github.com/tenderly/zkevm-erigon/rpc"
 	"github.com/tenderly/zkevm-erigon/rpc/rpccfg"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/smt"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
 	"github.com/tenderly/zkevm-erigon/turbo/adapter/ethapi"
 	"github.com/tenderly/zkevm-erigon/turbo/rpchelper"
 	"github.com/tenderly/zkevm-erigon/turbo/snapshotsync"
 	"github.com/tenderly/zkevm-erigon/turbo/stages"
-	"github.com/tenderly/zkevm-erigon/turbo/trie"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 )
 
 func TestEstimateGas(t *testing.T) {
@@ -101,164 +104,37 @@ func TestEthCallToPrunedBlock(t *testing.T) {
 	}
 }
 
-type valueNode []byte
-type hashNode libcommon.Hash
-
-type shortNode struct {
-	Key trie.Keybytes
-	Val any
-}
-
-type fullNode struct {
-	Children [17]any
-}
-
-func decodeRef(t *testing.T, buf []byte) (any, []byte) {
+// verifySMTProof rebuilds the smt proof from its rpc form and checks it against the state root
+func verifySMTProof(t *testing.T, stateRoot libcommon.Hash, value *big.Int, res accounts.SMTProofResult) {
 	t.Helper()
-	kind, val, rest, err := rlp.Split(buf)
-	require.NoError(t, err)
-	switch {
-	case kind == rlp.List:
-		require.Less(t, len(buf)-len(rest), length.Hash, "embedded nodes must be less than hash size")
-		return decodeNode(t, buf), rest
-	case kind == rlp.String && len(val) == 0:
-		return nil, rest
-	case kind == rlp.String && len(val) == 32:
-		return hashNode(libcommon.CastToHash(val)), rest
-	default:
-		t.Fatalf("invalid RLP string size %d (want 0 through 32)", len(val))
-		return nil, rest
-	}
-}
 
-func decodeFull(t *testing.T, elems []byte) fullNode {
-	t.Helper()
-	n := fullNode{}
-	for i := 0; i < 16; i++ {
-		n.Children[i], elems = decodeRef(t, elems)
-	}
-	val, _, err := rlp.SplitString(elems)
-	require.NoError(t, err)
-	if len(val) > 0 {
-		n.Children[16] = valueNode(val)
+	proof := &smt.SMTProof{
+		Root:     utils.ScalarToRoot(stateRoot.Big()),
+		Key:      utils.ScalarToRoot(res.Key.Big()),
+		Siblings: make([]utils.NodeKey, len(res.Siblings)),
 	}
-	return n
-}
-
-func decodeShort(t *testing.T, elems []byte) shortNode {
-	t.Helper()
-	kbuf, rest, err := rlp.SplitString(elems)
-	require.NoError(t, err)
-	kb := trie.CompactToKeybytes(kbuf)
-	if kb.Terminating {
-		val, _, err := rlp.SplitString(rest)
-		require.NoError(t, err)
-		return shortNode{
-			Key: kb,
-			Val: valueNode(val),
-		}
-	}
-
-	val, _ := decodeRef(t, rest)
-	return shortNode{
-		Key: kb,
-		Val: val,
-	}
-}
-
-func decodeNode(t *testing.T, encoded []byte) any {
-	t.Helper()
-	require.NotEmpty(t, encoded)
-	elems, _, err := rlp.SplitList(encoded)
-	require.NoError(t, err)
-	switch c, _ := rlp.CountValues(elems); c {
-	case 2:
-		return decodeShort(t, elems)
-	case 17:
-		return decodeFull(t, elems)
-	default:
-		t.Fatalf("invalid number of list elements: %v", c)
-		return nil // unreachable
+	for i, sibling := range res.Siblings {
+		proof.Siblings[i] = utils.ScalarToRoot(sibling.Big())
 	}
-}
-
-// proofMap creates a map from hash to proof node
-func proofMap(t *testing.T, proof []hexutility.Bytes) (map[libcommon.Hash]any, map[libcommon.Hash][]byte) {
-	res := map[libcommon.Hash]any{}
-	raw := map[libcommon.Hash][]byte{}
-	for _, proofB := range proof {
-		hash := crypto.Keccak256Hash(proofB)
-		res[hash] = decodeNode(t, proofB)
-		raw[hash] = proofB
+	if res.FoundRKey != nil {
+		rKey := utils.ScalarToRoot(res.FoundRKey.Big())
+		proof.FoundRKey = &rKey
+		proof.FoundValueHash = utils.ScalarToRoot(res.FoundValueHash.Big())
 	}
-	return res, raw
-}
-
-func verifyProof(t *testing.T, root libcommon.Hash, key []byte, proofs map[libcommon.Hash]any, used map[libcommon.Hash][]byte) []byte {
-	t.Helper()
-	key = (&trie.Keybytes{Data: key}).ToHex()
-	var node any = hashNode(root)
-	for {
-		switch nt := node.(type) {
-		case fullNode:
-			require.NotEmpty(t, key, "full nodes should not have values")
-			node, key = nt.Children[key[0]], key[1:]
-		case shortNode:
-			shortHex := nt.Key.ToHex()[:nt.Key.Nibbles()] // There is a trailing 0 on odd otherwise
-			require.LessOrEqual(t, len(shortHex), len(key))
-			require.Equal(t, shortHex, key[:len(shortHex)])
-			node, key = nt.Val, key[len(shortHex):]
-		case hashNode:
-			var ok bool
-			node, ok = proofs[libcommon.Hash(nt)]
-			require.True(t, ok, "missing hash %x", nt)
-			delete(used, libcommon.Hash(nt))
-		case valueNode:
-			require.Len(t, key, 0)
-			for hash, raw := range used {
-				require.Failf(t, "not all proof elements were used", "hash=%x value=%x decoded=%#v", hash, raw, proofs[hash])
-			}
-			return nt
-		default:
-			t.Fatalf("unexpected type: %T", node)
-		}
+	if value.Sign() != 0 {
+		v, err := utils.NodeValue8FromBigIntArray(utils.ScalarToArrayBig(value))
+		require.NoError(t, err)
+		proof.Value = *v
 	}
-}
 
-func verifyAccountProof(t *testing.T, stateRoot libcommon.Hash, proof *accounts.AccProofResult) {
-	t.Helper()
-	accountKey := crypto.Keccak256(proof.Address[:])
-	pm, used := proofMap(t, proof.AccountProof)
-	value := verifyProof(t, stateRoot, accountKey, pm, used)
-
-	expected, err := rlp.EncodeToBytes([]any{
-		uint64(proof.Nonce),
-		proof.Balance.ToInt().Bytes(),
-		proof.StorageHash,
-		proof.CodeHash,
-	})
-	require.NoError(t, err)
-
-	require.Equal(t, expected, value)
-}
-
-func verifyStorageProof(t *testing.T, storageRoot libcommon.Hash, proof accounts.StorProofResult) {
-	t.Helper()
-
-	storageKey := crypto.Keccak256(proof.Key[:])
-	pm, used := proofMap(t, proof.Proof)
-	value := verifyProof(t, storageRoot, storageKey, pm, used)
-
-	expected, err := rlp.EncodeToBytes(proof.Value.ToInt().Bytes())
-	require.NoError(t, err)
-
-	require.Equal(t, expected, value)
+	require.NoError(t, smt.VerifyProof(proof))
 }
 
 func TestGetProof(t *testing.T) {
 	maxGetProofRewindBlockCount = 1 // Note, this is unsafe for parallel tests, but, this test is the only consumer for now
+	defer func() { maxGetProofRewindBlockCount = 1_000 }()
 
-	m, bankAddr, contractAddr := chainWithDeployedContract(t)
+	m, bankAddr, contractAddr, roots := chainWithSmt(t)
 	br := snapshotsync.NewBlockReaderWithSnapshots(m.BlockSnapshots, m.TransactionsV3)
 
 	if m.HistoryV3 {
@@ -331,14 +207,12 @@ func TestGetProof(t *testing.T) {
 			require.NoError(t, err)
 			require.NotNil(t, proof)
 
-			tx, err := m.DB.BeginRo(context.Background())
-			assert.NoError(t, err)
-			defer tx.Rollback()
-			header, err := api.headerByRPCNumber(rpc.BlockNumber(tt.blockNum), tx)
-			require.NoError(t, err)
-
 			require.Equal(t, tt.addr, proof.Address)
-			verifyAccountProof(t, header.Root, proof)
+			require.Equal(t, roots[tt.blockNum], proof.StateRoot)
+			verifySMTProof(t, proof.StateRoot, proof.Balance.ToInt(), proof.BalanceProof)
+			verifySMTProof(t, proof.StateRoot, new(big.Int).SetUint64(uint64(proof.Nonce)), proof.NonceProof)
+			verifySMTProof(t, proof.StateRoot, proof.CodeHash.Big(), proof.CodeHashProof)
+			verifySMTProof(t, proof.StateRoot, new(big.Int).SetUint64(uint64(proof.CodeLength)), proof.CodeLengthProof)
 
 			require.Equal(t, len(tt.storageKeys), len(proof.StorageProof))
 			for _, storageKey := range tt.storageKeys {
@@ -349,7 +223,7 @@ func TestGetProof(t *testing.T) {
 					}
 					found = true
 					require.Equal(t, uint256.NewInt(tt.stateVal).ToBig(), (*big.Int)(storageProof.Value))
-					verifyStorageProof(t, proof.StorageHash, storageProof)
+					verifySMTProof(t, proof.StateRoot, storageProof.Value.ToInt(), storageProof.Proof)
 				}
 				require.True(t, found, "did not find storage proof for key=%x", storageKey)
 			}
@@ -357,6 +231,27 @@ func TestGetProof(t *testing.T) {
 	}
 }
 
+func TestGetProof_PrunedHistory(t *testing.T) {
+	m, _, contractAddr, _ := chainWithSmt(t)
+	br := snapshotsync.NewBlockReaderWithSnapshots(m.BlockSnapshots, m.TransactionsV3)
+	if m.HistoryV3 {
+		t.Skip("not supported by Erigon3")
+	}
+	api := NewEthAPI(NewBaseApi(nil, kvcache.New(kvcache.DefaultCoherentConfig), br, m.HistoryV3Components(), false, rpccfg.DefaultEvmCallTimeout, m.Engine, m.Dirs), m.DB, nil, nil, nil, 5000000, 100_000, "")
+
+	// the history is kept for the roots from block 3 on
+	tx, err := m.DB.BeginRw(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db2.NewEriDb(tx).PruneHistory(4))
+	require.NoError(t, tx.Commit())
+
+	_, err = api.GetProof(context.Background(), contractAddr, nil, rpc.BlockNumberOrHashWithNumber(2))
+	require.EqualError(t, err, "requested block is too old, the state tree history starts at block 3 (currently 3)")
+	proof, err := api.GetProof(context.Background(), contractAddr, nil, rpc.BlockNumberOrHashWithNumber(3))
+	require.NoError(t, err)
+	require.NotNil(t, proof)
+}
+
 func TestGetBlockByTimestampLatestTime(t *testing.T) {
 	ctx := context.Background()
 	m, _, _ := rpcdaemontest.CreateTestSentry(t)
@@ -708,6 +603,69 @@ func chainWithDeployedContract(t *testing.T) (*stages.MockSentry, libcommon.Addr
 	return m, bankAddress, contractAddr
 }
 
+// chainWithSmt builds the sparse merkle tree of every block of chainWithDeployedContract the way the interhashes
+// stage does, the nodes removed by a block are journaled under it and the root of each block is stored in the hermez db
+func chainWithSmt(t *testing.T) (*stages.MockSentry, libcommon.Address, libcommon.Address, []libcommon.Hash) {
+	m, bankAddr, contractAddr := chainWithDeployedContract(t)
+
+	tx, err := m.DB.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+
+	require.NoError(t, db2.CreateEriDbBuckets(tx))
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	require.NoError(t, err)
+
+	eridb := db2.NewEriDb(tx)
+	dbSmt := smt.NewSMT(eridb)
+
+	head := rawdb.ReadCurrentBlock(tx).NumberU64()
+	roots := make([]libcommon.Hash, head+1)
+	for blockNo := uint64(0); blockNo <= head; blockNo++ {
+		eridb.SetBlockNumber(blockNo)
+
+		// the state after the block
+		reader, err := rpchelper.CreateHistoryStateReader(tx, blockNo+1, 0, m.HistoryV3, "")
+		require.NoError(t, err)
+
+		for _, addr := range []libcommon.Address{bankAddr, contractAddr} {
+			acc, err := reader.ReadAccountData(addr)
+			require.NoError(t, err)
+			if acc == nil {
+				continue
+			}
+			_, err = dbSmt.SetAccountState(addr.String(), acc.Balance.ToBig(), new(big.Int).SetUint64(acc.Nonce))
+			require.NoError(t, err)
+
+			code, err := reader.ReadAccountCode(addr, acc.Incarnation, acc.CodeHash)
+			require.NoError(t, err)
+			if len(code) == 0 {
+				continue
+			}
+			require.NoError(t, dbSmt.SetContractBytecode(addr.String(), hex.EncodeToString(code)))
+
+			storage := make(map[string]string)
+			for i := 0; i < 16; i++ {
+				key := libcommon.Hash{}
+				key[31] = byte(i)
+				v, err := reader.ReadAccountStorage(addr, acc.Incarnation, &key)
+				require.NoError(t, err)
+				storage[fmt.Sprintf("0x%032x", key)] = fmt.Sprintf("0x%032x", libcommon.BytesToHash(v))
+			}
+			_, err = dbSmt.SetContractStorage(addr.String(), storage)
+			require.NoError(t, err)
+		}
+
+		roots[blockNo] = libcommon.BigToHash(dbSmt.LastRoot())
+		require.NoError(t, hermezDb.WriteStateRoot(blockNo, roots[blockNo]))
+	}
+
+	require.NoError(t, ethStages.SaveStageProgress(tx, ethStages.IntermediateHashes, head))
+	require.NoError(t, tx.Commit())
+
+	return m, bankAddr, contractAddr, roots
+}
+
 func doPrune(t *testing.T, db kv.RwDB, pruneTo uint64) {
 	ctx := context.Background()
 	tx, err := db.BeginRw(ctx)
@@ -17,9 +17,13 @@ import (
 	"github.com/holiman/uint256"
 	"github.com/tenderly/zkevm-erigon/common/hexutil"
 	eritypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/crypto"
 	"github.com/tenderly/zkevm-erigon/rpc"
 	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 	types "github.com/tenderly/zkevm-erigon/zk/rpcdaemon"
+	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
+	zktypes "github.com/tenderly/zkevm-erigon/zk/types"
+	"github.com/tenderly/zkevm-erigon/zk/utils"
 	"github.com/tenderly/zkevm-erigon/zkevm/jsonrpc/client"
 )
 
@@ -38,8 +42,13 @@ type ZkEvmAPI interface {
 	GetFullBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (types.Block, error)
 	GetFullBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (types.Block, error)
 	GetBroadcastURI(ctx context.Context) (string, error)
+	GetL1BatchMetadata(ctx context.Context, batchNumber rpc.BlockNumber) (*types.L1BatchMetadata, error)
+	GetFirstAccInputHashMismatch(ctx context.Context, fromBatch, toBatch rpc.BlockNumber) (*hexutil.Uint64, error)
 }
 
+// the most batches GetFirstAccInputHashMismatch checks in a call
+const maxAccInputHashCheckRange = 10000
+
 // APIImpl is implementation of the ZkEvmAPI interface based on remote Db access
 type ZkEvmAPIImpl struct {
 	ethApi *APIImpl
@@ -197,16 +206,144 @@ func (api *ZkEvmAPIImpl) VerifiedBatchNumber(ctx context.Context) (hexutil.Uint6
 }
 
 // GetBatchByNumber returns a batch from the current canonical chain. If number is nil, the
-// latest known batch is returned.
+// latest known batch is returned. A batch missing from the batch index is fetched from the trusted
+// sequencer if there is one.
 func (api *ZkEvmAPIImpl) GetBatchByNumber(ctx context.Context, batchNumber rpc.BlockNumber, fullTx *bool) (json.RawMessage, error) {
-	res, err := client.JSONRPCCall(api.ZkRpcUrl, "zkevm_getBatchByNumber", batchNumber, fullTx)
+	tx, err := api.db.BeginRo(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	batchNo, err := getBatchNumberByRPCNumber(tx, batchNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	hermezDb := hermez_db.NewHermezDbReader(tx)
+	header, err := hermezDb.GetBatchHeader(batchNo)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		if api.ZkRpcUrl == "" {
+			return nil, nil
+		}
+		res, err := client.JSONRPCCall(api.ZkRpcUrl, "zkevm_getBatchByNumber", batchNumber, fullTx)
+		if err != nil {
+			return nil, err
+		}
+		return res.Result, nil
+	}
+
+	// the l2 data of an etrog batch isn't kept, it is fetched along with the batch from the trusted sequencer
+	if api.ZkRpcUrl != "" {
+		forkId, err := hermezDb.GetForkId(batchNo)
+		if err != nil {
+			return nil, err
+		}
+		if forkId >= txtype.ForkIDEtrog {
+			res, err := client.JSONRPCCall(api.ZkRpcUrl, "zkevm_getBatchByNumber", batchNumber, fullTx)
+			if err != nil {
+				return nil, err
+			}
+			return res.Result, nil
+		}
+	}
+
+	batch, err := api.populateBatchDetail(ctx, tx, header, fullTx != nil && *fullTx)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(batch)
+}
+
+func (api *ZkEvmAPIImpl) populateBatchDetail(ctx context.Context, tx kv.Tx, header *zktypes.BatchHeader, fullTx bool) (*types.Batch, error) {
+	hermezDb := hermez_db.NewHermezDbReader(tx)
+
+	batch := &types.Batch{
+		Number:         types.ArgUint64(header.BatchNumber),
+		Coinbase:       header.Coinbase,
+		StateRoot:      header.StateRoot,
+		GlobalExitRoot: header.GlobalExitRoot,
+		LocalExitRoot:  header.LocalExitRoot,
+		AccInputHash:   header.AccInputHash,
+		Timestamp:      types.ArgUint64(header.Timestamp),
+		Closed:         header.Closed,
+		Blocks:         make([]interface{}, 0),
+		Transactions:   make([]interface{}, 0),
+		BatchL2Data:    types.ArgBytes(header.BatchL2Data),
+	}
+	if header.ForcedBatchNum != nil {
+		forcedBatchNum := types.ArgUint64(*header.ForcedBatchNum)
+		batch.ForcedBatchNumber = &forcedBatchNum
+	}
 
-	return res.Result, nil
+	// what was kept of the l2 data of an etrog batch is without its changeL2Block entries
+	forkId, err := hermezDb.GetForkId(header.BatchNumber)
+	if err != nil {
+		return nil, err
+	}
+	if forkId >= txtype.ForkIDEtrog {
+		batch.BatchL2Data = nil
+	}
+
+	l1Ger, err := hermezDb.GetL1GlobalExitRoot(header.GlobalExitRoot)
+	if err != nil {
+		return nil, err
+	}
+	if l1Ger != nil {
+		batch.MainnetExitRoot = l1Ger.MainnetExitRoot
+		batch.RollupExitRoot = l1Ger.RollupExitRoot
+	}
+
+	sequence, err := hermezDb.GetSequenceByBatchNo(header.BatchNumber)
+	if err != nil {
+		return nil, err
+	}
+	if sequence != nil {
+		batch.SendSequencesTxHash = &sequence.L1TxHash
+	}
+	verification, err := hermezDb.GetVerificationByBatchNo(header.BatchNumber)
+	if err != nil {
+		return nil, err
+	}
+	if verification != nil {
+		batch.VerifyBatchTxHash = &verification.L1TxHash
+	}
+
+	blockNos, err := hermezDb.GetL2BlockNosByBatch(header.BatchNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, blockNo := range blockNos {
+		block, err := api.ethApi.BaseAPI.blockByRPCNumber(rpc.BlockNumber(blockNo), tx)
+		if err != nil {
+			return nil, err
+		}
+		if block == nil {
+			return nil, fmt.Errorf("block %d of batch %d not found", blockNo, header.BatchNumber)
+		}
+
+		if !fullTx {
+			batch.Blocks = append(batch.Blocks, block.Hash())
+			for _, txn := range block.Transactions() {
+				batch.Transactions = append(batch.Transactions, txn.Hash())
+			}
+			continue
+		}
+
+		rpcBlock, err := api.populateBlockDetail(tx, ctx, block, true)
+		if err != nil {
+			return nil, err
+		}
+		batch.Blocks = append(batch.Blocks, rpcBlock)
+		for _, txn := range rpcBlock.Transactions {
+			batch.Transactions = append(batch.Transactions, txn)
+		}
+	}
 
-	// TODO: implement this when we get to sequencer activities in erigon
+	return batch, nil
 }
 
 // GetFullBlockByNumber returns a full block from the current canonical chain. If number is nil, the
@@ -293,6 +430,124 @@ func (api *ZkEvmAPIImpl) GetBroadcastURI(ctx context.Context) (string, error) {
 	return api.ZkRpcUrl, nil
 }
 
+// GetL1BatchMetadata returns what a batch was sequenced on L1 with and its accumulated input hash, along with the one
+// the node computes from its own batch. The latest tag is the latest batch read from L1.
+func (api *ZkEvmAPIImpl) GetL1BatchMetadata(ctx context.Context, batchNumber rpc.BlockNumber) (*types.L1BatchMetadata, error) {
+	tx, err := api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	hermezDb := hermez_db.NewHermezDbReader(tx)
+	var batchNo uint64
+	switch batchNumber {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+		latest, err := hermezDb.GetLatestL1BatchMetadata()
+		if err != nil || latest == nil {
+			return nil, err
+		}
+		batchNo = latest.BatchNo
+	default:
+		if batchNo, err = getBatchNumberByRPCNumber(tx, batchNumber); err != nil {
+			return nil, err
+		}
+	}
+
+	return getL1BatchMetadata(hermezDb, batchNo)
+}
+
+// GetFirstAccInputHashMismatch returns the first batch of a range whose accumulated input hash on L1 differs from the
+// one the node computes from its own batch, null if none does. The batches either hash isn't known for are skipped.
+func (api *ZkEvmAPIImpl) GetFirstAccInputHashMismatch(ctx context.Context, fromBatch, toBatch rpc.BlockNumber) (*hexutil.Uint64, error) {
+	tx, err := api.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	from, err := getBatchNumberByRPCNumber(tx, fromBatch)
+	if err != nil {
+		return nil, err
+	}
+	to, err := getBatchNumberByRPCNumber(tx, toBatch)
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		return nil, fmt.Errorf("batch %d is after batch %d", from, to)
+	}
+	if to-from >= maxAccInputHashCheckRange {
+		return nil, fmt.Errorf("more than %d batches asked for", maxAccInputHashCheckRange)
+	}
+
+	hermezDb := hermez_db.NewHermezDbReader(tx)
+	for batchNo := from; batchNo <= to; batchNo++ {
+		metadata, err := getL1BatchMetadata(hermezDb, batchNo)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil || metadata.AccInputHash == nil || metadata.LocalAccInputHash == nil {
+			continue
+		}
+		if *metadata.AccInputHash != *metadata.LocalAccInputHash {
+			mismatch := hexutil.Uint64(batchNo)
+			return &mismatch, nil
+		}
+	}
+	return nil, nil
+}
+
+// getL1BatchMetadata returns the metadata of a batch read from L1, nil if it wasn't. The local accumulated input hash
+// chains the batch of the node onto the accumulated input hash L1 has for the batch before it, it is only computed for
+// the batches read from their sequence tx. The l2 data of the etrog batches isn't kept, so there is no local hash for them.
+func getL1BatchMetadata(hermezDb *hermez_db.HermezDbReader, batchNo uint64) (*types.L1BatchMetadata, error) {
+	l1Metadata, err := hermezDb.GetL1BatchMetadata(batchNo)
+	if err != nil || l1Metadata == nil {
+		return nil, err
+	}
+
+	metadata := &types.L1BatchMetadata{
+		BatchNumber:       types.ArgUint64(batchNo),
+		L1BlockNumber:     types.ArgUint64(l1Metadata.L1BlockNo),
+		L1TxHash:          l1Metadata.L1TxHash,
+		Sequencer:         l1Metadata.Sequencer,
+		GlobalExitRoot:    l1Metadata.GlobalExitRoot,
+		Timestamp:         types.ArgUint64(l1Metadata.Timestamp),
+		TransactionsHash:  l1Metadata.TransactionsHash,
+		ForcedBlockHashL1: l1Metadata.ForcedBlockHashL1,
+	}
+	if l1Metadata.AccInputHash != (common.Hash{}) {
+		metadata.AccInputHash = &l1Metadata.AccInputHash
+	}
+	if l1Metadata.TransactionsHash == (common.Hash{}) || l1Metadata.Etrog {
+		return metadata, nil
+	}
+
+	// the genesis batch has no accumulated input hash
+	var prevAccInputHash common.Hash
+	if batchNo > 1 {
+		prev, err := hermezDb.GetL1BatchMetadata(batchNo - 1)
+		if err != nil {
+			return nil, err
+		}
+		if prev == nil || prev.AccInputHash == (common.Hash{}) {
+			return metadata, nil
+		}
+		prevAccInputHash = prev.AccInputHash
+	}
+
+	header, err := hermezDb.GetBatchHeader(batchNo)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		localAccInputHash := utils.CalculateAccInputHash(prevAccInputHash, crypto.Keccak256Hash(header.BatchL2Data), header.GlobalExitRoot, header.Timestamp, header.Coinbase)
+		metadata.LocalAccInputHash = &localAccInputHash
+	}
+	return metadata, nil
+}
+
 func getLastBlockInBatchNumber(tx kv.Tx, batchNumber uint64) (uint64, error) {
 	c, err := tx.Cursor(hermez_db.BLOCKBATCHES)
 	if err != nil {
@@ -341,6 +596,19 @@ func getAllBlocksInBatchNumber(tx kv.Tx, batchNumber uint64) ([]uint64, error) {
 	return result, nil
 }
 
+// getBatchNumberByRPCNumber resolves the latest and pending tags to the latest batch and the safe and finalized ones
+// to the latest verified batch
+func getBatchNumberByRPCNumber(tx kv.Tx, number rpc.BlockNumber) (uint64, error) {
+	switch number {
+	case rpc.LatestBlockNumber, rpc.PendingBlockNumber:
+		return getLatestBatchNumber(tx)
+	case rpc.SafeBlockNumber, rpc.FinalizedBlockNumber:
+		return stages.GetStageProgress(tx, stages.L1VerificationsBatchNo)
+	default:
+		return uint64(number.Int64()), nil
+	}
+}
+
 func getLatestBatchNumber(tx kv.Tx) (uint64, error) {
 	c, err := tx.Cursor(hermez_db.BLOCKBATCHES)
 	if err != nil {
@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv/memdb"
+
+	"github.com/tenderly/zkevm-erigon/common/hexutil"
+	"github.com/tenderly/zkevm-erigon/crypto"
+	"github.com/tenderly/zkevm-erigon/rpc"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+	zktypes "github.com/tenderly/zkevm-erigon/zk/types"
+	"github.com/tenderly/zkevm-erigon/zk/utils"
+)
+
+// newL1BatchMetadataTestAPI writes the batches of the node and what L1 has for them. Batch 2 was sequenced with
+// other transactions than the node has, batch 3 is a forced etrog batch and the sequence tx of batch 4 wasn't read.
+func newL1BatchMetadataTestAPI(t *testing.T) (*ZkEvmAPIImpl, []common.Hash) {
+	db := memdb.NewTestDB(t)
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, hermez_db.CreateHermezBuckets(tx))
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	require.NoError(t, err)
+
+	sequencer := common.HexToAddress("0x1")
+	ger := common.HexToHash("0x2")
+	forcedBlockHash := common.HexToHash("0x3")
+	accInputHashes := make([]common.Hash, 5)
+	for batchNo := uint64(1); batchNo <= 4; batchNo++ {
+		header := &zktypes.BatchHeader{
+			BatchNumber:    batchNo,
+			Coinbase:       sequencer,
+			GlobalExitRoot: ger,
+			Timestamp:      100 * batchNo,
+			BatchL2Data:    []byte{byte(batchNo)},
+		}
+		require.NoError(t, hermezDb.WriteBatchHeader(header))
+
+		metadata := &zktypes.L1BatchMetadata{
+			BatchNo:          batchNo,
+			L1BlockNo:        10 + batchNo,
+			L1TxHash:         common.BytesToHash([]byte{byte(batchNo)}),
+			Sequencer:        sequencer,
+			GlobalExitRoot:   ger,
+			Timestamp:        header.Timestamp,
+			TransactionsHash: crypto.Keccak256Hash(header.BatchL2Data),
+		}
+		switch batchNo {
+		case 2:
+			metadata.TransactionsHash = crypto.Keccak256Hash([]byte{0xff})
+			metadata.AccInputHash = utils.CalculateAccInputHash(accInputHashes[1], metadata.TransactionsHash, ger, header.Timestamp, sequencer)
+		case 3:
+			metadata.Etrog = true
+			metadata.ForcedBlockHashL1 = forcedBlockHash
+			metadata.AccInputHash = utils.CalculateEtrogAccInputHash(accInputHashes[2], metadata.TransactionsHash, ger, header.Timestamp, sequencer, forcedBlockHash)
+		case 4:
+			metadata = &zktypes.L1BatchMetadata{BatchNo: batchNo, L1BlockNo: 14, Etrog: true, AccInputHash: common.HexToHash("0x4")}
+		default:
+			metadata.AccInputHash = utils.CalculateAccInputHash(accInputHashes[0], metadata.TransactionsHash, ger, header.Timestamp, sequencer)
+		}
+		accInputHashes[batchNo] = metadata.AccInputHash
+		require.NoError(t, hermezDb.WriteL1BatchMetadata(metadata))
+	}
+	require.NoError(t, tx.Commit())
+
+	return NewZkEvmAPI(nil, db, 0, ""), accInputHashes
+}
+
+func TestGetL1BatchMetadata(t *testing.T) {
+	api, accInputHashes := newL1BatchMetadataTestAPI(t)
+	ctx := context.Background()
+
+	metadata, err := api.GetL1BatchMetadata(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), uint64(metadata.L1BlockNumber))
+	require.Equal(t, crypto.Keccak256Hash([]byte{1}), metadata.TransactionsHash)
+	require.Equal(t, accInputHashes[1], *metadata.AccInputHash)
+	require.Equal(t, accInputHashes[1], *metadata.LocalAccInputHash)
+
+	// the node has other transactions than were sequenced
+	metadata, err = api.GetL1BatchMetadata(ctx, 2)
+	require.NoError(t, err)
+	require.NotEqual(t, *metadata.AccInputHash, *metadata.LocalAccInputHash)
+
+	// the l2 data of an etrog batch isn't kept, there is no local hash for it
+	metadata, err = api.GetL1BatchMetadata(ctx, 3)
+	require.NoError(t, err)
+	require.Equal(t, common.HexToHash("0x3"), metadata.ForcedBlockHashL1)
+	require.Equal(t, accInputHashes[3], *metadata.AccInputHash)
+	require.Nil(t, metadata.LocalAccInputHash)
+
+	// the latest batch read from L1, without its sequence tx only the hash of the contract is known
+	metadata, err = api.GetL1BatchMetadata(ctx, rpc.LatestBlockNumber)
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), uint64(metadata.BatchNumber))
+	require.Equal(t, common.HexToHash("0x4"), *metadata.AccInputHash)
+	require.Nil(t, metadata.LocalAccInputHash)
+
+	metadata, err = api.GetL1BatchMetadata(ctx, 5)
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+}
+
+func TestGetFirstAccInputHashMismatch(t *testing.T) {
+	api, _ := newL1BatchMetadataTestAPI(t)
+	ctx := context.Background()
+
+	mismatch, err := api.GetFirstAccInputHashMismatch(ctx, 1, 4)
+	require.NoError(t, err)
+	require.Equal(t, hexutil.Uint64(2), *mismatch)
+
+	// the batches either hash isn't known for are skipped
+	mismatch, err = api.GetFirstAccInputHashMismatch(ctx, 3, 5)
+	require.NoError(t, err)
+	require.Nil(t, mismatch)
+
+	_, err = api.GetFirstAccInputHashMismatch(ctx, 2, 1)
+	require.Error(t, err)
+	_, err = api.GetFirstAccInputHashMismatch(ctx, 1, maxAccInputHashCheckRange+1)
+	require.Error(t, err)
+}
+
+func TestGetBatchByNumber_EtrogL2Data(t *testing.T) {
+	db := memdb.NewTestDB(t)
+	tx, err := db.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, hermez_db.CreateHermezBuckets(tx))
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	require.NoError(t, err)
+
+	// batch 2 is the first one of the etrog fork, its l2 data was written without the changeL2Block entries
+	require.NoError(t, hermezDb.WriteForkId(1, 6))
+	require.NoError(t, hermezDb.WriteForkId(2, 7))
+	for batchNo := uint64(1); batchNo <= 2; batchNo++ {
+		require.NoError(t, hermezDb.WriteBatchHeader(&zktypes.BatchHeader{BatchNumber: batchNo, BatchL2Data: []byte{byte(batchNo)}}))
+	}
+	require.NoError(t, tx.Commit())
+
+	getBatchL2Data := func(api *ZkEvmAPIImpl, batchNo rpc.BlockNumber) string {
+		res, err := api.GetBatchByNumber(context.Background(), batchNo, nil)
+		require.NoError(t, err)
+		var batch struct {
+			BatchL2Data string `json:"batchL2Data"`
+		}
+		require.NoError(t, json.Unmarshal(res, &batch))
+		return batch.BatchL2Data
+	}
+
+	api := NewZkEvmAPI(nil, db, 0, "")
+	require.Equal(t, "0x01", getBatchL2Data(api, 1))
+	require.Equal(t, "0x", getBatchL2Data(api, 2))
+
+	// with a trusted sequencer the etrog batch is fetched from it
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{"number":"0x2","batchL2Data":"0x0b0000000100000000"}}`))
+	}))
+	defer server.Close()
+
+	api = NewZkEvmAPI(nil, db, 0, server.URL)
+	require.Equal(t, "0x01", getBatchL2Data(api, 1))
+	require.Equal(t, "0x0b0000000100000000", getBatchL2Data(api, 2))
+}
@@ -25,6 +25,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/ledgerwatch/log/v3"
@@ -358,9 +359,23 @@ var (
 	}
 	L2DataStreamerUrlFlag = cli.StringFlag{
 		Name:  "zkevm.l2-datastreamer-url",
-		Usage: "L2 datastreamer endpoint",
+		Usage: "L2 datastreamer endpoint or a comma separated list of them to fail over between, file://<path> to read a stream file or replay://<path> to replay a capture of a stream",
 		Value: "",
 	}
+	L2DataStreamerMaxLagFlag = cli.Uint64Flag{
+		Name:  "zkevm.l2-datastreamer-max-lag",
+		Usage: "Entries the datastreamer read from can be behind the most advanced one before failing over to it",
+		Value: 1000,
+	}
+	L2DataStreamerHealthCheckIntervalFlag = cli.DurationFlag{
+		Name:  "zkevm.l2-datastreamer-health-check-interval",
+		Usage: "Interval between the health checks of the datastreamers, the one read from fails over if it sends nothing for as long while it has more entries",
+		Value: 10 * time.Second,
+	}
+	L2DataStreamerCrossCheckFlag = cli.BoolFlag{
+		Name:  "zkevm.l2-datastreamer-cross-check",
+		Usage: "Compare the hash and state root of every block with another datastreamer before writing it, stops the sync if they differ",
+	}
 	L1ChainIdFlag = cli.Uint64Flag{
 		Name:  "zkevm.l1-chain-id",
 		Usage: "Ethereum L1 chain ID",
@@ -368,9 +383,19 @@ var (
 	}
 	L1RpcUrlFlag = cli.StringFlag{
 		Name:  "zkevm.l1-rpc-url",
-		Usage: "Ethereum L1 RPC endpoint",
+		Usage: "Ethereum L1 RPC endpoint or a comma separated list of them to spread the requests over and fail over between",
+		Value: "",
+	}
+	L1RpcWeightsFlag = cli.StringFlag{
+		Name:  "zkevm.l1-rpc-weights",
+		Usage: "Comma separated weights of the Ethereum L1 RPC endpoints, the share of the requests each is sent. 1 each if not set",
 		Value: "",
 	}
+	L1RpcRateLimitFlag = cli.Float64Flag{
+		Name:  "zkevm.l1-rpc-rate-limit",
+		Usage: "Requests per second sent to each Ethereum L1 RPC endpoint at most, 0 for no limit",
+		Value: 0,
+	}
 	L1ContractAddressFlag = cli.StringFlag{
 		Name:  "zkevm.l1-contract-address",
 		Usage: "Ethereum L1 contract address",
@@ -387,6 +412,19 @@ var (
 		Usage:    "Ethereum L1 delay between queries for verifications and sequences - in milliseconds",
 		Value:    6000,
 	}
+	L1FinalityFlag = cli.StringFlag{
+		Name:  "zkevm.l1-finality",
+		Usage: "How final an Ethereum L1 block must be for its verifications and sequences to be read: latest, safe or finalized. Reorgs of the blocks read are unwound",
+		Value: "latest",
+	}
+	L1SyncOnlyFlag = cli.BoolFlag{
+		Name:  "zkevm.l1-sync-only",
+		Usage: "Rebuild the L2 blocks from the batches sequenced on Ethereum L1 instead of reading them from the datastream, for a chain before the etrog fork without forced batches. The state roots are checked against the L1 verifications",
+	}
+	L1BatchMetadataFlag = cli.BoolFlag{
+		Name:  "zkevm.l1-batch-metadata",
+		Usage: "Read what the batches were sequenced with and their accumulated input hashes from Ethereum L1, to compare them with the local batches over the zkevm rpc. Reads the tx of every sequence and calls the rollup contract at its block, which needs the L1 state of the blocks read",
+	}
 	L1MaticContractAddressFlag = cli.StringFlag{
 		Name:  "zkevm.l1-matic-contract-address",
 		Usage: "Ethereum L1 Matic contract address",
@@ -407,6 +445,21 @@ var (
 		Usage: "Rebuild the state tree after this many blocks behind",
 		Value: 100,
 	}
+	SmtRegenerateBufferSizeFlag = cli.StringFlag{
+		Name:  "zkevm.smt-regenerate-buffer-size",
+		Usage: "Memory used to sort the state tree leaves when regenerating the tree, the rest is sorted on disk. Defaults to the ETL buffer size",
+		Value: "",
+	}
+	SmtNodeCacheSizeFlag = cli.StringFlag{
+		Name:  "zkevm.smt-node-cache-size",
+		Usage: "Memory used to cache the state tree nodes between blocks, shared by the stages and the RPC. 0 disables the cache",
+		Value: "256MB",
+	}
+	SmtHistoryBlocksFlag = cli.Uint64Flag{
+		Name:  "zkevm.smt-history-blocks",
+		Usage: "How many blocks back the state tree is kept readable for proofs of past blocks, the older history of the tree is pruned",
+		Value: 1000,
+	}
 	RpcRateLimitsFlag = cli.IntFlag{
 		Name:  "zkevm.rpc-ratelimit",
 		Usage: "RPC rate limit in requests per second.",
@@ -417,6 +470,11 @@ var (
 		Usage: "Define the port used for the zkevm data stream",
 		Value: 0,
 	}
+	DataStreamVersion = cli.UintFlag{
+		Name:  "zkevm.data-stream-version",
+		Usage: "Version of the entries of a new zkevm data stream file, an existing file keeps its version. Clients get it from the stream header",
+		Value: 1,
+	}
 	DataStreamHost = cli.StringFlag{
 		Name:  "zkevm.data-stream-host",
 		Usage: "Define the host used for the zkevm data stream",
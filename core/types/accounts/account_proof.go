@@ -22,3 +22,34 @@ type StorProofResult struct {
 	Value *hexutil.Big       `json:"value"`
 	Proof []hexutility.Bytes `json:"proof"`
 }
+
+// Result structs for GetProof on a zkEVM chain, where the state is a sparse merkle tree.
+// Every account field is its own leaf so each one carries its own proof.
+type SMTAccProofResult struct {
+	Address         libcommon.Address    `json:"address"`
+	StateRoot       libcommon.Hash       `json:"stateRoot"`
+	Balance         *hexutil.Big         `json:"balance"`
+	Nonce           hexutil.Uint64       `json:"nonce"`
+	CodeHash        libcommon.Hash       `json:"codeHash"`
+	CodeLength      hexutil.Uint64       `json:"codeLength"`
+	BalanceProof    SMTProofResult       `json:"balanceProof"`
+	NonceProof      SMTProofResult       `json:"nonceProof"`
+	CodeHashProof   SMTProofResult       `json:"codeHashProof"`
+	CodeLengthProof SMTProofResult       `json:"codeLengthProof"`
+	StorageProof    []SMTStorProofResult `json:"storageProof"`
+}
+
+type SMTStorProofResult struct {
+	Key   libcommon.Hash `json:"key"`
+	Value *hexutil.Big   `json:"value"`
+	Proof SMTProofResult `json:"proof"`
+}
+
+// SMTProofResult is the path of a single SMT key, siblings are ordered from the root down.
+// FoundRKey and FoundValueHash are only set when the path ends in a leaf holding a different key.
+type SMTProofResult struct {
+	Key            libcommon.Hash   `json:"key"`
+	Siblings       []libcommon.Hash `json:"siblings"`
+	FoundRKey      *libcommon.Hash  `json:"foundRKey,omitempty"`
+	FoundValueHash *libcommon.Hash  `json:"foundValueHash,omitempty"`
+}
@@ -98,6 +98,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/eth/ethutils"
 	"github.com/tenderly/zkevm-erigon/eth/protocols/eth"
 	"github.com/tenderly/zkevm-erigon/eth/stagedsync"
+	"github.com/tenderly/zkevm-erigon/ethclient"
 	"github.com/tenderly/zkevm-erigon/ethdb/privateapi"
 	"github.com/tenderly/zkevm-erigon/ethdb/prune"
 	"github.com/tenderly/zkevm-erigon/ethstats"
@@ -114,8 +115,8 @@ import (
 	stages2 "github.com/tenderly/zkevm-erigon/turbo/stages"
 	"github.com/tenderly/zkevm-erigon/turbo/stages/headerdownload"
 	"github.com/tenderly/zkevm-erigon/zk/datastream/client"
+	dstypes "github.com/tenderly/zkevm-erigon/zk/datastream/types"
 	"github.com/tenderly/zkevm-erigon/zk/syncer"
-	"github.com/tenderly/zkevm-erigon/zkevm/etherman"
 )
 
 // Config contains the configuration options of the ETH protocol.
@@ -186,6 +187,8 @@ type Ethereum struct {
 
 	// zk
 	dataStream *datastreamer.StreamServer
+	// smt nodes shared by the stages and the rpc
+	smtNodeCache *db.NodeCache
 }
 
 func splitAddrIntoHostAndPort(addr string) (host string, port int, err error) {
@@ -711,12 +714,17 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 				Level:       "warn",
 				Outputs:     nil,
 			}
-			backend.dataStream, err = datastreamer.NewServer(uint16(httpCfg.DataStreamPort), datastreamer.StreamType(1), file, logConfig)
+			if _, err := dstypes.NewCodec(dstypes.StreamVersion(backend.config.Zk.DataStreamVersion)); err != nil {
+				return nil, err
+			}
+			backend.dataStream, err = datastreamer.NewServer(uint16(httpCfg.DataStreamPort), backend.config.Zk.DataStreamVersion, backend.config.Zk.L2ChainId, datastreamer.StreamType(1), file, logConfig)
 			if err != nil {
 				return nil, err
 			}
 		}
 
+		backend.smtNodeCache = db.NewNodeCache(backend.config.Zk.SmtNodeCacheSize)
+
 		// entering ZK territory!
 		if sequencer.IsSequencer() {
 			// if we are sequencing transactions, we do the sequencing loop...
@@ -735,6 +743,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 				backend.dataStream,
 				backend.txPool2,
 				backend.txPool2DB,
+				backend.smtNodeCache,
 			)
 
 			backend.syncUnwindOrder = zkStages.ZkSequencerUnwindOrder
@@ -752,15 +761,28 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 			*/
 
 			cfg := backend.config.Zk
-			datastreamClient := initDataStreamClient(cfg)
+			datastreamClient := initDataStreamClient(cfg, backend.chainDB)
 
-			etherMan := newEtherMan(cfg)
+			l1Client := newL1Client(cfg)
+			l1Finality, err := syncer.L1FinalityBlockNumber(cfg.L1Finality)
+			if err != nil {
+				return nil, err
+			}
 			zkL1Syncer := syncer.NewL1Syncer(
-				etherMan.EthClient,
+				l1Client,
 				cfg.L1ContractAddress,
+				cfg.L1GERManagerContractAddress,
 				cfg.L1BlockRange,
 				cfg.L1QueryDelay,
+				l1Finality,
+				cfg.L1SyncOnly,
+				cfg.L1BatchMetadata,
 			)
+			if cfg.L1SyncOnly {
+				if err := zkL1Syncer.CheckL1SyncOnly(); err != nil {
+					return nil, fmt.Errorf("the blocks can't be rebuilt from L1 with zkevm.l1-sync-only: %w", err)
+				}
+			}
 
 			backend.syncStages = stages2.NewDefaultZkStages(
 				backend.sentryCtx,
@@ -776,6 +798,7 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 				zkL1Syncer,
 				datastreamClient,
 				backend.dataStream,
+				backend.smtNodeCache,
 			)
 
 			backend.syncUnwindOrder = zkStages.ZkUnwindOrder
@@ -790,26 +813,50 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 	return backend, nil
 }
 
-// creates an EtherMan instance with default parameters
-func newEtherMan(cfg *ethconfig.Zk) *etherman.Client {
-	ethmanConf := etherman.Config{
-		URL:                       cfg.L1RpcUrl,
-		L1ChainID:                 cfg.L1ChainId,
-		PoEAddr:                   cfg.L1ContractAddress,
-		MaticAddr:                 cfg.L1MaticContractAddress,
-		GlobalExitRootManagerAddr: cfg.L1GERManagerContractAddress,
+// creates the L1 client over the endpoints of the L1 rpc url, with their weights and rate limit
+func newL1Client(cfg *ethconfig.Zk) *syncer.L1Client {
+	urls := cfg.L1RpcUrls()
+	endpoints := make([]syncer.L1Endpoint, 0, len(urls))
+	for i, url := range urls {
+		ethClient, err := ethclient.Dial(url)
+		//panic on error
+		if err != nil {
+			panic(err)
+		}
+		endpoint := syncer.L1Endpoint{Name: url, Client: ethClient, RateLimit: cfg.L1RpcRateLimit}
+		if i < len(cfg.L1RpcWeights) {
+			endpoint.Weight = cfg.L1RpcWeights[i]
+		}
+		endpoints = append(endpoints, endpoint)
 	}
+	return syncer.NewL1Client(endpoints)
+}
 
-	em, err := etherman.NewClient(ethmanConf)
-	//panic on error
-	if err != nil {
-		panic(err)
+// creates the datastream source: a local stream file for a file:// url, a capture of a stream for a replay:// url,
+// otherwise a client of the datastreamer at the url
+func initDataStreamClient(cfg *ethconfig.Zk, db kv.RoDB) client.Source {
+	if cfg.L1SyncOnly {
+		log.Info("Rebuilding the blocks from L1, the datastream isn't read")
+		return client.NewL1Source(db)
+	}
+	if fileName, ok := strings.CutPrefix(cfg.L2DataStreamerUrl, "file://"); ok {
+		log.Info("Reading the datastream from a file", "file", fileName)
+		return client.NewFileSource(fileName)
+	}
+	if fileName, ok := strings.CutPrefix(cfg.L2DataStreamerUrl, "replay://"); ok {
+		log.Info("Replaying a datastream capture", "file", fileName)
+		return client.NewReplaySource(fileName)
+	}
+
+	if servers := strings.Split(cfg.L2DataStreamerUrl, ","); len(servers) > 1 {
+		log.Info("Starting datastream client with failover", "servers", servers)
+		return client.NewMultiSource(servers, client.MultiSourceConfig{
+			MaxLag:              cfg.L2DataStreamerMaxLag,
+			HealthCheckInterval: cfg.L2DataStreamerHealthCheckInterval,
+			CrossCheck:          cfg.L2DataStreamerCrossCheck,
+		})
 	}
-	return em
-}
 
-// creates a datastream client with default parameters
-func initDataStreamClient(cfg *ethconfig.Zk) *client.StreamClient {
 	// datastream
 	// Create client
 	log.Info("Starting datastream client...")
@@ -881,7 +928,7 @@ func (backend *Ethereum) Init(stack *node.Node, config *ethconfig.Config) error
 	if casted, ok := backend.engine.(*bor.Bor); ok {
 		borDb = casted.DB
 	}
-	apiList := commands.APIList(chainKv, borDb, ethRpcClient, txPoolRpcClient, miningRpcClient, ff, stateCache, blockReader, backend.agg, httpRpcCfg, backend.engine, config.Zk.L2RpcUrl)
+	apiList := commands.APIList(chainKv, borDb, ethRpcClient, txPoolRpcClient, miningRpcClient, ff, stateCache, blockReader, backend.agg, httpRpcCfg, backend.engine, config.Zk.L2RpcUrl, backend.smtNodeCache)
 	authApiList := commands.AuthAPIList(chainKv, ethRpcClient, txPoolRpcClient, miningRpcClient, ff, stateCache, blockReader, backend.agg, httpRpcCfg, backend.engine)
 	go func() {
 		if err := cli.StartRpcServer(ctx, httpRpcCfg, apiList, authApiList); err != nil {
@@ -269,7 +269,48 @@ type Zk struct {
 	L1FirstBlock                uint64
 	RpcRateLimits               int
 
+	// with several datastreamers in L2DataStreamerUrl, how many entries the one read from can be behind the others,
+	// how often they are health checked and whether the blocks are compared between them
+	L2DataStreamerMaxLag              uint64
+	L2DataStreamerHealthCheckInterval time.Duration
+	L2DataStreamerCrossCheck          bool
+
+	// latest, safe or finalized, how final the L1 blocks the syncer reads must be
+	L1Finality string
+
+	// rebuild the blocks from the batches sequenced on L1 rather than reading them from the datastream
+	L1SyncOnly bool
+
+	// read the accumulated input hashes and what the batches were sequenced with from L1, for the
+	// zkevm_getL1BatchMetadata and zkevm_getFirstAccInputHashMismatch methods
+	L1BatchMetadata bool
+
+	// with several endpoints in L1RpcUrl, the share of the requests each is sent, and the requests per second each is
+	// sent at most
+	L1RpcWeights   []uint64
+	L1RpcRateLimit float64
+
+	// version of the entries of a new data stream file
+	DataStreamVersion uint8
+
 	RebuildTreeAfter uint64
+	// memory the smt regeneration sorts the leaves in before spilling them to disk
+	SmtRegenerateBufferSize datasize.ByteSize
+	// memory for the smt nodes cached across the stage runs and shared with the rpc
+	SmtNodeCacheSize datasize.ByteSize
+	// how many blocks back the smt can be read at, the history of the tree before that is pruned
+	SmtHistoryBlocks uint64
+}
+
+// L1RpcUrls returns the endpoints of L1RpcUrl, the comma separated urls are trimmed and the empty ones left out
+func (c *Zk) L1RpcUrls() []string {
+	urls := make([]string, 0)
+	for _, url := range strings.Split(c.L1RpcUrl, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
 }
 
 type Sync struct {
@@ -0,0 +1,15 @@
+package ethconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestZk_L1RpcUrls(t *testing.T) {
+	cfg := &Zk{L1RpcUrl: " http://a:8545, ,http://b:8545 ,"}
+	require.Equal(t, []string{"http://a:8545", "http://b:8545"}, cfg.L1RpcUrls())
+
+	cfg.L1RpcUrl = ""
+	require.Empty(t, cfg.L1RpcUrls())
+}
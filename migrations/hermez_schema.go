@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/tenderly/zkevm-erigon-lib/common/datadir"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+)
+
+// hermezSchemaMigrations run the migrations of the hermez tables, after the ones of the erigon tables
+var hermezSchemaMigrations = hermezMigrations(hermez_db.SchemaMigrations)
+
+func hermezMigrations(schemaMigrations []hermez_db.SchemaMigration) []Migration {
+	migrations := make([]Migration, 0, len(schemaMigrations))
+	for _, m := range schemaMigrations {
+		migrations = append(migrations, hermezMigration(m))
+	}
+	return migrations
+}
+
+// hermezMigration applies a hermez schema migration in a single transaction, along with the record of it being applied
+func hermezMigration(m hermez_db.SchemaMigration) Migration {
+	return Migration{
+		Name: m.Name,
+		Up: func(db kv.RwDB, dirs datadir.Dirs, progress []byte, BeforeCommit Callback) (err error) {
+			tx, err := db.BeginRw(context.Background())
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := m.Apply(tx); err != nil {
+				return err
+			}
+
+			if err := BeforeCommit(tx, nil, true); err != nil {
+				return err
+			}
+			return tx.Commit()
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon-lib/kv/memdb"
+
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+)
+
+func TestHermezSchemaMigrations(t *testing.T) {
+	require, db := require.New(t), memdb.NewTestDB(t)
+
+	migrator := NewMigrator(kv.ChainDB)
+	migrator.Migrations = hermezSchemaMigrations
+	require.NoError(migrator.Apply(db, t.TempDir()))
+
+	err := db.View(context.Background(), func(tx kv.Tx) error {
+		version, err := hermez_db.ReadSchemaVersion(tx)
+		require.NoError(err)
+		require.Equal(hermez_db.SchemaVersion, version)
+		return nil
+	})
+	require.NoError(err)
+
+	// a database written by a newer node is refused
+	err = db.Update(context.Background(), func(tx kv.RwTx) error {
+		return hermez_db.WriteSchemaVersion(tx, hermez_db.SchemaVersion+1)
+	})
+	require.NoError(err)
+	require.Error(migrator.VerifyVersion(db))
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/tenderly/zkevm-erigon-lib/common/datadir"
 	"github.com/tenderly/zkevm-erigon-lib/kv"
 	"github.com/tenderly/zkevm-erigon/common"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 )
 
 // migrations apply sequentially in order of this array, skips applied migrations
@@ -31,11 +32,11 @@ import (
 //   - if you need migrate multiple buckets - create separate migration for each bucket
 //   - write test - and check that it's safe to apply same migration twice
 var migrations = map[kv.Label][]Migration{
-	kv.ChainDB: {
+	kv.ChainDB: append([]Migration{
 		dbSchemaVersion5,
 		txsBeginEnd,
 		resetBlocks4,
-	},
+	}, hermezSchemaMigrations...),
 	kv.TxPoolDB: {},
 	kv.SentryDB: {},
 }
@@ -145,7 +146,8 @@ func (m *Migrator) VerifyVersion(db kv.RwDB) error {
 				}
 			}
 		}
-		return nil
+		// a node refuses the hermez tables written by a newer one too
+		return hermez_db.VerifySchemaVersion(tx)
 	}); err != nil {
 		return fmt.Errorf("migrator.VerifyVersion: %w", err)
 	}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/big"
+
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+var ErrReadOnly = errors.New("smt db is read only")
+
+// the block the history was last pruned before is kept along with the last root
+var historyPrunedBeforeKey = []byte("historyPrunedBefore")
+
+// EriRoDb is a read only view of the tree at a past root. Nodes are content addressed and are only ever removed
+// into the history tables, so a node of any past root is either still in the tree or in the history.
+type EriRoDb struct {
+	tx   kv.Getter
+	root *big.Int
+
+	nodeCache *NodeCache
+}
+
+func NewEriRoDb(tx kv.Getter, root *big.Int) *EriRoDb {
+	return &EriRoDb{
+		tx:   tx,
+		root: root,
+	}
+}
+
+// SetNodeCache makes the db read the nodes of the tree through a cache shared with other dbs. Only the nodes that
+// are still in the tree are added to it, the ones read from the history are not.
+func (m *EriRoDb) SetNodeCache(c *NodeCache) {
+	m.nodeCache = c
+}
+
+func (m *EriRoDb) Get(key utils.NodeKey) (utils.NodeValue12, error) {
+	if v, ok := m.nodeCache.Get(key); ok {
+		return v, nil
+	}
+
+	keyConc := utils.ArrayToScalar(key[:])
+	k := utils.ConvertBigIntToHex(keyConc)
+
+	data, err := m.tx.GetOne(TableSmt, []byte(k))
+	if err != nil {
+		return utils.NodeValue12{}, err
+	}
+
+	inTree := data != nil
+	if !inTree {
+		if data, err = m.tx.GetOne(TableHistory, []byte(k)); err != nil {
+			return utils.NodeValue12{}, err
+		}
+		if len(data) <= 8 {
+			return utils.NodeValue12{}, nil
+		}
+		data = data[8:]
+	}
+
+	vConc := utils.ConvertHexToBigInt(string(data))
+	val := utils.ScalarToNodeValue(vConc)
+	if inTree {
+		m.nodeCache.Add(key, val)
+	}
+
+	return val, nil
+}
+
+func (m *EriRoDb) GetLastRoot() (*big.Int, error) {
+	return new(big.Int).Set(m.root), nil
+}
+
+// GetAccountValue always fails, the account values table only holds the latest values
+func (m *EriRoDb) GetAccountValue(key utils.NodeKey) (utils.NodeValue8, error) {
+	return utils.NodeValue8{}, errors.New("account values are not kept for past roots")
+}
+
+func (m *EriRoDb) Insert(key utils.NodeKey, value utils.NodeValue12) error {
+	return ErrReadOnly
+}
+
+func (m *EriRoDb) InsertAccountValue(key utils.NodeKey, value utils.NodeValue8) error {
+	return ErrReadOnly
+}
+
+func (m *EriRoDb) Delete(key utils.NodeKey) error {
+	return ErrReadOnly
+}
+
+func (m *EriRoDb) DeleteAccountValue(key utils.NodeKey) error {
+	return ErrReadOnly
+}
+
+func (m *EriRoDb) SetLastRoot(lr *big.Int) error {
+	return ErrReadOnly
+}
+
+func (m *EriRoDb) OpenBatch(quitCh <-chan struct{}) {
+}
+
+func (m *EriRoDb) CommitBatch() error {
+	return nil
+}
+
+func (m *EriRoDb) RollbackBatch() {
+}
+
+// PruneHistory drops the nodes removed from the tree before the given block, after this the tree can only be
+// read at the roots of blocks from beforeBlock onwards
+func (m *EriDb) PruneHistory(beforeBlock uint64) error {
+	journal := make([][]byte, 0)
+	err := m.tx.ForEach(TableHistoryJournal, []byte{}, func(k, v []byte) error {
+		if binary.BigEndian.Uint64(k[:8]) < beforeBlock {
+			journal = append(journal, common.Copy(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, jk := range journal {
+		nodeKey := jk[8:]
+
+		// the node may have been brought back and removed again in a later block
+		data, err := m.tx.GetOne(TableHistory, nodeKey)
+		if err != nil {
+			return err
+		}
+		if data != nil && binary.BigEndian.Uint64(data[:8]) < beforeBlock {
+			if err := m.tx.Delete(TableHistory, nodeKey); err != nil {
+				return err
+			}
+		}
+
+		if err := m.tx.Delete(TableHistoryJournal, jk); err != nil {
+			return err
+		}
+	}
+
+	prunedBefore, err := GetHistoryPrunedBefore(m.tx)
+	if err != nil {
+		return err
+	}
+	if beforeBlock > prunedBefore {
+		return m.tx.Put(TableLastRoot, historyPrunedBeforeKey, historyBlockKey(beforeBlock))
+	}
+	return nil
+}
+
+// GetHistoryPrunedBefore returns the block the history was last pruned before, 0 if it never was. The tree can be
+// read at the roots of the blocks from the one before it onwards.
+func GetHistoryPrunedBefore(tx interface {
+	GetOne(bucket string, key []byte) ([]byte, error)
+}) (uint64, error) {
+	v, err := tx.GetOne(TableLastRoot, historyPrunedBeforeKey)
+	if err != nil || len(v) < 8 {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// historyBlockKey encodes the block the nodes were removed at, big endian so the journal is ordered by block
+func historyBlockKey(blockNo uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, blockNo)
+	return k
+}
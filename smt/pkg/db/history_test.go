@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/kv/mdbx"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func TestEriDbHistory(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	require.NoError(t, err)
+	tx, err := dbi.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, CreateEriDbBuckets(tx))
+
+	db := NewEriDb(tx)
+
+	key := utils.NodeKey{1, 2, 3, 4}
+	value := utils.NodeValue12{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5), big.NewInt(6),
+		big.NewInt(7), big.NewInt(8), big.NewInt(9), big.NewInt(10), big.NewInt(11), big.NewInt(12)}
+	root := big.NewInt(42)
+
+	require.NoError(t, db.Insert(key, value))
+
+	db.SetBlockNumber(5)
+	require.NoError(t, db.Delete(key))
+
+	live, err := db.Get(key)
+	require.NoError(t, err)
+	assert.Nil(t, live[0])
+
+	// the root of block 4
+	rodb := NewEriRoDb(tx, root)
+	r, err := rodb.GetLastRoot()
+	require.NoError(t, err)
+	assert.Equal(t, root, r)
+
+	old, err := rodb.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, value, old)
+	assert.Equal(t, ErrReadOnly, rodb.Insert(key, value))
+
+	// history from block 5 on is still needed
+	require.NoError(t, db.PruneHistory(5))
+	old, err = rodb.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, value, old)
+
+	require.NoError(t, db.PruneHistory(6))
+	old, err = rodb.Get(key)
+	require.NoError(t, err)
+	assert.Nil(t, old[0])
+
+	// the history is only ever pruned further
+	prunedBefore, err := GetHistoryPrunedBefore(tx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), prunedBefore)
+	require.NoError(t, db.PruneHistory(3))
+	prunedBefore, err = GetHistoryPrunedBefore(tx)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), prunedBefore)
+}
+
+func TestEriDbHistory_RemovedAgain(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	require.NoError(t, err)
+	tx, err := dbi.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, CreateEriDbBuckets(tx))
+
+	db := NewEriDb(tx)
+
+	key := utils.NodeKey{1, 2, 3, 4}
+	value := utils.NodeValue12{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5), big.NewInt(6),
+		big.NewInt(7), big.NewInt(8), big.NewInt(9), big.NewInt(10), big.NewInt(11), big.NewInt(12)}
+
+	for _, blockNo := range []uint64{5, 10} {
+		require.NoError(t, db.Insert(key, value))
+		db.SetBlockNumber(blockNo)
+		require.NoError(t, db.Delete(key))
+	}
+
+	// the journal entry of block 5 must not drop the node removed again at block 10
+	require.NoError(t, db.PruneHistory(8))
+
+	old, err := NewEriRoDb(tx, big.NewInt(1)).Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, value, old)
+}
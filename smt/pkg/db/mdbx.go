@@ -27,10 +27,21 @@ const TableSmt = "HermezSmt"
 const TableLastRoot = "HermezSmtLastRoot"
 const TableAccountValues = "HermezSmtAccountValues"
 const TableMetadata = "HermezSmtMetadata"
+const TableHistory = "HermezSmtHistory"               // nodeKey -> block removed at ++ node value
+const TableHistoryJournal = "HermezSmtHistoryJournal" // block removed at ++ nodeKey -> nil
 
 type EriDb struct {
 	kvTx kv.RwTx
 	tx   SmtDbTx
+
+	// block the nodes removed from the tree are journaled under
+	blockNo uint64
+
+	nodeCache *NodeCache
+	// nodes added to the node cache since the batch was opened, they are dropped from it if the batch is rolled back.
+	// Once the batch cached more nodes than the cache holds they aren't tracked anymore and the whole cache is dropped.
+	batchCached    []utils.NodeKey
+	batchCachedAll bool
 }
 
 func CreateEriDbBuckets(tx kv.RwTx) error {
@@ -49,6 +60,16 @@ func CreateEriDbBuckets(tx kv.RwTx) error {
 		return err
 	}
 
+	err = tx.CreateBucket(TableHistory)
+	if err != nil {
+		return err
+	}
+
+	err = tx.CreateBucket(TableHistoryJournal)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -66,6 +87,37 @@ func (m *EriDb) OpenBatch(quitCh <-chan struct{}) {
 		batch.Rollback()
 	}()
 	m.tx = batch
+	m.resetBatchCached()
+}
+
+// SetNodeCache makes the db read and write the nodes of the tree through a cache shared with other dbs
+func (m *EriDb) SetNodeCache(c *NodeCache) {
+	m.nodeCache = c
+}
+
+func (m *EriDb) inBatch() bool {
+	_, ok := m.tx.(ethdb.DbWithPendingMutations)
+	return ok
+}
+
+func (m *EriDb) cacheNode(key utils.NodeKey, value utils.NodeValue12) {
+	if m.nodeCache == nil {
+		return
+	}
+	m.nodeCache.Add(key, value)
+	if !m.inBatch() || m.batchCachedAll {
+		return
+	}
+	if len(m.batchCached) >= m.nodeCache.Cap() {
+		m.batchCached, m.batchCachedAll = nil, true
+		return
+	}
+	m.batchCached = append(m.batchCached, key)
+}
+
+func (m *EriDb) resetBatchCached() {
+	m.batchCached = m.batchCached[:0]
+	m.batchCachedAll = false
 }
 
 func (m *EriDb) CommitBatch() error {
@@ -78,6 +130,7 @@ func (m *EriDb) CommitBatch() error {
 		return err
 	}
 	m.tx = m.kvTx
+	m.resetBatchCached()
 	return nil
 }
 
@@ -87,6 +140,20 @@ func (m *EriDb) RollbackBatch() {
 	}
 	m.tx.Rollback()
 	m.tx = m.kvTx
+
+	// the nodes may only have been written by the batch
+	if m.batchCachedAll {
+		m.nodeCache.Purge()
+	}
+	for _, key := range m.batchCached {
+		m.nodeCache.Remove(key)
+	}
+	m.resetBatchCached()
+}
+
+// SetBlockNumber sets the block the nodes removed from now on are journaled under
+func (m *EriDb) SetBlockNumber(blockNo uint64) {
+	m.blockNo = blockNo
 }
 
 func (m *EriDb) GetLastRoot() (*big.Int, error) {
@@ -108,6 +175,10 @@ func (m *EriDb) SetLastRoot(r *big.Int) error {
 }
 
 func (m *EriDb) Get(key utils.NodeKey) (utils.NodeValue12, error) {
+	if v, ok := m.nodeCache.Get(key); ok {
+		return v, nil
+	}
+
 	keyConc := utils.ArrayToScalar(key[:])
 	k := utils.ConvertBigIntToHex(keyConc)
 
@@ -122,6 +193,7 @@ func (m *EriDb) Get(key utils.NodeKey) (utils.NodeValue12, error) {
 
 	vConc := utils.ConvertHexToBigInt(string(data))
 	val := utils.ScalarToNodeValue(vConc)
+	m.cacheNode(key, val)
 
 	return val, nil
 }
@@ -138,7 +210,11 @@ func (m *EriDb) Insert(key utils.NodeKey, value utils.NodeValue12) error {
 	vConc := utils.ArrayToScalarBig(vals[:])
 	v := utils.ConvertBigIntToHex(vConc)
 
-	return m.tx.Put(TableSmt, []byte(k), []byte(v))
+	if err := m.tx.Put(TableSmt, []byte(k), []byte(v)); err != nil {
+		return err
+	}
+	m.cacheNode(key, value)
+	return nil
 }
 
 func (m *EriDb) GetAccountValue(key utils.NodeKey) (utils.NodeValue8, error) {
@@ -175,8 +251,36 @@ func (m *EriDb) InsertAccountValue(key utils.NodeKey, value utils.NodeValue8) er
 	return m.tx.Put(TableAccountValues, []byte(k), []byte(v))
 }
 
-func (m *EriDb) Delete(key string) error {
-	return m.tx.Delete(TableSmt, []byte(key))
+// Delete removes the node from the tree. The node is kept in the history tables under the current block
+// so the tree can still be read at the roots of older blocks, see EriRoDb.
+func (m *EriDb) Delete(key utils.NodeKey) error {
+	m.nodeCache.Remove(key)
+
+	keyConc := utils.ArrayToScalar(key[:])
+	k := utils.ConvertBigIntToHex(keyConc)
+
+	data, err := m.tx.GetOne(TableSmt, []byte(k))
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return nil
+	}
+
+	if err := m.tx.Put(TableHistory, []byte(k), append(historyBlockKey(m.blockNo), data...)); err != nil {
+		return err
+	}
+	if err := m.tx.Put(TableHistoryJournal, append(historyBlockKey(m.blockNo), k...), []byte{}); err != nil {
+		return err
+	}
+
+	return m.tx.Delete(TableSmt, []byte(k))
+}
+
+func (m *EriDb) DeleteAccountValue(key utils.NodeKey) error {
+	keyConc := utils.ArrayToScalar(key[:])
+	k := utils.ConvertBigIntToHex(keyConc)
+	return m.tx.Delete(TableAccountValues, []byte(k))
 }
 
 func (m *EriDb) PrintDb() {
@@ -112,11 +112,25 @@ func (m *MemDb) InsertAccountValue(key utils.NodeKey, value utils.NodeValue8) er
 	return nil
 }
 
-func (m *MemDb) Delete(key string) error {
+func (m *MemDb) Delete(key utils.NodeKey) error {
 	m.lock.Lock()         // Lock for writing
 	defer m.lock.Unlock() // Make sure to unlock when done
 
-	delete(m.Db, key)
+	keyConc := utils.ArrayToScalar(key[:])
+	k := utils.ConvertBigIntToHex(keyConc)
+
+	delete(m.Db, k)
+	return nil
+}
+
+func (m *MemDb) DeleteAccountValue(key utils.NodeKey) error {
+	m.lock.Lock()         // Lock for writing
+	defer m.lock.Unlock() // Make sure to unlock when done
+
+	keyConc := utils.ArrayToScalar(key[:])
+	k := utils.ConvertBigIntToHex(keyConc)
+
+	delete(m.DbAccVal, k)
 	return nil
 }
 
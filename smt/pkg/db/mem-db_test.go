@@ -26,3 +26,22 @@ func TestMemDb(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, value, retrievedValue)
 }
+
+func TestMemDbDelete(t *testing.T) {
+	db := NewMemDb()
+
+	key := utils.NodeKey{1, 2, 3, 4}
+	value := utils.NodeValue12{big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5), big.NewInt(6),
+		big.NewInt(7), big.NewInt(8), big.NewInt(9), big.NewInt(10), big.NewInt(11), big.NewInt(12)}
+
+	err := db.Insert(key, value)
+	assert.NoError(t, err)
+
+	err = db.Delete(key)
+	assert.NoError(t, err)
+
+	retrievedValue, err := db.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.NodeValue12{}, retrievedValue)
+	assert.True(t, db.IsEmpty())
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"math/big"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/c2h5oh/datasize"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+// approximate memory used by a cached node: the key, the value and the lru bookkeeping
+const nodeCacheEntrySize = 4*8 + 12*8 + 96
+
+var (
+	nodeCacheHits   = metrics.GetOrCreateCounter(`smt_node_cache_hits`)
+	nodeCacheMisses = metrics.GetOrCreateCounter(`smt_node_cache_misses`)
+)
+
+// NodeCache keeps the most recently used nodes of the tree in memory. It outlives a single EriDb, so it can be shared
+// between the stage runs and the rpc readers, and it is safe for concurrent use.
+//
+// Nodes are keyed by the hash of their value, so a cached node is never stale: a node written by a transaction that
+// is later rolled back still has the right value. The only thing to take care of is that a node removed from the
+// tree isn't served from the cache anymore, EriDb removes nodes from the cache when it deletes them and drops the
+// nodes it cached while a batch was open when the batch is rolled back.
+//
+// A nil *NodeCache is valid and caches nothing.
+type NodeCache struct {
+	cache *lru.Cache[utils.NodeKey, [12]uint64]
+	size  int
+}
+
+// NewNodeCache creates a cache using about size bytes, nil if the size is too small to hold a node
+func NewNodeCache(size datasize.ByteSize) *NodeCache {
+	entries := int(size.Bytes() / nodeCacheEntrySize)
+	if entries <= 0 {
+		return nil
+	}
+	// lru.New only fails for a size that isn't positive
+	cache, _ := lru.New[utils.NodeKey, [12]uint64](entries)
+	return &NodeCache{cache: cache, size: entries}
+}
+
+func (c *NodeCache) Get(key utils.NodeKey) (utils.NodeValue12, bool) {
+	if c == nil {
+		return utils.NodeValue12{}, false
+	}
+	v, ok := c.cache.Get(key)
+	if !ok {
+		nodeCacheMisses.Inc()
+		return utils.NodeValue12{}, false
+	}
+	nodeCacheHits.Inc()
+
+	var res utils.NodeValue12
+	for i := range v {
+		res[i] = new(big.Int).SetUint64(v[i])
+	}
+	return res, true
+}
+
+func (c *NodeCache) Add(key utils.NodeKey, value utils.NodeValue12) {
+	if c == nil {
+		return
+	}
+	var v [12]uint64
+	for i := range value {
+		if value[i] != nil {
+			v[i] = value[i].Uint64()
+		}
+	}
+	c.cache.Add(key, v)
+}
+
+func (c *NodeCache) Remove(key utils.NodeKey) {
+	if c == nil {
+		return
+	}
+	c.cache.Remove(key)
+}
+
+// Purge drops every node from the cache
+func (c *NodeCache) Purge() {
+	if c == nil {
+		return
+	}
+	c.cache.Purge()
+}
+
+func (c *NodeCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	return c.cache.Len()
+}
+
+// Cap is the number of nodes the cache holds
+func (c *NodeCache) Cap() int {
+	if c == nil {
+		return 0
+	}
+	return c.size
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/c2h5oh/datasize"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/kv/mdbx"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func testNodeValue(base int64) utils.NodeValue12 {
+	var v utils.NodeValue12
+	for i := range v {
+		v[i] = big.NewInt(base + int64(i))
+	}
+	return v
+}
+
+func assertNodeValue(t *testing.T, expected, actual utils.NodeValue12) {
+	t.Helper()
+	assert.Equal(t, 0, expected.ToBigInt().Cmp(actual.ToBigInt()), "expected %v, got %v", expected, actual)
+}
+
+func TestNodeCache(t *testing.T) {
+	assert.Nil(t, NewNodeCache(0))
+	assert.Nil(t, NewNodeCache(nodeCacheEntrySize-1))
+
+	// a nil cache caches nothing
+	var nilCache *NodeCache
+	nilCache.Add(utils.NodeKey{1}, testNodeValue(1))
+	_, ok := nilCache.Get(utils.NodeKey{1})
+	assert.False(t, ok)
+
+	c := NewNodeCache(2 * nodeCacheEntrySize)
+	require.NotNil(t, c)
+	assert.Equal(t, 2, c.Cap())
+
+	c.Add(utils.NodeKey{1}, testNodeValue(1))
+	c.Add(utils.NodeKey{2}, testNodeValue(2))
+	c.Add(utils.NodeKey{3}, testNodeValue(3))
+	assert.Equal(t, 2, c.Len())
+
+	_, ok = c.Get(utils.NodeKey{1})
+	assert.False(t, ok, "the oldest node should have been evicted")
+	v, ok := c.Get(utils.NodeKey{3})
+	require.True(t, ok)
+	assertNodeValue(t, testNodeValue(3), v)
+
+	// the returned value is a copy
+	v[0].SetInt64(100)
+	v, _ = c.Get(utils.NodeKey{3})
+	assertNodeValue(t, testNodeValue(3), v)
+
+	c.Remove(utils.NodeKey{3})
+	_, ok = c.Get(utils.NodeKey{3})
+	assert.False(t, ok)
+
+	c.Purge()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestEriDbNodeCache(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	require.NoError(t, err)
+	tx, err := dbi.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, CreateEriDbBuckets(tx))
+
+	cache := NewNodeCache(datasize.MB)
+	db := NewEriDb(tx)
+	db.SetNodeCache(cache)
+
+	key := utils.NodeKey{1, 2, 3, 4}
+	value := testNodeValue(1)
+	require.NoError(t, db.Insert(key, value))
+
+	// another db sharing the cache is served from it even without the node in its tx
+	other := NewEriDb(tx)
+	other.SetNodeCache(cache)
+	require.NoError(t, tx.Delete(TableSmt, []byte(utils.ConvertBigIntToHex(utils.ArrayToScalar(key[:])))))
+	v, err := other.Get(key)
+	require.NoError(t, err)
+	assertNodeValue(t, value, v)
+
+	// deleting the node drops it from the cache
+	require.NoError(t, db.Insert(key, value))
+	require.NoError(t, db.Delete(key))
+	_, ok := cache.Get(key)
+	assert.False(t, ok)
+	v, err = other.Get(key)
+	require.NoError(t, err)
+	assert.Nil(t, v[0])
+
+	// reading a node caches it
+	require.NoError(t, NewEriDb(tx).Insert(key, value))
+	_, err = db.Get(key)
+	require.NoError(t, err)
+	_, ok = cache.Get(key)
+	assert.True(t, ok)
+
+	// nodes cached by a rolled back batch are dropped
+	quit := make(chan struct{})
+	batchKey := utils.NodeKey{5, 6, 7, 8}
+	db.OpenBatch(quit)
+	require.NoError(t, db.Insert(batchKey, testNodeValue(5)))
+	_, ok = cache.Get(batchKey)
+	assert.True(t, ok)
+	db.RollbackBatch()
+	_, ok = cache.Get(batchKey)
+	assert.False(t, ok)
+	_, ok = cache.Get(key)
+	assert.True(t, ok, "nodes cached before the batch are kept")
+
+	// and kept if it's committed
+	db.OpenBatch(quit)
+	require.NoError(t, db.Insert(batchKey, testNodeValue(5)))
+	require.NoError(t, db.CommitBatch())
+	_, ok = cache.Get(batchKey)
+	assert.True(t, ok)
+}
+
+func TestEriDbNodeCache_RollbackLargeBatch(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	require.NoError(t, err)
+	tx, err := dbi.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, CreateEriDbBuckets(tx))
+
+	cache := NewNodeCache(4 * nodeCacheEntrySize)
+	db := NewEriDb(tx)
+	db.SetNodeCache(cache)
+
+	quit := make(chan struct{})
+	db.OpenBatch(quit)
+	for i := uint64(1); i <= 10; i++ {
+		require.NoError(t, db.Insert(utils.NodeKey{i}, testNodeValue(int64(i))))
+	}
+	db.RollbackBatch()
+
+	// more nodes than the cache holds were cached by the batch, so the whole cache is dropped
+	assert.Equal(t, 0, cache.Len())
+}
+
+func TestEriRoDbNodeCache(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	require.NoError(t, err)
+	tx, err := dbi.BeginRw(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	require.NoError(t, CreateEriDbBuckets(tx))
+
+	db := NewEriDb(tx)
+	live, removed := utils.NodeKey{1}, utils.NodeKey{2}
+	require.NoError(t, db.Insert(live, testNodeValue(1)))
+	require.NoError(t, db.Insert(removed, testNodeValue(2)))
+	require.NoError(t, db.Delete(removed))
+
+	cache := NewNodeCache(datasize.MB)
+	rodb := NewEriRoDb(tx, big.NewInt(0))
+	rodb.SetNodeCache(cache)
+
+	v, err := rodb.Get(live)
+	require.NoError(t, err)
+	assertNodeValue(t, testNodeValue(1), v)
+	v, err = rodb.Get(removed)
+	require.NoError(t, err)
+	assertNodeValue(t, testNodeValue(2), v)
+
+	// only the node still in the tree is cached
+	_, ok := cache.Get(live)
+	assert.True(t, ok)
+	_, ok = cache.Get(removed)
+	assert.False(t, ok)
+}
@@ -0,0 +1,207 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+// LeafDiff is a leaf that differs between two roots. Old and New are the values of the leaf under each root, zero
+// where the leaf isn't in the tree.
+type LeafDiff struct {
+	Key utils.NodeKey
+	Old *big.Int
+	New *big.Int
+}
+
+func (d LeafDiff) String() string {
+	return fmt.Sprintf("%s: %s -> %s", utils.ConvertBigIntToHex(d.Key.ToBigInt()), d.Old.String(), d.New.String())
+}
+
+// Diff returns the leaves whose value differs between rootA and rootB, ordered by their path in the tree. Both trees
+// are read from the db, so it has to hold the nodes of both roots, see EriRoDb for reading past roots. Only the
+// subtrees whose hashes differ are walked.
+func (s *SMT) Diff(ctx context.Context, rootA, rootB utils.NodeKey) ([]LeafDiff, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	diffs := make([]LeafDiff, 0)
+	if err := s.diffNodes(ctx, rootA, rootB, make([]int, 0, 256), &diffs); err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+func (s *SMT) diffNodes(ctx context.Context, a, b utils.NodeKey, path []int, diffs *[]LeafDiff) error {
+	if a == b {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	nodeA, err := s.diffNode(a)
+	if err != nil {
+		return err
+	}
+	nodeB, err := s.diffNode(b)
+	if err != nil {
+		return err
+	}
+
+	// both are branches, only the children that differ are walked
+	if nodeA != nil && nodeB != nil && !nodeA.IsFinalNode() && !nodeB.IsFinalNode() {
+		for bit := 0; bit < 2; bit++ {
+			childA := utils.NodeKeyFromBigIntArray(nodeA[bit*4 : bit*4+4])
+			childB := utils.NodeKeyFromBigIntArray(nodeB[bit*4 : bit*4+4])
+			if err := s.diffNodes(ctx, childA, childB, append(path, bit), diffs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// one of the sides is empty or a single leaf, so all the leaves below the other side differ but maybe that one,
+	// they are compared directly
+	leavesA := make(map[utils.NodeKey]*big.Int)
+	if err := s.diffLeaves(ctx, nodeA, path, leavesA); err != nil {
+		return err
+	}
+	leavesB := make(map[utils.NodeKey]*big.Int)
+	if err := s.diffLeaves(ctx, nodeB, path, leavesB); err != nil {
+		return err
+	}
+
+	changed := make([]LeafDiff, 0, len(leavesA)+len(leavesB))
+	for k, old := range leavesA {
+		value, ok := leavesB[k]
+		if !ok {
+			value = big.NewInt(0)
+		}
+		if old.Cmp(value) != 0 {
+			changed = append(changed, LeafDiff{Key: k, Old: old, New: value})
+		}
+	}
+	for k, value := range leavesB {
+		if _, ok := leavesA[k]; !ok {
+			changed = append(changed, LeafDiff{Key: k, Old: big.NewInt(0), New: value})
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return comparePaths(changed[i].Key.GetPath(), changed[j].Key.GetPath()) < 0
+	})
+	*diffs = append(*diffs, changed...)
+
+	return nil
+}
+
+// diffNode reads a node of one of the compared trees, nil for the empty tree
+func (s *SMT) diffNode(nodeKey utils.NodeKey) (*utils.NodeValue12, error) {
+	if nodeKey.IsZero() {
+		return nil, nil
+	}
+	node, err := s.Db.Get(nodeKey)
+	if err != nil {
+		return nil, err
+	}
+	if node[8] == nil {
+		return nil, fmt.Errorf("node %s not found", utils.ConvertBigIntToHex(nodeKey.ToBigInt()))
+	}
+	return &node, nil
+}
+
+// diffLeaves adds the leaves of the subtree of node at path to leaves
+func (s *SMT) diffLeaves(ctx context.Context, node *utils.NodeValue12, path []int, leaves map[utils.NodeKey]*big.Int) error {
+	if node == nil {
+		return nil
+	}
+
+	if node.IsFinalNode() {
+		valueHash := *node.Get4to8()
+		value, err := s.Db.Get(valueHash)
+		if err != nil {
+			return err
+		}
+		if value[8] == nil {
+			return fmt.Errorf("leaf value %s not found", utils.ConvertBigIntToHex(valueHash.ToBigInt()))
+		}
+		leaves[*utils.JoinKey(path, *node.Get0to4())] = utils.ArrayBigToScalar(value[0:8])
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	for bit := 0; bit < 2; bit++ {
+		child, err := s.diffNode(utils.NodeKeyFromBigIntArray(node[bit*4 : bit*4+4]))
+		if err != nil {
+			return err
+		}
+		if err := s.diffLeaves(ctx, child, append(path, bit), leaves); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LeafField is the account field a leaf of the tree holds
+type LeafField struct {
+	Address libcommon.Address
+	Field   string
+}
+
+func (f LeafField) String() string {
+	return fmt.Sprintf("%s %s", f.Address.Hex(), f.Field)
+}
+
+// KeyResolver maps the keys of the tree back to the account fields they hold. The keys are hashes of the address
+// and the field, so only the keys of the accounts and storage slots added to the resolver are known.
+type KeyResolver struct {
+	fields map[utils.NodeKey]LeafField
+}
+
+func NewKeyResolver() *KeyResolver {
+	return &KeyResolver{fields: make(map[utils.NodeKey]LeafField)}
+}
+
+// AddAccount adds the keys of the balance, nonce and code fields of the account
+func (r *KeyResolver) AddAccount(addr libcommon.Address) error {
+	for c, name := range map[int]string{
+		utils.KEY_BALANCE: "balance",
+		utils.KEY_NONCE:   "nonce",
+		utils.SC_CODE:     "code hash",
+		utils.SC_LENGTH:   "code length",
+	} {
+		k, err := utils.Key(addr.String(), c)
+		if err != nil {
+			return err
+		}
+		r.fields[k] = LeafField{Address: addr, Field: name}
+	}
+	return nil
+}
+
+// AddStorage adds the key of a storage slot of the account, storageKey is formatted as in SetContractStorage
+func (r *KeyResolver) AddStorage(addr libcommon.Address, storageKey string) error {
+	k, err := utils.KeyContractStorage(utils.ScalarToArrayBig(utils.ConvertHexToBigInt(addr.String())), storageKey)
+	if err != nil {
+		return err
+	}
+	r.fields[k] = LeafField{Address: addr, Field: "storage " + storageKey}
+	return nil
+}
+
+func (r *KeyResolver) Resolve(k utils.NodeKey) (LeafField, bool) {
+	f, ok := r.fields[k]
+	return f, ok
+}
@@ -0,0 +1,140 @@
+package smt
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv/mdbx"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func TestSMT_Diff(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := dbi.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := db.CreateEriDbBuckets(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	eridb := db.NewEriDb(tx)
+	eridb.SetBlockNumber(1)
+	s := NewSMT(eridb)
+	for i := 1; i <= 100; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i*10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootA := utils.ScalarToRoot(s.LastRoot())
+	eridb.SetBlockNumber(2)
+
+	expected := map[utils.NodeKey][2]int64{}
+	change := func(k, old, new int64) {
+		if _, err := s.InsertBI(big.NewInt(k), big.NewInt(new)); err != nil {
+			t.Fatal(err)
+		}
+		expected[utils.ScalarToNodeKey(big.NewInt(k))] = [2]int64{old, new}
+	}
+	change(5, 50, 55)
+	change(64, 640, 0)
+	change(1000, 0, 1)
+	change(1001, 0, 2)
+	rootB := utils.ScalarToRoot(s.LastRoot())
+
+	// the nodes of rootA replaced by the changes are only in the history
+	s = NewSMT(db.NewEriRoDb(tx, s.LastRoot()))
+
+	for _, roots := range [][2]utils.NodeKey{{rootA, rootB}, {rootB, rootA}} {
+		diffs, err := s.Diff(context.Background(), roots[0], roots[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diffs) != len(expected) {
+			t.Fatalf("expected %d changed leaves, got %v", len(expected), diffs)
+		}
+		for i, d := range diffs {
+			want, ok := expected[d.Key]
+			if roots[0] == rootB {
+				want[0], want[1] = want[1], want[0]
+			}
+			if !ok || d.Old.Int64() != want[0] || d.New.Int64() != want[1] {
+				t.Errorf("unexpected change %v", d)
+			}
+			if i > 0 && comparePaths(diffs[i-1].Key.GetPath(), d.Key.GetPath()) >= 0 {
+				t.Errorf("changes are not ordered by path")
+			}
+		}
+	}
+
+	// a diff against the empty tree has every leaf
+	diffs, err := s.Diff(context.Background(), utils.NodeKey{}, rootA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 100 {
+		t.Fatalf("expected 100 leaves, got %d", len(diffs))
+	}
+
+	diffs, err = s.Diff(context.Background(), rootA, rootA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("expected no changes, got %v", diffs)
+	}
+
+	if _, err := s.Diff(context.Background(), rootA, utils.NodeKey{1, 2, 3, 4}); err == nil {
+		t.Fatal("expected an error for a root that isn't in the db")
+	}
+}
+
+func TestKeyResolver(t *testing.T) {
+	addr := libcommon.HexToAddress("0x1234567890123456789012345678901234567890")
+	storageKey := "0x0000000000000000000000000000000000000000000000000000000000000001"
+
+	r := NewKeyResolver()
+	if err := r.AddAccount(addr); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddStorage(addr, storageKey); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSMT(nil)
+	if _, err := s.SetAccountState(addr.String(), big.NewInt(1), big.NewInt(2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetContractStorage(addr.String(), map[string]string{storageKey: "0x5"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]bool{}
+	_, err := s.CheckNodes(context.Background(), utils.ScalarToRoot(s.LastRoot()), func(k utils.NodeKey, v utils.NodeValue8) error {
+		f, ok := r.Resolve(k)
+		if !ok || f.Address != addr {
+			t.Errorf("leaf %v not resolved", k)
+		}
+		fields[f.Field] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"balance", "nonce", "storage " + storageKey} {
+		if !fields[field] {
+			t.Errorf("field %s not found in %v", field, fields)
+		}
+	}
+
+	if _, ok := r.Resolve(utils.NodeKey{1}); ok {
+		t.Error("unknown key resolved")
+	}
+}
@@ -3,9 +3,7 @@ package smt
 import (
 	"math/big"
 	"strings"
-	"sync"
 
-	"github.com/dgravesa/go-parallel/parallel"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
 )
 
@@ -39,11 +37,30 @@ func (s *SMT) SetContractBytecode(ethAddr string, bytecode string) error {
 		return err
 	}
 
-	hashedBytecode, err := utils.HashContractBytecode(bytecode)
+	bi, bytecodeLength, err := bytecodeHashAndLength(bytecode)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.InsertKA(keyContractCode, bi)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.InsertKA(keyContractLength, big.NewInt(int64(bytecodeLength)))
 	if err != nil {
 		return err
 	}
 
+	return nil
+}
+
+func bytecodeHashAndLength(bytecode string) (*big.Int, int, error) {
+	hashedBytecode, err := utils.HashContractBytecode(bytecode)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	var parsedBytecode string
 
 	if strings.HasPrefix(bytecode, "0x") {
@@ -65,107 +82,112 @@ func (s *SMT) SetContractBytecode(ethAddr string, bytecode string) error {
 		bi = big.NewInt(0)
 	}
 
-	_, err = s.InsertKA(keyContractCode, bi)
+	return bi, bytecodeLength, nil
+}
+
+// AccountStateChanges returns the batch changes SetAccountState would make, to be applied with InsertBatch
+func AccountStateChanges(ethAddr string, balance, nonce *big.Int) ([]BatchChange, error) {
+	keyBalance, err := utils.KeyEthAddrBalance(ethAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	_, err = s.InsertKA(keyContractLength, big.NewInt(int64(bytecodeLength)))
+	keyNonce, err := utils.KeyEthAddrNonce(ethAddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return batchChangesKA([]utils.NodeKey{keyBalance, keyNonce}, []*big.Int{balance, nonce})
 }
 
-func (s *SMT) SetContractStorage(ethAddr string, storage map[string]string) (*big.Int, error) {
-	storageKeys := make([]string, len(storage))
-	ii := 0
-	for k := range storage {
-		storageKeys[ii] = k
-		ii++
+// ContractBytecodeChanges returns the batch changes SetContractBytecode would make, to be applied with InsertBatch
+func ContractBytecodeChanges(ethAddr string, bytecode string) ([]BatchChange, error) {
+	keyContractCode, err := utils.KeyContractCode(ethAddr)
+	if err != nil {
+		return nil, err
+	}
+	keyContractLength, err := utils.KeyContractLength(ethAddr)
+	if err != nil {
+		return nil, err
 	}
 
-	chm := make(map[string]*utils.NodeValue8)
-	vhm := make(map[string][4]uint64)
-	storageKeyCount := len(storageKeys)
+	bi, bytecodeLength, err := bytecodeHashAndLength(bytecode)
+	if err != nil {
+		return nil, err
+	}
 
-	//no need to parallelize too low amount of computations
-	if len(storage) > 100 {
-		cpuNum := parallel.DefaultNumGoroutines()
+	return batchChangesKA([]utils.NodeKey{keyContractCode, keyContractLength}, []*big.Int{bi, big.NewInt(int64(bytecodeLength))})
+}
 
-		keyArray := make([][]string, cpuNum)
-		cVArray := make([][]*utils.NodeValue8, cpuNum)
-		hashArray := make([][][4]uint64, cpuNum)
+// ContractStorageChanges returns the batch changes for the storage slots of a contract, empty values delete the slot
+func ContractStorageChanges(ethAddr string, storage map[string]string) ([]BatchChange, error) {
+	add := utils.ScalarToArrayBig(utils.ConvertHexToBigInt(ethAddr))
 
-		operationsPerCpu := storageKeyCount/cpuNum + storageKeyCount%cpuNum
-		for i := 0; i < len(hashArray); i++ {
-			keyArray[i] = make([]string, operationsPerCpu)
-			cVArray[i] = make([]*utils.NodeValue8, operationsPerCpu)
-			hashArray[i] = make([][4]uint64, operationsPerCpu)
+	keys := make([]utils.NodeKey, 0, len(storage))
+	values := make([]*big.Int, 0, len(storage))
+	for k, v := range storage {
+		keyStoragePosition, err := utils.KeyContractStorage(add, k)
+		if err != nil {
+			return nil, err
 		}
 
-		var wg sync.WaitGroup
-		wg.Add(cpuNum)
-
-		var err error
-		for i := 0; i < cpuNum; i++ {
-			go func(cpuI int) {
-				defer wg.Done()
-				count := 0
-				for j := cpuI; j < storageKeyCount; j += cpuNum {
-					k := storageKeys[j]
-					v := storage[k]
-					if v == "" {
-						continue
-					}
-
-					c, h, e := calcHashVal(v)
-					if e != nil {
-						err = e
-						return
-					}
-					keyArray[cpuI][count] = k
-					cVArray[cpuI][count] = c
-					hashArray[cpuI][count] = h
-					count++
-				}
-			}(i)
+		base := 10
+		if strings.HasPrefix(v, "0x") {
+			v = v[2:]
+			base = 16
+		}
+		val, ok := new(big.Int).SetString(v, base)
+		if !ok {
+			val = big.NewInt(0)
 		}
-		wg.Wait()
 
+		keys = append(keys, keyStoragePosition)
+		values = append(values, val)
+	}
+
+	return batchChangesKA(keys, values)
+}
+
+func batchChangesKA(keys []utils.NodeKey, values []*big.Int) ([]BatchChange, error) {
+	changes := make([]BatchChange, len(keys))
+	for i, k := range keys {
+		v, err := utils.NodeValue8FromBigIntArray(utils.ScalarToArrayBig(values[i]))
 		if err != nil {
 			return nil, err
 		}
+		changes[i] = BatchChange{Key: k, Value: *v}
+	}
+
+	return changes, nil
+}
 
-		for i := 0; i < len(keyArray); i++ {
-			for j := 0; j < len(keyArray[i]); j++ {
-				k := keyArray[i][j]
-				if k == "" {
-					continue
-				}
-
-				c := cVArray[i][j]
-				h := hashArray[i][j]
-				chm[k] = c
-				vhm[k] = h
-			}
+func (s *SMT) SetContractStorage(ethAddr string, storage map[string]string) (*big.Int, error) {
+	chm := make(map[string]*utils.NodeValue8)
+	vhm := make(map[string][4]uint64)
+
+	storageKeys := make([]string, 0, len(storage))
+	in := make([][8]uint64, 0, len(storage))
+	for k, v := range storage {
+		if v == "" {
+			continue
 		}
 
-	} else {
-		for _, k := range storageKeys {
-			v := storage[k]
-			if v == "" {
-				continue
-			}
-
-			c, h, e := calcHashVal(v)
-			if e != nil {
-				return nil, e
-			}
-			chm[k] = c
-			vhm[k] = h
+		c, err := parseStorageValue(v)
+		if err != nil {
+			return nil, err
 		}
+		chm[k] = c
+		storageKeys = append(storageKeys, k)
+		in = append(in, c.ToUintArray())
+	}
+
+	// the values are hashed in one batch, the hasher spreads big batches over several goroutines
+	capacity := make([][4]uint64, len(in))
+	hashes := make([][4]uint64, len(in))
+	if err := s.Hasher.HashBatch(in, capacity, hashes); err != nil {
+		return nil, err
+	}
+	for i, k := range storageKeys {
+		vhm[k] = hashes[i]
 	}
 
 	auxRes, err := s.InsertStorage(ethAddr, &storage, &chm, &vhm)
@@ -176,7 +198,7 @@ func (s *SMT) SetContractStorage(ethAddr string, storage map[string]string) (*bi
 	return auxRes.NewRootScalar.ToBigInt(), nil
 }
 
-func calcHashVal(v string) (*utils.NodeValue8, [4]uint64, error) {
+func parseStorageValue(v string) (*utils.NodeValue8, error) {
 	base := 10
 	if strings.HasPrefix(v, "0x") {
 		v = v[2:]
@@ -186,15 +208,5 @@ func calcHashVal(v string) (*utils.NodeValue8, [4]uint64, error) {
 	val, _ := new(big.Int).SetString(v, base)
 
 	x := utils.ScalarToArrayBig(val)
-	value, err := utils.NodeValue8FromBigIntArray(x)
-	if err != nil {
-		return nil, [4]uint64{}, err
-	}
-
-	h, err := utils.Hash(value.ToUintArray(), utils.BranchCapacity)
-	if err != nil {
-		return nil, [4]uint64{}, err
-	}
-
-	return value, h, nil
+	return utils.NodeValue8FromBigIntArray(x)
 }
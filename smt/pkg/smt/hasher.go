@@ -0,0 +1,156 @@
+package smt
+
+import (
+	"sync"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/dgravesa/go-parallel/parallel"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+const (
+	// number of hashes kept by the hasher shared by all trees, about 15MB
+	defaultHashCacheSize = 1 << 16
+	// batches with fewer uncached inputs than this are hashed on the calling goroutine
+	hashBatchParallelThreshold = 64
+)
+
+var (
+	hashCacheHits   = metrics.GetOrCreateCounter(`smt_hash_cache_hits`)
+	hashCacheMisses = metrics.GetOrCreateCounter(`smt_hash_cache_misses`)
+
+	defaultHasher = NewCachedHasher(defaultHashCacheSize)
+)
+
+// Hasher computes the poseidon hashes of the tree nodes. It is shared between trees and used from several
+// goroutines by the batch updates, so implementations have to be safe for concurrent use.
+type Hasher interface {
+	Hash(in [8]uint64, capacity [4]uint64) ([4]uint64, error)
+	// HashBatch hashes every in[i] with capacity[i] into out[i]
+	HashBatch(in [][8]uint64, capacity [][4]uint64, out [][4]uint64) error
+}
+
+// hashInput is the whole poseidon state a hash is computed from, it is used as the cache key
+type hashInput [12]uint64
+
+func newHashInput(in [8]uint64, capacity [4]uint64) hashInput {
+	var key hashInput
+	copy(key[:8], in[:])
+	copy(key[8:], capacity[:])
+	return key
+}
+
+// CachedHasher keeps the most recent hashes in an lru cache keyed by the hashed state and computes the rest with
+// the poseidon implementation of utils, large batches are split between several goroutines.
+type CachedHasher struct {
+	cache *lru.Cache[hashInput, [4]uint64]
+}
+
+// NewCachedHasher creates a hasher caching cacheSize hashes, nothing is cached if cacheSize isn't positive
+func NewCachedHasher(cacheSize int) *CachedHasher {
+	h := &CachedHasher{}
+	if cacheSize > 0 {
+		// lru.New only fails for a size that isn't positive
+		h.cache, _ = lru.New[hashInput, [4]uint64](cacheSize)
+	}
+	return h
+}
+
+func (h *CachedHasher) Hash(in [8]uint64, capacity [4]uint64) ([4]uint64, error) {
+	var key hashInput
+	if h.cache != nil {
+		key = newHashInput(in, capacity)
+		if cached, ok := h.cache.Get(key); ok {
+			hashCacheHits.Inc()
+			return cached, nil
+		}
+	}
+	hashCacheMisses.Inc()
+
+	res, err := utils.Hash(in, capacity)
+	if err != nil {
+		return [4]uint64{}, err
+	}
+	if h.cache != nil {
+		h.cache.Add(key, res)
+	}
+	return res, nil
+}
+
+func (h *CachedHasher) HashBatch(in [][8]uint64, capacity [][4]uint64, out [][4]uint64) error {
+	if len(capacity) != len(in) || len(out) != len(in) {
+		// let utils.HashBatch report the mismatch
+		return utils.HashBatch(in, capacity, out)
+	}
+
+	// the inputs that aren't cached, with their index in the batch
+	missIn := make([][8]uint64, 0, len(in))
+	missCapacity := make([][4]uint64, 0, len(in))
+	missIndex := make([]int, 0, len(in))
+	for i := range in {
+		if h.cache != nil {
+			if cached, ok := h.cache.Get(newHashInput(in[i], capacity[i])); ok {
+				out[i] = cached
+				continue
+			}
+		}
+		missIn = append(missIn, in[i])
+		missCapacity = append(missCapacity, capacity[i])
+		missIndex = append(missIndex, i)
+	}
+
+	hashCacheHits.Add(len(in) - len(missIn))
+	hashCacheMisses.Add(len(missIn))
+
+	if len(missIn) == 0 {
+		return nil
+	}
+
+	missOut := make([][4]uint64, len(missIn))
+	if err := hashBatchParallel(missIn, missCapacity, missOut); err != nil {
+		return err
+	}
+
+	for j, i := range missIndex {
+		out[i] = missOut[j]
+		if h.cache != nil {
+			h.cache.Add(newHashInput(missIn[j], missCapacity[j]), missOut[j])
+		}
+	}
+
+	return nil
+}
+
+func hashBatchParallel(in [][8]uint64, capacity [][4]uint64, out [][4]uint64) error {
+	workers := parallel.DefaultNumGoroutines()
+	if len(in) < hashBatchParallelThreshold || workers < 2 {
+		return utils.HashBatch(in, capacity, out)
+	}
+
+	chunk := (len(in) + workers - 1) / workers
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		from, to := w*chunk, (w+1)*chunk
+		if from >= len(in) {
+			break
+		}
+		if to > len(in) {
+			to = len(in)
+		}
+		wg.Add(1)
+		go func(w, from, to int) {
+			defer wg.Done()
+			errs[w] = utils.HashBatch(in[from:to], capacity[from:to], out[from:to])
+		}(w, from, to)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package smt
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func randomHashInputs(n int) ([][8]uint64, [][4]uint64) {
+	in := make([][8]uint64, n)
+	capacity := make([][4]uint64, n)
+	for i := range in {
+		for j := range in[i] {
+			in[i][j] = rand.Uint64()
+		}
+		if i%2 == 0 {
+			capacity[i] = utils.LeafCapacity
+		}
+	}
+	return in, capacity
+}
+
+func TestCachedHasher_Hash(t *testing.T) {
+	for _, size := range []int{0, 64} {
+		h := NewCachedHasher(size)
+		in, capacity := randomHashInputs(32)
+
+		// the second round is served from the cache when there is one
+		for round := 0; round < 2; round++ {
+			hits := hashCacheHits.Get()
+			for i := range in {
+				expected, err := utils.Hash(in[i], capacity[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, err := h.Hash(in[i], capacity[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res != expected {
+					t.Fatalf("cache size %d, round %d: hash %d is %v, expected %v", size, round, i, res, expected)
+				}
+			}
+			if size > 0 && round == 1 && hashCacheHits.Get()-hits < uint64(len(in)) {
+				t.Errorf("expected at least %d cache hits, got %d", len(in), hashCacheHits.Get()-hits)
+			}
+		}
+	}
+}
+
+func TestCachedHasher_HashBatch(t *testing.T) {
+	for _, n := range []int{0, 1, hashBatchParallelThreshold - 1, 1000} {
+		h := NewCachedHasher(n / 2)
+		in, capacity := randomHashInputs(n)
+
+		// half of the batch is cached beforehand
+		for i := 0; i < n; i += 2 {
+			if _, err := h.Hash(in[i], capacity[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := make([][4]uint64, n)
+		if err := h.HashBatch(in, capacity, out); err != nil {
+			t.Fatal(err)
+		}
+		for i := range in {
+			expected, err := utils.Hash(in[i], capacity[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out[i] != expected {
+				t.Fatalf("batch of %d: hash %d is %v, expected %v", n, i, out[i], expected)
+			}
+		}
+	}
+
+	if err := NewCachedHasher(1).HashBatch(make([][8]uint64, 2), make([][4]uint64, 1), make([][4]uint64, 2)); err == nil {
+		t.Error("expected an error for a batch with fewer capacities than inputs")
+	}
+}
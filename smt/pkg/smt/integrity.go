@@ -0,0 +1,209 @@
+package smt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+// BrokenNode is a node of the tree that is missing or whose content doesn't hash to the key it is stored under.
+// Path is the path from the root to the node, the subtree below it can't be trusted.
+type BrokenNode struct {
+	Path   []int
+	Key    utils.NodeKey
+	Reason string
+}
+
+func (b BrokenNode) String() string {
+	return fmt.Sprintf("node %s at depth %d: %s", utils.ConvertBigIntToHex(b.Key.ToBigInt()), len(b.Path), b.Reason)
+}
+
+type LeafAction func(k utils.NodeKey, v utils.NodeValue8) error
+
+// CheckNodes walks the whole tree from root and checks the hash of every node, leaf and value.
+// It returns the topmost broken nodes, the subtrees below them are not walked. leafAction is called for every leaf
+// reached through valid nodes with the full key and value of the leaf.
+func (s *SMT) CheckNodes(ctx context.Context, root utils.NodeKey, leafAction LeafAction) ([]BrokenNode, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	broken := make([]BrokenNode, 0)
+	err := s.checkNode(ctx, root, make([]int, 0, 256), leafAction, &broken)
+	return broken, err
+}
+
+func (s *SMT) checkNode(ctx context.Context, nodeKey utils.NodeKey, path []int, leafAction LeafAction, broken *[]BrokenNode) error {
+	if nodeKey.IsZero() {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	brokenNode := func(reason string) {
+		*broken = append(*broken, BrokenNode{Path: append([]int{}, path...), Key: nodeKey, Reason: reason})
+	}
+
+	node, err := s.Db.Get(nodeKey)
+	if err != nil {
+		return err
+	}
+	if node[8] == nil {
+		brokenNode("node not found")
+		return nil
+	}
+
+	capacity := utils.NodeKeyFromBigIntArray(node[8:12])
+	h, err := s.Hasher.Hash(node.StripCapacity(), capacity)
+	if err != nil {
+		return err
+	}
+	if h != nodeKey {
+		brokenNode("node content doesn't match its hash")
+		return nil
+	}
+
+	if node.IsFinalNode() {
+		if capacity != utils.LeafCapacity {
+			brokenNode("unexpected leaf capacity")
+			return nil
+		}
+		valueHash := *node.Get4to8()
+		value, err := s.Db.Get(valueHash)
+		if err != nil {
+			return err
+		}
+		if value[8] == nil {
+			brokenNode("leaf value not found")
+			return nil
+		}
+		vh, err := s.Hasher.Hash(value.StripCapacity(), utils.BranchCapacity)
+		if err != nil {
+			return err
+		}
+		if vh != valueHash {
+			brokenNode("leaf value doesn't match its hash")
+			return nil
+		}
+		if leafAction == nil {
+			return nil
+		}
+		return leafAction(*utils.JoinKey(path, *node.Get0to4()), utils.Value8FromBigIntArray(value[0:8]))
+	}
+
+	if capacity != utils.BranchCapacity {
+		brokenNode("unexpected branch capacity")
+		return nil
+	}
+
+	for bit := 0; bit < 2; bit++ {
+		child := utils.NodeKeyFromBigIntArray(node[bit*4 : bit*4+4])
+		if err := s.checkNode(ctx, child, append(path, bit), leafAction, broken); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RebuildSubtree replaces the subtree at path with a new one holding only the given leaves and rehashes the
+// nodes above it up to the root. All the leaves must be under path. The nodes above path must be valid, the ones
+// below it are not read so a broken subtree can be replaced with this.
+func (s *SMT) RebuildSubtree(ctx context.Context, path []int, leaves []BatchChange) (*SMTResponse, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	root, err := s.getLastRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, valueNodes, err := prepareBatchEntries(s.Hasher, leaves)
+	if err != nil {
+		return nil, err
+	}
+	set := make([]*batchEntry, 0, len(entries))
+	for _, e := range entries {
+		if comparePaths(e.path[:len(path)], path) != 0 {
+			return nil, fmt.Errorf("key %v is not under the rebuilt subtree", e.key)
+		}
+		if e.isSet {
+			set = append(set, e)
+		}
+	}
+
+	// the nodes from the root down to the subtree
+	ancestors := make([]utils.NodeValue12, 0, len(path))
+	current := root
+	for level := range path {
+		if current.IsZero() {
+			break
+		}
+		node, err := s.Db.Get(current)
+		if err != nil {
+			return nil, err
+		}
+		if node[8] == nil || node.IsFinalNode() {
+			return nil, fmt.Errorf("no branch at depth %d on the path to the rebuilt subtree", level)
+		}
+		ancestors = append(ancestors, node)
+		current = utils.NodeKeyFromBigIntArray(node[path[level]*4 : path[level]*4+4])
+	}
+
+	res, err := buildSubtree(s.Hasher, set, len(ancestors))
+	if err != nil {
+		return nil, err
+	}
+
+	for level := len(ancestors) - 1; level >= 0; level-- {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		bit := path[level]
+		sibling := &batchResult{hash: utils.NodeKeyFromBigIntArray(ancestors[level][(1-bit)*4 : (1-bit)*4+4])}
+		if !utils.IsArrayUint64Empty(sibling.hash[:]) {
+			siblingNode, err := s.Db.Get(sibling.hash)
+			if err != nil {
+				return nil, err
+			}
+			if siblingNode.IsFinalNode() {
+				prefix := append(append([]int{}, path[:level]...), 1-bit)
+				sibling.leaf = leafEntry(prefix, siblingNode)
+			}
+		}
+
+		children := [2]*batchResult{}
+		children[bit], children[1-bit] = res, sibling
+
+		if res, err = combineChildren(s.Hasher, children[0], children[1], level, res.nodes); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, nodes := range [][]batchSavedNode{valueNodes, res.nodes} {
+		for _, n := range nodes {
+			// a broken copy of the node may be in the db, so it has to be written even if it was hashed before
+			s.savedNodes.Remove(n.hash)
+			if _, err := s.hashSave(n.in, n.capacity, n.hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	newRoot := utils.NodeKey(res.hash)
+	if err := s.setLastRoot(newRoot); err != nil {
+		return nil, err
+	}
+
+	return &SMTResponse{
+		NewRootScalar: &newRoot,
+		Mode:          "rebuild",
+	}, nil
+}
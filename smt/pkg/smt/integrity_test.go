@@ -0,0 +1,123 @@
+package smt
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func TestSMT_CheckNodes(t *testing.T) {
+	s := NewSMT(nil)
+	values := make(map[utils.NodeKey]*big.Int)
+	for i := 1; i <= 64; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i*10))); err != nil {
+			t.Fatal(err)
+		}
+		values[utils.ScalarToNodeKey(big.NewInt(int64(i)))] = big.NewInt(int64(i * 10))
+	}
+	root := utils.ScalarToRoot(s.LastRoot())
+
+	leaves := 0
+	broken, err := s.CheckNodes(context.Background(), root, func(k utils.NodeKey, v utils.NodeValue8) error {
+		leaves++
+		if want, ok := values[k]; !ok || utils.ArrayToScalarBig(v[:]).Cmp(want) != 0 {
+			t.Errorf("unexpected leaf %v with value %v", k, utils.ArrayToScalarBig(v[:]))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 0 || leaves != len(values) {
+		t.Fatalf("expected %d leaves and no broken nodes, got %d leaves and %v", len(values), leaves, broken)
+	}
+}
+
+func TestSMT_RebuildSubtree(t *testing.T) {
+	s := NewSMT(nil)
+	changes := make([]BatchChange, 0)
+	for i := 1; i <= 64; i++ {
+		c := BatchChange{Key: utils.ScalarToNodeKey(big.NewInt(int64(i))), Value: utils.ScalarToNodeValue8(big.NewInt(int64(i * 10)))}
+		if _, err := s.insertSingle(c.Key, c.Value, [4]uint64{}); err != nil {
+			t.Fatal(err)
+		}
+		changes = append(changes, c)
+	}
+	root := utils.ScalarToRoot(s.LastRoot())
+
+	// corrupt a branch a few levels down
+	path := []int{1, 0, 1}
+	current := root
+	for _, bit := range path {
+		node, err := s.Db.Get(current)
+		if err != nil {
+			t.Fatal(err)
+		}
+		current = utils.NodeKeyFromBigIntArray(node[bit*4 : bit*4+4])
+	}
+	corrupt, err := s.Db.Get(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt[0] = new(big.Int).Add(corrupt[0], big.NewInt(1))
+	if err := s.Db.Insert(current, corrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	broken, err := s.CheckNodes(context.Background(), root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 1 || broken[0].Key != current || comparePaths(broken[0].Path, path) != 0 {
+		t.Fatalf("expected the corrupted node to be reported, got %v", broken)
+	}
+
+	under := make([]BatchChange, 0)
+	for _, c := range changes {
+		if comparePaths(c.Key.GetPath()[:len(path)], path) == 0 {
+			under = append(under, c)
+		}
+	}
+	if _, err := s.RebuildSubtree(context.Background(), broken[0].Path, under); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LastRoot().Cmp(root.ToBigInt()) != 0 {
+		t.Fatalf("root after rebuild is not as expected, got %s", s.LastRoot().Text(16))
+	}
+	if broken, err = s.CheckNodes(context.Background(), root, nil); err != nil || len(broken) != 0 {
+		t.Fatalf("expected no broken nodes after rebuild, got %v, %v", broken, err)
+	}
+}
+
+func TestSMT_RebuildSubtree_Collapse(t *testing.T) {
+	s := NewSMT(nil)
+	keys := make([]utils.NodeKey, 0)
+	for i := 1; i <= 16; i++ {
+		k := utils.ScalarToNodeKey(big.NewInt(int64(i)))
+		if _, err := s.insertSingle(k, utils.ScalarToNodeValue8(big.NewInt(int64(i))), [4]uint64{}); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+
+	// rebuilding the left half of the tree empty has to give the same tree as deleting those keys
+	expected := NewSMT(nil)
+	for i, k := range keys {
+		if k.GetPath()[0] == 0 {
+			continue
+		}
+		if _, err := expected.insertSingle(k, utils.ScalarToNodeValue8(big.NewInt(int64(i+1))), [4]uint64{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.RebuildSubtree(context.Background(), []int{0}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s.LastRoot().Cmp(expected.LastRoot()) != 0 {
+		t.Fatalf("root after rebuild is not as expected, got %s wanted %s", s.LastRoot().Text(16), expected.LastRoot().Text(16))
+	}
+}
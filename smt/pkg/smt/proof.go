@@ -0,0 +1,228 @@
+package smt
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+var ErrInvalidProof = errors.New("smt proof does not match root")
+
+// SMTProof is a merkle proof for a single key of the tree.
+// Siblings are ordered from the root down, Siblings[i] is the hash of the node next to the path at level i.
+//
+// If the key is present Value holds its leaf value. If it is absent the path either ends in an empty
+// branch slot, or in a leaf holding a different key, in which case FoundRKey and FoundValueHash describe
+// that leaf so the verifier can rebuild it.
+type SMTProof struct {
+	Root     utils.NodeKey
+	Key      utils.NodeKey
+	Value    utils.NodeValue8
+	Siblings []utils.NodeKey
+
+	FoundRKey      *utils.NodeKey
+	FoundValueHash utils.NodeKey
+}
+
+// AccountProof groups the proofs of all the keys that make up an account in the SMT
+type AccountProof struct {
+	Balance    *SMTProof
+	Nonce      *SMTProof
+	CodeHash   *SMTProof
+	CodeLength *SMTProof
+	Storage    map[string]*SMTProof
+}
+
+// IsInclusion returns true if the proof shows the key is set in the tree
+func (p *SMTProof) IsInclusion() bool {
+	return p.FoundRKey == nil && !isValueEmpty(p.Value)
+}
+
+// ValueBigInt returns the proven value for keys written with InsertKA (32 bit limbs)
+func (p *SMTProof) ValueBigInt() *big.Int {
+	if !p.IsInclusion() {
+		return big.NewInt(0)
+	}
+	return utils.ArrayBigToScalar(p.Value[:])
+}
+
+func (s *SMT) GetProof(k utils.NodeKey) (*SMTProof, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	root, err := s.getLastRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getProof(root, k)
+}
+
+// GetProofAtRoot builds the proof against an arbitrary root, the nodes of that root must still be in the db
+func (s *SMT) GetProofAtRoot(root *big.Int, k utils.NodeKey) (*SMTProof, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	return s.getProof(utils.ScalarToRoot(root), k)
+}
+
+func (s *SMT) GetAccountProof(root *big.Int, ethAddr string, storageKeys []string) (*AccountProof, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	r := utils.ScalarToRoot(root)
+	ap := &AccountProof{
+		Storage: make(map[string]*SMTProof, len(storageKeys)),
+	}
+
+	fields := []struct {
+		c     int
+		proof **SMTProof
+	}{
+		{utils.KEY_BALANCE, &ap.Balance},
+		{utils.KEY_NONCE, &ap.Nonce},
+		{utils.SC_CODE, &ap.CodeHash},
+		{utils.SC_LENGTH, &ap.CodeLength},
+	}
+
+	for _, f := range fields {
+		k, err := utils.Key(ethAddr, f.c)
+		if err != nil {
+			return nil, err
+		}
+		p, err := s.getProof(r, k)
+		if err != nil {
+			return nil, err
+		}
+		*f.proof = p
+	}
+
+	add := utils.ScalarToArrayBig(utils.ConvertHexToBigInt(ethAddr))
+	for _, sk := range storageKeys {
+		k, err := utils.KeyContractStorage(add, sk)
+		if err != nil {
+			return nil, err
+		}
+		p, err := s.getProof(r, k)
+		if err != nil {
+			return nil, err
+		}
+		ap.Storage[sk] = p
+	}
+
+	return ap, nil
+}
+
+func (s *SMT) getProof(root utils.NodeKey, k utils.NodeKey) (*SMTProof, error) {
+	proof := &SMTProof{
+		Root:     root,
+		Key:      k,
+		Siblings: make([]utils.NodeKey, 0),
+	}
+
+	path := k.GetPath()
+	current := root
+
+	for level := 0; !current.IsZero(); level++ {
+		node, err := s.Db.Get(current)
+		if err != nil {
+			return nil, err
+		}
+		if node[8] == nil {
+			return nil, fmt.Errorf("smt node %s not found", utils.ConvertBigIntToHex(current.ToBigInt()))
+		}
+
+		if node.IsFinalNode() {
+			rKey := node.Get0to4()
+			valueHash := node.Get4to8()
+
+			foundKey := utils.JoinKey(path[:level], *rKey)
+			if !foundKey.IsEqualTo(k) {
+				proof.FoundRKey = rKey
+				proof.FoundValueHash = *valueHash
+				break
+			}
+
+			valueNode, err := s.Db.Get(*valueHash)
+			if err != nil {
+				return nil, err
+			}
+			proof.Value = utils.Value8FromBigIntArray(valueNode[0:8])
+			break
+		}
+
+		bit := path[level]
+		proof.Siblings = append(proof.Siblings, utils.NodeKeyFromBigIntArray(node[(1-bit)*4:(1-bit)*4+4]))
+		current = utils.NodeKeyFromBigIntArray(node[bit*4 : bit*4+4])
+	}
+
+	return proof, nil
+}
+
+// VerifyProof recomputes the root from the proof siblings and checks it against the proof root.
+// It only depends on the poseidon hash so it can be used without access to the tree.
+func VerifyProof(p *SMTProof) error {
+	if p == nil {
+		return errors.New("nil proof")
+	}
+
+	path := p.Key.GetPath()
+	level := len(p.Siblings)
+	if level >= len(path) {
+		return fmt.Errorf("too many siblings in proof: %d", level)
+	}
+
+	var current [4]uint64
+	var err error
+
+	switch {
+	case p.FoundRKey != nil:
+		// exclusion, the path ends in a leaf holding another key sharing the same prefix
+		foundKey := utils.JoinKey(path[:level], *p.FoundRKey)
+		if foundKey.IsEqualTo(p.Key) {
+			return errors.New("exclusion proof leaf holds the proven key")
+		}
+		current, err = utils.Hash(utils.ConcatArrays4(*p.FoundRKey, p.FoundValueHash), utils.LeafCapacity)
+		if err != nil {
+			return err
+		}
+	case isValueEmpty(p.Value):
+		// exclusion, the path ends in an empty slot
+	default:
+		valueHash, err := utils.Hash(p.Value.ToUintArray(), utils.BranchCapacity)
+		if err != nil {
+			return err
+		}
+		rKey := utils.RemoveKeyBits(p.Key, level)
+		current, err = utils.Hash(utils.ConcatArrays4(rKey, valueHash), utils.LeafCapacity)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := level - 1; i >= 0; i-- {
+		var node [8]uint64
+		if path[i] == 0 {
+			node = utils.ConcatArrays4(current, p.Siblings[i])
+		} else {
+			node = utils.ConcatArrays4(p.Siblings[i], current)
+		}
+		current, err = utils.Hash(node, utils.BranchCapacity)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !p.Root.IsEqualTo(current) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+func isValueEmpty(v utils.NodeValue8) bool {
+	arr := v.ToUintArray()
+	return utils.IsArrayUint64Empty(arr[:])
+}
@@ -0,0 +1,218 @@
+package smt
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/tenderly/zkevm-erigon-lib/kv/mdbx"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func TestSMT_GetProof(t *testing.T) {
+	s := NewSMT(nil)
+
+	for i := 1; i <= 64; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i*10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i <= 64; i++ {
+		k := utils.ScalarToNodeKey(big.NewInt(int64(i)))
+		proof, err := s.GetProof(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.IsInclusion() {
+			t.Errorf("key %d: expected inclusion proof", i)
+		}
+		if got := utils.ArrayToScalarBig(proof.Value[:]); got.Cmp(big.NewInt(int64(i*10))) != 0 {
+			t.Errorf("key %d: value is not as expected, got %v", i, got)
+		}
+		if err := VerifyProof(proof); err != nil {
+			t.Errorf("key %d: proof failed to verify: %v", i, err)
+		}
+	}
+
+	// keys that are not in the tree, some of them end on a leaf and some on an empty slot
+	for i := 65; i <= 200; i++ {
+		k := utils.ScalarToNodeKey(big.NewInt(int64(i)))
+		proof, err := s.GetProof(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.IsInclusion() {
+			t.Errorf("key %d: expected exclusion proof", i)
+		}
+		if err := VerifyProof(proof); err != nil {
+			t.Errorf("key %d: exclusion proof failed to verify: %v", i, err)
+		}
+	}
+}
+
+func TestSMT_GetProof_EmptyTree(t *testing.T) {
+	s := NewSMT(nil)
+
+	proof, err := s.GetProof(utils.ScalarToNodeKey(big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.IsInclusion() || len(proof.Siblings) != 0 {
+		t.Errorf("expected empty exclusion proof, got %+v", proof)
+	}
+	if err := VerifyProof(proof); err != nil {
+		t.Errorf("proof failed to verify: %v", err)
+	}
+}
+
+func TestSMT_VerifyProof_Tampered(t *testing.T) {
+	s := NewSMT(nil)
+	for i := 1; i <= 16; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := utils.ScalarToNodeKey(big.NewInt(5))
+
+	proof, err := s.GetProof(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Value = utils.ScalarToNodeValue8(big.NewInt(6))
+	if err := VerifyProof(proof); err != ErrInvalidProof {
+		t.Errorf("expected ErrInvalidProof for a changed value, got %v", err)
+	}
+
+	proof, err = s.GetProof(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Siblings[0][0]++
+	if err := VerifyProof(proof); err != ErrInvalidProof {
+		t.Errorf("expected ErrInvalidProof for a changed sibling, got %v", err)
+	}
+
+	// claiming a present key is absent must fail
+	proof, err = s.GetProof(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof.Value = utils.NodeValue8{}
+	if err := VerifyProof(proof); err != ErrInvalidProof {
+		t.Errorf("expected ErrInvalidProof for a hidden value, got %v", err)
+	}
+}
+
+func TestSMT_GetAccountProof(t *testing.T) {
+	s := NewSMT(nil)
+	addr := "0x1234567890123456789012345678901234567890"
+	other := "0x0000000000000000000000000000000000000001"
+
+	if _, err := s.SetAccountState(addr, big.NewInt(100), big.NewInt(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetContractBytecode(addr, "0x6080604052"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetContractStorage(addr, map[string]string{"0x1": "0x2a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetAccountState(other, big.NewInt(1), big.NewInt(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	ap, err := s.GetAccountProof(s.LastRoot(), addr, []string{"0x1", "0x2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, p := range map[string]*SMTProof{
+		"balance":    ap.Balance,
+		"nonce":      ap.Nonce,
+		"codeHash":   ap.CodeHash,
+		"codeLength": ap.CodeLength,
+		"slot 0x1":   ap.Storage["0x1"],
+		"slot 0x2":   ap.Storage["0x2"],
+	} {
+		if err := VerifyProof(p); err != nil {
+			t.Errorf("%s proof failed to verify: %v", name, err)
+		}
+	}
+
+	if ap.Balance.ValueBigInt().Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("balance is not as expected, got %v", ap.Balance.ValueBigInt())
+	}
+	if ap.Nonce.ValueBigInt().Cmp(big.NewInt(2)) != 0 {
+		t.Errorf("nonce is not as expected, got %v", ap.Nonce.ValueBigInt())
+	}
+	if ap.CodeLength.ValueBigInt().Cmp(big.NewInt(5)) != 0 {
+		t.Errorf("code length is not as expected, got %v", ap.CodeLength.ValueBigInt())
+	}
+	if ap.Storage["0x1"].ValueBigInt().Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("storage value is not as expected, got %v", ap.Storage["0x1"].ValueBigInt())
+	}
+	if ap.Storage["0x2"].IsInclusion() {
+		t.Errorf("expected exclusion proof for an unset slot")
+	}
+}
+
+func TestSMT_GetProof_PastRoot(t *testing.T) {
+	dbi, err := mdbx.NewTemporaryMdbx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := dbi.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := db2.CreateEriDbBuckets(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	eridb := db2.NewEriDb(tx)
+	s := NewSMT(eridb)
+	for i := 1; i <= 32; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i*10))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pastRoot := s.LastRoot()
+
+	// the deletes prune the old paths from the live tree
+	eridb.SetBlockNumber(2)
+	for i := 1; i <= 32; i += 2 {
+		if _, err := s.Delete(utils.ScalarToNodeKey(big.NewInt(int64(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned := 0
+	if err := tx.ForEach(db2.TableHistory, []byte{}, func(k, v []byte) error {
+		pruned++
+		return nil
+	}); err != nil || pruned == 0 {
+		t.Fatalf("expected the pruned nodes in the history, got %d, %v", pruned, err)
+	}
+
+	past := NewSMT(db2.NewEriRoDb(tx, pastRoot))
+	for i := 1; i <= 32; i++ {
+		proof, err := past.GetProof(utils.ScalarToNodeKey(big.NewInt(int64(i))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proof.IsInclusion() {
+			t.Errorf("key %d: expected inclusion proof at the past root", i)
+		}
+		if err := VerifyProof(proof); err != nil {
+			t.Errorf("key %d: proof failed to verify: %v", i, err)
+		}
+	}
+
+	if _, err := past.InsertBI(big.NewInt(1), big.NewInt(1)); err == nil {
+		t.Errorf("expected insert into a past root to fail")
+	}
+}
@@ -7,11 +7,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sort"
 	"sync"
 	"time"
 
-	"github.com/TwiN/gocache/v2"
+	"github.com/VictoriaMetrics/metrics"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
@@ -22,7 +22,8 @@ type DB interface {
 	Insert(key utils.NodeKey, value utils.NodeValue12) error
 	GetAccountValue(key utils.NodeKey) (utils.NodeValue8, error)
 	InsertAccountValue(key utils.NodeKey, value utils.NodeValue8) error
-	Delete(string) error
+	Delete(key utils.NodeKey) error
+	DeleteAccountValue(key utils.NodeKey) error
 
 	SetLastRoot(lr *big.Int) error
 	GetLastRoot() (*big.Int, error)
@@ -38,10 +39,16 @@ type DebuggableDB interface {
 	GetDb() map[string][]string
 }
 
+// number of recently saved nodes that aren't written to the db again
+const savedNodesCacheSize = 10000
+
+var savedNodesCacheHits = metrics.GetOrCreateCounter(`smt_saved_nodes_cache_hits`)
+
 type SMT struct {
-	Db                DB
-	Cache             *gocache.Cache
-	CacheHitFrequency map[string]int
+	Db     DB
+	Hasher Hasher
+
+	savedNodes *lru.Cache[utils.NodeKey, struct{}]
 
 	clearUpMutex sync.Mutex
 }
@@ -56,10 +63,12 @@ func NewSMT(database DB) *SMT {
 		database = db.NewMemDb()
 	}
 
+	savedNodes, _ := lru.New[utils.NodeKey, struct{}](savedNodesCacheSize)
+
 	return &SMT{
-		Db:                database,
-		Cache:             gocache.NewCache().WithMaxSize(10000).WithEvictionPolicy(gocache.LeastRecentlyUsed),
-		CacheHitFrequency: make(map[string]int),
+		Db:         database,
+		Hasher:     defaultHasher,
+		savedNodes: savedNodes,
 	}
 }
 
@@ -125,6 +134,21 @@ func (s *SMT) InsertKA(key utils.NodeKey, value *big.Int) (*SMTResponse, error)
 	return s.insertSingle(key, *v, [4]uint64{})
 }
 
+// Delete removes the key from the tree. Branches left with a single leaf are collapsed back into that leaf
+// and the nodes of the old path that are no longer reachable are removed in the same batch.
+func (s *SMT) Delete(k utils.NodeKey) (*SMTResponse, error) {
+	smtr, err := s.insertSingle(k, utils.ScalarToNodeValue8(big.NewInt(0)), [4]uint64{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.Db.DeleteAccountValue(k); err != nil {
+		return nil, err
+	}
+
+	return smtr, nil
+}
+
 func (s *SMT) InsertStorage(ethAddr string, storage *map[string]string, chm *map[string]*utils.NodeValue8, vhm *map[string][4]uint64) (*SMTResponse, error) {
 	s.clearUpMutex.Lock()
 	defer s.clearUpMutex.Unlock()
@@ -199,6 +223,8 @@ func (s *SMT) insert(k utils.NodeKey, v utils.NodeValue8, newValH [4]uint64, old
 	var foundOldValHash utils.NodeKey
 
 	siblings := map[int]*utils.NodeValue12{}
+	// nodes on the path to k before the change, candidates for removal if k gets deleted
+	oldPath := map[utils.NodeKey]struct{}{}
 
 	var err error
 	// JS WHILE
@@ -207,6 +233,7 @@ func (s *SMT) insert(k utils.NodeKey, v utils.NodeValue8, newValH [4]uint64, old
 		if err != nil {
 			return nil, err
 		}
+		oldPath[oldRoot] = struct{}{}
 		siblings[level] = &sl
 		if siblings[level].IsFinalNode() {
 			foundOldValHash = utils.NodeKeyFromBigIntArray(siblings[level][4:8])
@@ -408,6 +435,8 @@ func (s *SMT) insert(k utils.NodeKey, v utils.NodeValue8, newValH [4]uint64, old
 				siblings[level+1] = &sl
 
 				if siblings[level+1].IsFinalNode() {
+					// the sibling leaf moves up, so its old node is no longer referenced
+					oldPath[dk] = struct{}{}
 					valH := siblings[level+1].Get4to8()
 
 					rKey := siblings[level+1].Get0to4()
@@ -481,16 +510,65 @@ func (s *SMT) insert(k utils.NodeKey, v utils.NodeValue8, newValH [4]uint64, old
 
 	_ = oldRoot
 
+	switch smtResponse.Mode {
+	case "deleteFound", "deleteNotFound", "deleteLast":
+		if err := s.deleteOrphanedNodes(oldPath, k, newRoot); err != nil {
+			return nil, err
+		}
+	}
+
 	smtResponse.NewRootScalar = &newRoot
 
 	return smtResponse, nil
 }
 
+// deleteOrphanedNodes removes the nodes of the old path to k that are not part of the new path.
+// Value nodes are content addressed and may be shared by many leaves, so they are left in place.
+func (s *SMT) deleteOrphanedNodes(oldPath map[utils.NodeKey]struct{}, k utils.NodeKey, newRoot utils.NodeKey) error {
+	newPath, err := s.pathNodes(newRoot, k)
+	if err != nil {
+		return err
+	}
+
+	for nodeKey := range oldPath {
+		if _, ok := newPath[nodeKey]; ok {
+			continue
+		}
+		if err := s.Db.Delete(nodeKey); err != nil {
+			return err
+		}
+		// the cache would otherwise skip re-saving this node if it is ever hashed again
+		s.savedNodes.Remove(nodeKey)
+	}
+
+	return nil
+}
+
+// pathNodes returns the keys of all the nodes from the root down to the leaf or empty slot for k
+func (s *SMT) pathNodes(root utils.NodeKey, k utils.NodeKey) (map[utils.NodeKey]struct{}, error) {
+	nodes := map[utils.NodeKey]struct{}{}
+	path := k.GetPath()
+
+	for level := 0; !root.IsZero(); level++ {
+		nodes[root] = struct{}{}
+		nodeValue, err := s.Db.Get(root)
+		if err != nil {
+			return nil, err
+		}
+		if nodeValue[8] == nil || nodeValue.IsFinalNode() {
+			break
+		}
+		root = utils.NodeKeyFromBigIntArray(nodeValue[path[level]*4 : path[level]*4+4])
+	}
+
+	return nodes, nil
+}
+
+// hashSave saves the node hashed to h, unless it was saved recently
 func (s *SMT) hashSave(in [8]uint64, capacity, h [4]uint64) ([4]uint64, error) {
-	cacheKey := fmt.Sprintf("%v-%v", in, capacity)
-	if cachedValue, exists := s.Cache.Get(cacheKey); exists {
-		s.CacheHitFrequency[cacheKey]++
-		return cachedValue.([4]uint64), nil
+	if _, exists := s.savedNodes.Get(h); exists {
+		savedNodesCacheHits.Inc()
+		return h, nil
 	}
 
 	var sl []uint64
@@ -503,15 +581,17 @@ func (s *SMT) hashSave(in [8]uint64, capacity, h [4]uint64) ([4]uint64, error) {
 		v[i] = b.SetUint64(val)
 	}
 
-	err := s.Db.Insert(h, v)
+	if err := s.Db.Insert(h, v); err != nil {
+		return h, err
+	}
 
-	s.Cache.Set(cacheKey, h)
+	s.savedNodes.Add(h, struct{}{})
 
-	return h, err
+	return h, nil
 }
 
 func (s *SMT) hashcalcAndSave(in [8]uint64, capacity [4]uint64) ([4]uint64, error) {
-	h, err := utils.Hash(in, capacity)
+	h, err := s.Hasher.Hash(in, capacity)
 	if err != nil {
 		return [4]uint64{}, err
 	}
@@ -550,33 +630,6 @@ func (s *SMT) PrintTree() {
 	}
 }
 
-func (s *SMT) PrintCacheHitsByFrequency() {
-	type kv struct {
-		Key   string
-		Value int
-	}
-
-	fmt.Println("SMT Cache Hits:")
-
-	var ss []kv
-	for k, v := range s.CacheHitFrequency {
-		if v > 1 {
-			ss = append(ss, kv{k, v})
-		}
-	}
-
-	sort.Slice(ss, func(i, j int) bool {
-		return ss[i].Value > ss[j].Value
-	})
-
-	for i, kv := range ss {
-		if i > 10 {
-			break
-		}
-		fmt.Printf("%s: %d\n", kv.Key, kv.Value)
-	}
-}
-
 type VisitedNodesMap map[string]bool
 
 func (s *SMT) CheckOrphanedNodes(ctx context.Context) int {
@@ -618,7 +671,7 @@ func (s *SMT) CheckOrphanedNodes(ctx context.Context) int {
 		if node == rootKey {
 			continue
 		}
-		err := s.Db.Delete(node)
+		err := s.Db.Delete(utils.ScalarToRoot(utils.ConvertHexToBigInt(node)))
 		if err != nil {
 			log.Warn("failed to delete orphaned node", "node", node, "err", err)
 		}
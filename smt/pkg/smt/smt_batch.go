@@ -0,0 +1,475 @@
+package smt
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//	InsertBatch applies a whole set of changes to the tree in one pass instead of walking from the
+//	root for every key.
+//
+//	The changes are sorted by their path, so at every level the keys going left and the keys going
+//	right are two contiguous runs and the shared part of their paths is only read and hashed once.
+//	The work is split in three phases:
+//	1. walk the existing tree along the changed paths and record what has to be rebuilt (sequential,
+//	   because the mdbx tx can't be used from several goroutines)
+//	2. hash the new nodes bottom up, with independent subtrees hashed concurrently
+//	3. save the new nodes to the db and delete the nodes of the old paths they replace (sequential)
+//
+//	The SMT is canonical - a set of keys always gives the same tree - so the result is the same as
+//	inserting the keys one by one.
+//////////////////////////////////////////////////////////////////////////////
+
+// subtrees with fewer changes than this are hashed on the current goroutine
+const batchParallelThreshold = 64
+
+type BatchChange struct {
+	Key   utils.NodeKey
+	Value utils.NodeValue8
+}
+
+type batchEntry struct {
+	key       utils.NodeKey
+	path      []int
+	value     [8]uint64
+	valueHash [4]uint64
+	isSet     bool // false for deletes
+}
+
+type batchSavedNode struct {
+	in       [8]uint64
+	capacity [4]uint64
+	hash     [4]uint64
+}
+
+// batchPlanNode is a node of the existing tree touched by the changes.
+// If it was a branch, its children are planned separately, otherwise its subtree is rebuilt from entries.
+type batchPlanNode struct {
+	level    int
+	isBranch bool
+	entries  []*batchEntry
+	// the node of the existing tree at this position, zero if there was none
+	nodeKey utils.NodeKey
+
+	children [2]*batchPlanNode
+	// children without changes keep their hash, siblingLeaf is only loaded when the node could collapse
+	unchanged   [2]utils.NodeKey
+	siblingLeaf [2]*batchEntry
+	changeCount int
+}
+
+type batchResult struct {
+	hash  [4]uint64
+	leaf  *batchEntry
+	nodes []batchSavedNode
+}
+
+func (s *SMT) InsertBatch(ctx context.Context, changes []BatchChange) (*SMTResponse, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	oldRoot, err := s.getLastRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, valueNodes, err := prepareBatchEntries(s.Hasher, changes)
+	if err != nil {
+		return nil, err
+	}
+
+	smtResponse := &SMTResponse{
+		NewRootScalar: &oldRoot,
+		Mode:          "batch",
+	}
+	if len(entries) == 0 {
+		return smtResponse, nil
+	}
+
+	plan, err := s.planBatch(ctx, oldRoot, 0, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := computeBatch(ctx, s.Hasher, plan)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nodes := range [][]batchSavedNode{valueNodes, res.nodes} {
+		for _, n := range nodes {
+			if _, err := s.hashSave(n.in, n.capacity, n.hash); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.deleteReplacedNodes(plan, res.nodes); err != nil {
+		return nil, err
+	}
+
+	newRoot := utils.NodeKey(res.hash)
+	if err := s.setLastRoot(newRoot); err != nil {
+		return nil, err
+	}
+	smtResponse.NewRootScalar = &newRoot
+
+	return smtResponse, nil
+}
+
+// prepareBatchEntries sorts the changes by path, keeps the last change for every key and hashes the new values
+func prepareBatchEntries(hasher Hasher, changes []BatchChange) ([]*batchEntry, []batchSavedNode, error) {
+	entries := make([]*batchEntry, len(changes))
+	for i, c := range changes {
+		value := c.Value.ToUintArray()
+		entries[i] = &batchEntry{
+			key:   c.Key,
+			path:  c.Key.GetPath(),
+			value: value,
+			isSet: !utils.IsArrayUint64Empty(value[:]),
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return comparePaths(entries[i].path, entries[j].path) < 0
+	})
+
+	deduped := entries[:0]
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].key == e.key {
+			continue
+		}
+		deduped = append(deduped, e)
+	}
+	entries = deduped
+
+	in := make([][8]uint64, 0, len(entries))
+	set := make([]*batchEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.isSet {
+			in = append(in, e.value)
+			set = append(set, e)
+		}
+	}
+
+	capacity := make([][4]uint64, len(in))
+	hashes := make([][4]uint64, len(in))
+	if err := hasher.HashBatch(in, capacity, hashes); err != nil {
+		return nil, nil, err
+	}
+
+	saved := make([]batchSavedNode, len(set))
+	for i, e := range set {
+		e.valueHash = hashes[i]
+		saved[i] = batchSavedNode{in: e.value, capacity: utils.BranchCapacity, hash: hashes[i]}
+	}
+
+	return entries, saved, nil
+}
+
+func comparePaths(a, b []int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return 0
+}
+
+// splitByBit returns the index of the first entry going right at level, entries must be sorted by path
+func splitByBit(entries []*batchEntry, level int) int {
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].path[level] == 1
+	})
+}
+
+func (s *SMT) planBatch(ctx context.Context, nodeKey utils.NodeKey, level int, entries []*batchEntry) (*batchPlanNode, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	plan := &batchPlanNode{
+		level:       level,
+		nodeKey:     nodeKey,
+		changeCount: len(entries),
+	}
+
+	if nodeKey.IsZero() {
+		plan.entries = entries
+		return plan, nil
+	}
+
+	nodeValue, err := s.Db.Get(nodeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if nodeValue.IsFinalNode() {
+		// the existing leaf is merged with the changes unless one of them overrides it
+		existing := leafEntry(entries[0].path[:level], nodeValue)
+		merged := make([]*batchEntry, 0, len(entries)+1)
+		inserted := false
+		for _, e := range entries {
+			if !inserted {
+				if c := comparePaths(existing.path, e.path); c <= 0 {
+					inserted = true
+					if c < 0 {
+						merged = append(merged, existing)
+					}
+				}
+			}
+			merged = append(merged, e)
+		}
+		if !inserted {
+			merged = append(merged, existing)
+		}
+		plan.entries = merged
+		return plan, nil
+	}
+
+	plan.isBranch = true
+	split := splitByBit(entries, level)
+	sides := [2][]*batchEntry{entries[:split], entries[split:]}
+
+	for side := 0; side < 2; side++ {
+		childKey := utils.NodeKeyFromBigIntArray(nodeValue[side*4 : side*4+4])
+		if len(sides[side]) == 0 {
+			plan.unchanged[side] = childKey
+			continue
+		}
+
+		if plan.children[side], err = s.planBatch(ctx, childKey, level+1, sides[side]); err != nil {
+			return nil, err
+		}
+
+		// if the changed side can end up empty, the other side may have to collapse into this node
+		other := 1 - side
+		if len(sides[other]) == 0 && hasDelete(sides[side]) {
+			siblingKey := utils.NodeKeyFromBigIntArray(nodeValue[other*4 : other*4+4])
+			if !siblingKey.IsZero() {
+				sibling, err := s.Db.Get(siblingKey)
+				if err != nil {
+					return nil, err
+				}
+				if sibling.IsFinalNode() {
+					prefix := append(append([]int{}, entries[0].path[:level]...), other)
+					plan.siblingLeaf[other] = leafEntry(prefix, sibling)
+				}
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// deleteReplacedNodes removes the nodes of the old paths that the new tree no longer references.
+// Value nodes are content addressed and may be shared by many leaves, so they are left in place as in insert.
+func (s *SMT) deleteReplacedNodes(plan *batchPlanNode, saved []batchSavedNode) error {
+	kept := make(map[utils.NodeKey]struct{}, len(saved)*2)
+	for _, n := range saved {
+		kept[n.hash] = struct{}{}
+		if n.capacity == utils.BranchCapacity {
+			// children without changes are only still referenced if their parent wasn't collapsed
+			kept[utils.NodeKey{n.in[0], n.in[1], n.in[2], n.in[3]}] = struct{}{}
+			kept[utils.NodeKey{n.in[4], n.in[5], n.in[6], n.in[7]}] = struct{}{}
+		}
+	}
+
+	return s.deleteReplacedPlanNodes(plan, kept)
+}
+
+func (s *SMT) deleteReplacedPlanNodes(plan *batchPlanNode, kept map[utils.NodeKey]struct{}) error {
+	replaced := []utils.NodeKey{plan.nodeKey}
+	for side := 0; side < 2; side++ {
+		if plan.siblingLeaf[side] != nil {
+			replaced = append(replaced, plan.unchanged[side])
+		}
+	}
+
+	for _, nodeKey := range replaced {
+		if nodeKey.IsZero() {
+			continue
+		}
+		if _, ok := kept[nodeKey]; ok {
+			continue
+		}
+		if err := s.Db.Delete(nodeKey); err != nil {
+			return err
+		}
+		// the cache would otherwise skip re-saving this node if it is ever hashed again
+		s.savedNodes.Remove(nodeKey)
+	}
+
+	for _, child := range plan.children {
+		if child == nil {
+			continue
+		}
+		if err := s.deleteReplacedPlanNodes(child, kept); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func leafEntry(prefix []int, nodeValue utils.NodeValue12) *batchEntry {
+	key := utils.JoinKey(prefix, *nodeValue.Get0to4())
+	return &batchEntry{
+		key:       *key,
+		path:      key.GetPath(),
+		valueHash: *nodeValue.Get4to8(),
+		isSet:     true,
+	}
+}
+
+func hasDelete(entries []*batchEntry) bool {
+	for _, e := range entries {
+		if !e.isSet {
+			return true
+		}
+	}
+	return false
+}
+
+func computeBatch(ctx context.Context, hasher Hasher, plan *batchPlanNode) (*batchResult, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	if !plan.isBranch {
+		set := make([]*batchEntry, 0, len(plan.entries))
+		for _, e := range plan.entries {
+			if e.isSet {
+				set = append(set, e)
+			}
+		}
+		return buildSubtree(hasher, set, plan.level)
+	}
+
+	var results [2]*batchResult
+	var errs [2]error
+
+	parallelChildren := plan.children[0] != nil && plan.children[1] != nil &&
+		plan.children[0].changeCount >= batchParallelThreshold && plan.children[1].changeCount >= batchParallelThreshold
+
+	var wg sync.WaitGroup
+	for side := 0; side < 2; side++ {
+		child := plan.children[side]
+		if child == nil {
+			results[side] = &batchResult{hash: plan.unchanged[side], leaf: plan.siblingLeaf[side]}
+			continue
+		}
+		if parallelChildren && side == 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[0], errs[0] = computeBatch(ctx, hasher, child)
+			}()
+			continue
+		}
+		results[side], errs[side] = computeBatch(ctx, hasher, child)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return combineChildren(hasher, results[0], results[1], plan.level, append(results[0].nodes, results[1].nodes...))
+}
+
+// combineChildren creates the node at level from its children, a leaf left alone in the node is moved up into it
+func combineChildren(hasher Hasher, left, right *batchResult, level int, nodes []batchSavedNode) (*batchResult, error) {
+	leftEmpty := utils.IsArrayUint64Empty(left.hash[:])
+	rightEmpty := utils.IsArrayUint64Empty(right.hash[:])
+
+	switch {
+	case leftEmpty && rightEmpty:
+		return &batchResult{nodes: nodes}, nil
+	case leftEmpty && right.leaf != nil:
+		return collapseLeaf(hasher, right.leaf, level, nodes)
+	case rightEmpty && left.leaf != nil:
+		return collapseLeaf(hasher, left.leaf, level, nodes)
+	}
+
+	return branchNode(hasher, left.hash, right.hash, nodes)
+}
+
+// buildSubtree creates the subtree holding only the given entries, rooted at level
+func buildSubtree(hasher Hasher, entries []*batchEntry, level int) (*batchResult, error) {
+	switch len(entries) {
+	case 0:
+		return &batchResult{}, nil
+	case 1:
+		return collapseLeaf(hasher, entries[0], level, nil)
+	}
+
+	split := splitByBit(entries, level)
+
+	var left, right *batchResult
+	var leftErr, rightErr error
+
+	if split >= batchParallelThreshold && len(entries)-split >= batchParallelThreshold {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			left, leftErr = buildSubtree(hasher, entries[:split], level+1)
+		}()
+		right, rightErr = buildSubtree(hasher, entries[split:], level+1)
+		wg.Wait()
+	} else {
+		left, leftErr = buildSubtree(hasher, entries[:split], level+1)
+		right, rightErr = buildSubtree(hasher, entries[split:], level+1)
+	}
+
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+
+	return branchNode(hasher, left.hash, right.hash, append(left.nodes, right.nodes...))
+}
+
+// collapseLeaf creates the leaf for the entry as a child of a node at level
+func collapseLeaf(hasher Hasher, e *batchEntry, level int, nodes []batchSavedNode) (*batchResult, error) {
+	rKey := utils.RemoveKeyBits(e.key, level)
+	in := utils.ConcatArrays4(rKey, e.valueHash)
+
+	h, err := hasher.Hash(in, utils.LeafCapacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchResult{
+		hash:  h,
+		leaf:  e,
+		nodes: append(nodes, batchSavedNode{in: in, capacity: utils.LeafCapacity, hash: h}),
+	}, nil
+}
+
+func branchNode(hasher Hasher, left, right [4]uint64, nodes []batchSavedNode) (*batchResult, error) {
+	in := utils.ConcatArrays4(left, right)
+
+	h, err := hasher.Hash(in, utils.BranchCapacity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &batchResult{
+		hash:  h,
+		nodes: append(nodes, batchSavedNode{in: in, capacity: utils.BranchCapacity, hash: h}),
+	}, nil
+}
@@ -0,0 +1,192 @@
+package smt
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/tenderly/zkevm-erigon-lib/kv/mdbx"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+func TestSMT_InsertBatch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	seq := NewSMT(nil)
+	batch := NewSMT(nil)
+
+	keys := make([]utils.NodeKey, 0)
+	values := make(map[utils.NodeKey]*big.Int)
+
+	for round := 0; round < 5; round++ {
+		changes := make([]BatchChange, 0)
+
+		// new keys
+		for i := 0; i < 300; i++ {
+			k := utils.ScalarToNodeKey(big.NewInt(rnd.Int63()))
+			keys = append(keys, k)
+			changes = append(changes, BatchChange{Key: k, Value: utils.ScalarToNodeValue8(big.NewInt(rnd.Int63n(1000) + 1))})
+		}
+
+		// updates and deletes of existing keys, some of them changed twice
+		for i := 0; i < 200 && round > 0; i++ {
+			k := keys[rnd.Intn(len(keys))]
+			v := big.NewInt(0)
+			if rnd.Intn(2) == 0 {
+				v = big.NewInt(rnd.Int63n(1000) + 1)
+			}
+			changes = append(changes, BatchChange{Key: k, Value: utils.ScalarToNodeValue8(v)})
+		}
+
+		for _, c := range changes {
+			v := utils.ArrayToScalarBig(c.Value[:])
+			if _, err := seq.insertSingle(c.Key, c.Value, [4]uint64{}); err != nil {
+				t.Fatal(err)
+			}
+			values[c.Key] = v
+		}
+
+		r, err := batch.InsertBatch(context.Background(), changes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if r.NewRootScalar.ToBigInt().Cmp(seq.LastRoot()) != 0 {
+			t.Fatalf("round %d: batch root %s is not equal to sequential root %s", round, r.NewRootScalar.ToBigInt().Text(16), seq.LastRoot().Text(16))
+		}
+	}
+
+	for k, v := range values {
+		proof, err := batch.GetProof(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyProof(proof); err != nil {
+			t.Fatalf("proof failed to verify: %v", err)
+		}
+		if proof.IsInclusion() != (v.Sign() != 0) {
+			t.Errorf("key %v: unexpected inclusion %v for value %v", k, proof.IsInclusion(), v)
+		}
+	}
+}
+
+func TestSMT_InsertBatch_DeleteAll(t *testing.T) {
+	s := NewSMT(nil)
+
+	changes := make([]BatchChange, 0)
+	for i := 1; i <= 100; i++ {
+		changes = append(changes, BatchChange{Key: utils.ScalarToNodeKey(big.NewInt(int64(i))), Value: utils.ScalarToNodeValue8(big.NewInt(int64(i)))})
+	}
+	if _, err := s.InsertBatch(context.Background(), changes); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range changes {
+		changes[i].Value = utils.ScalarToNodeValue8(big.NewInt(0))
+	}
+	r, err := s.InsertBatch(context.Background(), changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.NewRootScalar.IsZero() {
+		t.Errorf("expected empty root, got %s", r.NewRootScalar.ToBigInt().Text(16))
+	}
+}
+
+func TestSMT_AccountStateChanges(t *testing.T) {
+	seq := NewSMT(nil)
+	batch := NewSMT(nil)
+	addr := "0x1234567890123456789012345678901234567890"
+	storage := map[string]string{"0x1": "0x2a", "0x2": "0x1000"}
+
+	if _, err := seq.SetAccountState(addr, big.NewInt(100), big.NewInt(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := seq.SetContractBytecode(addr, "0x6080604052"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seq.SetContractStorage(addr, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := AccountStateChanges(addr, big.NewInt(100), big.NewInt(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := ContractBytecodeChanges(addr, "0x6080604052")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stor, err := ContractStorageChanges(addr, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes = append(append(changes, code...), stor...)
+
+	if _, err := batch.InsertBatch(context.Background(), changes); err != nil {
+		t.Fatal(err)
+	}
+	if batch.LastRoot().Cmp(seq.LastRoot()) != 0 {
+		t.Errorf("batch root %s is not equal to sequential root %s", batch.LastRoot().Text(16), seq.LastRoot().Text(16))
+	}
+}
+
+func TestSMT_InsertBatch_DeletesReplacedNodes(t *testing.T) {
+	N := 200
+	dbi, err := mdbx.NewTemporaryMdbx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := dbi.BeginRw(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := db2.CreateEriDbBuckets(tx); err != nil {
+		t.Fatal(err)
+	}
+
+	sdb := db2.NewEriDb(tx)
+	s := NewSMT(sdb)
+
+	// every round moves the same set of values to other keys, so the value nodes stay the same
+	changesForRound := func(round int) []BatchChange {
+		changes := make([]BatchChange, 0, N)
+		for i := 0; i < N; i++ {
+			v := big.NewInt(int64((i+round)%N + 1))
+			changes = append(changes, BatchChange{Key: utils.ScalarToNodeKey(big.NewInt(int64(i))), Value: utils.ScalarToNodeValue8(v)})
+		}
+		return changes
+	}
+
+	for round := 0; round < 4; round++ {
+		if _, err := s.InsertBatch(context.Background(), changesForRound(round)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// deleting a few keys collapses some of the branches
+	deletes := make([]BatchChange, 0)
+	for i := 0; i < N; i += 7 {
+		deletes = append(deletes, BatchChange{Key: utils.ScalarToNodeKey(big.NewInt(int64(i))), Value: utils.ScalarToNodeValue8(big.NewInt(0))})
+	}
+	if _, err := s.InsertBatch(context.Background(), deletes); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := NewSMT(nil)
+	if _, err := expected.InsertBatch(context.Background(), append(changesForRound(3), deletes...)); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LastRoot().Cmp(expected.LastRoot()) != 0 {
+		t.Fatalf("root is not as expected, got %v wanted %v", toHex(s.LastRoot()), toHex(expected.LastRoot()))
+	}
+
+	// the deleted keys leave their values behind, every other node in TableSmt must be part of the tree
+	got, want := len(sdb.GetDb()), len(expected.Db.(DebuggableDB).GetDb())
+	if got != want+len(deletes) {
+		t.Errorf("expected %d nodes in %s after the overwrites, got %d", want+len(deletes), db2.TableSmt, got)
+	}
+}
@@ -0,0 +1,238 @@
+package smt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/tenderly/zkevm-erigon-lib/etl"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//	GenerateFromCollector builds the same tree as GenerateFromKVBulk without holding the keys in memory.
+//
+//	The leaves are collected in an etl collector under a key that orders them by their path in the tree,
+//	so the collector (which spills to disk past its buffer size) does the sorting. When the leaves are read
+//	back in order, the depth of a leaf is known from the common prefixes with the leaves before and after it
+//	and every subtree left of the current leaf is final. Those subtrees are hashed and saved right away, only
+//	a stack of their hashes along the path of the current leaf is kept, so the memory used while building
+//	is bounded by the depth of the tree.
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	sortedLeafKeyLength   = 32
+	sortedLeafValueLength = 64
+)
+
+// SortedLeafKey encodes k so that the byte order of the encoded keys is the order of their paths in the tree
+func SortedLeafKey(k utils.NodeKey) []byte {
+	path := k.GetPath()
+	res := make([]byte, sortedLeafKeyLength)
+	for l, bit := range path {
+		res[l/8] |= byte(bit) << (7 - l%8)
+	}
+	return res
+}
+
+func sortedLeafKeyToPath(b []byte) ([]int, error) {
+	if len(b) != sortedLeafKeyLength {
+		return nil, fmt.Errorf("sorted leaf key is %d bytes, expected %d", len(b), sortedLeafKeyLength)
+	}
+	path := make([]int, sortedLeafKeyLength*8)
+	for l := range path {
+		path[l] = int(b[l/8]>>(7-l%8)) & 1
+	}
+	return path, nil
+}
+
+func encodeLeafValue(v utils.NodeValue8) []byte {
+	res := make([]byte, sortedLeafValueLength)
+	for i, limb := range v.ToUintArray() {
+		binary.BigEndian.PutUint64(res[i*8:], limb)
+	}
+	return res
+}
+
+func decodeLeafValue(b []byte) (utils.NodeValue8, error) {
+	if len(b) != sortedLeafValueLength {
+		return utils.NodeValue8{}, fmt.Errorf("leaf value is %d bytes, expected %d", len(b), sortedLeafValueLength)
+	}
+	var v utils.NodeValue8
+	for i := range v {
+		v[i] = new(big.Int).SetUint64(binary.BigEndian.Uint64(b[i*8:]))
+	}
+	return v, nil
+}
+
+// CollectLeaf adds a leaf of the tree to a collector read by GenerateFromCollector. Leaves with a zero value are
+// not part of the tree and are skipped.
+func CollectLeaf(collector *etl.Collector, k utils.NodeKey, v utils.NodeValue8) error {
+	value := v.ToUintArray()
+	if utils.IsArrayUint64Empty(value[:]) {
+		return nil
+	}
+	return collector.Collect(SortedLeafKey(k), encodeLeafValue(v))
+}
+
+// GenerateFromCollector builds the tree from the leaves added with CollectLeaf and sets it as the last root.
+// Like GenerateFromKVBulk it assumes an empty tree and unique keys. The nodes are saved to the db as soon as their
+// subtree is complete. The collector is drained by this, so it can't be read again.
+func (s *SMT) GenerateFromCollector(logPrefix string, collector *etl.Collector, quit <-chan struct{}) ([4]uint64, error) {
+	s.clearUpMutex.Lock()
+	defer s.clearUpMutex.Unlock()
+
+	log.Info(fmt.Sprintf("[%s] Building the tree from sorted leaves", logPrefix))
+	startTime := time.Now()
+
+	b := &streamBuilder{s: s, prevCommon: -1}
+	loadFunc := func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		path, err := sortedLeafKeyToPath(k)
+		if err != nil {
+			return err
+		}
+		value, err := decodeLeafValue(v)
+		if err != nil {
+			return err
+		}
+		return b.add(path, value)
+	}
+	if err := collector.Load(nil, "", loadFunc, etl.TransformArgs{
+		Quit: quit,
+		LogDetailsLoad: func(k, v []byte) []interface{} {
+			return []interface{}{"leaves", b.leaves}
+		},
+	}); err != nil {
+		return [4]uint64{}, err
+	}
+
+	root, err := b.finish()
+	if err != nil {
+		return [4]uint64{}, err
+	}
+
+	if err := s.setLastRoot(root); err != nil {
+		return [4]uint64{}, err
+	}
+
+	log.Info(fmt.Sprintf("[%s] Finished building the tree in %v", logPrefix, time.Since(startTime)), "leaves", b.leaves)
+
+	return root, nil
+}
+
+// streamNode is a complete subtree at depth, path is the path of one of its leaves
+type streamNode struct {
+	depth int
+	path  []int
+	hash  [4]uint64
+}
+
+type streamLeaf struct {
+	path  []int
+	value utils.NodeValue8
+}
+
+// streamBuilder builds the tree from leaves added in path order. The last leaf is held back until the next one
+// is known, because its depth depends on the common prefix with both of its neighbours.
+type streamBuilder struct {
+	s     *SMT
+	stack []streamNode
+
+	pending *streamLeaf
+	// length of the common prefix of the pending leaf and the one before it, -1 if there is none
+	prevCommon int
+	leaves     uint64
+}
+
+func (b *streamBuilder) add(path []int, value utils.NodeValue8) error {
+	if b.pending != nil {
+		common := 0
+		for common < len(path) && path[common] == b.pending.path[common] {
+			common++
+		}
+		if common == len(path) {
+			return fmt.Errorf("duplicate leaf %v", path)
+		}
+		if path[common] != 1 {
+			return fmt.Errorf("leaves are not sorted, %v is before %v", b.pending.path, path)
+		}
+		if err := b.flushPending(common); err != nil {
+			return err
+		}
+		b.prevCommon = common
+	}
+
+	b.pending = &streamLeaf{path: path, value: value}
+	b.leaves++
+	return nil
+}
+
+// flushPending saves the pending leaf, nextCommon is the length of its common prefix with the next leaf or -1
+func (b *streamBuilder) flushPending(nextCommon int) error {
+	depth := b.prevCommon
+	if nextCommon > depth {
+		depth = nextCommon
+	}
+	depth++
+
+	k, err := utils.NodeKeyFromPath(b.pending.path)
+	if err != nil {
+		return err
+	}
+	hash, err := b.s.createNewLeaf(k, utils.RemoveKeyBits(k, depth), b.pending.value)
+	if err != nil {
+		return err
+	}
+	b.stack = append(b.stack, streamNode{depth: depth, path: b.pending.path, hash: hash})
+	b.pending = nil
+
+	// everything below the branch where the next leaf splits off is complete
+	return b.fold(nextCommon + 1)
+}
+
+// fold hashes the top of the stack up until it is at depth
+func (b *streamBuilder) fold(depth int) error {
+	for len(b.stack) > 0 && b.stack[len(b.stack)-1].depth > depth {
+		top := b.stack[len(b.stack)-1]
+		b.stack = b.stack[:len(b.stack)-1]
+
+		var left, right [4]uint64
+		path := top.path
+		if len(b.stack) > 0 && b.stack[len(b.stack)-1].depth == top.depth {
+			// the subtree below is the left sibling of the top one
+			left, right = b.stack[len(b.stack)-1].hash, top.hash
+			path = b.stack[len(b.stack)-1].path
+			b.stack = b.stack[:len(b.stack)-1]
+		} else if top.path[top.depth-1] == 0 {
+			left = top.hash
+		} else {
+			right = top.hash
+		}
+
+		var in utils.NodeValue8
+		in.SetHalfValue(left, 0)
+		in.SetHalfValue(right, 1)
+		hash, err := b.s.hashcalcAndSave(in.ToUintArray(), utils.BranchCapacity)
+		if err != nil {
+			return err
+		}
+		b.stack = append(b.stack, streamNode{depth: top.depth - 1, path: path, hash: hash})
+	}
+	return nil
+}
+
+// finish saves the last leaf and hashes the remaining subtrees up to the root
+func (b *streamBuilder) finish() ([4]uint64, error) {
+	if b.pending == nil {
+		return [4]uint64{}, nil
+	}
+	if err := b.flushPending(-1); err != nil {
+		return [4]uint64{}, err
+	}
+	if len(b.stack) != 1 {
+		return [4]uint64{}, fmt.Errorf("%d subtrees left after building the tree", len(b.stack))
+	}
+	return b.stack[0].hash, nil
+}
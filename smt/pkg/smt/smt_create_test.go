@@ -1,12 +1,15 @@
 package smt
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/tenderly/zkevm-erigon-lib/etl"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
 )
 
@@ -138,6 +141,66 @@ func TestSMT_Create_CompareWithRandomData(t *testing.T) {
 	fmt.Println("Second build time: ", secondBuildTime)
 }
 
+func TestSMT_GenerateFromCollector(t *testing.T) {
+	for _, limit := range []int{0, 1, 2, 3, 100, 5000} {
+		t.Run(fmt.Sprintf("%d values", limit), func(t *testing.T) {
+			kvMap := map[utils.NodeKey]utils.NodeValue8{}
+			for i := 1; i <= limit; i++ {
+				bigInt := big.NewInt(rand.Int63n(int64(limit*10)) + 1)
+				kvMap[utils.ScalarToNodeKey(bigInt)] = utils.ScalarToNodeValue8(bigInt)
+			}
+
+			s1 := NewSMT(nil)
+			for k, v := range kvMap {
+				if _, err := s1.insertSingle(k, v, [4]uint64{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// a small buffer so the leaves are spilled to several files
+			collector := etl.NewCollector("", t.TempDir(), etl.NewSortableBuffer(4*datasize.KB))
+			defer collector.Close()
+			for k, v := range kvMap {
+				if err := CollectLeaf(collector, k, v); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s2 := NewSMT(nil)
+			root, err := s2.GenerateFromCollector("", collector, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if utils.ArrayToScalar(root[:]).Cmp(s1.LastRoot()) != 0 {
+				t.Fatalf("root hash is not as expected, got 0x%x wanted 0x%x", utils.ArrayToScalar(root[:]), s1.LastRoot())
+			}
+			if s2.LastRoot().Cmp(s1.LastRoot()) != 0 {
+				t.Fatalf("last root is not set, got 0x%x wanted 0x%x", s2.LastRoot(), s1.LastRoot())
+			}
+
+			leaves := 0
+			broken, err := s2.CheckNodes(context.Background(), root, func(k utils.NodeKey, v utils.NodeValue8) error {
+				leaves++
+				expected := kvMap[k]
+				if v.ToUintArray() != expected.ToUintArray() {
+					t.Errorf("leaf %v has value %v, expected %v", k, v.ToUintArray(), expected.ToUintArray())
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(broken) > 0 {
+				t.Fatalf("broken nodes in the generated tree: %v", broken)
+			}
+			if leaves != len(kvMap) {
+				t.Fatalf("expected %d leaves, got %d", len(kvMap), leaves)
+			}
+		})
+	}
+}
+
 func TestSMT_Create_Benchmark(t *testing.T) {
 	limit := 500000
 
@@ -307,6 +307,152 @@ func TestSMT_MultipleInsert2(t *testing.T) {
 	}
 }
 
+func TestSMT_Delete(t *testing.T) {
+	N := 64
+	deleted := map[int]bool{}
+	for i := 0; i < N; i += 3 {
+		deleted[i] = true
+	}
+
+	s := NewSMT(nil)
+	for i := 0; i < N; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i+1000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// inserts leave the replaced nodes behind, only the nodes orphaned by the deletes have to be gone
+	orphansBefore := unreachableNodes(t, s)
+
+	for i := range deleted {
+		if _, err := s.Delete(utils.ScalarToNodeKey(big.NewInt(int64(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := NewSMT(nil)
+	for i := 0; i < N; i++ {
+		if deleted[i] {
+			continue
+		}
+		if _, err := expected.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i+1000))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.LastRoot().Cmp(expected.LastRoot()) != 0 {
+		t.Fatalf("root is not as expected, got %v wanted %v", toHex(s.LastRoot()), toHex(expected.LastRoot()))
+	}
+
+	// value nodes may be shared between leaves so they are left in place
+	deletedValues := map[string]bool{}
+	for i := range deleted {
+		v := utils.ScalarToNodeValue8(big.NewInt(int64(i + 1000)))
+		h, err := utils.Hash(v.ToUintArray(), utils.BranchCapacity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deletedValues[utils.ConvertBigIntToHex(utils.ArrayToScalar(h[:]))] = true
+	}
+
+	got := s.Db.(DebuggableDB).GetDb()
+	for k := range reachableNodes(t, expected) {
+		if _, ok := got[k]; !ok {
+			t.Errorf("node %s missing after delete", k)
+		}
+	}
+	for k := range unreachableNodes(t, s) {
+		if !orphansBefore[k] && !deletedValues[k] {
+			t.Errorf("orphaned node %s left after delete", k)
+		}
+	}
+}
+
+func TestSMT_DeleteAll(t *testing.T) {
+	s := NewSMT(nil)
+	N := 32
+
+	for i := 0; i < N; i++ {
+		if _, err := s.InsertBI(big.NewInt(int64(i)), big.NewInt(int64(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orphansBefore := unreachableNodes(t, s)
+
+	var r *SMTResponse
+	var err error
+	for i := 0; i < N; i++ {
+		if r, err = s.Delete(utils.ScalarToNodeKey(big.NewInt(int64(i)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.Mode != "deleteLast" {
+		t.Errorf("Mode is not deleteLast, got %v", r.Mode)
+	}
+	if !r.NewRootScalar.IsZero() {
+		t.Errorf("Root hash is not zero, got %v", toHex(r.NewRootScalar.ToBigInt()))
+	}
+	if left := len(s.Db.(DebuggableDB).GetDb()) - len(orphansBefore); left > N {
+		t.Errorf("expected only value nodes to be left, got %d nodes", left)
+	}
+
+	// re-inserting after the prune has to save the nodes again, not rely on the hash cache
+	if _, err := s.InsertBI(big.NewInt(1), big.NewInt(2)); err != nil {
+		t.Fatal(err)
+	}
+	proof, err := s.GetProof(utils.ScalarToNodeKey(big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyProof(proof); err != nil {
+		t.Errorf("proof failed to verify after re-insert: %v", err)
+	}
+}
+
+// reachableNodes returns the db keys of all the nodes reachable from the last root, value nodes included
+func reachableNodes(t *testing.T, s *SMT) map[string]bool {
+	nodes := map[string]bool{}
+
+	var walk func(k utils.NodeKey)
+	walk = func(k utils.NodeKey) {
+		if k.IsZero() {
+			return
+		}
+		nodes[utils.ConvertBigIntToHex(utils.ArrayToScalar(k[:]))] = true
+
+		v, err := s.Db.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v[8] == nil {
+			t.Fatalf("node %s not found", utils.ConvertBigIntToHex(utils.ArrayToScalar(k[:])))
+		}
+		if v.IsFinalNode() {
+			valH := v.Get4to8()
+			nodes[utils.ConvertBigIntToHex(utils.ArrayToScalar(valH[:]))] = true
+			return
+		}
+		walk(utils.NodeKeyFromBigIntArray(v[0:4]))
+		walk(utils.NodeKeyFromBigIntArray(v[4:8]))
+	}
+	walk(utils.ScalarToRoot(s.LastRoot()))
+
+	return nodes
+}
+
+func unreachableNodes(t *testing.T, s *SMT) map[string]bool {
+	reachable := reachableNodes(t, s)
+	nodes := map[string]bool{}
+	for k := range s.Db.(DebuggableDB).GetDb() {
+		if !reachable[k] {
+			nodes[k] = true
+		}
+	}
+	return nodes
+}
+
 func printNode(n *SMTResponse) {
 	fmt.Printf(fmt.Sprintf("Root: %s Mode: %s\n", toHex(n.NewRootScalar.ToBigInt()), n.Mode))
 }
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"fmt"
+	"math/bits"
+
+	poseidon "github.com/iden3/go-iden3-crypto/goldenposeidon"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+//	A faster, batched version of goldenposeidon.Hash, used by Hash and HashBatch.
+//
+//	The reference implementation allocates a new state for every round, keeps the elements in
+//	montgomery form and raises to the 7th power through big.Int. Here the state is a fixed array of
+//	plain uint64s and the arithmetic uses the shape of the goldilocks prime p = 2^64 - 2^32 + 1:
+//	2^64 = 2^32 - 1 (mod p), so a 128 bit product is reduced with a few adds and subs. The entries of
+//	the MDS matrix are small, so a whole row is summed in 128 bits and reduced once.
+//	The permutations of a batch run side by side round by round, so the round constants are loaded once
+//	per batch. Elements are only brought into [0, p) at the end, the result is the same as
+//	goldenposeidon.Hash for every input.
+//////////////////////////////////////////////////////////////////////////////
+
+const (
+	poseidonWidth = poseidon.NROUNDSF + poseidon.CAPLEN
+
+	goldilocksPrime = 0xffffffff00000001
+	// 2^64 mod p
+	goldilocksEpsilon = 0xffffffff
+)
+
+type poseidonState [poseidonWidth]uint64
+
+var (
+	poseidonC []uint64
+	poseidonS []uint64
+	// the MDS matrix used in all full rounds but the one before the partial rounds, its entries are small
+	poseidonM [poseidonWidth][poseidonWidth]uint64
+	poseidonP [poseidonWidth][poseidonWidth]uint64
+)
+
+func init() {
+	poseidonC = make([]uint64, len(poseidon.C))
+	for i, c := range poseidon.C {
+		poseidonC[i] = c.ToUint64Regular()
+	}
+	poseidonS = make([]uint64, len(poseidon.S))
+	for i, s := range poseidon.S {
+		poseidonS[i] = s.ToUint64Regular()
+	}
+	for i := 0; i < poseidonWidth; i++ {
+		for j := 0; j < poseidonWidth; j++ {
+			poseidonM[i][j] = poseidon.M[i][j].ToUint64Regular()
+			poseidonP[i][j] = poseidon.P[i][j].ToUint64Regular()
+			// a row of the matrix times the state has to fit in 128 bits, see mixSmall
+			if poseidonM[i][j] >= 1<<32 {
+				panic(fmt.Sprintf("poseidon MDS matrix entry %d at %d,%d is too big", poseidonM[i][j], i, j))
+			}
+		}
+	}
+}
+
+func hashPoseidon(in [8]uint64, capacity [4]uint64) ([4]uint64, error) {
+	var states [1]poseidonState
+	copy(states[0][:poseidon.NROUNDSF], in[:])
+	copy(states[0][poseidon.NROUNDSF:], capacity[:])
+
+	permuteBatch(states[:])
+
+	var out [4]uint64
+	for j := range out {
+		out[j] = canonical(states[0][j])
+	}
+	return out, nil
+}
+
+// HashBatch hashes every in[i] with capacity[i] into out[i], the same way as Hash
+func HashBatch(in [][8]uint64, capacity [][4]uint64, out [][4]uint64) error {
+	if len(capacity) != len(in) || len(out) != len(in) {
+		return fmt.Errorf("hash batch of %d inputs with %d capacities and %d outputs", len(in), len(capacity), len(out))
+	}
+
+	states := make([]poseidonState, len(in))
+	for i := range in {
+		copy(states[i][:poseidon.NROUNDSF], in[i][:])
+		copy(states[i][poseidon.NROUNDSF:], capacity[i][:])
+	}
+
+	permuteBatch(states)
+
+	for i := range states {
+		for j := 0; j < poseidon.CAPLEN; j++ {
+			out[i][j] = canonical(states[i][j])
+		}
+	}
+
+	return nil
+}
+
+func permuteBatch(states []poseidonState) {
+	for i := range states {
+		states[i].ark(0)
+	}
+
+	for r := 0; r < poseidon.NROUNDSF/2; r++ {
+		last := r == poseidon.NROUNDSF/2-1
+		for i := range states {
+			states[i].exp7()
+			states[i].ark((r + 1) * poseidonWidth)
+			if last {
+				states[i].mix(&poseidonP)
+			} else {
+				states[i].mixSmall(&poseidonM)
+			}
+		}
+	}
+
+	for r := 0; r < poseidon.NROUNDSP; r++ {
+		c := poseidonC[(poseidon.NROUNDSF/2+1)*poseidonWidth+r]
+		sr := poseidonS[(poseidonWidth*2-1)*r : (poseidonWidth*2-1)*(r+1)]
+		for i := range states {
+			state := &states[i]
+			state[0] = goldilocksAdd(exp7(state[0]), c)
+
+			s0 := goldilocksMul(sr[0], state[0])
+			for j := 1; j < poseidonWidth; j++ {
+				s0 = goldilocksAdd(s0, goldilocksMul(sr[j], state[j]))
+				state[j] = goldilocksAdd(state[j], goldilocksMul(sr[poseidonWidth+j-1], state[0]))
+			}
+			state[0] = s0
+		}
+	}
+
+	for r := 0; r < poseidon.NROUNDSF/2; r++ {
+		for i := range states {
+			states[i].exp7()
+			if r < poseidon.NROUNDSF/2-1 {
+				states[i].ark((poseidon.NROUNDSF/2+1+r)*poseidonWidth + poseidon.NROUNDSP)
+			}
+			states[i].mixSmall(&poseidonM)
+		}
+	}
+}
+
+func (s *poseidonState) exp7() {
+	for i := range s {
+		s[i] = exp7(s[i])
+	}
+}
+
+// ark adds the round constants starting at it
+func (s *poseidonState) ark(it int) {
+	for i := range s {
+		s[i] = goldilocksAdd(s[i], poseidonC[it+i])
+	}
+}
+
+// mix multiplies the state by the matrix
+func (s *poseidonState) mix(matrix *[poseidonWidth][poseidonWidth]uint64) {
+	var res poseidonState
+	for i := 0; i < poseidonWidth; i++ {
+		for j := 0; j < poseidonWidth; j++ {
+			res[i] = goldilocksAdd(res[i], goldilocksMul(matrix[j][i], s[j]))
+		}
+	}
+	*s = res
+}
+
+// mixSmall multiplies the state by a matrix with entries below 2^32, summing the products of a row in 128 bits
+func (s *poseidonState) mixSmall(matrix *[poseidonWidth][poseidonWidth]uint64) {
+	var res poseidonState
+	for i := 0; i < poseidonWidth; i++ {
+		var hi, lo, carry uint64
+		for j := 0; j < poseidonWidth; j++ {
+			h, l := bits.Mul64(matrix[j][i], s[j])
+			lo, carry = bits.Add64(lo, l, 0)
+			hi += h + carry
+		}
+		res[i] = goldilocksReduce(hi, lo)
+	}
+	*s = res
+}
+
+func exp7(a uint64) uint64 {
+	a2 := goldilocksMul(a, a)
+	a3 := goldilocksMul(a2, a)
+	a4 := goldilocksMul(a2, a2)
+	return goldilocksMul(a3, a4)
+}
+
+// goldilocksAdd adds two elements, the inputs and the result may be any uint64, not only the ones below p
+func goldilocksAdd(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	sum, carry = bits.Add64(sum, carry*goldilocksEpsilon, 0)
+	// a second carry leaves sum below epsilon, so this can't overflow
+	return sum + carry*goldilocksEpsilon
+}
+
+func goldilocksMul(a, b uint64) uint64 {
+	return goldilocksReduce(bits.Mul64(a, b))
+}
+
+// goldilocksReduce reduces hi*2^64 + lo, using 2^64 = 2^32 - 1 and 2^96 = -1 (mod p)
+func goldilocksReduce(hi, lo uint64) uint64 {
+	hiHi := hi >> 32
+	hiLo := hi & goldilocksEpsilon
+
+	t0, borrow := bits.Sub64(lo, hiHi, 0)
+	// on a borrow t0 is 2^64 too big, and t0 >= 2^64 - 2^32 so this can't underflow
+	t0 -= borrow * goldilocksEpsilon
+
+	t1 := hiLo * goldilocksEpsilon
+	return goldilocksAdd(t0, t1)
+}
+
+func canonical(a uint64) uint64 {
+	if a >= goldilocksPrime {
+		return a - goldilocksPrime
+	}
+	return a
+}
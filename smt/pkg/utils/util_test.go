@@ -3,10 +3,13 @@ package utils
 import (
 	"fmt"
 	"math/big"
+	"math/rand"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+
+	poseidon "github.com/iden3/go-iden3-crypto/goldenposeidon"
 )
 
 func TestBinaryStringToInt64(t *testing.T) {
@@ -139,6 +142,16 @@ func TestScalarToArrayBig(t *testing.T) {
 	}
 }
 
+func TestArrayBigToScalar(t *testing.T) {
+	scalar, _ := new(big.Int).SetString("1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef", 16)
+
+	got := ArrayBigToScalar(ScalarToArrayBig(scalar))
+
+	if got.Cmp(scalar) != 0 {
+		t.Errorf("ArrayBigToScalar(ScalarToArrayBig(%x)) = %x", scalar, got)
+	}
+}
+
 func TestArrayToScalar(t *testing.T) {
 	array := []uint64{2, 3}
 	want := big.NewInt(0)
@@ -751,3 +764,134 @@ func TestNodeKeyFromPath(t *testing.T) {
 		}
 	}
 }
+
+func TestHashBatch(t *testing.T) {
+	in := [][8]uint64{{}, {1, 2, 3, 4, 5, 6, 7, 8}, {^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0), ^uint64(0)}}
+	capacity := [][4]uint64{BranchCapacity, LeafCapacity, {^uint64(0), 1, 2, 3}}
+	for i := 0; i < 100; i++ {
+		var v [8]uint64
+		for j := range v {
+			v[j] = rand.Uint64()
+		}
+		in = append(in, v)
+		capacity = append(capacity, [4]uint64{uint64(i % 2), 0, 0, 0})
+	}
+
+	out := make([][4]uint64, len(in))
+	if err := HashBatch(in, capacity, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range in {
+		expected, err := poseidon.Hash(in[i], capacity[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out[i] != expected {
+			t.Errorf("batch hash %d of %v: expected %v, got %v", i, in[i], expected, out[i])
+		}
+		if h, _ := Hash(in[i], capacity[i]); h != expected {
+			t.Errorf("hash %d of %v: expected %v, got %v", i, in[i], expected, h)
+		}
+	}
+
+	if err := HashBatch(in, capacity[1:], out); err == nil {
+		t.Error("expected an error for mismatched lengths")
+	}
+}
+
+func TestHash_KnownAnswers(t *testing.T) {
+	// vectors from the go-iden3-crypto goldenposeidon reference tests
+	const prime uint64 = 18446744069414584321
+
+	tests := []struct {
+		name     string
+		in       [8]uint64
+		capacity [4]uint64
+		expected [4]uint64
+	}{
+		{
+			name:     "zeros",
+			in:       [8]uint64{},
+			capacity: [4]uint64{},
+			expected: [4]uint64{4330397376401421145, 14124799381142128323, 8742572140681234676, 14345658006221440202},
+		},
+		{
+			name:     "ones",
+			in:       [8]uint64{1, 1, 1, 1, 1, 1, 1, 1},
+			capacity: [4]uint64{1, 1, 1, 1},
+			expected: [4]uint64{16428316519797902711, 13351830238340666928, 682362844289978626, 12150588177266359240},
+		},
+		{
+			name:     "prime minus one",
+			in:       [8]uint64{prime - 1, prime - 1, prime - 1, prime - 1, prime - 1, prime - 1, prime - 1, prime - 1},
+			capacity: [4]uint64{prime - 1, prime - 1, prime - 1, prime - 1},
+			expected: [4]uint64{13691089994624172887, 15662102337790434313, 14940024623104903507, 10772674582659927682},
+		},
+		{
+			name:     "prime",
+			in:       [8]uint64{prime, prime, prime, prime, prime, prime, prime, prime},
+			capacity: [4]uint64{},
+			expected: [4]uint64{4330397376401421145, 14124799381142128323, 8742572140681234676, 14345658006221440202},
+		},
+		{
+			name:     "mixed",
+			in:       [8]uint64{923978, 235763497586, 9827635653498, 112870, 289273673480943876, 230295874986745876, 6254867324987, 2087},
+			capacity: [4]uint64{},
+			expected: [4]uint64{1892171027578617759, 984732815927439256, 7866041765487844082, 8161503938059336191},
+		},
+	}
+
+	in := make([][8]uint64, len(tests))
+	capacity := make([][4]uint64, len(tests))
+	for i, tt := range tests {
+		in[i] = tt.in
+		capacity[i] = tt.capacity
+	}
+	out := make([][4]uint64, len(tests))
+	if err := HashBatch(in, capacity, out); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := Hash(tt.in, tt.capacity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h != tt.expected {
+				t.Errorf("Hash() = %v, want %v", h, tt.expected)
+			}
+			if out[i] != tt.expected {
+				t.Errorf("HashBatch() = %v, want %v", out[i], tt.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+	in := [8]uint64{1, 2, 3, 4, 5, 6, 7, 8}
+	for i := 0; i < b.N; i++ {
+		_, _ = Hash(in, BranchCapacity)
+	}
+}
+
+func BenchmarkHash_Reference(b *testing.B) {
+	in := [8]uint64{1, 2, 3, 4, 5, 6, 7, 8}
+	for i := 0; i < b.N; i++ {
+		_, _ = poseidon.Hash(in, BranchCapacity)
+	}
+}
+
+func BenchmarkHashBatch(b *testing.B) {
+	in := make([][8]uint64, 64)
+	capacity := make([][4]uint64, len(in))
+	out := make([][4]uint64, len(in))
+	for i := range in {
+		in[i] = [8]uint64{uint64(i), 2, 3, 4, 5, 6, 7, 8}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += len(in) {
+		_ = HashBatch(in, capacity, out)
+	}
+}
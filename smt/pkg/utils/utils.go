@@ -8,7 +8,6 @@ import (
 	"strconv"
 	"strings"
 
-	poseidon "github.com/iden3/go-iden3-crypto/goldenposeidon"
 	"sort"
 )
 
@@ -44,7 +43,7 @@ const (
 var (
 	LeafCapacity   = [4]uint64{1, 0, 0, 0}
 	BranchCapacity = [4]uint64{0, 0, 0, 0}
-	hashFunc       = poseidon.Hash
+	hashFunc       = hashPoseidon
 )
 
 func Hash(in [8]uint64, capacity [4]uint64) ([4]uint64, error) {
@@ -457,6 +456,18 @@ func ScalarToArrayBig(scalar *big.Int) []*big.Int {
 	return []*big.Int{r0, r1, r2, r3, r4, r5, r6, r7}
 }
 
+// ArrayBigToScalar is the inverse of ScalarToArrayBig, it joins 32 bit limbs back into a scalar
+func ArrayBigToScalar(array []*big.Int) *big.Int {
+	scalar := new(big.Int)
+	for i := len(array) - 1; i >= 0; i-- {
+		scalar.Lsh(scalar, 32)
+		if array[i] != nil {
+			scalar.Add(scalar, array[i])
+		}
+	}
+	return scalar
+}
+
 func JoinKey(usedBits []int, remainingKey NodeKey) *NodeKey {
 	n := make([]uint64, 4)
 	accs := make([]uint64, 4)
@@ -167,16 +167,28 @@ var DefaultFlags = []cli.Flag{
 	&utils.L2ChainIdFlag,
 	&utils.L2RpcUrlFlag,
 	&utils.L2DataStreamerUrlFlag,
+	&utils.L2DataStreamerMaxLagFlag,
+	&utils.L2DataStreamerHealthCheckIntervalFlag,
+	&utils.L2DataStreamerCrossCheckFlag,
 	&utils.L1ChainIdFlag,
 	&utils.L1RpcUrlFlag,
+	&utils.L1RpcWeightsFlag,
+	&utils.L1RpcRateLimitFlag,
 	&utils.L1ContractAddressFlag,
 	&utils.L1BlockRangeFlag,
 	&utils.L1QueryDelayFlag,
+	&utils.L1FinalityFlag,
+	&utils.L1SyncOnlyFlag,
+	&utils.L1BatchMetadataFlag,
 	&utils.L1MaticContractAddressFlag,
 	&utils.L1GERManagerContractAddressFlag,
 	&utils.L1FirstBlockFlag,
 	&utils.RpcRateLimitsFlag,
 	&utils.RebuildTreeAfterFlag,
+	&utils.SmtRegenerateBufferSizeFlag,
+	&utils.SmtNodeCacheSizeFlag,
+	&utils.SmtHistoryBlocksFlag,
 	&utils.DataStreamHost,
 	&utils.DataStreamPort,
+	&utils.DataStreamVersion,
 }
@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"github.com/tenderly/zkevm-erigon/zk/sequencer"
+	"strconv"
 	"strings"
 	"time"
 
@@ -306,14 +307,54 @@ func ApplyFlagsForZkConfig(ctx *cli.Context, cfg *ethconfig.Config) {
 		L1FirstBlock:                ctx.Uint64(utils.L1FirstBlockFlag.Name),
 		RpcRateLimits:               ctx.Int(utils.RpcRateLimitsFlag.Name),
 		RebuildTreeAfter:            ctx.Uint64(utils.RebuildTreeAfterFlag.Name),
+		SmtHistoryBlocks:            ctx.Uint64(utils.SmtHistoryBlocksFlag.Name),
 		L1BlockRange:                ctx.Uint64(utils.L1BlockRangeFlag.Name),
 		L1QueryDelay:                ctx.Uint64(utils.L1QueryDelayFlag.Name),
+
+		L2DataStreamerMaxLag:              ctx.Uint64(utils.L2DataStreamerMaxLagFlag.Name),
+		L2DataStreamerHealthCheckInterval: ctx.Duration(utils.L2DataStreamerHealthCheckIntervalFlag.Name),
+		L2DataStreamerCrossCheck:          ctx.Bool(utils.L2DataStreamerCrossCheckFlag.Name),
+
+		L1Finality: ctx.String(utils.L1FinalityFlag.Name),
+
+		L1SyncOnly:      ctx.Bool(utils.L1SyncOnlyFlag.Name),
+		L1BatchMetadata: ctx.Bool(utils.L1BatchMetadataFlag.Name),
+
+		L1RpcRateLimit: ctx.Float64(utils.L1RpcRateLimitFlag.Name),
+
+		DataStreamVersion: uint8(ctx.Uint(utils.DataStreamVersion.Name)),
+	}
+
+	if ctx.String(utils.SmtRegenerateBufferSizeFlag.Name) != "" {
+		err := cfg.Zk.SmtRegenerateBufferSize.UnmarshalText([]byte(ctx.String(utils.SmtRegenerateBufferSizeFlag.Name)))
+		if err != nil {
+			utils.Fatalf("Invalid smt regenerate buffer size provided: %v", err)
+		}
+	}
+
+	if err := cfg.Zk.SmtNodeCacheSize.UnmarshalText([]byte(ctx.String(utils.SmtNodeCacheSizeFlag.Name))); err != nil {
+		utils.Fatalf("Invalid smt node cache size provided: %v", err)
+	}
+
+	if weights := ctx.String(utils.L1RpcWeightsFlag.Name); weights != "" {
+		for _, w := range strings.Split(weights, ",") {
+			weight, err := strconv.ParseUint(strings.TrimSpace(w), 10, 64)
+			if err != nil {
+				utils.Fatalf("Invalid L1 rpc weight provided: %v", err)
+			}
+			cfg.Zk.L1RpcWeights = append(cfg.Zk.L1RpcWeights, weight)
+		}
+		if urls := cfg.Zk.L1RpcUrls(); len(cfg.Zk.L1RpcWeights) != len(urls) {
+			utils.Fatalf("%d L1 rpc weights provided for %d L1 rpc urls", len(cfg.Zk.L1RpcWeights), len(urls))
+		}
 	}
 
 	checkFlag(utils.L2ChainIdFlag.Name, cfg.Zk.L2ChainId)
 	if !sequencer.IsSequencer() {
 		checkFlag(utils.L2RpcUrlFlag.Name, cfg.Zk.L2RpcUrl)
-		checkFlag(utils.L2DataStreamerUrlFlag.Name, cfg.Zk.L2DataStreamerUrl)
+		if !cfg.Zk.L1SyncOnly {
+			checkFlag(utils.L2DataStreamerUrlFlag.Name, cfg.Zk.L2DataStreamerUrl)
+		}
 	}
 	checkFlag(utils.L1ChainIdFlag.Name, cfg.Zk.L1ChainId)
 	checkFlag(utils.L1RpcUrlFlag.Name, cfg.Zk.L1RpcUrl)
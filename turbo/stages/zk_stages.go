@@ -13,6 +13,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/core/vm"
 	"github.com/tenderly/zkevm-erigon/eth/ethconfig"
 	"github.com/tenderly/zkevm-erigon/eth/stagedsync"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	"github.com/tenderly/zkevm-erigon/turbo/engineapi"
 	"github.com/tenderly/zkevm-erigon/turbo/shards"
 	"github.com/tenderly/zkevm-erigon/turbo/snapshotsync"
@@ -33,8 +34,9 @@ func NewDefaultZkStages(ctx context.Context,
 	forkValidator *engineapi.ForkValidator,
 	engine consensus.Engine,
 	l1Syncer *syncer.L1Syncer,
-	datastreamClient *client.StreamClient,
+	datastreamClient client.Source,
 	datastreamServer *datastreamer.StreamServer,
+	smtNodeCache *db2.NodeCache,
 ) []*stagedsync.Stage {
 	dirs := cfg.Dirs
 	blockReader := snapshotsync.NewBlockReaderWithSnapshots(snapshots, cfg.TransactionsV3)
@@ -72,7 +74,7 @@ func NewDefaultZkStages(ctx context.Context,
 			cfg.Zk,
 		),
 		stagedsync.StageHashStateCfg(db, dirs, cfg.HistoryV3, agg),
-		zkStages.StageZkInterHashesCfg(db, true, true, false, dirs.Tmp, blockReader, controlServer.Hd, cfg.HistoryV3, agg, cfg.Zk),
+		zkStages.StageZkInterHashesCfg(db, true, true, false, dirs.Tmp, blockReader, controlServer.Hd, cfg.HistoryV3, agg, cfg.Zk, smtNodeCache),
 		stagedsync.StageHistoryCfg(db, cfg.Prune, dirs.Tmp),
 		stagedsync.StageLogIndexCfg(db, cfg.Prune, dirs.Tmp),
 		stagedsync.StageCallTracesCfg(db, cfg.Prune, 0, dirs.Tmp),
@@ -95,6 +97,7 @@ func NewSequencerZkStages(ctx context.Context,
 	datastreamServer *datastreamer.StreamServer,
 	txPool *txpool.TxPool,
 	txPoolDb kv.RwDB,
+	smtNodeCache *db2.NodeCache,
 ) []*stagedsync.Stage {
 	dirs := cfg.Dirs
 	blockReader := snapshotsync.NewBlockReaderWithSnapshots(snapshots, cfg.TransactionsV3)
@@ -106,7 +109,7 @@ func NewSequencerZkStages(ctx context.Context,
 	return zkStages.SequencerZkStages(ctx,
 		stagedsync.StageCumulativeIndexCfg(db),
 		zkStages.StageDataStreamCatchupCfg(datastreamServer, db),
-		zkStages.StageSequencerInterhashesCfg(db),
+		zkStages.StageSequencerInterhashesCfg(db, dirs.Tmp, smtNodeCache),
 		zkStages.StageSequenceBlocksCfg(
 			db,
 			cfg.Prune,
@@ -129,7 +132,7 @@ func NewSequencerZkStages(ctx context.Context,
 			txPoolDb,
 		),
 		stagedsync.StageHashStateCfg(db, dirs, cfg.HistoryV3, agg),
-		zkStages.StageZkInterHashesCfg(db, true, true, false, dirs.Tmp, blockReader, controlServer.Hd, cfg.HistoryV3, agg, cfg.Zk),
+		zkStages.StageZkInterHashesCfg(db, true, true, false, dirs.Tmp, blockReader, controlServer.Hd, cfg.HistoryV3, agg, cfg.Zk, smtNodeCache),
 		stagedsync.StageHistoryCfg(db, cfg.Prune, dirs.Tmp),
 		stagedsync.StageLogIndexCfg(db, cfg.Prune, dirs.Tmp),
 		stagedsync.StageCallTracesCfg(db, cfg.Prune, 0, dirs.Tmp),
@@ -0,0 +1,203 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+// the stream file starts with these, followed by the header entry
+var streamFileMagic = []byte("polygonDATSTREAM")
+
+// FileSource reads the blocks from a stream file written by a datastreamer, so a node can be synced from an archived
+// stream without a connection to one
+type FileSource struct {
+	blockQueue
+	fileName string
+}
+
+func NewFileSource(fileName string) *FileSource {
+	return &FileSource{
+		blockQueue: newBlockQueue(nil),
+		fileName:   fileName,
+	}
+}
+
+// reads the entries of the file up to the end of the stream
+func (f *FileSource) ReadAllEntriesToChannel(bookmark *types.Bookmark) error {
+	file, err := OpenStreamFile(f.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return f.readAllFullL2BlocksToChannel(file.r, file.r.codec, bookmark)
+}
+
+// StreamFile reads the entries of a stream file in order
+type StreamFile struct {
+	file *os.File
+	r    *streamFileReader
+}
+
+func OpenStreamFile(fileName string) (*StreamFile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("open stream file error: %v", err)
+	}
+
+	r, err := newStreamFileReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	return &StreamFile{file: file, r: r}, nil
+}
+
+// ReadEntry returns io.EOF after the last entry
+func (f *StreamFile) ReadEntry() (*types.FileEntry, error) {
+	entry, err := f.r.readFileEntry()
+	if errors.Is(err, errEndOfStream) {
+		return nil, io.EOF
+	}
+	return entry, err
+}
+
+// Codec decodes the entries in the version of the stream in the file header
+func (f *StreamFile) Codec() *types.Codec {
+	return f.r.codec
+}
+
+func (f *StreamFile) Close() error {
+	return f.file.Close()
+}
+
+// streamFileReader reads the entries of a stream file. The file is a header page followed by data pages, an entry
+// never spans two pages and the rest of a page that can't fit the next entry is padding.
+type streamFileReader struct {
+	r           *bufio.Reader
+	pos         uint64
+	totalLength uint64
+	codec       *types.Codec
+}
+
+func newStreamFileReader(file io.Reader) (*streamFileReader, error) {
+	s := &streamFileReader{r: bufio.NewReader(file)}
+
+	magic, err := s.read(uint32(len(streamFileMagic)))
+	if err != nil {
+		return nil, fmt.Errorf("read magic numbers error: %v", err)
+	}
+	if !bytes.Equal(magic, streamFileMagic) {
+		return nil, errors.New("not a stream file")
+	}
+
+	// the header changed between versions of the datastreamer but it always ends with the total length and entries
+	header, err := s.read(5)
+	if err != nil {
+		return nil, fmt.Errorf("read header error: %v", err)
+	}
+	if header[0] != PtHeader {
+		return nil, fmt.Errorf("error expecting header packet type %d and received %d", PtHeader, header[0])
+	}
+	length := binary.BigEndian.Uint32(header[1:5])
+	if length < 5+16 {
+		return nil, fmt.Errorf("wrong header length %d", length)
+	}
+	rest, err := s.read(length - 5)
+	if err != nil {
+		return nil, fmt.Errorf("read header error: %v", err)
+	}
+	s.totalLength = binary.BigEndian.Uint64(rest[len(rest)-16 : len(rest)-8])
+
+	// the version follows the length in the versioned header
+	var version types.StreamVersion
+	if length == types.HeaderSizeVersioned {
+		version = types.StreamVersion(rest[0])
+	}
+	if s.codec, err = types.NewCodec(version); err != nil {
+		return nil, err
+	}
+
+	if err := s.skip(datastreamer.PageHeaderSize - s.pos); err != nil {
+		return nil, fmt.Errorf("read header page error: %v", err)
+	}
+
+	return s, nil
+}
+
+func (s *streamFileReader) read(n uint32) ([]byte, error) {
+	b, err := readBuffer(s.r, n)
+	if err != nil {
+		return nil, err
+	}
+	s.pos += uint64(n)
+	return b, nil
+}
+
+func (s *streamFileReader) skip(n uint64) error {
+	if _, err := s.r.Discard(int(n)); err != nil {
+		return err
+	}
+	s.pos += n
+	return nil
+}
+
+// skipPadding moves to the next data page if the rest of the current one is padding
+func (s *streamFileReader) skipPadding() error {
+	if s.pos >= s.totalLength {
+		return nil
+	}
+	packet, err := s.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if packet[0] != PtPadding {
+		return nil
+	}
+	if offset := (s.pos - datastreamer.PageHeaderSize) % datastreamer.PageDataSize; offset != 0 {
+		return s.skip(datastreamer.PageDataSize - offset)
+	}
+	return errors.New("padding at the start of a data page")
+}
+
+func (s *streamFileReader) atEnd() (bool, error) {
+	if err := s.skipPadding(); err != nil {
+		return false, err
+	}
+	return s.pos >= s.totalLength, nil
+}
+
+func (s *streamFileReader) readFileEntry() (*types.FileEntry, error) {
+	end, err := s.atEnd()
+	if err != nil {
+		return &types.FileEntry{}, err
+	}
+	if end {
+		return &types.FileEntry{}, errEndOfStream
+	}
+
+	packet, err := s.read(1)
+	if err != nil {
+		return &types.FileEntry{}, fmt.Errorf("failed to read packet type: %v", err)
+	}
+	if packet[0] != PtData {
+		return &types.FileEntry{}, fmt.Errorf("error expecting data packet type %d and received %d", PtData, packet[0])
+	}
+
+	file, err := readDataEntry(s.r, packet)
+	if err != nil {
+		return &types.FileEntry{}, err
+	}
+	s.pos += uint64(file.Length) - 1
+
+	return file, nil
+}
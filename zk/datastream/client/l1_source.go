@@ -0,0 +1,330 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
+	zktypes "github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+const (
+	defaultL1PollInterval = 5 * time.Second
+	// how many of the batches sent last are checked against the L1 data, for a reorg of L1 that removed them
+	maxL1SentBatches = 1024
+)
+
+// L1Source rebuilds the blocks from the data of the batches sequenced on L1, as the L1 syncer read it into the hermez
+// db, so a node can sync without trusting the datastream of the sequencer. Up to the etrog fork every transaction of a
+// batch is a block of its own, with the timestamp and coinbase of the batch. The state roots of the blocks and batches
+// are left empty, the roots computed when the blocks are hashed are written into their headers then.
+type L1Source struct {
+	blockQueue
+
+	db           kv.RoDB
+	pollInterval time.Duration
+
+	// the batches sent last, lowest first
+	sent []l1SentBatch
+
+	stopCh chan struct{}
+}
+
+// l1SentBatch is a batch sent along with the sequence it was read from
+type l1SentBatch struct {
+	batchNo     uint64
+	l1TxHash    common.Hash
+	lastL2Block uint64
+}
+
+func NewL1Source(db kv.RoDB) *L1Source {
+	stopCh := make(chan struct{})
+	return &L1Source{
+		blockQueue:   newBlockQueue(stopCh),
+		db:           db,
+		pollInterval: defaultL1PollInterval,
+		stopCh:       stopCh,
+	}
+}
+
+func (l *L1Source) Stop() {
+	close(l.stopCh)
+}
+
+// ReadAllEntriesToChannel rebuilds the blocks of the batch of the block the bookmark points to onwards, and waits for
+// the L1 syncer to read new batches. It unwinds the blocks of the batches L1 was reorganised from.
+func (l *L1Source) ReadAllEntriesToChannel(bookmark *types.Bookmark) error {
+	if bookmark.Type != types.BookmarkTypeStart {
+		return fmt.Errorf("unsupported bookmark type %d", bookmark.Type)
+	}
+	if err := l.skipTo(bookmark); err != nil {
+		return err
+	}
+
+	var batchNo, l2BlockNo uint64
+	if err := l.db.View(context.Background(), func(tx kv.Tx) (err error) {
+		hermezDb := hermez_db.NewHermezDbReader(tx)
+		if batchNo, l2BlockNo, err = startOfBatchOf(hermezDb, bookmark.From); err != nil {
+			return err
+		}
+		l.sent, err = sentL1Batches(hermezDb, batchNo)
+		return err
+	}); err != nil {
+		return err
+	}
+	// the blocks before the ones rebuilt were written, a reorg of their batches unwinds them
+	if l2BlockNo > 1 {
+		lastL2BlockNo := l2BlockNo - 1
+		if bookmark.From > l2BlockNo {
+			lastL2BlockNo = bookmark.From - 1
+			l.resumeAfter(lastL2BlockNo, batchNo)
+		} else {
+			l.resumeAfter(lastL2BlockNo, batchNo-1)
+		}
+	}
+
+	for {
+		var batch *zktypes.L1BatchData
+		var forkId uint64
+		var unwindTo *l1SentBatch
+		if err := l.db.View(context.Background(), func(tx kv.Tx) (err error) {
+			hermezDb := hermez_db.NewHermezDbReader(tx)
+			if unwindTo, err = l.reorged(hermezDb); err != nil || unwindTo != nil {
+				return err
+			}
+			if batch, err = nextL1Batch(hermezDb, batchNo); err != nil || batch == nil {
+				return err
+			}
+			forkId, err = hermezDb.GetForkId(batchNo)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		if unwindTo != nil {
+			log.Warn("L1 reorganised, unwinding the blocks of the batches sequenced after", "batch", unwindTo.batchNo, "block", unwindTo.lastL2Block)
+			if err := l.sendUnwind(&types.Unwind{BatchNumber: unwindTo.batchNo, L2BlockNumber: unwindTo.lastL2Block}); err != nil {
+				return nil
+			}
+			batchNo, l2BlockNo = unwindTo.batchNo+1, unwindTo.lastL2Block+1
+			continue
+		}
+
+		if batch == nil {
+			select {
+			case <-l.stopCh:
+				return nil
+			case <-time.After(l.pollInterval):
+			}
+			continue
+		}
+
+		blocks, err := l1BatchBlocks(batch, forkId, l2BlockNo)
+		if err != nil {
+			return fmt.Errorf("batch %d: %w", batchNo, err)
+		}
+		for _, block := range blocks {
+			if err := l.sendBlock(block, nil); err != nil {
+				return l.stoppedOr(err)
+			}
+		}
+		if err := l.sendBatchEnd(&types.BatchEnd{BatchNumber: batchNo}); err != nil {
+			return l.stoppedOr(err)
+		}
+
+		l2BlockNo += uint64(len(blocks))
+		l.sent = append(l.sent, l1SentBatch{batchNo: batchNo, l1TxHash: batch.L1TxHash, lastL2Block: l2BlockNo - 1})
+		if len(l.sent) > maxL1SentBatches {
+			l.sent = l.sent[len(l.sent)-maxL1SentBatches:]
+		}
+		batchNo++
+	}
+}
+
+func (l *L1Source) stoppedOr(err error) error {
+	select {
+	case <-l.stopCh:
+		return nil
+	default:
+		return err
+	}
+}
+
+// reorged returns the last batch sent that is still sequenced by the same tx, if the last one isn't. If none of them
+// is, it is the last batch before them that is still sequenced.
+func (l *L1Source) reorged(hermezDb *hermez_db.HermezDbReader) (*l1SentBatch, error) {
+	for i := len(l.sent) - 1; i >= 0; i-- {
+		batch, err := hermezDb.GetL1BatchData(l.sent[i].batchNo)
+		if err != nil {
+			return nil, err
+		}
+		if batch != nil && batch.L1TxHash == l.sent[i].l1TxHash {
+			if i == len(l.sent)-1 {
+				return nil, nil
+			}
+			unwindTo := l.sent[i]
+			l.sent = l.sent[:i+1]
+			return &unwindTo, nil
+		}
+	}
+	if len(l.sent) == 0 {
+		return nil, nil
+	}
+
+	unwindTo, err := lastL1BatchBefore(hermezDb, l.sent[0].batchNo)
+	if err != nil {
+		return nil, err
+	}
+	l.sent = l.sent[:0]
+	if unwindTo.batchNo > 0 {
+		l.sent = append(l.sent, *unwindTo)
+	}
+	return unwindTo, nil
+}
+
+// sentL1Batches returns the batches written before batchNo, the last of them that are kept as sent, with the txs they
+// are sequenced by now. The data of a batch L1 was reorganised from while the node was stopped is removed, the batch
+// is then found reorganised.
+func sentL1Batches(hermezDb *hermez_db.HermezDbReader, batchNo uint64) ([]l1SentBatch, error) {
+	from := uint64(1)
+	if batchNo > maxL1SentBatches {
+		from = batchNo - maxL1SentBatches
+	}
+
+	sent := make([]l1SentBatch, 0)
+	for b := from; b < batchNo; b++ {
+		_, last, found, err := hermezDb.GetBatchBlockRange(b)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		batch, err := hermezDb.GetL1BatchData(b)
+		if err != nil {
+			return nil, err
+		}
+		sentBatch := l1SentBatch{batchNo: b, lastL2Block: last}
+		if batch != nil {
+			sentBatch.l1TxHash = batch.L1TxHash
+		}
+		sent = append(sent, sentBatch)
+	}
+	return sent, nil
+}
+
+// lastL1BatchBefore returns the highest batch before batchNo that is still sequenced on L1, the genesis batch if
+// there is none
+func lastL1BatchBefore(hermezDb *hermez_db.HermezDbReader, batchNo uint64) (*l1SentBatch, error) {
+	for b := batchNo - 1; b > 0; b-- {
+		batch, err := hermezDb.GetL1BatchData(b)
+		if err != nil {
+			return nil, err
+		}
+		if batch == nil {
+			continue
+		}
+		_, last, found, err := hermezDb.GetBatchBlockRange(b)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("no blocks of batch %d, still sequenced before the batches L1 was reorganised from", b)
+		}
+		return &l1SentBatch{batchNo: b, l1TxHash: batch.L1TxHash, lastL2Block: last}, nil
+	}
+	return &l1SentBatch{}, nil
+}
+
+// startOfBatchOf returns the batch of a block and the first block of it, the blocks are rebuilt from the start of a
+// batch. The batch after the genesis one starts at block 1.
+func startOfBatchOf(hermezDb *hermez_db.HermezDbReader, l2BlockNo uint64) (batchNo, firstL2BlockNo uint64, err error) {
+	if l2BlockNo == 0 {
+		return 1, 1, nil
+	}
+	if batchNo, err = hermezDb.GetBatchNoByL2Block(l2BlockNo); err != nil {
+		return 0, 0, err
+	}
+	first, _, found, err := hermezDb.GetBatchBlockRange(batchNo)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !found {
+		// a batch written before the batch index is complete, the next one is rebuilt
+		return batchNo + 1, l2BlockNo + 1, nil
+	}
+	return batchNo, first, nil
+}
+
+// nextL1Batch returns the data of a batch, nil while it is not sequenced yet. A batch that is sequenced without data
+// was sequenced by forced batches, or read before the L1 syncer read the data of the batches, it can't be rebuilt.
+func nextL1Batch(hermezDb *hermez_db.HermezDbReader, batchNo uint64) (*zktypes.L1BatchData, error) {
+	batch, err := hermezDb.GetL1BatchData(batchNo)
+	if err != nil || batch != nil {
+		return batch, err
+	}
+
+	sequence, err := hermezDb.GetLatestSequence()
+	if err != nil {
+		return nil, err
+	}
+	if sequence != nil && sequence.BatchNo >= batchNo {
+		return nil, fmt.Errorf("batch %d is sequenced on L1 but its data wasn't read from the sequence", batchNo)
+	}
+	return nil, nil
+}
+
+// l1BatchBlocks rebuilds the blocks of a batch sequenced before the etrog fork, the first one from firstL2BlockNo
+func l1BatchBlocks(batch *zktypes.L1BatchData, forkId uint64, firstL2BlockNo uint64) ([]*types.FullL2Block, error) {
+	if forkId == 0 {
+		return nil, fmt.Errorf("no fork id, the fork id updates are read from L1")
+	}
+	if forkId >= txtype.ForkIDEtrog {
+		return nil, fmt.Errorf("fork id %d isn't supported, the batches of the etrog fork onwards can't be rebuilt from L1", forkId)
+	}
+
+	txs, _, efficiencyPercentages, err := txtype.DecodeTxs(batch.Transactions, forkId)
+	if err != nil {
+		return nil, fmt.Errorf("decode txs error: %v", err)
+	}
+
+	blocks := make([]*types.FullL2Block, 0, len(txs))
+	for i, tx := range txs {
+		var encoded bytes.Buffer
+		if err := tx.MarshalBinary(&encoded); err != nil {
+			return nil, fmt.Errorf("encode tx error: %v", err)
+		}
+		efficiencyPercentage := txtype.MaxEffectivePercentage
+		if i < len(efficiencyPercentages) {
+			efficiencyPercentage = efficiencyPercentages[i]
+		}
+
+		block := &types.FullL2Block{
+			BatchNumber:   batch.BatchNo,
+			L2BlockNumber: firstL2BlockNo + uint64(i),
+			Timestamp:     int64(batch.Timestamp),
+			Coinbase:      batch.Coinbase,
+			ForkId:        uint16(forkId),
+			L2Txs: []types.L2Transaction{{
+				EffectiveGasPricePercentage: efficiencyPercentage,
+				IsValid:                     1,
+				EncodedLength:               uint32(encoded.Len()),
+				Encoded:                     encoded.Bytes(),
+			}},
+		}
+		// the global exit root of the batch is set by its first block
+		if i == 0 {
+			block.GlobalExitRoot = batch.GlobalExitRoot
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
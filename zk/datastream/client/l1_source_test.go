@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon-lib/kv/memdb"
+
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/crypto"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
+	zktypes "github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+const testL1ForkId = 5
+
+// testL1Txs signs txs from nonce on and encodes them as batch data
+func testL1Txs(t *testing.T, nonce uint64, count int) ([]ethTypes.Transaction, []byte) {
+	key, err := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	require.NoError(t, err)
+	signer := ethTypes.LatestSignerForChainID(big.NewInt(1001))
+
+	txs := make([]ethTypes.Transaction, 0, count)
+	data := make([]byte, 0)
+	for i := 0; i < count; i++ {
+		tx := ethTypes.NewTransaction(nonce+uint64(i), common.HexToAddress("0x1"), uint256.NewInt(1), 21000, uint256.NewInt(1), nil)
+		signed, err := ethTypes.SignTx(tx, *signer, key)
+		require.NoError(t, err)
+		encoded, err := txtype.EncodeTx(signed, 200, testL1ForkId)
+		require.NoError(t, err)
+		txs = append(txs, signed)
+		data = append(data, encoded...)
+	}
+	return txs, data
+}
+
+func writeTestL1Batch(t *testing.T, db kv.RwDB, batch *zktypes.L1BatchData) {
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		hermezDb, err := hermez_db.NewHermezDb(tx)
+		if err != nil {
+			return err
+		}
+		if err := hermezDb.WriteL1BatchData(batch); err != nil {
+			return err
+		}
+		return hermezDb.WriteSequence(batch.L1BlockNo, batch.BatchNo, batch.L1TxHash, common.Hash{})
+	}))
+}
+
+func newTestL1Source(t *testing.T, forkId uint64) (kv.RwDB, *L1Source) {
+	db := memdb.NewTestDB(t)
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		if err := hermez_db.CreateHermezBuckets(tx); err != nil {
+			return err
+		}
+		hermezDb, err := hermez_db.NewHermezDb(tx)
+		if err != nil {
+			return err
+		}
+		return hermezDb.WriteForkId(1, forkId)
+	}))
+
+	l := NewL1Source(db)
+	l.pollInterval = 10 * time.Millisecond
+	return db, l
+}
+
+func nextTestItem(t *testing.T, l *L1Source) *StreamItem {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	item, err := l.Next(ctx)
+	require.NoError(t, err)
+	return item
+}
+
+func requireL1Block(t *testing.T, item *StreamItem, batchNo, blockNo uint64, tx ethTypes.Transaction) {
+	require.NotNil(t, item.Block)
+	require.Equal(t, batchNo, item.Block.BatchNumber)
+	require.Equal(t, blockNo, item.Block.L2BlockNumber)
+	require.Len(t, item.Block.L2Txs, 1)
+	decoded, _, err := txtype.DecodeTx(item.Block.L2Txs[0].Encoded, item.Block.L2Txs[0].EffectiveGasPricePercentage, item.Block.ForkId)
+	require.NoError(t, err)
+	require.Equal(t, tx.Hash(), decoded.Hash())
+	require.Equal(t, uint8(200), item.Block.L2Txs[0].EffectiveGasPricePercentage)
+}
+
+func TestL1Source(t *testing.T) {
+	db, l := newTestL1Source(t, testL1ForkId)
+	ger := common.HexToHash("0x10")
+	coinbase := common.HexToAddress("0x20")
+
+	txs1, data1 := testL1Txs(t, 0, 2)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 1, L1BlockNo: 10, L1TxHash: common.HexToHash("0xa"), Coinbase: coinbase, GlobalExitRoot: ger, Timestamp: 100, Transactions: data1})
+	txs2, data2 := testL1Txs(t, 2, 1)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 2, L1BlockNo: 11, L1TxHash: common.HexToHash("0xb"), Coinbase: coinbase, Timestamp: 200, Transactions: data2})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	item := nextTestItem(t, l)
+	requireL1Block(t, item, 1, 1, txs1[0])
+	require.Equal(t, ger, item.Block.GlobalExitRoot)
+	require.Equal(t, coinbase, item.Block.Coinbase)
+	require.Equal(t, int64(100), item.Block.Timestamp)
+	require.Equal(t, uint16(testL1ForkId), item.Block.ForkId)
+
+	// the ger of the batch is set by its first block only
+	item = nextTestItem(t, l)
+	requireL1Block(t, item, 1, 2, txs1[1])
+	require.Equal(t, common.Hash{}, item.Block.GlobalExitRoot)
+	require.Equal(t, uint64(1), nextTestItem(t, l).BatchEnd.BatchNumber)
+	requireL1Block(t, nextTestItem(t, l), 2, 3, txs2[0])
+	require.Equal(t, uint64(2), nextTestItem(t, l).BatchEnd.BatchNumber)
+
+	// L1 reorganised and batch 2 was sequenced again with other txs
+	txs2, data2 = testL1Txs(t, 2, 2)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 2, L1BlockNo: 12, L1TxHash: common.HexToHash("0xc"), Coinbase: coinbase, Timestamp: 300, Transactions: data2})
+
+	require.Equal(t, &types.Unwind{BatchNumber: 1, L2BlockNumber: 2}, nextTestItem(t, l).Unwind)
+	requireL1Block(t, nextTestItem(t, l), 2, 3, txs2[0])
+	requireL1Block(t, nextTestItem(t, l), 2, 4, txs2[1])
+	require.Equal(t, uint64(2), nextTestItem(t, l).BatchEnd.BatchNumber)
+
+	l.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func TestL1Source_Errors(t *testing.T) {
+	// the batches of etrog onwards have block boundaries in their data
+	db, l := newTestL1Source(t, txtype.ForkIDEtrog)
+	_, data := testL1Txs(t, 0, 1)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 1, L1BlockNo: 10, Transactions: data})
+	require.ErrorContains(t, l.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0)), "fork id 7 isn't supported")
+
+	// a batch sequenced without its data can't be rebuilt
+	db, l = newTestL1Source(t, testL1ForkId)
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		hermezDb, err := hermez_db.NewHermezDb(tx)
+		if err != nil {
+			return err
+		}
+		return hermezDb.WriteSequence(10, 1, common.HexToHash("0xa"), common.Hash{})
+	}))
+	require.ErrorContains(t, l.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0)), "its data wasn't read")
+}
+
+func TestL1Source_ReorgOfAllSent(t *testing.T) {
+	db, l := newTestL1Source(t, testL1ForkId)
+
+	txs1, data1 := testL1Txs(t, 0, 1)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 1, L1BlockNo: 10, L1TxHash: common.HexToHash("0xa"), Transactions: data1})
+	txs2, data2 := testL1Txs(t, 1, 1)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 2, L1BlockNo: 11, L1TxHash: common.HexToHash("0xb"), Transactions: data2})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	requireL1Block(t, nextTestItem(t, l), 1, 1, txs1[0])
+	require.Equal(t, uint64(1), nextTestItem(t, l).BatchEnd.BatchNumber)
+	requireL1Block(t, nextTestItem(t, l), 2, 2, txs2[0])
+	require.Equal(t, uint64(2), nextTestItem(t, l).BatchEnd.BatchNumber)
+
+	// L1 reorganised from before both batches, the blocks go back to the genesis batch
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 1, L1BlockNo: 9, L1TxHash: common.HexToHash("0xc"), Transactions: data2})
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 2, L1BlockNo: 9, L1TxHash: common.HexToHash("0xc"), Transactions: data1})
+
+	require.Equal(t, &types.Unwind{}, nextTestItem(t, l).Unwind)
+	requireL1Block(t, nextTestItem(t, l), 1, 1, txs2[0])
+
+	l.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func TestL1Source_ReorgBeforeStart(t *testing.T) {
+	db, l := newTestL1Source(t, testL1ForkId)
+
+	// batch 1 is blocks 1 and 2, batch 2 block 3 and batch 3 block 4
+	_, data1 := testL1Txs(t, 0, 2)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 1, L1BlockNo: 10, L1TxHash: common.HexToHash("0xa"), Transactions: data1})
+	require.NoError(t, db.Update(context.Background(), func(tx kv.RwTx) error {
+		hermezDb, err := hermez_db.NewHermezDb(tx)
+		if err != nil {
+			return err
+		}
+		for l2BlockNo, batchNo := range map[uint64]uint64{1: 1, 2: 1, 3: 2, 4: 3} {
+			if err := hermezDb.WriteBlockBatch(l2BlockNo, batchNo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	// the data of batches 2 and 3 was removed by a reorg while the node was stopped
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- l.ReadAllEntriesToChannel(types.NewL2BlockBookmark(4))
+	}()
+
+	require.Equal(t, &types.Unwind{BatchNumber: 1, L2BlockNumber: 2}, nextTestItem(t, l).Unwind)
+
+	txs2, data2 := testL1Txs(t, 2, 1)
+	writeTestL1Batch(t, db, &zktypes.L1BatchData{BatchNo: 2, L1BlockNo: 12, L1TxHash: common.HexToHash("0xc"), Transactions: data2})
+	requireL1Block(t, nextTestItem(t, l), 2, 3, txs2[0])
+
+	l.Stop()
+	require.NoError(t, <-errCh)
+}
@@ -0,0 +1,465 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ledgerwatch/log/v3"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultUpstreamTimeout     = 5 * time.Second
+)
+
+// ErrUpstreamMismatch is returned when two upstreams stream different blocks at the same height
+var ErrUpstreamMismatch = errors.New("datastream upstreams mismatch")
+
+type MultiSourceConfig struct {
+	// MaxLag is how many entries the upstream read from can be behind the most advanced one before failing over
+	MaxLag uint64
+	// HealthCheckInterval is how often the headers of the upstreams are requested, the upstream read from is
+	// stalled if it sent no entry for as long while its header has more
+	HealthCheckInterval time.Duration
+	// CrossCheck compares the hash and state root of every block with the same block of another upstream before
+	// sending it
+	CrossCheck bool
+	// Timeout of connecting to an upstream, of its header requests and of waiting for a block to cross check
+	Timeout time.Duration
+}
+
+// MultiSource reads the blocks from one of several datastream upstreams of the same chain. The upstreams are health
+// checked with the header command and it fails over to the most advanced of the others when the one read from
+// drops, stalls or falls too far behind, resuming from the last block sent.
+type MultiSource struct {
+	blockQueue
+
+	servers []string
+	cfg     MultiSourceConfig
+
+	mu sync.Mutex
+	// the upstream read from and its connection, nil once the health check dropped it
+	active int
+	conn   net.Conn
+	// headers of the last health check, nil for the unreachable upstreams
+	headers []*types.HeaderEntry
+
+	// the last entry read from the active upstream and when
+	lastEntry     atomic.Uint64
+	lastEntryTime atomic.Int64
+
+	// the upstream the blocks are cross checked with and the last block read from it
+	witness        *StreamClient
+	witnessIndex   int
+	witnessBlock   *types.FullL2Block
+	witnessRetryAt time.Time
+
+	reconnectBackoff     time.Duration
+	maxReconnectBackoff  time.Duration
+	maxReconnectAttempts int
+
+	stopCh chan struct{}
+}
+
+func NewMultiSource(servers []string, cfg MultiSourceConfig) *MultiSource {
+	if cfg.HealthCheckInterval == 0 {
+		cfg.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultUpstreamTimeout
+	}
+
+	stopCh := make(chan struct{})
+	return &MultiSource{
+		blockQueue: newBlockQueue(stopCh),
+		servers:    servers,
+		cfg:        cfg,
+		headers:    make([]*types.HeaderEntry, len(servers)),
+
+		reconnectBackoff:     defaultReconnectBackoff,
+		maxReconnectBackoff:  defaultMaxReconnectBackoff,
+		maxReconnectAttempts: defaultMaxReconnectAttempts,
+		stopCh:               stopCh,
+	}
+}
+
+func (m *MultiSource) Stop() {
+	close(m.stopCh)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.conn != nil {
+		m.conn.Close()
+	}
+}
+
+// ReadAllEntriesToChannel reads the blocks from the most advanced upstream and waits for new ones at the end of the
+// stream. It only gives up after maxReconnectAttempts failed attempts in a row, or when the cross check finds the
+// upstreams disagree.
+func (m *MultiSource) ReadAllEntriesToChannel(bookmark *types.Bookmark) error {
+	m.checkUpstreams()
+
+	done := make(chan struct{})
+	defer close(done)
+	go m.healthCheck(done)
+	defer m.closeWitness()
+
+	index := m.pick(-1)
+	attempts := 0
+	backoff := m.reconnectBackoff
+	for {
+		lastL2Block, sentL2Block := m.lastL2Block, m.sentL2Block
+
+		err := m.readFrom(index, bookmark)
+		if m.stopped() {
+			return nil
+		}
+
+		var unwound *unwindError
+		if errors.As(err, &unwound) {
+			log.Warn("Datastream unwound, resuming", "server", m.servers[index], "lastBlock", m.lastL2Block, "unwindBlock", unwound.unwind.L2BlockNumber, "unwindBatch", unwound.unwind.BatchNumber)
+			if err := m.sendUnwind(unwound.unwind); err != nil {
+				return nil
+			}
+			// the witness still has the unwound blocks
+			m.closeWitness()
+			attempts = 0
+			backoff = m.reconnectBackoff
+			continue
+		}
+		if errors.Is(err, ErrUpstreamMismatch) {
+			return err
+		}
+
+		// the attempts are counted from the last time a block got through
+		if m.sentL2Block != sentL2Block || m.lastL2Block != lastL2Block {
+			attempts = 0
+			backoff = m.reconnectBackoff
+		}
+		if attempts >= m.maxReconnectAttempts {
+			return fmt.Errorf("datastream upstreams error: %v", err)
+		}
+		attempts++
+
+		next := m.pick(index)
+		log.Warn("Datastream upstream failed, failing over", "server", m.servers[index], "next", m.servers[next], "lastBlock", m.lastL2Block, "attempt", attempts, "err", err)
+		index = next
+
+		// the backoff is only waited for once every upstream failed in a row
+		if attempts%len(m.servers) != 0 {
+			continue
+		}
+		select {
+		case <-m.stopCh:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > m.maxReconnectBackoff {
+			backoff = m.maxReconnectBackoff
+		}
+	}
+}
+
+// readFrom queues the blocks of an upstream until its connection fails, resuming from the last block queued
+func (m *MultiSource) readFrom(index int, bookmark *types.Bookmark) error {
+	c, err := dialUpstream(m.servers[index], m.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	defer c.conn.Close()
+
+	m.mu.Lock()
+	if m.stopped() {
+		m.mu.Unlock()
+		return errStopped
+	}
+	m.active, m.conn = index, c.conn
+	m.mu.Unlock()
+	m.lastEntryTime.Store(time.Now().UnixNano())
+
+	if err := c.GetHeader(); err != nil {
+		return err
+	}
+
+	// the last sent block is requested again as in StreamClient.ReadAllEntriesToChannel
+	resume := bookmark
+	if m.sentL2Block {
+		resume = types.NewL2BlockBookmark(m.lastL2Block)
+	}
+	if err := c.initiateDownloadBookmark(resume.Encode()); err != nil {
+		return err
+	}
+
+	defer m.Streaming.Store(false)
+	r := &activeReader{c: c, m: m}
+	for {
+		fullBlock, gerUpdates, _, _, _, err := readFullBlock(r, c.codec, m.sendBatchEnd)
+		if err != nil {
+			return fmt.Errorf("failed to read full block: %w", err)
+		}
+
+		if m.cfg.CrossCheck && !m.sent(fullBlock.L2BlockNumber) {
+			if err := m.crossCheck(fullBlock); err != nil {
+				return err
+			}
+		}
+
+		if err := m.sendBlock(fullBlock, gerUpdates); err != nil {
+			return err
+		}
+	}
+}
+
+// activeReader reads the entries of the active upstream and records when the last one was read for the stall check
+type activeReader struct {
+	c *StreamClient
+	m *MultiSource
+}
+
+func (r *activeReader) readFileEntry() (*types.FileEntry, error) {
+	entry, err := r.c.readFileEntry()
+	if err == nil {
+		r.m.lastEntry.Store(entry.EntryNum)
+		r.m.lastEntryTime.Store(time.Now().UnixNano())
+	}
+	return entry, err
+}
+
+// pick chooses the reachable upstream with the most entries at the last health check other than exclude, or the one
+// after exclude when none of the others is known to be reachable
+func (m *MultiSource) pick(exclude int) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	best := -1
+	for i, header := range m.headers {
+		if i == exclude || header == nil {
+			continue
+		}
+		if best == -1 || header.TotalEntries > m.headers[best].TotalEntries {
+			best = i
+		}
+	}
+	if best != -1 {
+		return best
+	}
+	return (exclude + 1) % len(m.servers)
+}
+
+func (m *MultiSource) healthCheck(done <-chan struct{}) {
+	ticker := time.NewTicker(m.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		m.checkUpstreams()
+		m.dropUnhealthy()
+	}
+}
+
+// checkUpstreams requests the header of every upstream
+func (m *MultiSource) checkUpstreams() {
+	headers := make([]*types.HeaderEntry, len(m.servers))
+	for i, server := range m.servers {
+		header, err := fetchHeader(server, m.cfg.Timeout)
+		if err != nil {
+			log.Debug("Datastream upstream header check failed", "server", server, "err", err)
+			continue
+		}
+		headers[i] = header
+	}
+
+	m.mu.Lock()
+	m.headers = headers
+	m.mu.Unlock()
+}
+
+// dropUnhealthy closes the connection to the active upstream if it should be failed over, which makes the reading
+// move on to another one
+func (m *MultiSource) dropUnhealthy() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil {
+		return
+	}
+	// the upstream isn't read from while the queue is full, which isn't a stall
+	sinceLastEntry := time.Since(time.Unix(0, max(m.lastEntryTime.Load(), m.LastWrittenTime.Load())))
+	if m.queue.waiting.Load() {
+		sinceLastEntry = 0
+	}
+	reason := failoverReason(m.headers, m.active, m.lastEntry.Load(), sinceLastEntry, m.cfg.HealthCheckInterval, m.cfg.MaxLag)
+	if reason == "" {
+		return
+	}
+
+	log.Warn("Datastream upstream unhealthy", "server", m.servers[m.active], "reason", reason)
+	m.conn.Close()
+	m.conn = nil
+}
+
+// failoverReason tells why the active upstream should be failed over, if it should: it is unreachable, it stalled
+// with entries it didn't send for a whole health check interval, or it is more than maxLag entries behind another
+// one. It is kept while none of the others is reachable.
+func failoverReason(headers []*types.HeaderEntry, active int, lastEntry uint64, sinceLastEntry, interval time.Duration, maxLag uint64) string {
+	var best *types.HeaderEntry
+	for i, header := range headers {
+		if i != active && header != nil && (best == nil || header.TotalEntries > best.TotalEntries) {
+			best = header
+		}
+	}
+	if best == nil {
+		return ""
+	}
+
+	header := headers[active]
+	switch {
+	case header == nil:
+		return "unreachable"
+	case sinceLastEntry > interval && header.TotalEntries > lastEntry+1:
+		return fmt.Sprintf("stalled at entry %d of %d", lastEntry, header.TotalEntries)
+	case best.TotalEntries > header.TotalEntries+maxLag:
+		return fmt.Sprintf("%d entries behind", best.TotalEntries-header.TotalEntries)
+	}
+	return ""
+}
+
+// crossCheck compares a block with the same block of the witness, another upstream streaming alongside the active
+// one. A witness that can't provide the block in time is dropped and the blocks go unchecked until another one is
+// found.
+func (m *MultiSource) crossCheck(block *types.FullL2Block) error {
+	if m.witness == nil {
+		if time.Now().Before(m.witnessRetryAt) {
+			return nil
+		}
+		if err := m.openWitness(block.L2BlockNumber); err != nil {
+			log.Warn("Datastream cross check upstream unavailable", "block", block.L2BlockNumber, "err", err)
+			m.witnessRetryAt = time.Now().Add(m.cfg.HealthCheckInterval)
+			return nil
+		}
+	}
+
+	for m.witnessBlock == nil || m.witnessBlock.L2BlockNumber < block.L2BlockNumber {
+		if err := m.witness.conn.SetReadDeadline(time.Now().Add(m.cfg.Timeout)); err != nil {
+			return err
+		}
+		witnessBlock, _, _, _, _, err := readFullBlock(m.witness, m.witness.codec, nil)
+		if err != nil {
+			log.Warn("Datastream cross check upstream dropped", "server", m.servers[m.witnessIndex], "block", block.L2BlockNumber, "err", err)
+			m.closeWitness()
+			m.witnessRetryAt = time.Now().Add(m.cfg.HealthCheckInterval)
+			return nil
+		}
+		m.witnessBlock = witnessBlock
+	}
+
+	witnessBlock := m.witnessBlock
+	if witnessBlock.L2BlockNumber != block.L2BlockNumber {
+		// the witness skipped the block, start over from the next one
+		m.closeWitness()
+		return nil
+	}
+	if witnessBlock.L2Blockhash != block.L2Blockhash || witnessBlock.StateRoot != block.StateRoot {
+		m.mu.Lock()
+		active := m.servers[m.active]
+		m.mu.Unlock()
+		return fmt.Errorf("%w: block %d has hash %s and root %s from %s, hash %s and root %s from %s", ErrUpstreamMismatch,
+			block.L2BlockNumber, block.L2Blockhash, block.StateRoot, active,
+			witnessBlock.L2Blockhash, witnessBlock.StateRoot, m.servers[m.witnessIndex])
+	}
+	return nil
+}
+
+// openWitness starts streaming another upstream than the active one from a block
+func (m *MultiSource) openWitness(blockNumber uint64) error {
+	m.mu.Lock()
+	active := m.active
+	m.mu.Unlock()
+
+	index := m.pick(active)
+	if index == active {
+		return errors.New("no other upstream")
+	}
+
+	c, err := dialUpstream(m.servers[index], m.cfg.Timeout)
+	if err != nil {
+		return err
+	}
+	if err := c.conn.SetDeadline(time.Now().Add(m.cfg.Timeout)); err != nil {
+		c.conn.Close()
+		return err
+	}
+	if err := c.GetHeader(); err != nil {
+		c.conn.Close()
+		return err
+	}
+	if err := c.initiateDownloadBookmark(types.NewL2BlockBookmark(blockNumber).Encode()); err != nil {
+		c.conn.Close()
+		return fmt.Errorf("%s: %v", m.servers[index], err)
+	}
+
+	m.witness, m.witnessIndex, m.witnessBlock = c, index, nil
+	return nil
+}
+
+func (m *MultiSource) closeWitness() {
+	if m.witness == nil {
+		return
+	}
+	m.witness.conn.Close()
+	m.witness, m.witnessBlock = nil, nil
+}
+
+func (m *MultiSource) stopped() bool {
+	select {
+	case <-m.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// dialUpstream connects a bare client, without the block queue, to an upstream
+func dialUpstream(server string, timeout time.Duration) (*StreamClient, error) {
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to server %s: %v", server, err)
+	}
+
+	return &StreamClient{
+		server:     server,
+		streamType: StSequencer,
+		conn:       conn,
+		id:         conn.LocalAddr().String(),
+		codec:      types.DefaultCodec(),
+	}, nil
+}
+
+// fetchHeader requests the header of an upstream on a connection of its own
+func fetchHeader(server string, timeout time.Duration) (*types.HeaderEntry, error) {
+	c, err := dialUpstream(server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.conn.Close()
+
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if err := c.GetHeader(); err != nil {
+		return nil, err
+	}
+	return &c.Header, nil
+}
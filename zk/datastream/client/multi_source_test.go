@@ -0,0 +1,267 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+// fakeUpstream answers the header command with totalEntries, and a start bookmark command with the blocks from the
+// bookmark's one to lastBlock, after which it keeps the connection open without sending anything or drops it
+type fakeUpstream struct {
+	listener     net.Listener
+	totalEntries uint64
+	lastBlock    uint64
+	// the hash of block badBlock is changed, if set
+	badBlock  uint64
+	drop      bool
+	bookmarks chan uint64
+	done      chan struct{}
+}
+
+func newFakeUpstream(t *testing.T, totalEntries, lastBlock uint64) *fakeUpstream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	u := &fakeUpstream{
+		listener:     listener,
+		totalEntries: totalEntries,
+		lastBlock:    lastBlock,
+		bookmarks:    make(chan uint64, 100),
+		done:         make(chan struct{}),
+	}
+	t.Cleanup(func() {
+		close(u.done)
+		listener.Close()
+	})
+	return u
+}
+
+func (u *fakeUpstream) address() string {
+	return u.listener.Addr().String()
+}
+
+func (u *fakeUpstream) serve() {
+	for {
+		conn, err := u.listener.Accept()
+		if err != nil {
+			return
+		}
+		go u.handle(conn)
+	}
+}
+
+func (u *fakeUpstream) handle(conn net.Conn) {
+	defer conn.Close()
+
+	cmd := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(conn, cmd); err != nil {
+			return
+		}
+		if Command(binary.BigEndian.Uint64(cmd[:8])) != CmdHeader {
+			break
+		}
+		if err := writeTestHeader(conn, u.totalEntries); err != nil {
+			return
+		}
+	}
+
+	if Command(binary.BigEndian.Uint64(cmd[:8])) == CmdStartBookmark {
+		bookmark := make([]byte, 4+BookmarkLength)
+		if _, err := io.ReadFull(conn, bookmark); err != nil {
+			return
+		}
+		from := binary.LittleEndian.Uint64(bookmark[5:])
+		u.bookmarks <- from
+
+		stream := []byte{PtResult, 0, 0, 0, 9, 0, 0, 0, 0}
+		for blockNo := from; blockNo <= u.lastBlock; blockNo++ {
+			hash := common.BigToHash(new(big.Int).SetUint64(blockNo))
+			if u.badBlock != 0 && blockNo == u.badBlock {
+				hash = common.HexToHash("0xbad")
+			}
+			stream = append(stream, encodeFileEntry(types.BookmarkEntryType, 0, types.NewL2BlockBookmark(blockNo).Encode())...)
+			stream = append(stream, encodeFileEntry(types.EntryTypeStartL2Block, 0, types.EncodeStartL2Block(&types.StartL2Block{L2BlockNumber: blockNo}))...)
+			stream = append(stream, encodeFileEntry(types.EntryTypeEndL2Block, 0, types.EncodeEndL2Block(&types.EndL2Block{L2BlockNumber: blockNo, L2Blockhash: hash}))...)
+		}
+		conn.Write(stream)
+		if !u.drop {
+			<-u.done
+		}
+	}
+}
+
+func Test_MultiSource_Failover(t *testing.T) {
+	// the most advanced upstream stalls after block 2
+	stalled := newFakeUpstream(t, 100, 2)
+	other := newFakeUpstream(t, 90, 5)
+	go stalled.serve()
+	go other.serve()
+
+	m := NewMultiSource([]string{other.address(), stalled.address()}, MultiSourceConfig{
+		MaxLag:              1000,
+		HealthCheckInterval: 50 * time.Millisecond,
+		Timeout:             time.Second,
+	})
+	m.reconnectBackoff = time.Millisecond
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	requireBlocks(t, m, 0, 5)
+	require.Equal(t, uint64(0), <-stalled.bookmarks)
+	// resumed from the last block sent
+	require.Equal(t, uint64(2), <-other.bookmarks)
+	requireEmpty(t, m)
+
+	m.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func Test_MultiSource_FailoverDropped(t *testing.T) {
+	// the most advanced upstream drops the connection after block 1
+	dropped := newFakeUpstream(t, 100, 1)
+	dropped.drop = true
+	other := newFakeUpstream(t, 90, 3)
+	go dropped.serve()
+	go other.serve()
+
+	m := NewMultiSource([]string{dropped.address(), other.address()}, MultiSourceConfig{
+		HealthCheckInterval: time.Hour,
+		Timeout:             time.Second,
+	})
+	m.reconnectBackoff = time.Millisecond
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	requireBlocks(t, m, 0, 3)
+	require.Equal(t, uint64(0), <-dropped.bookmarks)
+	require.Equal(t, uint64(1), <-other.bookmarks)
+
+	m.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func Test_MultiSource_CrossCheck(t *testing.T) {
+	active := newFakeUpstream(t, 100, 3)
+	witness := newFakeUpstream(t, 90, 3)
+	witness.badBlock = 2
+	go active.serve()
+	go witness.serve()
+
+	m := NewMultiSource([]string{active.address(), witness.address()}, MultiSourceConfig{
+		HealthCheckInterval: time.Hour,
+		CrossCheck:          true,
+		Timeout:             time.Second,
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	requireBlocks(t, m, 0, 1)
+	select {
+	case err := <-errCh:
+		require.True(t, errors.Is(err, ErrUpstreamMismatch), "unexpected error %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("mismatch not detected")
+	}
+	requireEmpty(t, m)
+	require.Equal(t, uint64(0), <-witness.bookmarks)
+}
+
+func Test_failoverReason(t *testing.T) {
+	header := func(totalEntries uint64) *types.HeaderEntry {
+		return &types.HeaderEntry{TotalEntries: totalEntries}
+	}
+
+	type testCase struct {
+		name           string
+		headers        []*types.HeaderEntry
+		lastEntry      uint64
+		sinceLastEntry time.Duration
+		expected       string
+	}
+	testCases := []testCase{
+		{
+			name:     "Healthy",
+			headers:  []*types.HeaderEntry{header(100), header(100)},
+			expected: "",
+		},
+		{
+			name:     "Unreachable",
+			headers:  []*types.HeaderEntry{nil, header(100)},
+			expected: "unreachable",
+		},
+		{
+			name:     "No other upstream reachable",
+			headers:  []*types.HeaderEntry{nil, nil},
+			expected: "",
+		},
+		{
+			name:     "Behind",
+			headers:  []*types.HeaderEntry{header(100), header(111)},
+			expected: "11 entries behind",
+		},
+		{
+			name:     "Behind within the max lag",
+			headers:  []*types.HeaderEntry{header(100), header(110)},
+			expected: "",
+		},
+		{
+			name:           "Stalled",
+			headers:        []*types.HeaderEntry{header(100), header(100)},
+			lastEntry:      50,
+			sinceLastEntry: 2 * time.Second,
+			expected:       "stalled at entry 50 of 100",
+		},
+		{
+			name:           "Idle at the end of the stream",
+			headers:        []*types.HeaderEntry{header(100), header(100)},
+			lastEntry:      99,
+			sinceLastEntry: 2 * time.Second,
+			expected:       "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			reason := failoverReason(testCase.headers, 0, testCase.lastEntry, testCase.sinceLastEntry, time.Second, 10)
+			require.Equal(t, testCase.expected, reason)
+		})
+	}
+}
+
+func Test_MultiSource_NoFailoverOnBackpressure(t *testing.T) {
+	m := NewMultiSource([]string{"a", "b"}, MultiSourceConfig{HealthCheckInterval: time.Second})
+	conn, other := net.Pipe()
+	defer other.Close()
+	m.conn = conn
+	m.headers = []*types.HeaderEntry{{TotalEntries: 100}, {TotalEntries: 100}}
+	m.lastEntry.Store(50)
+	m.lastEntryTime.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	// not read from while the queue is full
+	m.queue.waiting.Store(true)
+	m.dropUnhealthy()
+	require.NotNil(t, m.conn)
+
+	m.queue.waiting.Store(false)
+	m.dropUnhealthy()
+	require.Nil(t, m.conn)
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+const (
+	// defaultMaxQueueBytes is about how much memory the items read ahead of the consumer can take
+	defaultMaxQueueBytes = 64 * 1024 * 1024
+
+	// rough sizes of the items in memory, the encoded transactions aside
+	blockItemSize = 512
+	txItemSize    = 128
+	otherItemSize = 128
+)
+
+var (
+	queuedItemsGauge     = metrics.GetOrCreateCounter(`datastream_queued_items`)
+	queuedBytesGauge     = metrics.GetOrCreateCounter(`datastream_queued_bytes`)
+	consumerLagGauge     = metrics.GetOrCreateCounter(`datastream_consumer_lag_blocks`)
+	backpressureCounter  = metrics.GetOrCreateCounter(`datastream_backpressure_wait_ms`)
+	lastQueuedBlockGauge = metrics.GetOrCreateCounter(`datastream_last_queued_block`)
+)
+
+// StreamItem is the next thing read from a stream: a block along with the ger updates before it, the end of a batch
+// or an unwind of the stream back before blocks already read. The blocks after an unwind follow it.
+type StreamItem struct {
+	Block      *types.FullL2Block
+	GerUpdates []types.GerUpdate
+	BatchEnd   *types.BatchEnd
+	Unwind     *types.Unwind
+}
+
+func (i *StreamItem) size() uint64 {
+	if i.Block == nil {
+		return otherItemSize
+	}
+	size := uint64(blockItemSize + otherItemSize*len(i.GerUpdates))
+	for _, tx := range i.Block.L2Txs {
+		size += txItemSize + uint64(len(tx.Encoded))
+	}
+	return size
+}
+
+// streamQueue holds the items read from a stream until they are taken by Next. Adding to it blocks while it holds
+// maxBytes, so the reading of the stream, and with it the TCP connection, slows down to the pace of the consumer
+// rather than the items piling up in memory. An item is always added to an empty queue, however big.
+type streamQueue struct {
+	mu       sync.Mutex
+	items    []*StreamItem
+	bytes    uint64
+	maxBytes uint64
+
+	// the last block added and taken, for the lag
+	lastQueued uint64
+	lastTaken  uint64
+
+	// signalled when an item is added or taken
+	pushed chan struct{}
+	popped chan struct{}
+
+	// set while adding waits for room
+	waiting atomic.Bool
+}
+
+func newStreamQueue(maxBytes uint64) *streamQueue {
+	return &streamQueue{
+		maxBytes: maxBytes,
+		pushed:   make(chan struct{}, 1),
+		popped:   make(chan struct{}, 1),
+	}
+}
+
+// push adds an item once there is room for it, it returns errStopped if stop is closed first
+func (q *streamQueue) push(item *StreamItem, stop <-chan struct{}) error {
+	size := item.size()
+	var waitStart time.Time
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 || q.bytes+size <= q.maxBytes {
+			q.items = append(q.items, item)
+			q.bytes += size
+			if item.Block != nil {
+				q.lastQueued = item.Block.L2BlockNumber
+			}
+			q.updateMetrics()
+			q.mu.Unlock()
+
+			if !waitStart.IsZero() {
+				q.waiting.Store(false)
+				backpressureCounter.Add(int(time.Since(waitStart).Milliseconds()))
+			}
+			signal(q.pushed)
+			return nil
+		}
+		q.mu.Unlock()
+
+		if waitStart.IsZero() {
+			waitStart = time.Now()
+			q.waiting.Store(true)
+		}
+		select {
+		case <-q.popped:
+		case <-stop:
+			q.waiting.Store(false)
+			return errStopped
+		}
+	}
+}
+
+// pop takes the next item, waiting for one until the context is done
+func (q *streamQueue) pop(ctx context.Context) (*StreamItem, error) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.bytes -= item.size()
+			if item.Block != nil {
+				q.lastTaken = item.Block.L2BlockNumber
+			}
+			q.updateMetrics()
+			q.mu.Unlock()
+
+			signal(q.popped)
+			return item, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.pushed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// len is the number of items queued
+func (q *streamQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// updateMetrics is called with the lock held
+func (q *streamQueue) updateMetrics() {
+	queuedItemsGauge.Set(uint64(len(q.items)))
+	queuedBytesGauge.Set(q.bytes)
+	lastQueuedBlockGauge.Set(q.lastQueued)
+	if q.lastQueued > q.lastTaken {
+		consumerLagGauge.Set(q.lastQueued - q.lastTaken)
+	} else {
+		consumerLagGauge.Set(0)
+	}
+}
+
+// signal wakes up the other side of the queue, if it waits
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
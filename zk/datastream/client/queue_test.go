@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+func testBlockItem(blockNo uint64, txs ...int) *StreamItem {
+	block := &types.FullL2Block{L2BlockNumber: blockNo}
+	for _, size := range txs {
+		block.L2Txs = append(block.L2Txs, types.L2Transaction{Encoded: make([]byte, size)})
+	}
+	return &StreamItem{Block: block}
+}
+
+func Test_streamQueue_Backpressure(t *testing.T) {
+	// room for two blocks without transactions
+	q := newStreamQueue(2 * blockItemSize)
+	stop := make(chan struct{})
+
+	require.NoError(t, q.push(testBlockItem(1), stop))
+	require.NoError(t, q.push(testBlockItem(2), stop))
+
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- q.push(testBlockItem(3), stop)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("pushed into a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.True(t, q.waiting.Load())
+
+	item, err := q.pop(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), item.Block.L2BlockNumber)
+	require.NoError(t, <-pushed)
+	require.False(t, q.waiting.Load())
+	require.Equal(t, 2, q.len())
+
+	// stopping gives up on waiting for room
+	go func() {
+		pushed <- q.push(testBlockItem(4), stop)
+	}()
+	close(stop)
+	require.ErrorIs(t, <-pushed, errStopped)
+
+	for _, blockNo := range []uint64{2, 3} {
+		item, err := q.pop(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, blockNo, item.Block.L2BlockNumber)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = q.pop(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func Test_streamQueue_LargeItem(t *testing.T) {
+	q := newStreamQueue(blockItemSize)
+
+	// an item bigger than the queue goes into it once it is empty
+	require.NoError(t, q.push(testBlockItem(1, 10*blockItemSize), nil))
+	require.Equal(t, uint64(blockItemSize+txItemSize+10*blockItemSize), q.bytes)
+
+	_, err := q.pop(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), q.bytes)
+}
+
+func Test_FileSource_Backpressure(t *testing.T) {
+	blocks := make([][]byte, 0)
+	for i := uint64(0); i < 100; i++ {
+		blocks = append(blocks, encodeTestBlock(i, nil))
+	}
+	s := NewFileSource(writeStreamFile(t, blocks))
+	s.queue = newStreamQueue(10 * blockItemSize)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	// the reading waits for the blocks to be taken
+	require.Eventually(t, s.queue.waiting.Load, 5*time.Second, time.Millisecond)
+	require.Equal(t, 10, s.queue.len())
+	select {
+	case <-done:
+		t.Fatal("read the whole file into a full queue")
+	default:
+	}
+
+	requireBlocks(t, s, 0, 99)
+	require.NoError(t, <-done)
+	requireEmpty(t, s)
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+// ReplaySource reads the blocks from a capture of what a datastreamer sent to a client after its start command, the
+// result entries of the commands included. Captures of several connections can be concatenated, the blocks they
+// have in common are only sent once. A capture has no header, its entries are decoded in the first stream version.
+type ReplaySource struct {
+	blockQueue
+	fileName string
+}
+
+func NewReplaySource(fileName string) *ReplaySource {
+	return &ReplaySource{
+		blockQueue: newBlockQueue(nil),
+		fileName:   fileName,
+	}
+}
+
+// reads the entries of the capture up to its end
+func (s *ReplaySource) ReadAllEntriesToChannel(bookmark *types.Bookmark) error {
+	file, err := os.Open(s.fileName)
+	if err != nil {
+		return fmt.Errorf("open capture error: %v", err)
+	}
+	defer file.Close()
+
+	return s.readAllFullL2BlocksToChannel(&captureReader{r: bufio.NewReader(file)}, types.DefaultCodec(), bookmark)
+}
+
+// captureReader reads the entries of a captured stream
+type captureReader struct {
+	r *bufio.Reader
+}
+
+// atEnd skips the result entries before the next data entry
+func (c *captureReader) atEnd() (bool, error) {
+	for {
+		packet, err := c.r.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if packet[0] != PtResult {
+			return false, nil
+		}
+
+		if _, err := c.r.Discard(1); err != nil {
+			return false, err
+		}
+		r, err := readResultEntry(c.r, "", []byte{PtResult})
+		if err != nil {
+			return false, fmt.Errorf("read result entry error: %v", err)
+		}
+		if err := r.GetError(); err != nil {
+			return false, fmt.Errorf("got Result error code %d: %v", r.ErrorNum, err)
+		}
+	}
+}
+
+func (c *captureReader) readFileEntry() (*types.FileEntry, error) {
+	end, err := c.atEnd()
+	if err != nil {
+		return &types.FileEntry{}, err
+	}
+	if end {
+		return &types.FileEntry{}, errEndOfStream
+	}
+
+	packet, err := readBuffer(c.r, 1)
+	if err != nil {
+		return &types.FileEntry{}, fmt.Errorf("failed to read packet type: %v", err)
+	}
+	if packet[0] != PtData {
+		return &types.FileEntry{}, fmt.Errorf("error expecting data packet type %d and received %d", PtData, packet[0])
+	}
+
+	return readDataEntry(c.r, packet)
+}
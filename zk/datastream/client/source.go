@@ -0,0 +1,385 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+// Source is where the batches stage reads the l2 blocks from: a live datastreamer, a local stream file or a replayed
+// capture of a stream. The blocks are taken with Next in order, without gaps or repeats.
+type Source interface {
+	// ReadAllEntriesToChannel queues the blocks from the one the bookmark points to onwards for Next, it returns at
+	// the end of a finite source and keeps waiting for new blocks on a live one. It blocks while the queue is full,
+	// so the stream is only read as fast as the blocks are taken.
+	ReadAllEntriesToChannel(bookmark *types.Bookmark) error
+	// Next takes the next block, end of a batch or unwind, waiting for one until the context is done. The end of a
+	// batch comes after its blocks, and an unwind back before blocks already taken before the blocks after it.
+	Next(ctx context.Context) (*StreamItem, error)
+	// GetLastWrittenTimeAtomic is the time the last block was queued
+	GetLastWrittenTimeAtomic() *atomic.Int64
+	// GetStreamingAtomic is true while blocks are being queued
+	GetStreamingAtomic() *atomic.Bool
+}
+
+var (
+	_ Source = (*StreamClient)(nil) // compile-time interface check
+	_ Source = (*FileSource)(nil)
+	_ Source = (*ReplaySource)(nil)
+	_ Source = (*MultiSource)(nil)
+	_ Source = (*L1Source)(nil)
+)
+
+// blockQueue is the queue of a Source along with the last block queued
+type blockQueue struct {
+	// atomic
+	LastWrittenTime atomic.Int64
+	Streaming       atomic.Bool
+
+	queue *streamQueue
+	// closed when the source is stopped, while waiting for room in the queue
+	stop <-chan struct{}
+
+	// last block queued, a stream is resumed from it after a reconnect
+	lastL2Block uint64
+	lastBatch   uint64
+	sentL2Block bool
+
+	// last batch end queued
+	lastBatchEnd uint64
+	sentBatchEnd bool
+
+	// the blocks before these are skipped, for the sources that can't seek to a bookmark
+	fromL2Block uint64
+	fromBatch   uint64
+}
+
+func newBlockQueue(stop <-chan struct{}) blockQueue {
+	return blockQueue{
+		queue: newStreamQueue(defaultMaxQueueBytes),
+		stop:  stop,
+	}
+}
+
+func (b *blockQueue) Next(ctx context.Context) (*StreamItem, error) {
+	return b.queue.pop(ctx)
+}
+
+func (b *blockQueue) GetLastWrittenTimeAtomic() *atomic.Int64 {
+	return &b.LastWrittenTime
+}
+
+func (b *blockQueue) GetStreamingAtomic() *atomic.Bool {
+	return &b.Streaming
+}
+
+// sendBlock queues a block along with the ger updates before it
+// blocks already queued are skipped, so a stream resumed from an earlier block doesn't repeat them, and a block
+// further than the next one is an error as the blocks in between would be missing
+func (b *blockQueue) sendBlock(fullBlock *types.FullL2Block, gerUpdates *[]types.GerUpdate) error {
+	if fullBlock.L2BlockNumber < b.fromL2Block || fullBlock.BatchNumber < b.fromBatch {
+		return nil
+	}
+
+	if b.sent(fullBlock.L2BlockNumber) {
+		// the ger updates before the block were queued along with it
+		return nil
+	}
+	if b.sentL2Block {
+		if fullBlock.L2BlockNumber != b.lastL2Block+1 {
+			return fmt.Errorf("expected block %d, got %d", b.lastL2Block+1, fullBlock.L2BlockNumber)
+		}
+	}
+
+	item := &StreamItem{Block: fullBlock}
+	if gerUpdates != nil {
+		item.GerUpdates = *gerUpdates
+	}
+	b.Streaming.Store(true)
+	if err := b.queue.push(item, b.stop); err != nil {
+		return err
+	}
+	b.LastWrittenTime.Store(time.Now().UnixNano())
+
+	b.lastL2Block = fullBlock.L2BlockNumber
+	b.lastBatch = fullBlock.BatchNumber
+	b.sentL2Block = true
+	return nil
+}
+
+// sent tells if a block was already queued
+func (b *blockQueue) sent(l2BlockNumber uint64) bool {
+	return b.sentL2Block && l2BlockNumber <= b.lastL2Block
+}
+
+// sendBatchEnd queues the end of a batch, the ends of the batches before the last block queued and the ones already
+// queued are skipped
+func (b *blockQueue) sendBatchEnd(end *types.BatchEnd) error {
+	if !b.sentL2Block || end.BatchNumber < b.lastBatch {
+		return nil
+	}
+	if b.sentBatchEnd && end.BatchNumber <= b.lastBatchEnd {
+		return nil
+	}
+
+	if err := b.queue.push(&StreamItem{BatchEnd: end}, b.stop); err != nil {
+		return err
+	}
+
+	b.lastBatchEnd = end.BatchNumber
+	b.sentBatchEnd = true
+	return nil
+}
+
+// sendUnwind rolls the last block and batch queued back to the unwind's ones, so the blocks after it are queued
+// again. If blocks after it were queued already, the unwind is queued too, so it is taken after the blocks queued
+// before it and before the ones queued after it.
+func (b *blockQueue) sendUnwind(unwind *types.Unwind) error {
+	if !b.sentL2Block || unwind.L2BlockNumber >= b.lastL2Block {
+		return nil
+	}
+
+	if err := b.queue.push(&StreamItem{Unwind: unwind}, b.stop); err != nil {
+		return err
+	}
+
+	b.lastL2Block = unwind.L2BlockNumber
+	b.lastBatch = unwind.BatchNumber
+	if b.sentBatchEnd && b.lastBatchEnd > unwind.BatchNumber {
+		b.lastBatchEnd = unwind.BatchNumber
+	}
+	// the blocks after the unwind are sent again, even the ones before the bookmark
+	if b.fromL2Block > unwind.L2BlockNumber+1 {
+		b.fromL2Block = unwind.L2BlockNumber + 1
+	}
+	return nil
+}
+
+// resumeAfter makes the blocks up to l2BlockNumber of batchNumber count as queued, for a source that knows the
+// consumer has them. An unwind before them is then queued.
+func (b *blockQueue) resumeAfter(l2BlockNumber, batchNumber uint64) {
+	b.lastL2Block = l2BlockNumber
+	b.lastBatch = batchNumber
+	b.sentL2Block = true
+}
+
+// skipTo makes sendBlock skip the blocks before the one the bookmark points to, for the sources that can't seek to it
+func (b *blockQueue) skipTo(bookmark *types.Bookmark) error {
+	switch bookmark.Type {
+	case types.BookmarkTypeStart:
+		b.fromL2Block = bookmark.From
+	case types.BookmarkTypeBatch:
+		b.fromBatch = bookmark.From
+	default:
+		return fmt.Errorf("unsupported bookmark type %d", bookmark.Type)
+	}
+	return nil
+}
+
+// entryReader reads the entries of a stream one at a time
+type entryReader interface {
+	// readFileEntry returns errEndOfStream at the end of a finite stream
+	readFileEntry() (*types.FileEntry, error)
+}
+
+var (
+	errEndOfStream = errors.New("end of stream")
+	errStopped     = errors.New("stopped")
+)
+
+// unwindError is returned by readFullBlock for an unwind of the stream
+type unwindError struct {
+	unwind *types.Unwind
+}
+
+func (e *unwindError) Error() string {
+	return fmt.Sprintf("stream unwound to block %d of batch %d", e.unwind.L2BlockNumber, e.unwind.BatchNumber)
+}
+
+// reads a full block from the server
+// returns the parsed FullL2Block and the amount of entries read
+// the entries are decoded with the codec of the stream's version
+// the batch ends read before the block are passed to onBatchEnd, if set
+// the end of a finite stream before the start of a block is returned as errEndOfStream and an unwind as an unwindError
+func readFullBlock(r entryReader, codec *types.Codec, onBatchEnd func(*types.BatchEnd) error) (*types.FullL2Block, *[]types.GerUpdate, []byte, uint64, uint64, error) {
+	entriesRead := uint64(0)
+
+	// TODO: maybe parse it and return it if needed
+	file, err := r.readFileEntry()
+	if errors.Is(err, errEndOfStream) {
+		return nil, nil, []byte{}, 0, 0, err
+	}
+	if err != nil {
+		return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
+	}
+	entriesRead++
+	fromEntry := file.EntryNum
+
+	// read whatever might be between current position and block start
+	gerUpdates := []types.GerUpdate{}
+	var bookmark []byte
+	for {
+		if file.IsBlockStart() {
+			break
+		}
+
+		if file.IsBookmark() {
+			// the batch bookmark comes before the bookmark of its first block
+			if len(file.Data) > 0 && file.Data[0] == types.BookmarkTypeStart {
+				bookmark = file.Data
+			}
+		} else if file.IsBatchStart() {
+			if _, err := types.DecodeBatchStart(file.Data); err != nil {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse batchStart error: %v", err)
+			}
+		} else if file.IsBatchEnd() {
+			batchEnd, err := types.DecodeBatchEnd(file.Data)
+			if err != nil {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse batchEnd error: %v", err)
+			}
+			if onBatchEnd != nil {
+				if err := onBatchEnd(batchEnd); err != nil {
+					return nil, nil, []byte{}, 0, 0, err
+				}
+			}
+		} else if file.IsUnwind() {
+			unwind, err := types.DecodeUnwind(file.Data)
+			if err != nil {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse unwind error: %v", err)
+			}
+			return nil, nil, []byte{}, 0, 0, &unwindError{unwind: unwind}
+		} else if file.IsGerUpdate() {
+			gerUpdate, err := codec.DecodeGerUpdate(file.Data)
+			if err != nil {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse gerUpdate error: %v", err)
+			}
+			gerUpdates = append(gerUpdates, *gerUpdate)
+		} else {
+			return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected GerUpdate or Bookmark type, got type: %d", file.EntryType)
+		}
+
+		file, err = r.readFileEntry()
+		if errors.Is(err, errEndOfStream) {
+			return nil, nil, []byte{}, 0, 0, err
+		}
+		if err != nil {
+			return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
+		}
+		entriesRead++
+	}
+
+	// should start with a StartL2Block entry, followed by
+	// txs entries and ending with a block endL2BlockEntry
+	var startL2Block *types.StartL2Block
+	l2Txs := []types.L2Transaction{}
+	var endL2Block *types.EndL2Block
+	if file.IsBlockStart() {
+		startL2Block, err = codec.DecodeStartL2Block(file.Data)
+		if err != nil {
+			return nil, nil, []byte{}, 0, 0, fmt.Errorf("read start of block error: %v", err)
+		}
+
+		for {
+			file, err := r.readFileEntry()
+			if err != nil {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
+			}
+
+			entriesRead++
+
+			if file.IsTx() {
+				l2Tx, err := codec.DecodeL2Transaction(file.Data)
+				if err != nil {
+					return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse l2Transaction error: %v", err)
+				}
+				l2Txs = append(l2Txs, *l2Tx)
+			} else if file.IsBlockEnd() {
+				endL2Block, err = codec.DecodeEndL2Block(file.Data)
+				if err != nil {
+					return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse endL2Block error: %v", err)
+				}
+				if startL2Block.L2BlockNumber != endL2Block.L2BlockNumber {
+					return nil, nil, []byte{}, 0, 0, fmt.Errorf("start block block number different than endBlock block number. StartBlock: %d, EndBlock: %d", startL2Block.L2BlockNumber, endL2Block.L2BlockNumber)
+				}
+				break
+			} else {
+				return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected EndL2Block or L2Transaction type, got type: %d", file.EntryType)
+			}
+		}
+	} else {
+		return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected StartL2Block, but got type: %d", file.EntryType)
+	}
+
+	fullL2Block := types.ParseFullL2Block(startL2Block, endL2Block, &l2Txs)
+
+	return fullL2Block, &gerUpdates, bookmark, fromEntry, entriesRead, nil
+}
+
+// reads the rest of a data entry after its packet type
+// returns the parsed FileEntry
+func readDataEntry(r io.Reader, packet []byte) (*types.FileEntry, error) {
+	// Read the rest of fixed size fields
+	buffer, err := readBuffer(r, types.FileEntryMinSize-1)
+	if err != nil {
+		return &types.FileEntry{}, fmt.Errorf("error reading file bytes: %v", err)
+	}
+	buffer = append(packet, buffer...)
+
+	// Read variable field (data)
+	length := binary.BigEndian.Uint32(buffer[1:5])
+	if length < types.FileEntryMinSize {
+		return &types.FileEntry{}, errors.New("error reading data entry: wrong data length")
+	}
+
+	// Read rest of the file data
+	bufferAux, err := readBuffer(r, length-types.FileEntryMinSize)
+	if err != nil {
+		return &types.FileEntry{}, fmt.Errorf("error reading file data bytes: %v", err)
+	}
+	buffer = append(buffer, bufferAux...)
+
+	// Decode binary data to data entry struct
+	file, err := types.DecodeFileEntry(buffer)
+	if err != nil {
+		return &types.FileEntry{}, fmt.Errorf("decode file entry error: %v", err)
+	}
+
+	return file, nil
+}
+
+// readAllFullL2BlocksToChannel queues the blocks of a finite stream, starting at bookmark
+func (b *blockQueue) readAllFullL2BlocksToChannel(r entryReader, codec *types.Codec, bookmark *types.Bookmark) error {
+	defer b.Streaming.Store(false)
+
+	if err := b.skipTo(bookmark); err != nil {
+		return err
+	}
+
+	for {
+		fullBlock, gerUpdates, _, _, _, err := readFullBlock(r, codec, b.sendBatchEnd)
+		if errors.Is(err, errEndOfStream) {
+			return nil
+		}
+		// a captured stream goes on with the entries that replaced the unwound ones
+		var unwound *unwindError
+		if errors.As(err, &unwound) {
+			if err := b.sendUnwind(unwound.unwind); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read full block: %v", err)
+		}
+
+		if err := b.sendBlock(fullBlock, gerUpdates); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+// writeStreamFile writes the blocks to a stream file laid out as the datastreamer does
+func writeStreamFile(t *testing.T, blocks [][]byte) string {
+	data := make([]byte, 0)
+	entries := uint64(0)
+	for _, block := range blocks {
+		for len(block) > 0 {
+			length := binary.BigEndian.Uint32(block[1:5])
+			if remaining := datastreamer.PageDataSize - len(data)%datastreamer.PageDataSize; int(length) > remaining {
+				data = append(data, make([]byte, remaining)...)
+			}
+			data = append(data, block[:length]...)
+			block = block[length:]
+			entries++
+		}
+	}
+
+	header := append([]byte{}, streamFileMagic...)
+	header = append(header, PtHeader)
+	header = binary.BigEndian.AppendUint32(header, 38)
+	header = append(header, 1)
+	header = binary.BigEndian.AppendUint64(header, 1)
+	header = binary.BigEndian.AppendUint64(header, uint64(StSequencer))
+	header = binary.BigEndian.AppendUint64(header, uint64(datastreamer.PageHeaderSize+len(data)))
+	header = binary.BigEndian.AppendUint64(header, entries)
+	header = append(header, make([]byte, datastreamer.PageHeaderSize-len(header))...)
+
+	// the file has room for more entries after the stream
+	file := append(append(header, data...), make([]byte, datastreamer.PageDataSize)...)
+
+	fileName := filepath.Join(t.TempDir(), "stream.bin")
+	require.NoError(t, os.WriteFile(fileName, file, 0600))
+	return fileName
+}
+
+// encodeTestBatch encodes a batch of blocks with the batch number set on them
+func encodeTestBatch(batchNo uint64, blockNos ...uint64) []byte {
+	b := encodeFileEntry(types.BookmarkEntryType, 0, types.NewBatchBookmark(batchNo).Encode())
+	b = append(b, encodeFileEntry(types.EntryTypeBatchStart, 0, types.EncodeBatchStart(&types.BatchStart{BatchNumber: batchNo}))...)
+	for _, blockNo := range blockNos {
+		b = append(b, encodeFileEntry(types.BookmarkEntryType, 0, types.NewL2BlockBookmark(blockNo).Encode())...)
+		b = append(b, encodeFileEntry(types.EntryTypeStartL2Block, 0, types.EncodeStartL2Block(&types.StartL2Block{BatchNumber: batchNo, L2BlockNumber: blockNo}))...)
+		b = append(b, encodeFileEntry(types.EntryTypeEndL2Block, 0, types.EncodeEndL2Block(&types.EndL2Block{L2BlockNumber: blockNo}))...)
+	}
+	end := &types.BatchEnd{BatchNumber: batchNo, StateRoot: common.BigToHash(new(big.Int).SetUint64(batchNo))}
+	return append(b, encodeFileEntry(types.EntryTypeBatchEnd, 0, types.EncodeBatchEnd(end))...)
+}
+
+// nextItem takes the next item of a source, failing if none is queued in time
+func nextItem(t *testing.T, s Source) *StreamItem {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	item, err := s.Next(ctx)
+	require.NoError(t, err, "no item queued")
+	return item
+}
+
+// requireEmpty checks that nothing is left queued
+func requireEmpty(t *testing.T, s Source) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	item, err := s.Next(ctx)
+	require.ErrorIs(t, err, context.Canceled, "unexpected %s", describeItem(item))
+}
+
+func describeItem(item *StreamItem) string {
+	switch {
+	case item == nil:
+		return "nothing"
+	case item.Block != nil:
+		return fmt.Sprintf("block %d", item.Block.L2BlockNumber)
+	case item.BatchEnd != nil:
+		return fmt.Sprintf("batch end %d", item.BatchEnd.BatchNumber)
+	case item.Unwind != nil:
+		return fmt.Sprintf("unwind %d", item.Unwind.L2BlockNumber)
+	}
+	return "empty item"
+}
+
+// requireItems takes the next items of a source, as described by describeItem
+func requireItems(t *testing.T, s Source, expected ...string) {
+	t.Helper()
+	for _, e := range expected {
+		require.Equal(t, e, describeItem(nextItem(t, s)))
+	}
+}
+
+// requireBlocks takes the blocks from to to, and nothing else, returning the number of ger updates before them
+func requireBlocks(t *testing.T, s Source, from, to uint64) int {
+	t.Helper()
+	gerUpdates := 0
+	for i := from; i <= to; i++ {
+		item := nextItem(t, s)
+		require.Equal(t, fmt.Sprintf("block %d", i), describeItem(item))
+		gerUpdates += len(item.GerUpdates)
+	}
+	return gerUpdates
+}
+
+func Test_FileSource(t *testing.T) {
+	// enough blocks to fill more than a data page
+	blocks := make([][]byte, 0)
+	for i := uint64(0); i < 6000; i++ {
+		blocks = append(blocks, encodeTestBlock(i, nil))
+	}
+	fileName := writeStreamFile(t, blocks)
+
+	s := NewFileSource(fileName)
+	require.NoError(t, s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(10)))
+	requireBlocks(t, s, 10, 5999)
+	requireEmpty(t, s)
+
+	s = NewFileSource(filepath.Join(t.TempDir(), "missing.bin"))
+	require.Error(t, s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0)))
+}
+
+func Test_ReplaySource(t *testing.T) {
+	ger := &types.GerUpdate{BatchNumber: 1, GlobalExitRoot: common.HexToHash("0x1")}
+	result := []byte{PtResult, 0, 0, 0, 9, 0, 0, 0, 0}
+
+	// two connections, the second one resumed from block 3
+	capture := append([]byte{}, result...)
+	for i := uint64(1); i <= 3; i++ {
+		capture = append(capture, encodeTestBlock(i, ger)...)
+	}
+	capture = append(capture, result...)
+	for i := uint64(3); i <= 5; i++ {
+		capture = append(capture, encodeTestBlock(i, ger)...)
+	}
+	fileName := filepath.Join(t.TempDir(), "capture.bin")
+	require.NoError(t, os.WriteFile(fileName, capture, 0600))
+
+	s := NewReplaySource(fileName)
+	require.NoError(t, s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(2)))
+	require.Equal(t, 4, requireBlocks(t, s, 2, 5))
+	requireEmpty(t, s)
+
+	// a capture cut in the middle of a block
+	require.NoError(t, os.WriteFile(fileName, capture[:len(capture)-10], 0600))
+	s = NewReplaySource(fileName)
+	require.Error(t, s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(1)))
+	requireBlocks(t, s, 1, 4)
+	requireEmpty(t, s)
+}
+
+func Test_ReplaySource_Batches(t *testing.T) {
+	capture := []byte{PtResult, 0, 0, 0, 9, 0, 0, 0, 0}
+	capture = append(capture, encodeTestBatch(1, 1, 2)...)
+	capture = append(capture, encodeTestBatch(2, 3)...)
+	capture = append(capture, encodeTestBatch(3)...)
+	capture = append(capture, encodeTestBatch(4, 4, 5)...)
+	fileName := filepath.Join(t.TempDir(), "capture.bin")
+	require.NoError(t, os.WriteFile(fileName, capture, 0600))
+
+	// from the batch bookmark, the blocks of the earlier batches are skipped
+	s := NewReplaySource(fileName)
+	require.NoError(t, s.ReadAllEntriesToChannel(types.NewBatchBookmark(2)))
+	// the end of a batch follows its blocks, the end of the empty batch is queued too
+	requireItems(t, s, "block 3", "batch end 2", "batch end 3", "block 4", "block 5")
+	end := nextItem(t, s).BatchEnd
+	require.NotNil(t, end)
+	require.Equal(t, uint64(4), end.BatchNumber)
+	require.Equal(t, common.BigToHash(new(big.Int).SetUint64(4)), end.StateRoot)
+	requireEmpty(t, s)
+
+	s = NewReplaySource(fileName)
+	require.NoError(t, s.ReadAllEntriesToChannel(types.NewL2BlockBookmark(2)))
+	requireItems(t, s, "block 2", "batch end 1", "block 3", "batch end 2", "batch end 3", "block 4", "block 5", "batch end 4")
+	requireEmpty(t, s)
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
-	"sync/atomic"
+	"sync"
 	"time"
 
+	"github.com/ledgerwatch/log/v3"
 	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
 )
 
@@ -27,16 +29,27 @@ type StreamClient struct {
 	conn       net.Conn
 	id         string            // Client id
 	Header     types.HeaderEntry // Header info received (from Header command)
+	codec      *types.Codec      // for the version of the stream in the header, the first one until it is received
 
 	entriesDefinition map[types.EntryType]EntityDefinition
 
-	// atomic
-	LastWrittenTime atomic.Int64
-	Streaming       atomic.Bool
+	blockQueue
 
-	// Channels
-	L2BlockChan    chan types.FullL2Block
-	GerUpdatesChan chan types.GerUpdate
+	// reconnect settings, the backoff doubles after every failed attempt up to maxReconnectBackoff
+	reconnectBackoff     time.Duration
+	maxReconnectBackoff  time.Duration
+	maxReconnectAttempts int
+	// a connection the server sends nothing on for as long is considered lost, a half-open one included
+	readTimeout time.Duration
+	// the deadline of the reads of the current connection
+	readDeadline time.Time
+
+	stopCh chan struct{}
+
+	// guards conn, which the reading routine replaces on reconnects, and readerDone
+	mu sync.Mutex
+	// closed once ReadAllEntriesToChannel returns, Stop waits for it before returning
+	readerDone chan struct{}
 }
 
 const (
@@ -49,11 +62,18 @@ const (
 	PtData    = 2    // StackData entry
 	PtResult  = 0xff // Not stored/present in file (just for client command result)
 
+	defaultReconnectBackoff     = 1 * time.Second
+	defaultMaxReconnectBackoff  = 30 * time.Second
+	defaultMaxReconnectAttempts = 10
+	// well above the block interval, an idle sequencer doesn't send anything either
+	defaultReadTimeout = 2 * time.Minute
 )
 
 // Creates a new client fo datastream
 // server must be in format "url:port"
 func NewClient(server string) *StreamClient {
+	stopCh := make(chan struct{})
+
 	// Create the client data stream
 	c := &StreamClient{
 		server:     server,
@@ -81,8 +101,14 @@ func NewClient(server string) *StreamClient {
 				Definition: reflect.TypeOf(types.GerUpdate{}),
 			},
 		},
-		L2BlockChan:    make(chan types.FullL2Block, 100000),
-		GerUpdatesChan: make(chan types.GerUpdate, 1000),
+		blockQueue: newBlockQueue(stopCh),
+		codec:      types.DefaultCodec(),
+
+		reconnectBackoff:     defaultReconnectBackoff,
+		maxReconnectBackoff:  defaultMaxReconnectBackoff,
+		maxReconnectAttempts: defaultMaxReconnectAttempts,
+		readTimeout:          defaultReadTimeout,
+		stopCh:               stopCh,
 	}
 
 	return c
@@ -92,21 +118,39 @@ func NewClient(server string) *StreamClient {
 func (c *StreamClient) Start() error {
 	// Connect to server
 	var err error
-	c.conn, err = net.Dial("tcp", c.server)
+	conn, err := net.Dial("tcp", c.server)
 	if err != nil {
 		return fmt.Errorf("error connecting to server %s: %v", c.server, err)
 	}
 
-	c.id = c.conn.LocalAddr().String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// a reconnect racing Stop must not leave a connection open behind it
+	if c.stopped() {
+		conn.Close()
+		return errStopped
+	}
+	c.conn = conn
+	c.id = conn.LocalAddr().String()
 
 	return nil
 }
 
+// Stop closes the connection and waits for ReadAllEntriesToChannel to return, so the connection and the queue are
+// no longer used by it once Stop returns
 func (c *StreamClient) Stop() {
-	c.conn.Close()
+	close(c.stopCh)
+
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	done := c.readerDone
+	c.mu.Unlock()
 
-	close(c.L2BlockChan)
-	close(c.GerUpdatesChan)
+	if done != nil {
+		<-done
+	}
 }
 
 // Command header: Get status
@@ -143,7 +187,13 @@ func (c *StreamClient) GetHeader() error {
 		return fmt.Errorf("%s read header entry error: %v", c.id, err)
 	}
 
+	codec, err := types.CodecForHeader(h)
+	if err != nil {
+		return fmt.Errorf("%s %v", c.id, err)
+	}
+
 	c.Header = *h
+	c.codec = codec
 
 	return nil
 }
@@ -170,19 +220,140 @@ func (c *StreamClient) ReadEntries(bookmark *types.Bookmark, l2BlocksAmount int)
 
 // reads entries to the end of the stream
 // at end will wait for new entries to arrive
+// if the connection drops, or the server sends nothing for readTimeout, it reconnects and resumes from the last
+// block queued, it only gives up after maxReconnectAttempts failed attempts in a row
+// if the server unwinds the stream it resumes from the last block left in it
+// the header is requested on every connection for the version of the stream
 func (c *StreamClient) ReadAllEntriesToChannel(bookmark *types.Bookmark) error {
+	c.mu.Lock()
+	if c.stopped() {
+		c.mu.Unlock()
+		return nil
+	}
+	done := make(chan struct{})
+	c.readerDone = done
+	c.mu.Unlock()
+	defer close(done)
+
+	if err := c.extendReadDeadline(); err != nil {
+		return fmt.Errorf("%s set read deadline error: %v", c.id, err)
+	}
+
+	if err := c.GetHeader(); err != nil {
+		return fmt.Errorf("%s get header error: %v", c.id, err)
+	}
+
 	// send start command
 	if err := c.initiateDownloadBookmark(bookmark.Encode()); err != nil {
 		return ErrBadBookmark
 	}
 
-	if err := c.readAllFullL2BlocksToChannel(); err != nil {
-		return fmt.Errorf("%s read full L2 blocks error: %v", c.id, err)
+	attempts := 0
+	backoff := c.reconnectBackoff
+	for {
+		lastL2Block, sentL2Block := c.lastL2Block, c.sentL2Block
+
+		err := c.readAllFullL2BlocksToChannel()
+		if c.stopped() {
+			return nil
+		}
+
+		var unwound *unwindError
+		if errors.As(err, &unwound) {
+			log.Warn("Datastream unwound, resuming", "server", c.server, "lastBlock", c.lastL2Block, "unwindBlock", unwound.unwind.L2BlockNumber, "unwindBatch", unwound.unwind.BatchNumber)
+			if err := c.sendUnwind(unwound.unwind); err != nil {
+				return nil
+			}
+			c.conn.Close()
+			attempts = 0
+			backoff = c.reconnectBackoff
+
+			// the stream is read again right away, from the last block left in it
+			if err = c.resume(bookmark); err == nil {
+				continue
+			}
+			log.Warn("Datastream resume failed", "server", c.server, "lastBlock", c.lastL2Block, "err", err)
+		} else if c.sentL2Block != sentL2Block || c.lastL2Block != lastL2Block || c.readTimedOut() {
+			// the attempts are counted from the last time a block got through, or the server was silent for as
+			// long as an idle one is, a half-open connection fails the resume in turn
+			attempts = 0
+			backoff = c.reconnectBackoff
+		}
+
+		for err != nil {
+			if attempts >= c.maxReconnectAttempts {
+				return fmt.Errorf("%s read full L2 blocks error: %v", c.id, err)
+			}
+			attempts++
+
+			log.Warn("Datastream connection lost, reconnecting", "server", c.server, "lastBlock", c.lastL2Block, "attempt", attempts, "backoff", backoff, "err", err)
+			c.conn.Close()
+
+			select {
+			case <-c.stopCh:
+				return nil
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > c.maxReconnectBackoff {
+				backoff = c.maxReconnectBackoff
+			}
+
+			if err = c.resume(bookmark); err != nil {
+				log.Warn("Datastream resume failed", "server", c.server, "lastBlock", c.lastL2Block, "err", err)
+			}
+		}
+	}
+}
+
+// resume reconnects and starts the stream again, the connection is closed if the stream isn't started on it.
+// The last sent block is requested again rather than the next one, it is in the stream for sure while the next one
+// might not be there yet, and it is skipped when read.
+func (c *StreamClient) resume(bookmark *types.Bookmark) error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	if c.sentL2Block {
+		bookmark = types.NewL2BlockBookmark(c.lastL2Block)
+	}
+	err := c.extendReadDeadline()
+	if err == nil {
+		err = c.GetHeader()
+	}
+	if err == nil {
+		err = c.initiateDownloadBookmark(bookmark.Encode())
+	}
+	if err != nil {
+		c.conn.Close()
+		return err
 	}
 
 	return nil
 }
 
+// extendReadDeadline gives the reads readTimeout from now to complete
+func (c *StreamClient) extendReadDeadline() error {
+	if c.readTimeout == 0 {
+		return nil
+	}
+	c.readDeadline = time.Now().Add(c.readTimeout)
+	return c.conn.SetReadDeadline(c.readDeadline)
+}
+
+// readTimedOut tells if the reads of the current connection failed for reaching their deadline
+func (c *StreamClient) readTimedOut() bool {
+	return c.readTimeout != 0 && !time.Now().Before(c.readDeadline)
+}
+
+func (c *StreamClient) stopped() bool {
+	select {
+	case <-c.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // runs the prerequisites for entries download
 func (c *StreamClient) initiateDownloadBookmark(bookmark []byte) error {
 	// send start command
@@ -231,27 +402,24 @@ func (c *StreamClient) afterStartCommand() error {
 	return nil
 }
 
-// reads all entries from the server and sends them to a channel
-// sends the parsed FullL2Blocks with transactions to a channel
+// reads all entries from the server and queues the parsed FullL2Blocks with transactions
 func (c *StreamClient) readAllFullL2BlocksToChannel() error {
+	defer c.Streaming.Store(false)
+
 	for {
-		fullBlock, gerUpdates, _, _, _, err := c.readFullBlock()
+		if err := c.extendReadDeadline(); err != nil {
+			return err
+		}
+
+		fullBlock, gerUpdates, _, _, _, err := readFullBlock(c, c.codec, c.sendBatchEnd)
 		if err != nil {
-			return fmt.Errorf("failed to read full block: %v", err)
+			return fmt.Errorf("failed to read full block: %w", err)
 		}
 
-		if gerUpdates != nil {
-			for _, gerUpdate := range *gerUpdates {
-				c.GerUpdatesChan <- gerUpdate
-			}
+		if err := c.sendBlock(fullBlock, gerUpdates); err != nil {
+			return err
 		}
-		c.LastWrittenTime.Store(time.Now().UnixNano())
-		c.Streaming.Store(true)
-		c.L2BlockChan <- *fullBlock
 	}
-
-	c.Streaming.Store(false)
-	return nil
 }
 
 // reads a set amount of l2blocks from the server and returns them
@@ -290,88 +458,7 @@ func (c *StreamClient) readFullL2Blocks(l2BlocksAmount int) (*[]types.FullL2Bloc
 // reads a full block from the server
 // returns the parsed FullL2Block and the amount of entries read
 func (c *StreamClient) readFullBlock() (*types.FullL2Block, *[]types.GerUpdate, []byte, uint64, uint64, error) {
-	entriesRead := uint64(0)
-
-	// TODO: maybe parse it and return it if needed
-	file, err := c.readFileEntry()
-	if err != nil {
-		return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
-	}
-	entriesRead++
-	fromEntry := file.EntryNum
-
-	// read whatever might be between current position and block start
-	gerUpdates := []types.GerUpdate{}
-	var bookmark []byte
-	for {
-		if file.IsBlockStart() {
-			break
-		}
-
-		if file.IsBookmark() {
-			bookmark = file.Data
-		} else if file.IsGerUpdate() {
-			gerUpdate, err := types.DecodeGerUpdate(file.Data)
-			if err != nil {
-				return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse gerUpdate error: %v", err)
-			}
-			gerUpdates = append(gerUpdates, *gerUpdate)
-		} else {
-			return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected GerUpdate or Bookmark type, got type: %d", file.EntryType)
-		}
-
-		file, err = c.readFileEntry()
-		if err != nil {
-			return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
-		}
-		entriesRead++
-	}
-
-	// should start with a StartL2Block entry, followed by
-	// txs entries and ending with a block endL2BlockEntry
-	var startL2Block *types.StartL2Block
-	l2Txs := []types.L2Transaction{}
-	var endL2Block *types.EndL2Block
-	if file.IsBlockStart() {
-		startL2Block, err = types.DecodeStartL2Block(file.Data)
-		if err != nil {
-			return nil, nil, []byte{}, 0, 0, fmt.Errorf("read start of block error: %v", err)
-		}
-
-		for {
-			file, err := c.readFileEntry()
-			if err != nil {
-				return nil, nil, []byte{}, 0, 0, fmt.Errorf("read file entry error: %v", err)
-			}
-
-			entriesRead++
-
-			if file.IsTx() {
-				l2Tx, err := types.DecodeL2Transaction(file.Data)
-				if err != nil {
-					return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse l2Transaction error: %v", err)
-				}
-				l2Txs = append(l2Txs, *l2Tx)
-			} else if file.IsBlockEnd() {
-				endL2Block, err = types.DecodeEndL2Block(file.Data)
-				if err != nil {
-					return nil, nil, []byte{}, 0, 0, fmt.Errorf("parse endL2Block error: %v", err)
-				}
-				if startL2Block.L2BlockNumber != endL2Block.L2BlockNumber {
-					return nil, nil, []byte{}, 0, 0, fmt.Errorf("start block block number different than endBlock block number. StartBlock: %d, EndBlock: %d", startL2Block.L2BlockNumber, endL2Block.L2BlockNumber)
-				}
-				break
-			} else {
-				return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected EndL2Block or L2Transaction type, got type: %d", file.EntryType)
-			}
-		}
-	} else {
-		return nil, nil, []byte{}, 0, 0, fmt.Errorf("expected StartL2Block, but got type: %d", file.EntryType)
-	}
-
-	fullL2Block := types.ParseFullL2Block(startL2Block, endL2Block, &l2Txs)
-
-	return fullL2Block, &gerUpdates, bookmark, fromEntry, entriesRead, nil
+	return readFullBlock(c, c.codec, nil)
 }
 
 // reads file bytes from socket and tries to parse them
@@ -398,33 +485,7 @@ func (c *StreamClient) readFileEntry() (*types.FileEntry, error) {
 		return &types.FileEntry{}, fmt.Errorf("error expecting data packet type %d and received %d", PtData, packet[0])
 	}
 
-	// Read the rest of fixed size fields
-	buffer, err := readBuffer(c.conn, types.FileEntryMinSize-1)
-	if err != nil {
-		return &types.FileEntry{}, fmt.Errorf("error reading file bytes: %v", err)
-	}
-	buffer = append(packet, buffer...)
-
-	// Read variable field (data)
-	length := binary.BigEndian.Uint32(buffer[1:5])
-	if length < types.FileEntryMinSize {
-		return &types.FileEntry{}, errors.New("error reading data entry: wrong data length")
-	}
-
-	// Read rest of the file data
-	bufferAux, err := readBuffer(c.conn, length-types.FileEntryMinSize)
-	if err != nil {
-		return &types.FileEntry{}, fmt.Errorf("error reading file data bytes: %v", err)
-	}
-	buffer = append(buffer, bufferAux...)
-
-	// Decode binary data to data entry struct
-	file, err := types.DecodeFileEntry(buffer)
-	if err != nil {
-		return &types.FileEntry{}, fmt.Errorf("decode file entry error: %v", err)
-	}
-
-	return file, nil
+	return readDataEntry(c.conn, packet)
 }
 
 // reads header bytes from socket and tries to parse them
@@ -436,6 +497,15 @@ func (c *StreamClient) readHeaderEntry() (*types.HeaderEntry, error) {
 		return &types.HeaderEntry{}, fmt.Errorf("failed to read header bytes %v", err)
 	}
 
+	// the versioned header is longer, its length follows the packet type
+	if binary.BigEndian.Uint32(binaryHeader[1:5]) == types.HeaderSizeVersioned {
+		rest, err := readBuffer(c.conn, types.HeaderSizeVersioned-types.HeaderSize)
+		if err != nil {
+			return &types.HeaderEntry{}, fmt.Errorf("failed to read header bytes %v", err)
+		}
+		binaryHeader = append(binaryHeader, rest...)
+	}
+
 	// Decode bytes stream to header entry struct
 	h, err := types.DecodeHeaderEntry(binaryHeader)
 	if err != nil {
@@ -448,12 +518,17 @@ func (c *StreamClient) readHeaderEntry() (*types.HeaderEntry, error) {
 // reads result bytes and tries to parse them
 // returns the parsed ResultEntry
 func (c *StreamClient) readResultEntry(packet []byte) (*types.ResultEntry, error) {
+	return readResultEntry(c.conn, c.id, packet)
+}
+
+// reads the rest of a result entry after its packet type
+func readResultEntry(r io.Reader, id string, packet []byte) (*types.ResultEntry, error) {
 	if len(packet) != 1 {
 		return &types.ResultEntry{}, fmt.Errorf("expected packet size of 1, got: %d", len(packet))
 	}
 
 	// Read the rest of fixed size fields
-	buffer, err := readBuffer(c.conn, types.ResultEntryMinSize-1)
+	buffer, err := readBuffer(r, types.ResultEntryMinSize-1)
 	if err != nil {
 		return &types.ResultEntry{}, fmt.Errorf("failed to read main result bytes %v", err)
 	}
@@ -462,11 +537,11 @@ func (c *StreamClient) readResultEntry(packet []byte) (*types.ResultEntry, error
 	// Read variable field (errStr)
 	length := binary.BigEndian.Uint32(buffer[1:5])
 	if length < types.ResultEntryMinSize {
-		return &types.ResultEntry{}, fmt.Errorf("%s Error reading result entry", c.id)
+		return &types.ResultEntry{}, fmt.Errorf("%s Error reading result entry", id)
 	}
 
 	// read the rest of the result
-	bufferAux, err := readBuffer(c.conn, length-types.ResultEntryMinSize)
+	bufferAux, err := readBuffer(r, length-types.ResultEntryMinSize)
 	if err != nil {
 		return &types.ResultEntry{}, fmt.Errorf("failed to read result errStr bytes %v", err)
 	}
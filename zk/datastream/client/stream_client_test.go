@@ -1,11 +1,14 @@
 package client
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/tenderly/zkevm-erigon-lib/common"
@@ -492,3 +495,211 @@ func Test_readFullBlock(t *testing.T) {
 		})
 	}
 }
+
+func encodeFileEntry(entryType types.EntryType, entryNum uint64, data []byte) []byte {
+	b := []byte{PtData}
+	b = binary.BigEndian.AppendUint32(b, types.FileEntryMinSize+uint32(len(data)))
+	b = binary.BigEndian.AppendUint32(b, uint32(entryType))
+	b = binary.BigEndian.AppendUint64(b, entryNum)
+	return append(b, data...)
+}
+
+func encodeTestBlock(blockNo uint64, ger *types.GerUpdate) []byte {
+	b := encodeFileEntry(types.BookmarkEntryType, 0, types.NewL2BlockBookmark(blockNo).Encode())
+	if ger != nil {
+		b = append(b, encodeFileEntry(types.EntryTypeGerUpdate, 0, ger.EncodeToBytes())...)
+	}
+	b = append(b, encodeFileEntry(types.EntryTypeStartL2Block, 0, types.EncodeStartL2Block(&types.StartL2Block{L2BlockNumber: blockNo}))...)
+	return append(b, encodeFileEntry(types.EntryTypeEndL2Block, 0, types.EncodeEndL2Block(&types.EndL2Block{L2BlockNumber: blockNo}))...)
+}
+
+// writeTestHeader answers a header command with an unversioned header
+func writeTestHeader(conn net.Conn, totalEntries uint64) error {
+	header := []byte{PtResult, 0, 0, 0, 9, 0, 0, 0, 0, PtHeader}
+	header = binary.BigEndian.AppendUint32(header, types.HeaderSize)
+	header = binary.BigEndian.AppendUint64(header, uint64(StSequencer))
+	header = binary.BigEndian.AppendUint64(header, 0)
+	header = binary.BigEndian.AppendUint64(header, totalEntries)
+	_, err := conn.Write(header)
+	return err
+}
+
+// readStartBookmark reads a start bookmark command from the client and answers it, along with the header commands
+// before it
+func readStartBookmark(conn net.Conn) (*types.Bookmark, error) {
+	cmd := make([]byte, 8+8)
+	for {
+		if _, err := io.ReadFull(conn, cmd); err != nil {
+			return nil, err
+		}
+		if Command(binary.BigEndian.Uint64(cmd[:8])) != CmdHeader {
+			break
+		}
+		if err := writeTestHeader(conn, 0); err != nil {
+			return nil, err
+		}
+	}
+	if Command(binary.BigEndian.Uint64(cmd[:8])) != CmdStartBookmark {
+		return nil, fmt.Errorf("expected start bookmark command, got %d", binary.BigEndian.Uint64(cmd[:8]))
+	}
+
+	bookmark := make([]byte, 4+BookmarkLength)
+	if _, err := io.ReadFull(conn, bookmark); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{PtResult, 0, 0, 0, 9, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+
+	return &types.Bookmark{Type: bookmark[4], From: binary.LittleEndian.Uint64(bookmark[5:])}, nil
+}
+
+func Test_ReadAllEntriesToChannel_Reconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ger := &types.GerUpdate{BatchNumber: 1, GlobalExitRoot: common.HexToHash("0x1")}
+	block3 := encodeTestBlock(3, nil)
+	streams := [][]byte{
+		// the first connection drops in the middle of block 3
+		append(append(encodeTestBlock(1, nil), encodeTestBlock(2, ger)...), block3[:len(block3)-10]...),
+		// the second one is resumed from block 2 which was already received
+		append(append(encodeTestBlock(2, ger), block3...), encodeTestBlock(4, nil)...),
+	}
+
+	bookmarks := make(chan uint64, len(streams))
+	done := make(chan struct{})
+	go func() {
+		for i, stream := range streams {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			bookmark, err := readStartBookmark(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			bookmarks <- bookmark.From
+			conn.Write(stream)
+			if i < len(streams)-1 {
+				conn.Close()
+				continue
+			}
+			<-done
+			conn.Close()
+		}
+	}()
+
+	c := NewClient(listener.Addr().String())
+	c.reconnectBackoff = time.Millisecond
+	require.NoError(t, c.Start())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.ReadAllEntriesToChannel(types.NewL2BlockBookmark(1))
+	}()
+
+	require.Equal(t, 1, requireBlocks(t, c, 1, 4))
+	requireEmpty(t, c)
+	require.Equal(t, uint64(1), <-bookmarks)
+	require.Equal(t, uint64(2), <-bookmarks)
+
+	close(done)
+	c.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func Test_ReadAllEntriesToChannel_SilentServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// the first connection goes silent after block 1, the second one doesn't answer the header command and the third
+	// one resumes the stream, the silent ones are kept open as a half-open connection would be
+	bookmarks := make(chan uint64, 3)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if i == 1 {
+				io.ReadFull(conn, make([]byte, 8+8))
+				continue
+			}
+			bookmark, err := readStartBookmark(conn)
+			if err != nil {
+				return
+			}
+			bookmarks <- bookmark.From
+			if i == 0 {
+				conn.Write(encodeTestBlock(1, nil))
+				continue
+			}
+			conn.Write(append(encodeTestBlock(1, nil), encodeTestBlock(2, nil)...))
+		}
+		<-done
+	}()
+
+	c := NewClient(listener.Addr().String())
+	c.reconnectBackoff = time.Millisecond
+	c.readTimeout = 100 * time.Millisecond
+	require.NoError(t, c.Start())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.ReadAllEntriesToChannel(types.NewL2BlockBookmark(1))
+	}()
+
+	requireBlocks(t, c, 1, 2)
+	requireEmpty(t, c)
+	require.Equal(t, uint64(1), <-bookmarks)
+	require.Equal(t, uint64(1), <-bookmarks)
+
+	close(done)
+	c.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func Test_Stop_WaitsForReader(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// the server sends a block and keeps the connection open, so the reader waits on it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := readStartBookmark(conn); err != nil {
+			return
+		}
+		conn.Write(encodeTestBlock(1, nil))
+		<-done
+	}()
+
+	c := NewClient(listener.Addr().String())
+	require.NoError(t, c.Start())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.ReadAllEntriesToChannel(types.NewL2BlockBookmark(1))
+	}()
+	requireBlocks(t, c, 1, 1)
+
+	c.Stop()
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+	default:
+		t.Fatal("Stop returned before the reader")
+	}
+}
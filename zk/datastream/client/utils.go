@@ -61,7 +61,7 @@ func writeFullUint32ToConn(conn net.Conn, value uint32) error {
 }
 
 // reads a set amount of bytes from a connection
-func readBuffer(conn net.Conn, n uint32) ([]byte, error) {
+func readBuffer(conn io.Reader, n uint32) ([]byte, error) {
 	buffer := make([]byte, n)
 	rbc, err := io.ReadFull(conn, buffer)
 	if err != nil {
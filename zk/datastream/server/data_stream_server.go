@@ -2,7 +2,11 @@ package server
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+
 	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
+	"github.com/syndtr/goleveldb/leveldb"
 	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
 	types2 "github.com/tenderly/zkevm-erigon/core/types"
 	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
@@ -10,12 +14,16 @@ import (
 
 type BookmarkType byte
 
-var BlockBookmarkType BookmarkType = 0
+var BlockBookmarkType BookmarkType = BookmarkType(types.BookmarkTypeStart)
+var BatchBookmarkType BookmarkType = BookmarkType(types.BookmarkTypeBatch)
 
 var (
+	EntryTypeBatchEnd   = datastreamer.EntryType(types.EntryTypeBatchEnd)
+	EntryTypeBatchStart = datastreamer.EntryType(types.EntryTypeBatchStart)
 	EntryTypeUpdateGer  = datastreamer.EntryType(4)
 	EntryTypeL2BlockEnd = datastreamer.EntryType(3)
 	EntryTypeL2Tx       = datastreamer.EntryType(2)
+	EntryTypeUnwind     = datastreamer.EntryType(types.EntryTypeUnwind)
 )
 
 type DataStreamServer struct {
@@ -26,13 +34,42 @@ func NewDataStreamServer(stream *datastreamer.StreamServer) *DataStreamServer {
 	return &DataStreamServer{stream: stream}
 }
 
+// codec encodes the entries in the version of the stream file, which the clients get from its header
+func (srv *DataStreamServer) codec() (*types.Codec, error) {
+	return types.NewCodec(types.StreamVersion(srv.stream.GetHeader().Version))
+}
+
 func (srv *DataStreamServer) AddBookmark(t BookmarkType, marker uint64) error {
 	bookmark := types.Bookmark{Type: byte(t), From: marker}
 	_, err := srv.stream.AddStreamBookmark(bookmark.Encode())
 	return err
 }
 
+func (srv *DataStreamServer) AddBatchStart(batchNumber uint64, forkId uint16) error {
+	start := &types.BatchStart{
+		BatchNumber: batchNumber,
+		ForkId:      forkId,
+	}
+	_, err := srv.stream.AddStreamEntry(EntryTypeBatchStart, types.EncodeBatchStart(start))
+	return err
+}
+
+func (srv *DataStreamServer) AddBatchEnd(batchNumber uint64, stateRoot, localExitRoot, accInputHash libcommon.Hash) (uint64, error) {
+	end := &types.BatchEnd{
+		BatchNumber:   batchNumber,
+		StateRoot:     stateRoot,
+		LocalExitRoot: localExitRoot,
+		AccInputHash:  accInputHash,
+	}
+	return srv.stream.AddStreamEntry(EntryTypeBatchEnd, types.EncodeBatchEnd(end))
+}
+
 func (srv *DataStreamServer) AddBlockStart(block *types2.Block, batchNumber uint64, forkId uint16, ger libcommon.Hash) error {
+	codec, err := srv.codec()
+	if err != nil {
+		return err
+	}
+
 	b := &types.StartL2Block{
 		BatchNumber:    batchNumber,
 		L2BlockNumber:  block.NumberU64(),
@@ -40,22 +77,35 @@ func (srv *DataStreamServer) AddBlockStart(block *types2.Block, batchNumber uint
 		GlobalExitRoot: ger,
 		Coinbase:       block.Coinbase(),
 		ForkId:         forkId,
+		// TODO: the l1 info tree index isn't stored by the node yet
+		GasLimit: block.GasLimit(),
 	}
-	_, err := srv.stream.AddStreamEntry(1, types.EncodeStartL2Block(b))
+	_, err = srv.stream.AddStreamEntry(1, codec.EncodeStartL2Block(b))
 	return err
 }
 
-func (srv *DataStreamServer) AddBlockEnd(blockNumber uint64, blockHash, stateRoot libcommon.Hash) error {
+func (srv *DataStreamServer) AddBlockEnd(block *types2.Block) error {
+	codec, err := srv.codec()
+	if err != nil {
+		return err
+	}
+
 	end := &types.EndL2Block{
-		L2BlockNumber: blockNumber,
-		L2Blockhash:   blockHash,
-		StateRoot:     stateRoot,
+		L2BlockNumber: block.NumberU64(),
+		L2Blockhash:   block.Hash(),
+		StateRoot:     block.Root(),
+		GasUsed:       block.GasUsed(),
 	}
-	_, err := srv.stream.AddStreamEntry(3, types.EncodeEndL2Block(end))
+	_, err = srv.stream.AddStreamEntry(3, codec.EncodeEndL2Block(end))
 	return err
 }
 
 func (srv *DataStreamServer) AddGerUpdate(batchNumber uint64, ger libcommon.Hash, fork uint16, block *types2.Block) (uint64, error) {
+	codec, err := srv.codec()
+	if err != nil {
+		return 0, err
+	}
+
 	update := types.GerUpdate{
 		BatchNumber:    batchNumber,
 		Timestamp:      block.Time(),
@@ -65,11 +115,15 @@ func (srv *DataStreamServer) AddGerUpdate(batchNumber uint64, ger libcommon.Hash
 		StateRoot:      block.Root(),
 	}
 
-	return srv.stream.AddStreamEntry(EntryTypeUpdateGer, update.EncodeToBytes())
+	return srv.stream.AddStreamEntry(EntryTypeUpdateGer, codec.EncodeGerUpdate(&update))
 }
 
 func (srv *DataStreamServer) AddGerUpdateFromDb(ger *types.GerUpdate) (uint64, error) {
-	return srv.stream.AddStreamEntry(EntryTypeUpdateGer, ger.EncodeToBytes())
+	codec, err := srv.codec()
+	if err != nil {
+		return 0, err
+	}
+	return srv.stream.AddStreamEntry(EntryTypeUpdateGer, codec.EncodeGerUpdate(ger))
 }
 
 func (srv *DataStreamServer) AddTransaction(
@@ -78,9 +132,14 @@ func (srv *DataStreamServer) AddTransaction(
 	fork uint16,
 	tx types2.Transaction,
 ) (uint64, error) {
+	codec, err := srv.codec()
+	if err != nil {
+		return 0, err
+	}
+
 	buf := make([]byte, 0)
 	writer := bytes.NewBuffer(buf)
-	err := tx.EncodeRLP(writer)
+	err = tx.EncodeRLP(writer)
 	if err != nil {
 		return 0, err
 	}
@@ -101,5 +160,137 @@ func (srv *DataStreamServer) AddTransaction(
 		Encoded:                     encoded,
 	}
 
-	return srv.stream.AddStreamEntry(EntryTypeL2Tx, types.EncodeL2Transaction(l2Tx))
+	return srv.stream.AddStreamEntry(EntryTypeL2Tx, codec.EncodeL2Transaction(l2Tx))
+}
+
+// UnwindToBlock truncates the stream back to the end of the batch before the one holding the block after blockNumber,
+// the blocks of that batch up to blockNumber are removed as well and written again with the rest of it. The header's
+// TotalEntries is updated and the connected clients are sent an Unwind entry, which is then truncated too so it
+// doesn't stay in the file. It returns nil if the stream has no blocks after blockNumber.
+func (srv *DataStreamServer) UnwindToBlock(blockNumber uint64) (*types.Unwind, error) {
+	if _, err := srv.FinishUnwind(); err != nil {
+		return nil, err
+	}
+
+	codec, err := srv.codec()
+	if err != nil {
+		return nil, err
+	}
+
+	blockEntry, found, err := srv.bookmarkEntry(types.NewL2BlockBookmark(blockNumber + 1))
+	if err != nil || !found {
+		return nil, err
+	}
+
+	start, err := srv.stream.GetEntry(blockEntry + 1)
+	if err != nil {
+		return nil, err
+	}
+	if start.Type != datastreamer.EntryType(types.EntryTypeStartL2Block) {
+		return nil, fmt.Errorf("expected the start of block %d at entry %d, got type %d", blockNumber+1, start.Number, start.Type)
+	}
+	startL2Block, err := codec.DecodeStartL2Block(start.Data)
+	if err != nil {
+		return nil, err
+	}
+	batchNumber := startL2Block.BatchNumber
+
+	batchEntry, found, err := srv.bookmarkEntry(types.NewBatchBookmark(batchNumber))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("no bookmark for batch %d of block %d", batchNumber, blockNumber+1)
+	}
+
+	// the first block of the batch is removed with it
+	firstBlock := startL2Block.L2BlockNumber
+	for entryNum := batchEntry + 1; entryNum < blockEntry; entryNum++ {
+		entry, err := srv.stream.GetEntry(entryNum)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Type == datastreamer.EntryType(types.EntryTypeStartL2Block) {
+			first, err := codec.DecodeStartL2Block(entry.Data)
+			if err != nil {
+				return nil, err
+			}
+			firstBlock = first.L2BlockNumber
+			break
+		}
+	}
+	if batchNumber == 0 || firstBlock == 0 {
+		return nil, fmt.Errorf("can't unwind batch %d holding the genesis block", batchNumber)
+	}
+
+	unwind := &types.Unwind{
+		BatchNumber:   batchNumber - 1,
+		L2BlockNumber: firstBlock - 1,
+	}
+
+	if err := srv.stream.TruncateFile(batchEntry); err != nil {
+		return nil, err
+	}
+
+	// the clients get the unwind as the last entry of the stream and re-request it from a bookmark
+	if err := srv.stream.StartAtomicOp(); err != nil {
+		return nil, err
+	}
+	if _, err := srv.stream.AddStreamEntry(EntryTypeUnwind, types.EncodeUnwind(unwind)); err != nil {
+		return nil, err
+	}
+	if err := srv.stream.CommitAtomicOp(); err != nil {
+		return nil, err
+	}
+	if err := srv.stream.TruncateFile(batchEntry); err != nil {
+		return nil, err
+	}
+
+	return unwind, nil
+}
+
+// FinishUnwind truncates the Unwind entry left at the end of the stream by an unwind interrupted before it was
+// truncated, it returns whether there was one
+func (srv *DataStreamServer) FinishUnwind() (bool, error) {
+	header := srv.stream.GetHeader()
+	if header.TotalEntries == 0 {
+		return false, nil
+	}
+
+	latest, err := srv.stream.GetEntry(header.TotalEntries - 1)
+	if err != nil {
+		return false, err
+	}
+	if latest.Type != EntryTypeUnwind {
+		return false, nil
+	}
+
+	return true, srv.stream.TruncateFile(latest.Number)
+}
+
+// bookmarkEntry gets the entry number of a bookmark, the bookmarks of truncated entries are kept by the streamer so
+// the entry is checked to still be the bookmark
+func (srv *DataStreamServer) bookmarkEntry(bookmark *types.Bookmark) (uint64, bool, error) {
+	encoded := bookmark.Encode()
+
+	entryNum, err := srv.stream.GetBookmark(encoded)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if entryNum >= srv.stream.GetHeader().TotalEntries {
+		return 0, false, nil
+	}
+	entry, err := srv.stream.GetEntry(entryNum)
+	if err != nil {
+		return 0, false, err
+	}
+	if entry.Type != datastreamer.EtBookmark || !bytes.Equal(entry.Data, encoded) {
+		return 0, false, nil
+	}
+
+	return entryNum, true, nil
 }
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
+	dslog "github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/stretchr/testify/require"
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	types2 "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/client"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+)
+
+func newTestStream(t *testing.T) (*datastreamer.StreamServer, string) {
+	return newTestStreamVersion(t, types.StreamVersion1)
+}
+
+func newTestStreamVersion(t *testing.T, version types.StreamVersion) (*datastreamer.StreamServer, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := listener.Addr().(*net.TCPAddr).Port
+	require.NoError(t, listener.Close())
+
+	logConfig := &dslog.Config{
+		Environment: "production",
+		Level:       "warn",
+	}
+	stream, err := datastreamer.NewServer(uint16(port), uint8(version), 1, datastreamer.StreamType(1), filepath.Join(t.TempDir(), "data-stream.bin"), logConfig)
+	require.NoError(t, err)
+	require.NoError(t, stream.Start())
+
+	return stream, fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// addTestBatch writes a batch with its blocks the way the data stream catchup stage does, the root of a block is
+// its number plus rootOffset so the blocks written again after an unwind differ
+func addTestBatch(t *testing.T, stream *datastreamer.StreamServer, batch uint64, blocks []uint64, rootOffset uint64) {
+	srv := NewDataStreamServer(stream)
+
+	require.NoError(t, stream.StartAtomicOp())
+	require.NoError(t, srv.AddBookmark(BatchBookmarkType, batch))
+	require.NoError(t, srv.AddBatchStart(batch, 7))
+	var root libcommon.Hash
+	for _, blockNo := range blocks {
+		header := &types2.Header{
+			Number:     new(big.Int).SetUint64(blockNo),
+			Root:       libcommon.BigToHash(new(big.Int).SetUint64(blockNo + rootOffset)),
+			Difficulty: big.NewInt(0),
+			GasLimit:   blockNo + 1000,
+		}
+		block := types2.NewBlock(header, nil, nil, nil, nil)
+		require.NoError(t, srv.AddBookmark(BlockBookmarkType, blockNo))
+		require.NoError(t, srv.AddBlockStart(block, batch, 7, libcommon.Hash{}))
+		require.NoError(t, srv.AddBlockEnd(block))
+		root = block.Root()
+	}
+	_, err := srv.AddBatchEnd(batch, root, libcommon.Hash{}, libcommon.Hash{})
+	require.NoError(t, err)
+	require.NoError(t, stream.CommitAtomicOp())
+}
+
+func TestUnwindToBlock(t *testing.T) {
+	stream, _ := newTestStream(t)
+	srv := NewDataStreamServer(stream)
+
+	addTestBatch(t, stream, 0, []uint64{0}, 0)
+	addTestBatch(t, stream, 1, []uint64{1, 2}, 0)
+	batch2Entries := stream.GetHeader().TotalEntries
+	addTestBatch(t, stream, 2, []uint64{3, 4}, 0)
+	addTestBatch(t, stream, 3, nil, 0)
+
+	// nothing after the last block
+	unwind, err := srv.UnwindToBlock(4)
+	require.NoError(t, err)
+	require.Nil(t, unwind)
+
+	// block 3 goes with the rest of its batch
+	unwind, err = srv.UnwindToBlock(3)
+	require.NoError(t, err)
+	require.Equal(t, &types.Unwind{BatchNumber: 1, L2BlockNumber: 2}, unwind)
+	require.Equal(t, batch2Entries, stream.GetHeader().TotalEntries)
+
+	latest, err := stream.GetEntry(batch2Entries - 1)
+	require.NoError(t, err)
+	require.Equal(t, EntryTypeBatchEnd, latest.Type)
+
+	// the bookmark of block 4 is left behind by the truncation and points past the end of the stream
+	addTestBatch(t, stream, 2, []uint64{3}, 100)
+	unwind, err = srv.UnwindToBlock(3)
+	require.NoError(t, err)
+	require.Nil(t, unwind)
+
+	// down to the genesis batch
+	unwind, err = srv.UnwindToBlock(0)
+	require.NoError(t, err)
+	require.Equal(t, &types.Unwind{BatchNumber: 0, L2BlockNumber: 0}, unwind)
+}
+
+func TestFinishUnwind(t *testing.T) {
+	stream, _ := newTestStream(t)
+	srv := NewDataStreamServer(stream)
+
+	finished, err := srv.FinishUnwind()
+	require.NoError(t, err)
+	require.False(t, finished)
+
+	addTestBatch(t, stream, 0, []uint64{0}, 0)
+	addTestBatch(t, stream, 1, []uint64{1, 2}, 0)
+	entries := stream.GetHeader().TotalEntries
+
+	// an unwind interrupted before its entry was truncated
+	require.NoError(t, stream.StartAtomicOp())
+	_, err = stream.AddStreamEntry(EntryTypeUnwind, types.EncodeUnwind(&types.Unwind{BatchNumber: 1, L2BlockNumber: 2}))
+	require.NoError(t, err)
+	require.NoError(t, stream.CommitAtomicOp())
+
+	finished, err = srv.FinishUnwind()
+	require.NoError(t, err)
+	require.True(t, finished)
+	require.Equal(t, entries, stream.GetHeader().TotalEntries)
+
+	finished, err = srv.FinishUnwind()
+	require.NoError(t, err)
+	require.False(t, finished)
+
+	// the unwind is run again after the stream was already truncated
+	addTestBatch(t, stream, 2, []uint64{3}, 0)
+	unwind, err := srv.UnwindToBlock(2)
+	require.NoError(t, err)
+	require.Equal(t, &types.Unwind{BatchNumber: 1, L2BlockNumber: 2}, unwind)
+	unwind, err = srv.UnwindToBlock(2)
+	require.NoError(t, err)
+	require.Nil(t, unwind)
+	require.Equal(t, entries, stream.GetHeader().TotalEntries)
+}
+
+// nextSkippingBatchEnds takes the next block or unwind a client queued
+func nextSkippingBatchEnds(t *testing.T, c *client.StreamClient) *client.StreamItem {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		item, err := c.Next(ctx)
+		require.NoError(t, err, "timed out waiting for the stream")
+		if item.BatchEnd == nil {
+			return item
+		}
+	}
+}
+
+func TestUnwindToBlock_Client(t *testing.T) {
+	stream, address := newTestStream(t)
+	srv := NewDataStreamServer(stream)
+
+	addTestBatch(t, stream, 0, []uint64{0}, 0)
+	addTestBatch(t, stream, 1, []uint64{1, 2}, 0)
+	addTestBatch(t, stream, 2, []uint64{3, 4}, 0)
+
+	c := client.NewClient(address)
+	require.NoError(t, c.Start())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.ReadAllEntriesToChannel(types.NewL2BlockBookmark(0))
+	}()
+
+	receive := func(expected uint64) *types.FullL2Block {
+		item := nextSkippingBatchEnds(t, c)
+		require.NotNil(t, item.Block, "expected block %d", expected)
+		require.Equal(t, expected, item.Block.L2BlockNumber)
+		return item.Block
+	}
+	for blockNo := uint64(0); blockNo <= 4; blockNo++ {
+		receive(blockNo)
+	}
+
+	unwind, err := srv.UnwindToBlock(2)
+	require.NoError(t, err)
+	require.Equal(t, &types.Unwind{BatchNumber: 1, L2BlockNumber: 2}, unwind)
+
+	// the unwind is taken after the batch ends queued before it
+	require.Equal(t, unwind, nextSkippingBatchEnds(t, c).Unwind)
+
+	// the client re-requested the stream from block 2 and gets the blocks that replaced the unwound ones
+	addTestBatch(t, stream, 2, []uint64{3, 4}, 100)
+	require.Equal(t, libcommon.BigToHash(big.NewInt(103)), receive(3).StateRoot)
+	require.Equal(t, libcommon.BigToHash(big.NewInt(104)), receive(4).StateRoot)
+
+	c.Stop()
+	require.NoError(t, <-errCh)
+}
+
+func TestStreamVersion2_Client(t *testing.T) {
+	stream, address := newTestStreamVersion(t, types.StreamVersion2)
+	addTestBatch(t, stream, 0, []uint64{0}, 0)
+	addTestBatch(t, stream, 1, []uint64{1, 2}, 0)
+
+	c := client.NewClient(address)
+	require.NoError(t, c.Start())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- c.ReadAllEntriesToChannel(types.NewL2BlockBookmark(1))
+	}()
+
+	// the client decodes the blocks in the version of the stream header
+	for blockNo := uint64(1); blockNo <= 2; blockNo++ {
+		block := nextSkippingBatchEnds(t, c).Block
+		require.NotNil(t, block)
+		require.Equal(t, blockNo, block.L2BlockNumber)
+		require.Equal(t, blockNo+1000, block.GasLimit)
+	}
+	require.Equal(t, uint8(types.StreamVersion2), c.Header.Version)
+
+	c.Stop()
+	require.NoError(t, <-errCh)
+}
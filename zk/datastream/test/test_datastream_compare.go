@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -79,21 +80,20 @@ func readFromClient(client *client.StreamClient, bookmark *types.Bookmark, total
 	data := make([]interface{}, 0)
 	count := 0
 
-LOOP:
-	for {
-		select {
-		case d := <-client.L2BlockChan:
-			data = append(data, d)
-			count++
-		case d := <-client.GerUpdatesChan:
+	for count < total {
+		item, err := client.Next(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		if item.Block == nil {
+			continue
+		}
+		for _, d := range item.GerUpdates {
 			data = append(data, d)
 			count++
 		}
-
-		if count == total {
-			break LOOP
-		}
-
+		data = append(data, *item.Block)
+		count++
 	}
 
 	return data, nil
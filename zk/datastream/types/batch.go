@@ -0,0 +1,73 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tenderly/zkevm-erigon-lib/common"
+)
+
+const (
+	batchStartDataLength = 10
+	batchEndDataLength   = 104
+
+	// EntryTypeBatchStart and EntryTypeBatchEnd wrap the blocks of a batch
+	EntryTypeBatchStart EntryType = 5
+	EntryTypeBatchEnd   EntryType = 6
+)
+
+// BatchStart represents the start of a zkEvm batch, it is preceded by its batch bookmark
+type BatchStart struct {
+	BatchNumber uint64 // 8 bytes
+	ForkId      uint16 // 2 bytes
+}
+
+// decodes a BatchStart from a byte array
+func DecodeBatchStart(data []byte) (*BatchStart, error) {
+	if len(data) != batchStartDataLength {
+		return &BatchStart{}, fmt.Errorf("expected data length: %d, got: %d", batchStartDataLength, len(data))
+	}
+
+	return &BatchStart{
+		BatchNumber: binary.LittleEndian.Uint64(data[:8]),
+		ForkId:      binary.LittleEndian.Uint16(data[8:10]),
+	}, nil
+}
+
+func EncodeBatchStart(start *BatchStart) []byte {
+	bytes := make([]byte, 0)
+	bytes = binary.LittleEndian.AppendUint64(bytes, start.BatchNumber)
+	bytes = binary.LittleEndian.AppendUint16(bytes, start.ForkId)
+	return bytes
+}
+
+// BatchEnd represents the end of a zkEvm batch, after its last block
+type BatchEnd struct {
+	BatchNumber   uint64      // 8 bytes
+	StateRoot     common.Hash // 32 bytes
+	LocalExitRoot common.Hash // 32 bytes
+	AccInputHash  common.Hash // 32 bytes
+}
+
+// decodes a BatchEnd from a byte array
+func DecodeBatchEnd(data []byte) (*BatchEnd, error) {
+	if len(data) != batchEndDataLength {
+		return &BatchEnd{}, fmt.Errorf("expected data length: %d, got: %d", batchEndDataLength, len(data))
+	}
+
+	return &BatchEnd{
+		BatchNumber:   binary.LittleEndian.Uint64(data[:8]),
+		StateRoot:     common.BytesToHash(data[8:40]),
+		LocalExitRoot: common.BytesToHash(data[40:72]),
+		AccInputHash:  common.BytesToHash(data[72:104]),
+	}, nil
+}
+
+func EncodeBatchEnd(end *BatchEnd) []byte {
+	bytes := make([]byte, 0)
+	bytes = binary.LittleEndian.AppendUint64(bytes, end.BatchNumber)
+	bytes = append(bytes, end.StateRoot[:]...)
+	bytes = append(bytes, end.LocalExitRoot[:]...)
+	bytes = append(bytes, end.AccInputHash[:]...)
+	return bytes
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+)
+
+func TestBatchStartEncodeDecode(t *testing.T) {
+	start := BatchStart{BatchNumber: 101, ForkId: 7}
+	encoded := EncodeBatchStart(&start)
+	require.Equal(t, []byte{101, 0, 0, 0, 0, 0, 0, 0, 7, 0}, encoded)
+
+	decoded, err := DecodeBatchStart(encoded)
+	require.NoError(t, err)
+	require.Equal(t, start, *decoded)
+
+	_, err = DecodeBatchStart(encoded[:9])
+	require.Equal(t, fmt.Errorf("expected data length: 10, got: 9"), err)
+}
+
+func TestBatchEndEncodeDecode(t *testing.T) {
+	end := BatchEnd{
+		BatchNumber:   101,
+		StateRoot:     common.HexToHash("0x01"),
+		LocalExitRoot: common.HexToHash("0x02"),
+		AccInputHash:  common.HexToHash("0x03"),
+	}
+	encoded := EncodeBatchEnd(&end)
+	require.Len(t, encoded, 104)
+	require.Equal(t, byte(101), encoded[0])
+	require.Equal(t, byte(1), encoded[39])
+	require.Equal(t, byte(2), encoded[71])
+	require.Equal(t, byte(3), encoded[103])
+
+	decoded, err := DecodeBatchEnd(encoded)
+	require.NoError(t, err)
+	require.Equal(t, end, *decoded)
+
+	_, err = DecodeBatchEnd(encoded[:100])
+	require.Equal(t, fmt.Errorf("expected data length: 104, got: 100"), err)
+}
@@ -25,6 +25,10 @@ type StartL2Block struct {
 	GlobalExitRoot common.Hash    // 32 bytes
 	Coinbase       common.Address // 20 bytes
 	ForkId         uint16         // 2 bytes
+
+	// from StreamVersion2
+	L1InfoTreeIndex uint32 // 4 bytes
+	GasLimit        uint64 // 8 bytes
 }
 
 // decodes a StartL2Block from a byte array
@@ -64,6 +68,9 @@ type EndL2Block struct {
 	L2BlockNumber uint64      // 8 bytes
 	L2Blockhash   common.Hash // 32 bytes
 	StateRoot     common.Hash // 32 bytes
+
+	// from StreamVersion2
+	GasUsed uint64 // 8 bytes
 }
 
 // DecodeEndL2Block decodes a EndL2Block from a byte array
@@ -98,6 +105,11 @@ type FullL2Block struct {
 	StateRoot      common.Hash
 	L2Txs          []L2Transaction
 	ParentHash     common.Hash
+
+	// from StreamVersion2
+	L1InfoTreeIndex uint32
+	GasLimit        uint64
+	GasUsed         uint64
 }
 
 // ParseFullL2Block parses a FullL2Block from a StartL2Block, EndL2Block and a slice of L2Transactions
@@ -112,5 +124,9 @@ func ParseFullL2Block(startL2Block *StartL2Block, endL2Block *EndL2Block, l2Txs
 		L2Blockhash:    endL2Block.L2Blockhash,
 		StateRoot:      endL2Block.StateRoot,
 		L2Txs:          *l2Txs,
+
+		L1InfoTreeIndex: startL2Block.L1InfoTreeIndex,
+		GasLimit:        startL2Block.GasLimit,
+		GasUsed:         endL2Block.GasUsed,
 	}
 }
@@ -5,7 +5,8 @@ import (
 )
 
 const (
-	BookmarkTypeStart byte = 0
+	BookmarkTypeStart byte = 0 // points to the start of an l2 block
+	BookmarkTypeBatch byte = 1 // points to the start of a batch
 )
 
 type Bookmark struct {
@@ -20,6 +21,13 @@ func NewL2BlockBookmark(fromBlock uint64) *Bookmark {
 	}
 }
 
+func NewBatchBookmark(batchNo uint64) *Bookmark {
+	return &Bookmark{
+		Type: BookmarkTypeBatch,
+		From: batchNo,
+	}
+}
+
 func (b *Bookmark) Encode() []byte {
 	bytes := make([]byte, 0)
 	bytes = append(bytes, b.Type)
@@ -0,0 +1,120 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// StreamVersion is the version of the layouts of the entries of a stream, it is in the header of the stream
+type StreamVersion uint8
+
+const (
+	// StreamVersion1 is the layout of the streams with the unversioned header too
+	StreamVersion1 StreamVersion = 1
+	// StreamVersion2 adds the l1 info tree index and the gas limit to the start of a block and the gas used to its end
+	StreamVersion2 StreamVersion = 2
+
+	LatestStreamVersion = StreamVersion2
+
+	startL2BlockDataLengthV2 = startL2BlockDataLength + 12
+	endL2BlockDataLengthV2   = endL2BlockDataLength + 8
+)
+
+// Codec encodes and decodes the entries of a stream in the layouts of its version. The fields a version doesn't
+// have are left out when encoding and zero when decoding.
+type Codec struct {
+	version StreamVersion
+}
+
+// NewCodec returns the codec of a stream version, version 0 being a stream without a version
+func NewCodec(version StreamVersion) (*Codec, error) {
+	if version == 0 {
+		version = StreamVersion1
+	}
+	if version > LatestStreamVersion {
+		return nil, fmt.Errorf("unsupported stream version %d, the latest supported is %d", version, LatestStreamVersion)
+	}
+	return &Codec{version: version}, nil
+}
+
+// DefaultCodec returns the codec of the streams without a version
+func DefaultCodec() *Codec {
+	return &Codec{version: StreamVersion1}
+}
+
+// CodecForHeader returns the codec of the stream a header belongs to
+func CodecForHeader(header *HeaderEntry) (*Codec, error) {
+	return NewCodec(StreamVersion(header.Version))
+}
+
+func (c *Codec) Version() StreamVersion {
+	return c.version
+}
+
+func (c *Codec) EncodeStartL2Block(block *StartL2Block) []byte {
+	bytes := EncodeStartL2Block(block)
+	if c.version >= StreamVersion2 {
+		bytes = binary.LittleEndian.AppendUint32(bytes, block.L1InfoTreeIndex)
+		bytes = binary.LittleEndian.AppendUint64(bytes, block.GasLimit)
+	}
+	return bytes
+}
+
+func (c *Codec) DecodeStartL2Block(data []byte) (*StartL2Block, error) {
+	if c.version < StreamVersion2 {
+		return DecodeStartL2Block(data)
+	}
+
+	if len(data) != startL2BlockDataLengthV2 {
+		return &StartL2Block{}, fmt.Errorf("expected data length: %d, got: %d", startL2BlockDataLengthV2, len(data))
+	}
+	block, err := DecodeStartL2Block(data[:startL2BlockDataLength])
+	if err != nil {
+		return &StartL2Block{}, err
+	}
+	block.L1InfoTreeIndex = binary.LittleEndian.Uint32(data[78:82])
+	block.GasLimit = binary.LittleEndian.Uint64(data[82:90])
+	return block, nil
+}
+
+func (c *Codec) EncodeEndL2Block(end *EndL2Block) []byte {
+	bytes := EncodeEndL2Block(end)
+	if c.version >= StreamVersion2 {
+		bytes = binary.LittleEndian.AppendUint64(bytes, end.GasUsed)
+	}
+	return bytes
+}
+
+func (c *Codec) DecodeEndL2Block(data []byte) (*EndL2Block, error) {
+	if c.version < StreamVersion2 {
+		return DecodeEndL2Block(data)
+	}
+
+	if len(data) != endL2BlockDataLengthV2 {
+		return &EndL2Block{}, fmt.Errorf("expected data length: %d, got: %d", endL2BlockDataLengthV2, len(data))
+	}
+	end, err := DecodeEndL2Block(data[:endL2BlockDataLength])
+	if err != nil {
+		return &EndL2Block{}, err
+	}
+	end.GasUsed = binary.LittleEndian.Uint64(data[72:80])
+	return end, nil
+}
+
+// the transactions and ger updates are the same in all the versions so far
+
+func (c *Codec) EncodeL2Transaction(tx L2Transaction) []byte {
+	return EncodeL2Transaction(tx)
+}
+
+func (c *Codec) DecodeL2Transaction(data []byte) (*L2Transaction, error) {
+	return DecodeL2Transaction(data)
+}
+
+func (c *Codec) EncodeGerUpdate(update *GerUpdate) []byte {
+	return update.EncodeToBytes()
+}
+
+func (c *Codec) DecodeGerUpdate(data []byte) (*GerUpdate, error) {
+	return DecodeGerUpdate(data)
+}
@@ -0,0 +1,142 @@
+package types
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+)
+
+// the entries the golden vectors encode, with the fields of the latest version set
+var (
+	goldenStartL2Block = StartL2Block{
+		BatchNumber:     0x0102,
+		L2BlockNumber:   0x0304,
+		Timestamp:       0x65000000,
+		GlobalExitRoot:  common.HexToHash(strings.Repeat("aa", 32)),
+		Coinbase:        common.HexToAddress(strings.Repeat("bb", 20)),
+		ForkId:          7,
+		L1InfoTreeIndex: 0x0a0b,
+		GasLimit:        30_000_000,
+	}
+	goldenEndL2Block = EndL2Block{
+		L2BlockNumber: 0x0304,
+		L2Blockhash:   common.HexToHash(strings.Repeat("cc", 32)),
+		StateRoot:     common.HexToHash(strings.Repeat("dd", 32)),
+		GasUsed:       21000,
+	}
+	goldenL2Transaction = L2Transaction{
+		EffectiveGasPricePercentage: 255,
+		IsValid:                     1,
+		StateRoot:                   common.HexToHash(strings.Repeat("dd", 32)),
+		EncodedLength:               3,
+		Encoded:                     []byte{0xc0, 0x01, 0xff},
+	}
+	goldenGerUpdate = GerUpdate{
+		BatchNumber:    0x0102,
+		Timestamp:      0x65000000,
+		GlobalExitRoot: common.HexToHash(strings.Repeat("aa", 32)),
+		Coinbase:       common.HexToAddress(strings.Repeat("bb", 20)),
+		ForkId:         7,
+		StateRoot:      common.HexToHash(strings.Repeat("dd", 32)),
+	}
+)
+
+type goldenVectors struct {
+	startL2Block  string
+	endL2Block    string
+	l2Transaction string
+	gerUpdate     string
+}
+
+// the entries encoded by each version, written out by hand from the layouts
+var golden = map[StreamVersion]goldenVectors{
+	StreamVersion1: {
+		startL2Block: "0201000000000000" + "0403000000000000" + "0000006500000000" + strings.Repeat("aa", 32) + strings.Repeat("bb", 20) + "0700",
+		endL2Block:   "0403000000000000" + strings.Repeat("cc", 32) + strings.Repeat("dd", 32),
+	},
+	StreamVersion2: {
+		startL2Block: "0201000000000000" + "0403000000000000" + "0000006500000000" + strings.Repeat("aa", 32) + strings.Repeat("bb", 20) + "0700" +
+			"0b0a0000" + "80c3c90100000000",
+		endL2Block: "0403000000000000" + strings.Repeat("cc", 32) + strings.Repeat("dd", 32) + "0852000000000000",
+	},
+}
+
+func init() {
+	// unchanged in all the versions
+	for version, vectors := range golden {
+		vectors.l2Transaction = "ff01" + strings.Repeat("dd", 32) + "03000000" + "c001ff"
+		vectors.gerUpdate = "0201000000000000" + "0000006500000000" + strings.Repeat("aa", 32) + strings.Repeat("bb", 20) + "0700" + strings.Repeat("dd", 32)
+		golden[version] = vectors
+	}
+}
+
+func TestCodecGolden(t *testing.T) {
+	for version := StreamVersion1; version <= LatestStreamVersion; version++ {
+		vectors, ok := golden[version]
+		require.True(t, ok, "no golden vectors for version %d", version)
+
+		t.Run(fmt.Sprintf("Version %d", version), func(t *testing.T) {
+			codec, err := NewCodec(version)
+			require.NoError(t, err)
+			require.Equal(t, version, codec.Version())
+
+			// the fields the version doesn't have are dropped
+			start, end := goldenStartL2Block, goldenEndL2Block
+			if version < StreamVersion2 {
+				start.L1InfoTreeIndex, start.GasLimit, end.GasUsed = 0, 0, 0
+			}
+
+			require.Equal(t, vectors.startL2Block, hex.EncodeToString(codec.EncodeStartL2Block(&goldenStartL2Block)))
+			decodedStart, err := codec.DecodeStartL2Block(common.FromHex(vectors.startL2Block))
+			require.NoError(t, err)
+			require.Equal(t, start, *decodedStart)
+
+			require.Equal(t, vectors.endL2Block, hex.EncodeToString(codec.EncodeEndL2Block(&goldenEndL2Block)))
+			decodedEnd, err := codec.DecodeEndL2Block(common.FromHex(vectors.endL2Block))
+			require.NoError(t, err)
+			require.Equal(t, end, *decodedEnd)
+
+			require.Equal(t, vectors.l2Transaction, hex.EncodeToString(codec.EncodeL2Transaction(goldenL2Transaction)))
+			decodedTx, err := codec.DecodeL2Transaction(common.FromHex(vectors.l2Transaction))
+			require.NoError(t, err)
+			require.Equal(t, goldenL2Transaction, *decodedTx)
+
+			require.Equal(t, vectors.gerUpdate, hex.EncodeToString(codec.EncodeGerUpdate(&goldenGerUpdate)))
+			decodedGer, err := codec.DecodeGerUpdate(common.FromHex(vectors.gerUpdate))
+			require.NoError(t, err)
+			require.Equal(t, goldenGerUpdate, *decodedGer)
+		})
+	}
+}
+
+func TestCodecVersionMismatch(t *testing.T) {
+	v1, err := NewCodec(StreamVersion1)
+	require.NoError(t, err)
+	v2, err := NewCodec(StreamVersion2)
+	require.NoError(t, err)
+
+	_, err = v1.DecodeStartL2Block(common.FromHex(golden[StreamVersion2].startL2Block))
+	require.Equal(t, fmt.Errorf("expected data length: 78, got: 90"), err)
+	_, err = v2.DecodeStartL2Block(common.FromHex(golden[StreamVersion1].startL2Block))
+	require.Equal(t, fmt.Errorf("expected data length: 90, got: 78"), err)
+	_, err = v2.DecodeEndL2Block(common.FromHex(golden[StreamVersion1].endL2Block))
+	require.Equal(t, fmt.Errorf("expected data length: 80, got: 72"), err)
+}
+
+func TestNewCodec(t *testing.T) {
+	// the streams with the unversioned header
+	codec, err := CodecForHeader(&HeaderEntry{HeadLength: HeaderSize})
+	require.NoError(t, err)
+	require.Equal(t, StreamVersion1, codec.Version())
+
+	codec, err = CodecForHeader(&HeaderEntry{HeadLength: HeaderSizeVersioned, Version: 2})
+	require.NoError(t, err)
+	require.Equal(t, StreamVersion2, codec.Version())
+
+	_, err = NewCodec(LatestStreamVersion + 1)
+	require.Error(t, err)
+}
@@ -32,6 +32,18 @@ func (f *FileEntry) IsBlockEnd() bool {
 	return f.EntryType == EntryTypeEndL2Block
 }
 
+func (f *FileEntry) IsBatchStart() bool {
+	return f.EntryType == EntryTypeBatchStart
+}
+
+func (f *FileEntry) IsBatchEnd() bool {
+	return f.EntryType == EntryTypeBatchEnd
+}
+
+func (f *FileEntry) IsUnwind() bool {
+	return f.EntryType == EntryTypeUnwind
+}
+
 func (f *FileEntry) IsBookmark() bool {
 	return f.EntryType == BookmarkEntryType
 }
@@ -5,13 +5,19 @@ import (
 	"fmt"
 )
 
-const HeaderSize = 29
+const (
+	HeaderSize = 29
+	// HeaderSizeVersioned is the size of the header of the streams that have a version and a system id
+	HeaderSizeVersioned = 38
+)
 
 type StreamType uint64
 
 type HeaderEntry struct {
 	PacketType   uint8      // 1:Header
-	HeadLength   uint32     // 29
+	HeadLength   uint32     // 29 or 38
+	Version      uint8      // only in the versioned header
+	SystemID     uint64     // only in the versioned header
 	StreamType   StreamType // 1:Sequencer
 	TotalLength  uint64     // Total bytes used in the file
 	TotalEntries uint64     // Total number of data entries (entry type 2)
@@ -19,6 +25,18 @@ type HeaderEntry struct {
 
 // Decode/convert from binary bytes slice to a header entry type
 func DecodeHeaderEntry(b []byte) (*HeaderEntry, error) {
+	if len(b) == HeaderSizeVersioned {
+		return &HeaderEntry{
+			PacketType:   b[0],
+			HeadLength:   binary.BigEndian.Uint32(b[1:5]),
+			Version:      b[5],
+			SystemID:     binary.BigEndian.Uint64(b[6:14]),
+			StreamType:   StreamType(binary.BigEndian.Uint64(b[14:22])),
+			TotalLength:  binary.BigEndian.Uint64(b[22:30]),
+			TotalEntries: binary.BigEndian.Uint64(b[30:38]),
+		}, nil
+	}
+
 	if len(b) != HeaderSize {
 		return &HeaderEntry{}, fmt.Errorf("invalid header entry binary size. Expected: %d, got: %d", HeaderSize, len(b))
 	}
@@ -28,6 +28,20 @@ func TestHeaderEntryDecode(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			name:  "Versioned header",
+			input: []byte{1, 0, 0, 0, 38, 2, 0, 0, 0, 0, 0, 0, 0, 3, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 16, 24, 0, 0, 0, 0, 0, 0, 0, 64},
+			expectedResult: HeaderEntry{
+				PacketType:   1,
+				HeadLength:   38,
+				Version:      2,
+				SystemID:     3,
+				StreamType:   StreamType(1),
+				TotalLength:  4120,
+				TotalEntries: 64,
+			},
+			expectedError: nil,
+		},
 		{
 			name:           "Invalid byte array length",
 			input:          []byte{20, 21, 22, 23, 24, 20},
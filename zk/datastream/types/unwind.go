@@ -0,0 +1,39 @@
+package types
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	unwindDataLength = 16
+
+	// EntryTypeUnwind tells the connected clients the stream was truncated, it is never left in the stream file
+	EntryTypeUnwind EntryType = 7
+)
+
+// Unwind is broadcast when the stream is truncated back to the end of a batch, the entries that follow it replace
+// the truncated ones
+type Unwind struct {
+	BatchNumber   uint64 // 8 bytes, the last batch left in the stream
+	L2BlockNumber uint64 // 8 bytes, the last block left in the stream
+}
+
+// decodes an Unwind from a byte array
+func DecodeUnwind(data []byte) (*Unwind, error) {
+	if len(data) != unwindDataLength {
+		return &Unwind{}, fmt.Errorf("expected data length: %d, got: %d", unwindDataLength, len(data))
+	}
+
+	return &Unwind{
+		BatchNumber:   binary.LittleEndian.Uint64(data[:8]),
+		L2BlockNumber: binary.LittleEndian.Uint64(data[8:16]),
+	}, nil
+}
+
+func EncodeUnwind(unwind *Unwind) []byte {
+	bytes := make([]byte, 0)
+	bytes = binary.LittleEndian.AppendUint64(bytes, unwind.BatchNumber)
+	bytes = binary.LittleEndian.AppendUint64(bytes, unwind.L2BlockNumber)
+	return bytes
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnwindEncodeDecode(t *testing.T) {
+	unwind := Unwind{BatchNumber: 101, L2BlockNumber: 202}
+	encoded := EncodeUnwind(&unwind)
+	require.Equal(t, []byte{101, 0, 0, 0, 0, 0, 0, 0, 202, 0, 0, 0, 0, 0, 0, 0}, encoded)
+
+	decoded, err := DecodeUnwind(encoded)
+	require.NoError(t, err)
+	require.Equal(t, unwind, *decoded)
+
+	_, err = DecodeUnwind(encoded[:8])
+	require.Equal(t, fmt.Errorf("expected data length: 16, got: 8"), err)
+}
@@ -0,0 +1,403 @@
+package datastream
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon/core/rawdb"
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+)
+
+// EntryReader reads the entries of a stream in order, returning io.EOF after the last one
+type EntryReader interface {
+	ReadEntry() (*types.FileEntry, error)
+	// Codec decodes the entries in the version of the stream
+	Codec() *types.Codec
+}
+
+// StreamDivergence is the first entry of a stream that doesn't match the node's db
+type StreamDivergence struct {
+	EntryNum  uint64
+	EntryType types.EntryType
+	Field     string
+	Expected  string
+	Actual    string
+}
+
+func (d StreamDivergence) String() string {
+	return fmt.Sprintf("entry %d (type %d) %s: expected %s, got %s", d.EntryNum, d.EntryType, d.Field, d.Expected, d.Actual)
+}
+
+type StreamVerifyReport struct {
+	Entries   uint64
+	Blocks    uint64
+	LastBlock uint64
+	// nil if the whole stream matches
+	Divergence *StreamDivergence
+}
+
+func (r *StreamVerifyReport) Ok() bool {
+	return r.Divergence == nil
+}
+
+// streamVerifier holds the position in the stream while it is checked
+type streamVerifier struct {
+	tx     kv.Tx
+	reader *hermez_db.HermezDbReader
+	codec  *types.Codec
+	report *StreamVerifyReport
+
+	entry *types.FileEntry
+
+	// the block being read, between its start and end entries
+	block   *ethTypes.Block
+	start   *types.StartL2Block
+	txIndex int
+
+	lastBlock   *ethTypes.Block
+	batch       uint64
+	inBatch     bool
+	batchClosed bool
+}
+
+// VerifyStream reads the stream written by the data stream catchup stage to the end and checks its blocks,
+// transactions, batches and global exit roots against the canonical chain and the hermez tables of the db. It stops
+// at the first entry that doesn't match, which the report holds.
+func VerifyStream(ctx context.Context, tx kv.Tx, entries EntryReader) (*StreamVerifyReport, error) {
+	v := &streamVerifier{
+		tx:     tx,
+		reader: hermez_db.NewHermezDbReader(tx),
+		codec:  entries.Codec(),
+		report: &StreamVerifyReport{},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		entry, err := entries.ReadEntry()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read entry %d error: %v", v.report.Entries, err)
+		}
+		v.entry = entry
+		v.report.Entries++
+
+		if err := v.verifyEntry(); err != nil {
+			return nil, err
+		}
+		if v.report.Divergence != nil {
+			return v.report, nil
+		}
+	}
+
+	if v.block != nil {
+		v.diverge("end of stream", "end of block", "end of stream")
+	}
+
+	return v.report, nil
+}
+
+// diverge records the first divergence
+func (v *streamVerifier) diverge(field string, expected, actual interface{}) {
+	if v.report.Divergence != nil {
+		return
+	}
+	v.report.Divergence = &StreamDivergence{
+		EntryNum:  v.entry.EntryNum,
+		EntryType: v.entry.EntryType,
+		Field:     field,
+		Expected:  fmt.Sprint(expected),
+		Actual:    fmt.Sprint(actual),
+	}
+}
+
+func (v *streamVerifier) verifyEntry() error {
+	entry := v.entry
+
+	// only the transactions and the end of a block can be between its start and end
+	if v.block != nil && !entry.IsTx() && !entry.IsBlockEnd() {
+		v.diverge("entry type", "transaction or end of block", entry.EntryType)
+		return nil
+	}
+
+	switch {
+	case entry.IsBookmark():
+		return v.verifyBookmark()
+	case entry.IsBatchStart():
+		return v.verifyBatchStart()
+	case entry.IsBatchEnd():
+		return v.verifyBatchEnd()
+	case entry.IsGerUpdate():
+		return v.verifyGerUpdate()
+	case entry.IsBlockStart():
+		return v.verifyBlockStart()
+	case entry.IsTx():
+		return v.verifyTx()
+	case entry.IsBlockEnd():
+		return v.verifyBlockEnd()
+	default:
+		v.diverge("entry type", "known entry type", entry.EntryType)
+		return nil
+	}
+}
+
+func (v *streamVerifier) verifyBookmark() error {
+	if len(v.entry.Data) != 9 {
+		v.diverge("bookmark length", 9, len(v.entry.Data))
+		return nil
+	}
+	if t := v.entry.Data[0]; t != types.BookmarkTypeStart && t != types.BookmarkTypeBatch {
+		v.diverge("bookmark type", "block or batch", t)
+	}
+	return nil
+}
+
+func (v *streamVerifier) verifyBatchStart() error {
+	start, err := types.DecodeBatchStart(v.entry.Data)
+	if err != nil {
+		v.diverge("batch start", "valid batch start", err)
+		return nil
+	}
+
+	if v.inBatch && !v.batchClosed {
+		v.diverge("batch start", fmt.Sprintf("end of batch %d", v.batch), start.BatchNumber)
+		return nil
+	}
+	if v.inBatch && start.BatchNumber != v.batch+1 {
+		v.diverge("batch number", v.batch+1, start.BatchNumber)
+		return nil
+	}
+
+	fork, err := v.reader.GetForkId(start.BatchNumber)
+	if err != nil {
+		return err
+	}
+	if uint64(start.ForkId) != fork {
+		v.diverge("fork id", fork, start.ForkId)
+		return nil
+	}
+
+	v.batch = start.BatchNumber
+	v.inBatch = true
+	v.batchClosed = false
+	return nil
+}
+
+func (v *streamVerifier) verifyBatchEnd() error {
+	end, err := types.DecodeBatchEnd(v.entry.Data)
+	if err != nil {
+		v.diverge("batch end", "valid batch end", err)
+		return nil
+	}
+
+	if !v.inBatch || v.batchClosed || end.BatchNumber != v.batch {
+		v.diverge("batch end", fmt.Sprintf("end of batch %d", v.batch), end.BatchNumber)
+		return nil
+	}
+	if v.lastBlock != nil && end.StateRoot != v.lastBlock.Root() {
+		v.diverge("batch state root", v.lastBlock.Root(), end.StateRoot)
+		return nil
+	}
+
+	v.batchClosed = true
+	return nil
+}
+
+func (v *streamVerifier) verifyGerUpdate() error {
+	update, err := v.codec.DecodeGerUpdate(v.entry.Data)
+	if err != nil {
+		v.diverge("ger update", "valid ger update", err)
+		return nil
+	}
+
+	ger, err := v.reader.GetBatchGlobalExitRoot(update.BatchNumber)
+	if err != nil {
+		return err
+	}
+	if ger == nil {
+		v.diverge("batch global exit root", "none", update.GlobalExitRoot)
+		return nil
+	}
+	if ger.GlobalExitRoot != update.GlobalExitRoot {
+		v.diverge("batch global exit root", ger.GlobalExitRoot, update.GlobalExitRoot)
+	}
+	return nil
+}
+
+func (v *streamVerifier) verifyBlockStart() error {
+	start, err := v.codec.DecodeStartL2Block(v.entry.Data)
+	if err != nil {
+		v.diverge("block start", "valid block start", err)
+		return nil
+	}
+
+	if v.lastBlock != nil && start.L2BlockNumber != v.lastBlock.NumberU64()+1 {
+		v.diverge("block number", v.lastBlock.NumberU64()+1, start.L2BlockNumber)
+		return nil
+	}
+
+	block, err := rawdb.ReadBlockByNumber(v.tx, start.L2BlockNumber)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		v.diverge("block", fmt.Sprintf("canonical block %d", start.L2BlockNumber), "no block in the db")
+		return nil
+	}
+
+	batch, err := v.reader.GetBatchNoByL2Block(start.L2BlockNumber)
+	if err != nil {
+		return err
+	}
+	if start.BatchNumber != batch {
+		v.diverge("batch number", batch, start.BatchNumber)
+		return nil
+	}
+	if v.inBatch && (v.batchClosed || start.BatchNumber != v.batch) {
+		v.diverge("batch number", fmt.Sprintf("block of batch %d", v.batch), start.BatchNumber)
+		return nil
+	}
+
+	fork, err := v.reader.GetForkId(batch)
+	if err != nil {
+		return err
+	}
+	if uint64(start.ForkId) != fork {
+		v.diverge("fork id", fork, start.ForkId)
+		return nil
+	}
+
+	ger, err := v.reader.GetBlockGlobalExitRoot(start.L2BlockNumber)
+	if err != nil {
+		return err
+	}
+	if start.GlobalExitRoot != ger {
+		v.diverge("global exit root", ger, start.GlobalExitRoot)
+		return nil
+	}
+
+	if uint64(start.Timestamp) != block.Time() {
+		v.diverge("timestamp", block.Time(), start.Timestamp)
+		return nil
+	}
+	if start.Coinbase != block.Coinbase() {
+		v.diverge("coinbase", block.Coinbase(), start.Coinbase)
+		return nil
+	}
+	if v.codec.Version() >= types.StreamVersion2 && start.GasLimit != block.GasLimit() {
+		v.diverge("gas limit", block.GasLimit(), start.GasLimit)
+		return nil
+	}
+
+	v.block = block
+	v.start = start
+	v.txIndex = 0
+	return nil
+}
+
+func (v *streamVerifier) verifyTx() error {
+	if v.block == nil {
+		v.diverge("entry type", "start of block", v.entry.EntryType)
+		return nil
+	}
+
+	l2Tx, err := v.codec.DecodeL2Transaction(v.entry.Data)
+	if err != nil {
+		v.diverge("transaction", "valid transaction", err)
+		return nil
+	}
+
+	txs := v.block.Transactions()
+	if v.txIndex >= len(txs) {
+		v.diverge("transactions", len(txs), v.txIndex+1)
+		return nil
+	}
+	tx := txs[v.txIndex]
+	v.txIndex++
+
+	effectiveGasPricePercentage, err := v.reader.GetEffectiveGasPricePercentage(tx.Hash())
+	if err != nil {
+		return err
+	}
+	if l2Tx.EffectiveGasPricePercentage != effectiveGasPricePercentage {
+		v.diverge(fmt.Sprintf("tx %s effective gas price percentage", tx.Hash()), effectiveGasPricePercentage, l2Tx.EffectiveGasPricePercentage)
+		return nil
+	}
+
+	stateRoot, err := v.reader.GetStateRoot(v.block.NumberU64())
+	if err != nil {
+		return err
+	}
+	if l2Tx.StateRoot != stateRoot {
+		v.diverge(fmt.Sprintf("tx %s state root", tx.Hash()), stateRoot, l2Tx.StateRoot)
+		return nil
+	}
+
+	// encoded as the stream server does it
+	var buf bytes.Buffer
+	if err := tx.EncodeRLP(&buf); err != nil {
+		return err
+	}
+	encoded := buf.Bytes()
+	if v.start.ForkId >= 5 {
+		encoded = append(encoded, effectiveGasPricePercentage)
+	}
+	if !bytes.Equal(l2Tx.Encoded, encoded) {
+		v.diverge(fmt.Sprintf("tx %s encoding", tx.Hash()), hex.EncodeToString(encoded), hex.EncodeToString(l2Tx.Encoded))
+	}
+	return nil
+}
+
+func (v *streamVerifier) verifyBlockEnd() error {
+	if v.block == nil {
+		v.diverge("entry type", "start of block", v.entry.EntryType)
+		return nil
+	}
+
+	end, err := v.codec.DecodeEndL2Block(v.entry.Data)
+	if err != nil {
+		v.diverge("block end", "valid block end", err)
+		return nil
+	}
+
+	if end.L2BlockNumber != v.block.NumberU64() {
+		v.diverge("block number", v.block.NumberU64(), end.L2BlockNumber)
+		return nil
+	}
+	if txs := len(v.block.Transactions()); v.txIndex != txs {
+		v.diverge("transactions", txs, v.txIndex)
+		return nil
+	}
+	if end.L2Blockhash != v.block.Hash() {
+		v.diverge("block hash", v.block.Hash(), end.L2Blockhash)
+		return nil
+	}
+	if end.StateRoot != v.block.Root() {
+		v.diverge("state root", v.block.Root(), end.StateRoot)
+		return nil
+	}
+	if v.codec.Version() >= types.StreamVersion2 && end.GasUsed != v.block.GasUsed() {
+		v.diverge("gas used", v.block.GasUsed(), end.GasUsed)
+		return nil
+	}
+
+	v.lastBlock = v.block
+	v.block = nil
+	v.start = nil
+	v.report.Blocks++
+	v.report.LastBlock = end.L2BlockNumber
+	return nil
+}
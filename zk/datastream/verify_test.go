@@ -0,0 +1,188 @@
+package datastream
+
+import (
+	"context"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/0xPolygonHermez/zkevm-data-streamer/datastreamer"
+	dslog "github.com/0xPolygonHermez/zkevm-data-streamer/log"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon-lib/kv/memdb"
+	"github.com/tenderly/zkevm-erigon/core/rawdb"
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/client"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/server"
+	"github.com/tenderly/zkevm-erigon/zk/datastream/types"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+)
+
+const testForkId = 7
+
+// blocks of the test batches, batch 2 is empty
+var testBatches = [][]uint64{{0}, {1, 2}, {}, {3}}
+
+// writeTestChain writes the blocks of testBatches to the db along with their hermez data
+func writeTestChain(t *testing.T, tx kv.RwTx) {
+	require.NoError(t, hermez_db.CreateHermezBuckets(tx))
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	require.NoError(t, err)
+	require.NoError(t, hermezDb.WriteForkId(0, testForkId))
+
+	parent := libcommon.Hash{}
+	for batchNo, blockNos := range testBatches {
+		if len(blockNos) == 0 {
+			ger := types.GerUpdate{BatchNumber: uint64(batchNo), GlobalExitRoot: libcommon.HexToHash("0xbeef")}
+			require.NoError(t, hermezDb.WriteBatchGBatchGlobalExitRoot(uint64(batchNo), ger))
+		}
+
+		for _, blockNo := range blockNos {
+			header := &ethTypes.Header{
+				ParentHash: parent,
+				Number:     new(big.Int).SetUint64(blockNo),
+				Time:       1000 + blockNo,
+				Coinbase:   libcommon.HexToAddress("0x1234"),
+				Root:       libcommon.BigToHash(new(big.Int).SetUint64(100 + blockNo)),
+				Difficulty: big.NewInt(0),
+				GasLimit:   30_000_000,
+			}
+			var txs []ethTypes.Transaction
+			if blockNo > 0 {
+				txs = append(txs, ethTypes.NewTransaction(blockNo, libcommon.HexToAddress("0x5678"), uint256.NewInt(blockNo), 21000, uint256.NewInt(1), nil))
+				header.GasUsed = 21000
+			}
+			block := ethTypes.NewBlock(header, txs, nil, nil, nil)
+			require.NoError(t, rawdb.WriteBlock(tx, block))
+			require.NoError(t, rawdb.WriteCanonicalHash(tx, block.Hash(), blockNo))
+			parent = block.Hash()
+
+			require.NoError(t, hermezDb.WriteBlockBatch(blockNo, uint64(batchNo)))
+			require.NoError(t, hermezDb.WriteBlockGlobalExitRoot(blockNo, libcommon.BigToHash(new(big.Int).SetUint64(blockNo))))
+			require.NoError(t, hermezDb.WriteStateRoot(blockNo, block.Root()))
+			for _, tx := range txs {
+				require.NoError(t, hermezDb.WriteEffectiveGasPricePercentage(tx.Hash(), 255))
+			}
+		}
+	}
+}
+
+// writeTestStream writes the chain to a stream file of a version the way the data stream catchup stage does
+func writeTestStream(t *testing.T, tx kv.Tx, version types.StreamVersion) string {
+	fileName := filepath.Join(t.TempDir(), "data-stream.bin")
+	logConfig := &dslog.Config{
+		Environment: "production",
+		Level:       "warn",
+	}
+	stream, err := datastreamer.NewServer(0, uint8(version), 1, datastreamer.StreamType(1), fileName, logConfig)
+	require.NoError(t, err)
+	require.NoError(t, stream.Start())
+	srv := server.NewDataStreamServer(stream)
+	reader := hermez_db.NewHermezDbReader(tx)
+
+	require.NoError(t, stream.StartAtomicOp())
+	var stateRoot libcommon.Hash
+	for batchNo, blockNos := range testBatches {
+		batch := uint64(batchNo)
+		require.NoError(t, srv.AddBookmark(server.BatchBookmarkType, batch))
+		require.NoError(t, srv.AddBatchStart(batch, testForkId))
+
+		if len(blockNos) == 0 {
+			ger, err := reader.GetBatchGlobalExitRoot(batch)
+			require.NoError(t, err)
+			_, err = srv.AddGerUpdateFromDb(ger)
+			require.NoError(t, err)
+		}
+
+		for _, blockNo := range blockNos {
+			block, err := rawdb.ReadBlockByNumber(tx, blockNo)
+			require.NoError(t, err)
+			ger, err := reader.GetBlockGlobalExitRoot(blockNo)
+			require.NoError(t, err)
+
+			require.NoError(t, srv.AddBookmark(server.BlockBookmarkType, blockNo))
+			require.NoError(t, srv.AddBlockStart(block, batch, testForkId, ger))
+			for _, tx := range block.Transactions() {
+				egp, err := reader.GetEffectiveGasPricePercentage(tx.Hash())
+				require.NoError(t, err)
+				_, err = srv.AddTransaction(egp, block.Root(), testForkId, tx)
+				require.NoError(t, err)
+			}
+			require.NoError(t, srv.AddBlockEnd(block))
+			stateRoot = block.Root()
+		}
+
+		_, err := srv.AddBatchEnd(batch, stateRoot, libcommon.Hash{}, libcommon.Hash{})
+		require.NoError(t, err)
+	}
+	require.NoError(t, stream.CommitAtomicOp())
+
+	return fileName
+}
+
+func verifyTestStream(t *testing.T, tx kv.Tx, fileName string) *StreamVerifyReport {
+	file, err := client.OpenStreamFile(fileName)
+	require.NoError(t, err)
+	defer file.Close()
+
+	report, err := VerifyStream(context.Background(), tx, file)
+	require.NoError(t, err)
+	return report
+}
+
+func TestVerifyStream(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeTestChain(t, tx)
+	fileName := writeTestStream(t, tx, types.StreamVersion1)
+
+	report := verifyTestStream(t, tx, fileName)
+	require.True(t, report.Ok(), "unexpected divergence %v", report.Divergence)
+	require.Equal(t, uint64(4), report.Blocks)
+	require.Equal(t, uint64(3), report.LastBlock)
+
+	// the node's db changed after the stream was written
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	require.NoError(t, err)
+	require.NoError(t, hermezDb.WriteBlockGlobalExitRoot(2, libcommon.HexToHash("0xdead")))
+
+	report = verifyTestStream(t, tx, fileName)
+	require.False(t, report.Ok())
+	require.Equal(t, types.EntryTypeStartL2Block, report.Divergence.EntryType)
+	require.Equal(t, "global exit root", report.Divergence.Field)
+	require.Equal(t, libcommon.HexToHash("0xdead").String(), report.Divergence.Expected)
+	require.Equal(t, uint64(2), report.Blocks)
+}
+
+func TestVerifyStream_Reorg(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeTestChain(t, tx)
+	fileName := writeTestStream(t, tx, types.StreamVersion1)
+
+	// block 3 is replaced after the stream was written
+	block, err := rawdb.ReadBlockByNumber(tx, 3)
+	require.NoError(t, err)
+	header := block.Header()
+	header.Time++
+	reorged := ethTypes.NewBlock(header, block.Transactions(), nil, nil, nil)
+	require.NoError(t, rawdb.WriteBlock(tx, reorged))
+	require.NoError(t, rawdb.WriteCanonicalHash(tx, reorged.Hash(), 3))
+
+	report := verifyTestStream(t, tx, fileName)
+	require.False(t, report.Ok())
+	require.Equal(t, "timestamp", report.Divergence.Field)
+	require.Equal(t, uint64(3), report.Blocks)
+	require.Equal(t, uint64(2), report.LastBlock)
+}
+
+func TestVerifyStream_Version2(t *testing.T) {
+	_, tx := memdb.NewTestTx(t)
+	writeTestChain(t, tx)
+	fileName := writeTestStream(t, tx, types.StreamVersion2)
+
+	report := verifyTestStream(t, tx, fileName)
+	require.True(t, report.Ok(), "unexpected divergence %v", report.Divergence)
+	require.Equal(t, uint64(4), report.Blocks)
+}
@@ -0,0 +1,208 @@
+package hermez_db
+
+import (
+	"fmt"
+
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+// the batch index keeps the span of the blocks of every batch, as its blocks are consecutive, and a header of the
+// batch written by the stages that know it
+
+const (
+	// coinbase, state root, local exit root, acc input hash, ger, timestamp, flags and forced batch number, followed
+	// by the l2 data
+	batchHeaderLength = 20 + 32*4 + 8 + 1 + 8
+
+	batchHeaderClosed = 1 << 0
+	batchHeaderForced = 1 << 1
+)
+
+// GetBatchBlockRange returns the first and last block of a batch, found is false for a batch without blocks
+func (db *HermezDbReader) GetBatchBlockRange(batchNo uint64) (first, last uint64, found bool, err error) {
+	v, err := db.tx.GetOne(BATCH_BLOCKS, Uint64ToBytes(batchNo))
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if len(v) == 0 {
+		return 0, 0, false, nil
+	}
+	first, last, err = SplitKey(v)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("batch %d blocks: %w", batchNo, err)
+	}
+	return first, last, true, nil
+}
+
+func (db *HermezDb) extendBatchBlockRange(batchNo, l2BlockNo uint64) error {
+	first, last, found, err := db.GetBatchBlockRange(batchNo)
+	if err != nil {
+		return err
+	}
+	if !found {
+		first, last = l2BlockNo, l2BlockNo
+	}
+	if l2BlockNo < first {
+		first = l2BlockNo
+	}
+	if l2BlockNo > last {
+		last = l2BlockNo
+	}
+	return db.tx.Put(BATCH_BLOCKS, Uint64ToBytes(batchNo), ConcatKey(first, last))
+}
+
+// GetBatchHeader returns the header of a batch, nil if none was written
+func (db *HermezDbReader) GetBatchHeader(batchNo uint64) (*types.BatchHeader, error) {
+	v, err := db.tx.GetOne(BATCH_HEADERS, Uint64ToBytes(batchNo))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return decodeBatchHeader(batchNo, v)
+}
+
+func (db *HermezDb) WriteBatchHeader(header *types.BatchHeader) error {
+	return db.tx.Put(BATCH_HEADERS, Uint64ToBytes(header.BatchNumber), encodeBatchHeader(header))
+}
+
+// TruncateBatchIndex removes the blocks after l2BlockNo from the batch index, the batches only made of them along
+// with their headers. The header of the batch cut short is removed too, as it no longer describes its blocks.
+func (db *HermezDb) TruncateBatchIndex(l2BlockNo uint64) error {
+	batchNo, err := db.GetBatchNoByL2Block(l2BlockNo)
+	if err != nil {
+		return err
+	}
+
+	batchNos := map[uint64]struct{}{}
+	blockNos := make([][]byte, 0)
+	if err := db.tx.ForEach(BLOCKBATCHES, Uint64ToBytes(l2BlockNo+1), func(k, v []byte) error {
+		blockNos = append(blockNos, common.Copy(k))
+		batchNos[BytesToUint64(v)] = struct{}{}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range blockNos {
+		if err := db.tx.Delete(BLOCKBATCHES, k); err != nil {
+			return err
+		}
+	}
+
+	for n := range batchNos {
+		first, last, found, err := db.GetBatchBlockRange(n)
+		if err != nil {
+			return err
+		}
+		if !found || last <= l2BlockNo {
+			continue
+		}
+		if err := db.tx.Delete(BATCH_HEADERS, Uint64ToBytes(n)); err != nil {
+			return err
+		}
+		if first > l2BlockNo || n != batchNo {
+			if err := db.tx.Delete(BATCH_BLOCKS, Uint64ToBytes(n)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := db.tx.Put(BATCH_BLOCKS, Uint64ToBytes(n), ConcatKey(first, l2BlockNo)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearBatchIndex removes the spans of the blocks of all the batches along with their headers
+func (db *HermezDb) ClearBatchIndex() error {
+	if err := db.tx.ClearBucket(BATCH_BLOCKS); err != nil {
+		return err
+	}
+	return db.tx.ClearBucket(BATCH_HEADERS)
+}
+
+// backfillBatchBlockRanges fills the spans of the blocks of the batches from the batches of the blocks
+func backfillBatchBlockRanges(tx kv.RwTx) error {
+	db, err := NewHermezDb(tx)
+	if err != nil {
+		return err
+	}
+
+	// the blocks of a batch are consecutive, the span of a batch is written once the next one is reached
+	var batchNo, first, last uint64
+	started := false
+	flush := func() error {
+		if !started {
+			return nil
+		}
+		if err := db.extendBatchBlockRange(batchNo, first); err != nil {
+			return err
+		}
+		return db.extendBatchBlockRange(batchNo, last)
+	}
+	if err := tx.ForEach(BLOCKBATCHES, nil, func(k, v []byte) error {
+		blockNo, n := BytesToUint64(k), BytesToUint64(v)
+		if started && n == batchNo {
+			last = blockNo
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		batchNo, first, last, started = n, blockNo, blockNo, true
+		return nil
+	}); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func encodeBatchHeader(header *types.BatchHeader) []byte {
+	v := make([]byte, 0, batchHeaderLength+len(header.BatchL2Data))
+	v = append(v, header.Coinbase.Bytes()...)
+	v = append(v, header.StateRoot.Bytes()...)
+	v = append(v, header.LocalExitRoot.Bytes()...)
+	v = append(v, header.AccInputHash.Bytes()...)
+	v = append(v, header.GlobalExitRoot.Bytes()...)
+	v = append(v, Uint64ToBytes(header.Timestamp)...)
+
+	var flags byte
+	var forcedBatchNum uint64
+	if header.Closed {
+		flags |= batchHeaderClosed
+	}
+	if header.ForcedBatchNum != nil {
+		flags |= batchHeaderForced
+		forcedBatchNum = *header.ForcedBatchNum
+	}
+	v = append(v, flags)
+	v = append(v, Uint64ToBytes(forcedBatchNum)...)
+	return append(v, header.BatchL2Data...)
+}
+
+func decodeBatchHeader(batchNo uint64, v []byte) (*types.BatchHeader, error) {
+	if len(v) < batchHeaderLength {
+		return nil, fmt.Errorf("batch %d header: expected at least %d bytes, got %d", batchNo, batchHeaderLength, len(v))
+	}
+
+	header := &types.BatchHeader{
+		BatchNumber:    batchNo,
+		Coinbase:       common.BytesToAddress(v[:20]),
+		StateRoot:      common.BytesToHash(v[20:52]),
+		LocalExitRoot:  common.BytesToHash(v[52:84]),
+		AccInputHash:   common.BytesToHash(v[84:116]),
+		GlobalExitRoot: common.BytesToHash(v[116:148]),
+		Timestamp:      BytesToUint64(v[148:156]),
+		Closed:         v[156]&batchHeaderClosed != 0,
+		BatchL2Data:    common.Copy(v[batchHeaderLength:]),
+	}
+	if v[156]&batchHeaderForced != 0 {
+		forcedBatchNum := BytesToUint64(v[157:165])
+		header.ForcedBatchNum = &forcedBatchNum
+	}
+	return header, nil
+}
@@ -0,0 +1,180 @@
+package hermez_db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+func TestBatchBlockRange(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	for blockNo, batchNo := range []uint64{0, 1, 1, 1, 2, 3, 3} {
+		require.NoError(t, db.WriteBlockBatch(uint64(blockNo), batchNo))
+	}
+
+	first, last, found, err := db.GetBatchBlockRange(1)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(1), first)
+	require.Equal(t, uint64(3), last)
+
+	blockNos, err := db.GetL2BlockNosByBatch(3)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{5, 6}, blockNos)
+
+	highest, err := db.GetHighestBlockInBatch(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), highest)
+
+	_, _, found, err = db.GetBatchBlockRange(4)
+	require.NoError(t, err)
+	require.False(t, found)
+	blockNos, err = db.GetL2BlockNosByBatch(4)
+	require.NoError(t, err)
+	require.Empty(t, blockNos)
+}
+
+func TestBatchHeader(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	forced := uint64(7)
+	headers := []*types.BatchHeader{
+		{
+			BatchNumber:    1,
+			Coinbase:       common.HexToAddress("0x1"),
+			StateRoot:      common.HexToHash("0x2"),
+			LocalExitRoot:  common.HexToHash("0x3"),
+			AccInputHash:   common.HexToHash("0x4"),
+			GlobalExitRoot: common.HexToHash("0x5"),
+			Timestamp:      1700000000,
+			ForcedBatchNum: &forced,
+			Closed:         true,
+			BatchL2Data:    []byte{0xc0, 0x01},
+		},
+		{
+			BatchNumber: 2,
+			StateRoot:   common.HexToHash("0x6"),
+			BatchL2Data: []byte{},
+		},
+	}
+	for _, header := range headers {
+		require.NoError(t, db.WriteBatchHeader(header))
+	}
+	for _, header := range headers {
+		read, err := db.GetBatchHeader(header.BatchNumber)
+		require.NoError(t, err)
+		require.Equal(t, header, read)
+	}
+
+	missing, err := db.GetBatchHeader(3)
+	require.NoError(t, err)
+	require.Nil(t, missing)
+}
+
+func TestTruncateBatchIndex(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	for blockNo, batchNo := range []uint64{0, 1, 1, 1, 2, 2, 3} {
+		require.NoError(t, db.WriteBlockBatch(uint64(blockNo), batchNo))
+		require.NoError(t, db.WriteBatchHeader(&types.BatchHeader{BatchNumber: batchNo, Closed: true}))
+	}
+
+	// batch 1 is kept whole, batch 2 is cut short and batch 3 removed
+	require.NoError(t, db.TruncateBatchIndex(4))
+
+	type expected struct {
+		first, last uint64
+		found       bool
+		header      bool
+	}
+	for batchNo, e := range map[uint64]expected{
+		1: {1, 3, true, true},
+		2: {4, 4, true, false},
+		3: {0, 0, false, false},
+	} {
+		first, last, found, err := db.GetBatchBlockRange(batchNo)
+		require.NoError(t, err)
+		require.Equal(t, e.found, found, "batch %d", batchNo)
+		require.Equal(t, e.first, first, "batch %d", batchNo)
+		require.Equal(t, e.last, last, "batch %d", batchNo)
+
+		header, err := db.GetBatchHeader(batchNo)
+		require.NoError(t, err)
+		require.Equal(t, e.header, header != nil, "batch %d", batchNo)
+	}
+
+	batchNo, err := db.GetBatchNoByL2Block(5)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), batchNo)
+
+	// the blocks downloaded again go into the batches they are in now
+	require.NoError(t, db.WriteBlockBatch(5, 3))
+	first, last, found, err := db.GetBatchBlockRange(3)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, uint64(5), first)
+	require.Equal(t, uint64(5), last)
+}
+
+func TestClearBatchIndex(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	for blockNo, batchNo := range []uint64{0, 1, 1, 2} {
+		require.NoError(t, db.WriteBlockBatch(uint64(blockNo), batchNo))
+	}
+	require.NoError(t, db.WriteBatchHeader(&types.BatchHeader{BatchNumber: 1, Closed: true}))
+
+	require.NoError(t, db.ClearBatchIndex())
+
+	for batchNo := uint64(0); batchNo <= 2; batchNo++ {
+		_, _, found, err := db.GetBatchBlockRange(batchNo)
+		require.NoError(t, err)
+		require.False(t, found)
+	}
+	header, err := db.GetBatchHeader(1)
+	require.NoError(t, err)
+	require.Nil(t, header)
+}
+
+func TestBackfillBatchBlockRanges(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+
+	// the batches of the blocks as written before the index
+	for blockNo, batchNo := range []uint64{0, 1, 1, 2, 2, 2, 3} {
+		require.NoError(t, tx.Put(BLOCKBATCHES, Uint64ToBytes(uint64(blockNo)), Uint64ToBytes(batchNo)))
+	}
+	require.NoError(t, WriteSchemaVersion(tx, 1))
+	for _, m := range SchemaMigrations {
+		require.NoError(t, m.Apply(tx))
+	}
+
+	db := NewHermezDbReader(tx)
+	for batchNo, span := range map[uint64][2]uint64{0: {0, 0}, 1: {1, 2}, 2: {3, 5}, 3: {6, 6}} {
+		first, last, found, err := db.GetBatchBlockRange(batchNo)
+		require.NoError(t, err)
+		require.True(t, found, "batch %d", batchNo)
+		require.Equal(t, span, [2]uint64{first, last}, "batch %d", batchNo)
+	}
+}
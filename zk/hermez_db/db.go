@@ -18,6 +18,14 @@ const GLOBAL_EXIT_ROOTS = "hermez_globalExitRoots"                 // l2blockno
 const GLOBAL_EXIT_ROOTS_BATCHES = "hermez_globalExitRoots_batches" // l2blockno -> GER
 const TX_PRICE_PERCENTAGE = "hermez_txPricePercentage"             // txHash -> txPricePercentage
 const STATE_ROOTS = "hermez_stateRoots"                            // l2blockno -> stateRoot
+const BATCH_BLOCKS = "hermez_batchBlocks"                          // batchno -> first l2blockno, last l2blockno
+const BATCH_HEADERS = "hermez_batchHeaders"                        // batchno -> batch header
+const L1_BLOCK_HASHES = "hermez_l1BlockHashes"                     // l1blockno -> l1blockhash
+const FORCED_BATCHES = "hermez_forcedBatches"                      // forcedbatchno -> l1blockno, l1txhash, ger, sequencer, timestamp, txs
+const L1_GLOBAL_EXIT_ROOTS = "hermez_l1GlobalExitRoots"            // ger -> l1blockno, mainnet exit root, rollup exit root, timestamp
+const L1_BATCH_DATA = "hermez_l1BatchData"                         // batchno -> l1blockno, l1txhash, coinbase, ger, timestamp, txs
+const L1_BATCH_METADATA = "hermez_l1BatchMetadata"                 // batchno -> l1blockno, l1txhash, sequencer, ger, timestamp, txs hash, acc input hash
+const L1_FORK_IDS = "hermez_l1ForkIds"                             // l1blockno, batchno -> fork id the update replaced
 
 type HermezDb struct {
 	tx kv.RwTx
@@ -42,37 +50,10 @@ func NewHermezDb(tx kv.RwTx) (*HermezDb, error) {
 }
 
 func CreateHermezBuckets(tx kv.RwTx) error {
-	err := tx.CreateBucket(L1VERIFICATIONS)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(L1SEQUENCES)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(FORKIDS)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(BLOCKBATCHES)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(GLOBAL_EXIT_ROOTS)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(GLOBAL_EXIT_ROOTS_BATCHES)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(TX_PRICE_PERCENTAGE)
-	if err != nil {
-		return err
-	}
-	err = tx.CreateBucket(STATE_ROOTS)
-	if err != nil {
-		return err
+	for _, t := range HermezTables {
+		if err := tx.CreateBucket(t); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -101,26 +82,16 @@ func (db *HermezDbReader) GetBatchNoByL2Block(l2BlockNo uint64) (uint64, error)
 }
 
 func (db *HermezDbReader) GetL2BlockNosByBatch(batchNo uint64) ([]uint64, error) {
-	// TODO: not the most efficient way of doing this
-	c, err := db.tx.Cursor(BLOCKBATCHES)
-	if err != nil {
+	first, last, found, err := db.GetBatchBlockRange(batchNo)
+	if err != nil || !found {
 		return nil, err
 	}
-	defer c.Close()
-
-	var blockNos []uint64
-	var k, v []byte
 
-	for k, v, err = c.First(); k != nil; k, v, err = c.Next() {
-		if err != nil {
-			break
-		}
-		if BytesToUint64(v) == batchNo {
-			blockNos = append(blockNos, BytesToUint64(k))
-		}
+	blockNos := make([]uint64, 0, last-first+1)
+	for blockNo := first; blockNo <= last; blockNo++ {
+		blockNos = append(blockNos, blockNo)
 	}
-
-	return blockNos, err
+	return blockNos, nil
 }
 
 func (db *HermezDbReader) GetLatestDownloadedBatchNo() (uint64, error) {
@@ -139,19 +110,8 @@ func (db *HermezDbReader) GetLatestDownloadedBatchNo() (uint64, error) {
 }
 
 func (db *HermezDbReader) GetHighestBlockInBatch(batchNo uint64) (uint64, error) {
-	blocks, err := db.GetL2BlockNosByBatch(batchNo)
-	if err != nil {
-		return 0, err
-	}
-
-	max := uint64(0)
-	for _, block := range blocks {
-		if block > max {
-			max = block
-		}
-	}
-
-	return max, nil
+	_, last, _, err := db.GetBatchBlockRange(batchNo)
+	return last, err
 }
 
 func (db *HermezDbReader) GetHighestVerifiedBlockNo() (uint64, error) {
@@ -292,6 +252,9 @@ func (db *HermezDbReader) getLatest(table string) (*types.L1BatchInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if k == nil {
+		return nil, nil
+	}
 
 	l1BlockNo, batchNo, err := SplitKey(k)
 	if err != nil {
@@ -321,8 +284,12 @@ func (db *HermezDb) WriteVerification(l1BlockNo, batchNo uint64, l1TxHash common
 	return db.tx.Put(L1VERIFICATIONS, ConcatKey(l1BlockNo, batchNo), append(l1TxHash.Bytes(), stateRoot.Bytes()...))
 }
 
+// WriteBlockBatch records the batch of a block and extends the span of the batch's blocks to it
 func (db *HermezDb) WriteBlockBatch(l2BlockNo, batchNo uint64) error {
-	return db.tx.Put(BLOCKBATCHES, Uint64ToBytes(l2BlockNo), Uint64ToBytes(batchNo))
+	if err := db.tx.Put(BLOCKBATCHES, Uint64ToBytes(l2BlockNo), Uint64ToBytes(batchNo)); err != nil {
+		return err
+	}
+	return db.extendBatchBlockRange(batchNo, l2BlockNo)
 }
 
 func (db *HermezDb) WriteBlockGlobalExitRoot(l2BlockNo uint64, ger common.Hash) error {
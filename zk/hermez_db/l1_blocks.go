@@ -0,0 +1,146 @@
+package hermez_db
+
+import (
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+// the hashes of the L1 blocks the syncer processed are kept to find the block L1 was reorganised from, what was read
+// from the blocks after it is then removed
+
+func (db *HermezDb) WriteL1BlockHash(l1BlockNo uint64, hash common.Hash) error {
+	return db.tx.Put(L1_BLOCK_HASHES, Uint64ToBytes(l1BlockNo), hash.Bytes())
+}
+
+// GetLatestL1BlockHashes returns up to limit of the hashes of the highest L1 blocks up to l1BlockNo, lowest first
+func (db *HermezDbReader) GetLatestL1BlockHashes(l1BlockNo uint64, limit int) ([]types.L1BlockHash, error) {
+	c, err := db.tx.Cursor(L1_BLOCK_HASHES)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	hashes := make([]types.L1BlockHash, 0)
+	k, v, err := c.Seek(Uint64ToBytes(l1BlockNo + 1))
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		k, v, err = c.Last()
+	} else {
+		k, v, err = c.Prev()
+	}
+	for ; k != nil && len(hashes) < limit; k, v, err = c.Prev() {
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, types.L1BlockHash{BlockNo: BytesToUint64(k), Hash: common.BytesToHash(v)})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, nil
+}
+
+// WriteL1ForkId writes a fork id update read from an L1 block, keeping the fork id it replaced to restore it if the
+// block is reorganised away. An update read again keeps what it replaced the first time.
+func (db *HermezDb) WriteL1ForkId(l1BlockNo, batchNo, forkId uint64) error {
+	key := ConcatKey(l1BlockNo, batchNo)
+	v, err := db.tx.GetOne(L1_FORK_IDS, key)
+	if err != nil {
+		return err
+	}
+	if len(v) == 0 {
+		replaced, err := db.tx.GetOne(FORKIDS, Uint64ToBytes(batchNo))
+		if err != nil {
+			return err
+		}
+		// 0 when the batch had no fork id
+		if err := db.tx.Put(L1_FORK_IDS, key, Uint64ToBytes(BytesToUint64(replaced))); err != nil {
+			return err
+		}
+	}
+	return db.WriteForkId(batchNo, forkId)
+}
+
+// restoreL1ForkIds undoes the fork id updates read from the L1 blocks after l1BlockNo, latest first
+func (db *HermezDb) restoreL1ForkIds(l1BlockNo uint64) error {
+	type update struct {
+		key, batchNo, replaced []byte
+	}
+	updates := make([]update, 0)
+	if err := db.tx.ForEach(L1_FORK_IDS, ConcatKey(l1BlockNo+1, 0), func(k, v []byte) error {
+		_, batchNo, err := SplitKey(k)
+		if err != nil {
+			return err
+		}
+		updates = append(updates, update{common.Copy(k), Uint64ToBytes(batchNo), common.Copy(v)})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for i := len(updates) - 1; i >= 0; i-- {
+		u := updates[i]
+		if BytesToUint64(u.replaced) == 0 {
+			if err := db.tx.Delete(FORKIDS, u.batchNo); err != nil {
+				return err
+			}
+		} else if err := db.tx.Put(FORKIDS, u.batchNo, u.replaced); err != nil {
+			return err
+		}
+		if err := db.tx.Delete(L1_FORK_IDS, u.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TruncateL1Blocks removes what was read from the L1 blocks after l1BlockNo: their hashes, sequences, verifications,
+// batch data and metadata, forced batches and global exit roots. The fork ids their updates replaced are restored.
+func (db *HermezDb) TruncateL1Blocks(l1BlockNo uint64) error {
+	if err := db.restoreL1ForkIds(l1BlockNo); err != nil {
+		return err
+	}
+	if err := db.deleteFrom(L1_BLOCK_HASHES, Uint64ToBytes(l1BlockNo+1)); err != nil {
+		return err
+	}
+	if err := db.deleteFrom(L1SEQUENCES, ConcatKey(l1BlockNo+1, 0)); err != nil {
+		return err
+	}
+	if err := db.deleteFrom(L1VERIFICATIONS, ConcatKey(l1BlockNo+1, 0)); err != nil {
+		return err
+	}
+	if err := db.deleteAfterL1Block(L1_BATCH_DATA, l1BlockNo); err != nil {
+		return err
+	}
+	if err := db.deleteAfterL1Block(L1_BATCH_METADATA, l1BlockNo); err != nil {
+		return err
+	}
+	if err := db.deleteAfterL1Block(FORCED_BATCHES, l1BlockNo); err != nil {
+		return err
+	}
+	return db.deleteAfterL1Block(L1_GLOBAL_EXIT_ROOTS, l1BlockNo)
+}
+
+// deleteFrom deletes the keys of a table from the given one on
+func (db *HermezDb) deleteFrom(table string, from []byte) error {
+	keys := make([][]byte, 0)
+	if err := db.tx.ForEach(table, from, func(k, _ []byte) error {
+		keys = append(keys, common.Copy(k))
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := db.tx.Delete(table, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package hermez_db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+func testL1BlockHash(blockNo uint64) types.L1BlockHash {
+	return types.L1BlockHash{BlockNo: blockNo, Hash: common.BytesToHash(Uint64ToBytes(blockNo))}
+}
+
+func TestGetLatestL1BlockHashes(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	hashes, err := db.GetLatestL1BlockHashes(100, 10)
+	require.NoError(t, err)
+	require.Empty(t, hashes)
+
+	for _, blockNo := range []uint64{10, 20, 30, 40} {
+		h := testL1BlockHash(blockNo)
+		require.NoError(t, db.WriteL1BlockHash(h.BlockNo, h.Hash))
+	}
+
+	scenarios := map[string]struct {
+		l1BlockNo uint64
+		limit     int
+		expected  []uint64
+	}{
+		"all":            {100, 10, []uint64{10, 20, 30, 40}},
+		"limited":        {100, 2, []uint64{30, 40}},
+		"up to a block":  {30, 10, []uint64{10, 20, 30}},
+		"between blocks": {35, 2, []uint64{20, 30}},
+		"before all":     {5, 10, []uint64{}},
+	}
+	// the tx can't be used from the goroutines of subtests
+	for name, s := range scenarios {
+		expected := make([]types.L1BlockHash, 0)
+		for _, blockNo := range s.expected {
+			expected = append(expected, testL1BlockHash(blockNo))
+		}
+		hashes, err := db.GetLatestL1BlockHashes(s.l1BlockNo, s.limit)
+		require.NoError(t, err, name)
+		require.Equal(t, expected, hashes, name)
+	}
+}
+
+func TestTruncateL1Blocks(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	for i, l1BlockNo := range []uint64{10, 20, 30} {
+		batchNo := uint64(i + 1)
+		h := testL1BlockHash(l1BlockNo)
+		require.NoError(t, db.WriteL1BlockHash(h.BlockNo, h.Hash))
+		require.NoError(t, db.WriteSequence(l1BlockNo, batchNo, common.HexToHash("0x1"), common.HexToHash("0x2")))
+		require.NoError(t, db.WriteVerification(l1BlockNo+1, batchNo, common.HexToHash("0x3"), common.HexToHash("0x4")))
+	}
+
+	require.NoError(t, db.TruncateL1Blocks(20))
+
+	hashes, err := db.GetLatestL1BlockHashes(100, 10)
+	require.NoError(t, err)
+	require.Equal(t, []types.L1BlockHash{testL1BlockHash(10), testL1BlockHash(20)}, hashes)
+
+	sequence, err := db.GetLatestSequence()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), sequence.BatchNo)
+
+	// the verification of batch 2 was in the block after the one kept
+	verification, err := db.GetLatestVerification()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), verification.BatchNo)
+
+	require.NoError(t, db.TruncateL1Blocks(0))
+	verification, err = db.GetLatestVerification()
+	require.NoError(t, err)
+	require.Nil(t, verification)
+}
+
+func TestTruncateL1Blocks_ForkIds(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	// the genesis fork id isn't read from L1
+	require.NoError(t, db.WriteForkId(1, 5))
+	require.NoError(t, db.WriteL1ForkId(10, 1, 6))
+	require.NoError(t, db.WriteL1ForkId(20, 100, 7))
+	require.NoError(t, db.WriteL1ForkId(30, 100, 8))
+	// read again it keeps what it replaced the first time
+	require.NoError(t, db.WriteL1ForkId(30, 100, 8))
+
+	forkId, err := db.GetForkId(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), forkId)
+
+	require.NoError(t, db.TruncateL1Blocks(20))
+	forkId, err = db.GetForkId(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), forkId)
+
+	require.NoError(t, db.TruncateL1Blocks(10))
+	forkId, err = db.GetForkId(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), forkId)
+
+	require.NoError(t, db.TruncateL1Blocks(0))
+	forkId, err = db.GetForkId(100)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), forkId)
+}
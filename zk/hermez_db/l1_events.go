@@ -0,0 +1,229 @@
+package hermez_db
+
+import (
+	"fmt"
+
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+const (
+	// l1 block, l1 tx hash, ger, sequencer and timestamp, followed by the transactions
+	forcedBatchLength = 8 + 32 + 32 + 20 + 8
+	// l1 block, mainnet exit root, rollup exit root and timestamp
+	l1GlobalExitRootLength = 8 + 32 + 32 + 8
+	// l1 block, l1 tx hash, coinbase, ger and timestamp, followed by the transactions
+	l1BatchDataLength = 8 + 32 + 20 + 32 + 8
+	// l1 block, l1 tx hash, sequencer, ger, timestamp, transactions hash, acc input hash, etrog and forced block hash
+	l1BatchMetadataLength = 8 + 32 + 20 + 32 + 8 + 32 + 32 + 1 + 32
+)
+
+func (db *HermezDb) WriteForcedBatch(batch *types.ForcedBatch) error {
+	v := make([]byte, 0, forcedBatchLength+len(batch.Transactions))
+	v = append(v, Uint64ToBytes(batch.L1BlockNo)...)
+	v = append(v, batch.L1TxHash.Bytes()...)
+	v = append(v, batch.GlobalExitRoot.Bytes()...)
+	v = append(v, batch.Sequencer.Bytes()...)
+	v = append(v, Uint64ToBytes(batch.Timestamp)...)
+	v = append(v, batch.Transactions...)
+	return db.tx.Put(FORCED_BATCHES, Uint64ToBytes(batch.ForcedBatchNumber), v)
+}
+
+// GetForcedBatch returns a forced batch, nil if it wasn't forced on L1
+func (db *HermezDbReader) GetForcedBatch(forcedBatchNo uint64) (*types.ForcedBatch, error) {
+	v, err := db.tx.GetOne(FORCED_BATCHES, Uint64ToBytes(forcedBatchNo))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	if len(v) < forcedBatchLength {
+		return nil, fmt.Errorf("forced batch %d: expected at least %d bytes, got %d", forcedBatchNo, forcedBatchLength, len(v))
+	}
+
+	return &types.ForcedBatch{
+		ForcedBatchNumber: forcedBatchNo,
+		L1BlockNo:         BytesToUint64(v[:8]),
+		L1TxHash:          common.BytesToHash(v[8:40]),
+		GlobalExitRoot:    common.BytesToHash(v[40:72]),
+		Sequencer:         common.BytesToAddress(v[72:92]),
+		Timestamp:         BytesToUint64(v[92:100]),
+		Transactions:      common.Copy(v[forcedBatchLength:]),
+	}, nil
+}
+
+func (db *HermezDb) WriteL1GlobalExitRoot(ger *types.L1GlobalExitRoot) error {
+	v := make([]byte, 0, l1GlobalExitRootLength)
+	v = append(v, Uint64ToBytes(ger.L1BlockNo)...)
+	v = append(v, ger.MainnetExitRoot.Bytes()...)
+	v = append(v, ger.RollupExitRoot.Bytes()...)
+	v = append(v, Uint64ToBytes(ger.Timestamp)...)
+	return db.tx.Put(L1_GLOBAL_EXIT_ROOTS, ger.GlobalExitRoot.Bytes(), v)
+}
+
+// GetL1GlobalExitRoot returns the L1 update of a global exit root, nil if it wasn't updated on L1
+func (db *HermezDbReader) GetL1GlobalExitRoot(ger common.Hash) (*types.L1GlobalExitRoot, error) {
+	v, err := db.tx.GetOne(L1_GLOBAL_EXIT_ROOTS, ger.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	if len(v) != l1GlobalExitRootLength {
+		return nil, fmt.Errorf("l1 global exit root %s: expected %d bytes, got %d", ger, l1GlobalExitRootLength, len(v))
+	}
+
+	return &types.L1GlobalExitRoot{
+		GlobalExitRoot:  ger,
+		L1BlockNo:       BytesToUint64(v[:8]),
+		MainnetExitRoot: common.BytesToHash(v[8:40]),
+		RollupExitRoot:  common.BytesToHash(v[40:72]),
+		Timestamp:       BytesToUint64(v[72:80]),
+	}, nil
+}
+
+func (db *HermezDb) WriteL1BatchData(batch *types.L1BatchData) error {
+	v := make([]byte, 0, l1BatchDataLength+len(batch.Transactions))
+	v = append(v, Uint64ToBytes(batch.L1BlockNo)...)
+	v = append(v, batch.L1TxHash.Bytes()...)
+	v = append(v, batch.Coinbase.Bytes()...)
+	v = append(v, batch.GlobalExitRoot.Bytes()...)
+	v = append(v, Uint64ToBytes(batch.Timestamp)...)
+	v = append(v, batch.Transactions...)
+	return db.tx.Put(L1_BATCH_DATA, Uint64ToBytes(batch.BatchNo), v)
+}
+
+// GetL1BatchData returns the data a batch was sequenced with on L1, nil if it wasn't read from L1
+func (db *HermezDbReader) GetL1BatchData(batchNo uint64) (*types.L1BatchData, error) {
+	v, err := db.tx.GetOne(L1_BATCH_DATA, Uint64ToBytes(batchNo))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	if len(v) < l1BatchDataLength {
+		return nil, fmt.Errorf("l1 batch data %d: expected at least %d bytes, got %d", batchNo, l1BatchDataLength, len(v))
+	}
+
+	return &types.L1BatchData{
+		BatchNo:        batchNo,
+		L1BlockNo:      BytesToUint64(v[:8]),
+		L1TxHash:       common.BytesToHash(v[8:40]),
+		Coinbase:       common.BytesToAddress(v[40:60]),
+		GlobalExitRoot: common.BytesToHash(v[60:92]),
+		Timestamp:      BytesToUint64(v[92:100]),
+		Transactions:   common.Copy(v[l1BatchDataLength:]),
+	}, nil
+}
+
+// GetFirstL1BatchDataAfterL1Block returns the lowest batch whose data was read from an L1 block after l1BlockNo, the
+// batches are sequenced in the order of their L1 blocks
+func (db *HermezDbReader) GetFirstL1BatchDataAfterL1Block(l1BlockNo uint64) (batchNo uint64, found bool, err error) {
+	c, err := db.tx.Cursor(L1_BATCH_DATA)
+	if err != nil {
+		return 0, false, err
+	}
+	defer c.Close()
+
+	var k, v []byte
+	for k, v, err = c.Last(); k != nil && err == nil; k, v, err = c.Prev() {
+		if len(v) < 8 || BytesToUint64(v[:8]) <= l1BlockNo {
+			break
+		}
+		batchNo, found = BytesToUint64(k), true
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return batchNo, found, nil
+}
+
+func (db *HermezDb) WriteL1BatchMetadata(metadata *types.L1BatchMetadata) error {
+	v := make([]byte, 0, l1BatchMetadataLength)
+	v = append(v, Uint64ToBytes(metadata.L1BlockNo)...)
+	v = append(v, metadata.L1TxHash.Bytes()...)
+	v = append(v, metadata.Sequencer.Bytes()...)
+	v = append(v, metadata.GlobalExitRoot.Bytes()...)
+	v = append(v, Uint64ToBytes(metadata.Timestamp)...)
+	v = append(v, metadata.TransactionsHash.Bytes()...)
+	v = append(v, metadata.AccInputHash.Bytes()...)
+	if metadata.Etrog {
+		v = append(v, 1)
+	} else {
+		v = append(v, 0)
+	}
+	v = append(v, metadata.ForcedBlockHashL1.Bytes()...)
+	return db.tx.Put(L1_BATCH_METADATA, Uint64ToBytes(metadata.BatchNo), v)
+}
+
+// GetL1BatchMetadata returns what a batch was sequenced on L1 with, nil if it wasn't read from L1
+func (db *HermezDbReader) GetL1BatchMetadata(batchNo uint64) (*types.L1BatchMetadata, error) {
+	v, err := db.tx.GetOne(L1_BATCH_METADATA, Uint64ToBytes(batchNo))
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, nil
+	}
+	return decodeL1BatchMetadata(batchNo, v)
+}
+
+// GetLatestL1BatchMetadata returns the metadata of the highest batch read from L1, nil if none was
+func (db *HermezDbReader) GetLatestL1BatchMetadata() (*types.L1BatchMetadata, error) {
+	c, err := db.tx.Cursor(L1_BATCH_METADATA)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	k, v, err := c.Last()
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, nil
+	}
+	return decodeL1BatchMetadata(BytesToUint64(k), v)
+}
+
+func decodeL1BatchMetadata(batchNo uint64, v []byte) (*types.L1BatchMetadata, error) {
+	if len(v) != l1BatchMetadataLength {
+		return nil, fmt.Errorf("l1 batch metadata %d: expected %d bytes, got %d", batchNo, l1BatchMetadataLength, len(v))
+	}
+
+	return &types.L1BatchMetadata{
+		BatchNo:           batchNo,
+		L1BlockNo:         BytesToUint64(v[:8]),
+		L1TxHash:          common.BytesToHash(v[8:40]),
+		Sequencer:         common.BytesToAddress(v[40:60]),
+		GlobalExitRoot:    common.BytesToHash(v[60:92]),
+		Timestamp:         BytesToUint64(v[92:100]),
+		TransactionsHash:  common.BytesToHash(v[100:132]),
+		AccInputHash:      common.BytesToHash(v[132:164]),
+		Etrog:             v[164] == 1,
+		ForcedBlockHashL1: common.BytesToHash(v[165:197]),
+	}, nil
+}
+
+// deleteAfterL1Block deletes the entries of a table whose value starts with an L1 block after l1BlockNo
+func (db *HermezDb) deleteAfterL1Block(table string, l1BlockNo uint64) error {
+	keys := make([][]byte, 0)
+	if err := db.tx.ForEach(table, nil, func(k, v []byte) error {
+		if len(v) >= 8 && BytesToUint64(v[:8]) > l1BlockNo {
+			keys = append(keys, common.Copy(k))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := db.tx.Delete(table, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package hermez_db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/zk/types"
+)
+
+func TestForcedBatch(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	forcedBatch, err := db.GetForcedBatch(1)
+	require.NoError(t, err)
+	require.Nil(t, forcedBatch)
+
+	expected := &types.ForcedBatch{
+		ForcedBatchNumber: 1,
+		L1BlockNo:         10,
+		L1TxHash:          common.HexToHash("0x1"),
+		GlobalExitRoot:    common.HexToHash("0x2"),
+		Sequencer:         common.HexToAddress("0x3"),
+		Timestamp:         1000,
+		Transactions:      []byte{0xaa, 0xbb},
+	}
+	require.NoError(t, db.WriteForcedBatch(expected))
+	forcedBatch, err = db.GetForcedBatch(1)
+	require.NoError(t, err)
+	require.Equal(t, expected, forcedBatch)
+}
+
+func TestL1GlobalExitRoot(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	ger, err := db.GetL1GlobalExitRoot(common.HexToHash("0x1"))
+	require.NoError(t, err)
+	require.Nil(t, ger)
+
+	expected := &types.L1GlobalExitRoot{
+		GlobalExitRoot:  common.HexToHash("0x1"),
+		MainnetExitRoot: common.HexToHash("0x2"),
+		RollupExitRoot:  common.HexToHash("0x3"),
+		L1BlockNo:       10,
+		Timestamp:       1000,
+	}
+	require.NoError(t, db.WriteL1GlobalExitRoot(expected))
+	ger, err = db.GetL1GlobalExitRoot(common.HexToHash("0x1"))
+	require.NoError(t, err)
+	require.Equal(t, expected, ger)
+}
+
+func TestL1BatchData(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	batch, err := db.GetL1BatchData(1)
+	require.NoError(t, err)
+	require.Nil(t, batch)
+
+	expected := &types.L1BatchData{
+		BatchNo:        1,
+		L1BlockNo:      10,
+		L1TxHash:       common.HexToHash("0x1"),
+		Coinbase:       common.HexToAddress("0x2"),
+		GlobalExitRoot: common.HexToHash("0x3"),
+		Timestamp:      1000,
+		Transactions:   []byte{0xaa, 0xbb},
+	}
+	require.NoError(t, db.WriteL1BatchData(expected))
+	batch, err = db.GetL1BatchData(1)
+	require.NoError(t, err)
+	require.Equal(t, expected, batch)
+}
+
+func TestL1BatchMetadata(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	metadata, err := db.GetLatestL1BatchMetadata()
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+
+	expected := make([]*types.L1BatchMetadata, 0)
+	for n := uint64(1); n <= 2; n++ {
+		expected = append(expected, &types.L1BatchMetadata{
+			BatchNo:          n,
+			L1BlockNo:        10,
+			L1TxHash:         common.HexToHash("0x1"),
+			Sequencer:        common.HexToAddress("0x2"),
+			GlobalExitRoot:   common.HexToHash("0x3"),
+			Timestamp:        1000 + n,
+			TransactionsHash: common.HexToHash("0x4"),
+			AccInputHash:     common.BytesToHash(Uint64ToBytes(n)),
+		})
+		// the second batch is a forced one sequenced from etrog on
+		if n == 2 {
+			expected[n-1].Etrog = true
+			expected[n-1].ForcedBlockHashL1 = common.HexToHash("0x5")
+		}
+		require.NoError(t, db.WriteL1BatchMetadata(expected[n-1]))
+	}
+
+	metadata, err = db.GetL1BatchMetadata(1)
+	require.NoError(t, err)
+	require.Equal(t, expected[0], metadata)
+	metadata, err = db.GetLatestL1BatchMetadata()
+	require.NoError(t, err)
+	require.Equal(t, expected[1], metadata)
+	metadata, err = db.GetL1BatchMetadata(3)
+	require.NoError(t, err)
+	require.Nil(t, metadata)
+}
+
+func TestTruncateL1Blocks_Events(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	for i, l1BlockNo := range []uint64{10, 20, 30} {
+		n := uint64(i + 1)
+		require.NoError(t, db.WriteForcedBatch(&types.ForcedBatch{ForcedBatchNumber: n, L1BlockNo: l1BlockNo}))
+		require.NoError(t, db.WriteL1GlobalExitRoot(&types.L1GlobalExitRoot{GlobalExitRoot: common.BytesToHash(Uint64ToBytes(n)), L1BlockNo: l1BlockNo}))
+		require.NoError(t, db.WriteL1BatchData(&types.L1BatchData{BatchNo: n, L1BlockNo: l1BlockNo}))
+		require.NoError(t, db.WriteL1BatchMetadata(&types.L1BatchMetadata{BatchNo: n, L1BlockNo: l1BlockNo}))
+	}
+
+	require.NoError(t, db.TruncateL1Blocks(20))
+
+	for n, kept := range map[uint64]bool{1: true, 2: true, 3: false} {
+		forcedBatch, err := db.GetForcedBatch(n)
+		require.NoError(t, err)
+		require.Equal(t, kept, forcedBatch != nil, n)
+
+		ger, err := db.GetL1GlobalExitRoot(common.BytesToHash(Uint64ToBytes(n)))
+		require.NoError(t, err)
+		require.Equal(t, kept, ger != nil, n)
+
+		batch, err := db.GetL1BatchData(n)
+		require.NoError(t, err)
+		require.Equal(t, kept, batch != nil, n)
+
+		metadata, err := db.GetL1BatchMetadata(n)
+		require.NoError(t, err)
+		require.Equal(t, kept, metadata != nil, n)
+	}
+}
+
+func TestGetFirstL1BatchDataAfterL1Block(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+	require.NoError(t, CreateHermezBuckets(tx))
+	db, err := NewHermezDb(tx)
+	require.NoError(t, err)
+
+	_, found, err := db.GetFirstL1BatchDataAfterL1Block(0)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// two batches sequenced in each block
+	for n, l1BlockNo := range []uint64{10, 10, 20, 20, 30, 30} {
+		require.NoError(t, db.WriteL1BatchData(&types.L1BatchData{BatchNo: uint64(n + 1), L1BlockNo: l1BlockNo}))
+	}
+
+	for l1BlockNo, batchNo := range map[uint64]uint64{0: 1, 10: 3, 15: 3, 20: 5, 29: 5} {
+		first, found, err := db.GetFirstL1BatchDataAfterL1Block(l1BlockNo)
+		require.NoError(t, err)
+		require.True(t, found, l1BlockNo)
+		require.Equal(t, batchNo, first, l1BlockNo)
+	}
+
+	_, found, err = db.GetFirstL1BatchDataAfterL1Block(30)
+	require.NoError(t, err)
+	require.False(t, found)
+}
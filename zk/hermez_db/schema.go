@@ -0,0 +1,111 @@
+package hermez_db
+
+import (
+	"fmt"
+
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+)
+
+// SchemaVersion is the version of the layout of the hermez tables this node reads and writes. A database at an older
+// version is brought to it by the SchemaMigrations, one written by a newer node is refused.
+const SchemaVersion uint64 = 2
+
+// the schema version is kept in the database info table, alongside the version of the erigon tables
+var schemaVersionKey = []byte("hermezSchemaVersion")
+
+// HermezTables are the tables of the hermez db, they are created on startup and by the migrations
+var HermezTables = []string{
+	L1VERIFICATIONS,
+	L1SEQUENCES,
+	FORKIDS,
+	BLOCKBATCHES,
+	GLOBAL_EXIT_ROOTS,
+	GLOBAL_EXIT_ROOTS_BATCHES,
+	TX_PRICE_PERCENTAGE,
+	STATE_ROOTS,
+	BATCH_BLOCKS,
+	BATCH_HEADERS,
+	L1_BLOCK_HASHES,
+	FORCED_BATCHES,
+	L1_GLOBAL_EXIT_ROOTS,
+	L1_BATCH_DATA,
+	L1_BATCH_METADATA,
+	L1_FORK_IDS,
+}
+
+// SchemaMigration rewrites the hermez tables in place from the layout of the version before it to the layout of its
+// version
+type SchemaMigration struct {
+	Name    string
+	Version uint64
+	Up      func(tx kv.RwTx) error
+}
+
+// SchemaMigrations are applied in order by the migrations package, the last one is at SchemaVersion. A new one is
+// appended along with the change of layout and the bump of SchemaVersion.
+var SchemaMigrations = []SchemaMigration{
+	{
+		// the layout of the databases written before the schema was versioned
+		Name:    "hermez_schema_1",
+		Version: 1,
+		Up:      func(tx kv.RwTx) error { return nil },
+	},
+	{
+		// the spans of the blocks of the batches, the headers of the batches written before can't be recovered
+		Name:    "hermez_schema_2_batch_blocks",
+		Version: 2,
+		Up:      backfillBatchBlockRanges,
+	},
+}
+
+// ReadSchemaVersion returns the version of the hermez tables, 0 for a database written before it was versioned
+func ReadSchemaVersion(tx kv.Getter) (uint64, error) {
+	v, err := tx.GetOne(kv.DatabaseInfo, schemaVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 0 && len(v) != 8 {
+		return 0, fmt.Errorf("incorrect length of hermez schema version: %d", len(v))
+	}
+	return BytesToUint64(v), nil
+}
+
+func WriteSchemaVersion(tx kv.Putter, version uint64) error {
+	return tx.Put(kv.DatabaseInfo, schemaVersionKey, Uint64ToBytes(version))
+}
+
+// VerifySchemaVersion refuses a database whose hermez tables were written by a newer node
+func VerifySchemaVersion(tx kv.Getter) error {
+	version, err := ReadSchemaVersion(tx)
+	if err != nil {
+		return fmt.Errorf("reading hermez schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("cannot downgrade hermez schema version from %d to %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// Apply runs the migration if the hermez tables are at an older version, and moves them to its version. The tables
+// are created first as a migration may run before the node ever created them.
+func (m SchemaMigration) Apply(tx kv.RwTx) error {
+	if err := CreateHermezBuckets(tx); err != nil {
+		return err
+	}
+
+	version, err := ReadSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version >= m.Version {
+		return nil
+	}
+	if version != m.Version-1 {
+		return fmt.Errorf("hermez schema migration %s expects version %d, got %d", m.Name, m.Version-1, version)
+	}
+
+	if err := m.Up(tx); err != nil {
+		return fmt.Errorf("hermez schema migration %s: %w", m.Name, err)
+	}
+	return WriteSchemaVersion(tx, m.Version)
+}
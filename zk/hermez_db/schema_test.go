@@ -0,0 +1,80 @@
+package hermez_db
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+)
+
+func TestSchemaMigrations(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+
+	// a database written before the schema was versioned
+	version, err := ReadSchemaVersion(tx)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), version)
+	require.NoError(t, VerifySchemaVersion(tx))
+
+	for _, m := range SchemaMigrations {
+		require.NoError(t, m.Apply(tx))
+	}
+	version, err = ReadSchemaVersion(tx)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, version)
+
+	// applying them again changes nothing
+	for _, m := range SchemaMigrations {
+		require.NoError(t, m.Apply(tx))
+	}
+	version, err = ReadSchemaVersion(tx)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion, version)
+}
+
+func TestSchemaMigration_RewritesInPlace(t *testing.T) {
+	tx, cleanup := GetDbTx()
+	defer cleanup()
+
+	require.NoError(t, CreateHermezBuckets(tx))
+	require.NoError(t, WriteSchemaVersion(tx, SchemaVersion))
+	require.NoError(t, tx.Put(FORKIDS, Uint64ToBytes(1), Uint64ToBytes(5)))
+
+	// a migration to a layout with the fork ids as single bytes
+	next := SchemaMigration{
+		Name:    "test_fork_id_bytes",
+		Version: SchemaVersion + 1,
+		Up: func(tx kv.RwTx) error {
+			forkIds := map[uint64]uint64{}
+			if err := tx.ForEach(FORKIDS, nil, func(k, v []byte) error {
+				forkIds[BytesToUint64(k)] = BytesToUint64(v)
+				return nil
+			}); err != nil {
+				return err
+			}
+			for batchNo, forkId := range forkIds {
+				if err := tx.Put(FORKIDS, Uint64ToBytes(batchNo), Uint8ToBytes(uint8(forkId))); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	require.NoError(t, next.Apply(tx))
+
+	v, err := tx.GetOne(FORKIDS, Uint64ToBytes(1))
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, v)
+	version, err := ReadSchemaVersion(tx)
+	require.NoError(t, err)
+	require.Equal(t, SchemaVersion+1, version)
+
+	// this node doesn't know the layout of the newer version
+	require.EqualError(t, VerifySchemaVersion(tx), fmt.Sprintf("cannot downgrade hermez schema version from %d to %d", SchemaVersion+1, SchemaVersion))
+
+	// a migration is only applied on top of the version before it
+	skipping := SchemaMigration{Name: "test_skipping", Version: SchemaVersion + 3, Up: next.Up}
+	require.Error(t, skipping.Apply(tx))
+}
@@ -336,6 +336,23 @@ type Batch struct {
 	BatchL2Data         ArgBytes       `json:"batchL2Data"`
 }
 
+// L1BatchMetadata is what a batch was sequenced on L1 with, the global exit root being the l1 info root of its sequence
+// from the etrog fork on unless it was forced. AccInputHash is the accumulated input hash the rollup contract has for
+// the batch and LocalAccInputHash the one the node computes from its own batch onto the one of the batch before it,
+// they are null while unknown.
+type L1BatchMetadata struct {
+	BatchNumber       ArgUint64      `json:"batchNumber"`
+	L1BlockNumber     ArgUint64      `json:"l1BlockNumber"`
+	L1TxHash          common.Hash    `json:"l1TxHash"`
+	Sequencer         common.Address `json:"sequencer"`
+	GlobalExitRoot    common.Hash    `json:"globalExitRoot"`
+	Timestamp         ArgUint64      `json:"timestamp"`
+	TransactionsHash  common.Hash    `json:"transactionsHash"`
+	ForcedBlockHashL1 common.Hash    `json:"forcedBlockHashL1"`
+	AccInputHash      *common.Hash   `json:"accInputHash"`
+	LocalAccInputHash *common.Hash   `json:"localAccInputHash"`
+}
+
 // TransactionOrHash for union type of transaction and types.Hash
 type TransactionOrHash struct {
 	Hash *common.Hash
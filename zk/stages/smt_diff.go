@@ -0,0 +1,76 @@
+package stages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ledgerwatch/log/v3"
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/common/length"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon/common/dbutils"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/smt"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+// number of changed leaves logged when the root doesn't match
+const maxLoggedSmtDiffs = 100
+
+// logSmtDiff logs the leaves that differ between the expected root and the computed root, the keys are named from the
+// account and storage changes of the blocks after fromBlockNo up to toBlockNo
+func logSmtDiff(ctx context.Context, logPrefix string, tx kv.Tx, fromBlockNo, toBlockNo uint64, root, expectedRoot libcommon.Hash) error {
+	// the nodes of the expected root are read from the tree and its history, so only the roots this db had are found
+	dbSmt := smt.NewSMT(db2.NewEriRoDb(tx, root.Big()))
+	diffs, err := dbSmt.Diff(ctx, utils.ScalarToRoot(expectedRoot.Big()), utils.ScalarToRoot(root.Big()))
+	if err != nil {
+		return err
+	}
+
+	resolver, err := changedKeysResolver(tx, fromBlockNo, toBlockNo)
+	if err != nil {
+		return err
+	}
+
+	log.Warn(fmt.Sprintf("[%s] Leaves differing from the expected root", logPrefix), "expected root", expectedRoot, "root", root, "changed", len(diffs))
+	for i, d := range diffs {
+		if i == maxLoggedSmtDiffs {
+			log.Warn(fmt.Sprintf("[%s] ... %d more", logPrefix, len(diffs)-maxLoggedSmtDiffs))
+			break
+		}
+		field := "unknown"
+		if f, ok := resolver.Resolve(d.Key); ok {
+			field = f.String()
+		}
+		log.Warn(fmt.Sprintf("[%s] Changed leaf", logPrefix), "key", utils.ConvertBigIntToHex(d.Key.ToBigInt()), "field", field, "expected", d.Old, "got", d.New)
+	}
+
+	return nil
+}
+
+// changedKeysResolver names the keys of the accounts and storage slots changed in the blocks after fromBlockNo up to toBlockNo
+func changedKeysResolver(tx kv.Tx, fromBlockNo, toBlockNo uint64) (*smt.KeyResolver, error) {
+	resolver := smt.NewKeyResolver()
+
+	for blockNo := fromBlockNo + 1; blockNo <= toBlockNo; blockNo++ {
+		prefix := dbutils.EncodeBlockNumber(blockNo)
+
+		err := tx.ForPrefix(kv.AccountChangeSet, prefix, func(k, v []byte) error {
+			return resolver.AddAccount(libcommon.BytesToAddress(v[:length.Addr]))
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.ForPrefix(kv.StorageChangeSet, prefix, func(k, v []byte) error {
+			address, _ := dbutils.PlainParseStoragePrefix(k[length.BlockNum:])
+			storageKey := fmt.Sprintf("0x%032x", libcommon.BytesToHash(v[:length.Hash]))
+			return resolver.AddStorage(address, storageKey)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resolver, nil
+}
@@ -0,0 +1,340 @@
+package stages
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/log/v3"
+	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/common/length"
+	"github.com/tenderly/zkevm-erigon-lib/etl"
+	"github.com/tenderly/zkevm-erigon-lib/kv"
+	"github.com/tenderly/zkevm-erigon/core/rawdb"
+	state2 "github.com/tenderly/zkevm-erigon/core/state"
+	"github.com/tenderly/zkevm-erigon/eth/stagedsync/stages"
+	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/smt"
+	"github.com/tenderly/zkevm-erigon/smt/pkg/utils"
+)
+
+// SmtMismatch is a leaf of the tree that doesn't hold the value derived from the plain state
+type SmtMismatch struct {
+	Key      utils.NodeKey
+	Address  libcommon.Address
+	Field    string
+	Expected *big.Int
+	Actual   *big.Int
+}
+
+func (m SmtMismatch) String() string {
+	return fmt.Sprintf("%s %s: expected %s, got %s", m.Address.Hex(), m.Field, m.Expected.String(), m.Actual.String())
+}
+
+type SmtIntegrityReport struct {
+	BlockNo      uint64
+	Root         libcommon.Hash
+	ExpectedRoot libcommon.Hash
+	BrokenNodes  []smt.BrokenNode
+	Mismatches   []SmtMismatch
+	// root after the repair, empty if no repair was done
+	RepairedRoot libcommon.Hash
+}
+
+func (r *SmtIntegrityReport) Ok() bool {
+	return r.Root == r.ExpectedRoot && len(r.BrokenNodes) == 0 && len(r.Mismatches) == 0
+}
+
+type expectedSmtLeaf struct {
+	// SortedLeafKey of key, the leaves are read back from the collectors in this order
+	sortKey []byte
+	key     utils.NodeKey
+	value   utils.NodeValue8
+	address libcommon.Address
+	field   string
+}
+
+func (e *expectedSmtLeaf) mismatch(actual *big.Int) SmtMismatch {
+	return SmtMismatch{Key: e.key, Address: e.address, Field: e.field, Expected: utils.ArrayBigToScalar(e.value[:]), Actual: actual}
+}
+
+// CheckSmtIntegrity walks the smt and checks the hash of every node, cross checks the leaves against the plain state
+// using the same keys as the regeneration and the root against the header of the last hashed block.
+// With repair set, the broken subtrees are rebuilt from the plain state and the mismatched leaves are rewritten,
+// the caller has to commit tx to keep the repair.
+//
+// The leaves derived from the plain state are sorted by their path in an etl collector and compared in order with the
+// leaves of the tree as the walk reaches them, so neither side is held in memory.
+func CheckSmtIntegrity(ctx context.Context, logPrefix string, tx kv.RwTx, tmpDir string, repair bool) (*SmtIntegrityReport, error) {
+	hashedBlockNo, err := stages.GetStageProgress(tx, stages.IntermediateHashes)
+	if err != nil {
+		return nil, err
+	}
+	executedBlockNo, err := stages.GetStageProgress(tx, stages.Execution)
+	if err != nil {
+		return nil, err
+	}
+	if hashedBlockNo != executedBlockNo {
+		return nil, fmt.Errorf("plain state is at block %d and the smt at block %d, sync both stages to the same block first", executedBlockNo, hashedBlockNo)
+	}
+
+	header := rawdb.ReadHeaderByNumber(tx, hashedBlockNo)
+	if header == nil {
+		return nil, fmt.Errorf("header %d not found", hashedBlockNo)
+	}
+
+	eridb := db2.NewEriDb(tx)
+	dbSmt := smt.NewSMT(eridb)
+
+	report := &SmtIntegrityReport{
+		BlockNo:      hashedBlockNo,
+		Root:         libcommon.BigToHash(dbSmt.LastRoot()),
+		ExpectedRoot: header.Root,
+	}
+
+	log.Info(fmt.Sprintf("[%s] Collecting the expected leaves from the plain state", logPrefix))
+	expectedCollector := etl.NewCollector(logPrefix, tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer expectedCollector.Close()
+	if err := collectExpectedSmtLeaves(tx, expectedCollector); err != nil {
+		return nil, err
+	}
+
+	// the broken nodes are only known once the walk is done, the expected leaves it didn't reach are kept until then
+	missingCollector := etl.NewCollector(logPrefix, tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer missingCollector.Close()
+
+	expected := newExpectedLeafStream(expectedCollector, ctx.Done())
+	defer expected.close()
+
+	// skipTo sets aside the expected leaves ordered before sortKey, and returns the one at sortKey if there is one
+	skipTo := func(sortKey []byte) (*expectedSmtLeaf, error) {
+		for {
+			e, err := expected.peek()
+			if err != nil || e == nil {
+				return nil, err
+			}
+			c := bytes.Compare(e.sortKey, sortKey)
+			if c > 0 {
+				return nil, nil
+			}
+			expected.pop()
+			if c == 0 {
+				return e, nil
+			}
+			if err := collectExpectedSmtLeaf(missingCollector, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	log.Info(fmt.Sprintf("[%s] Checking the tree", logPrefix), "root", report.Root)
+	leaves := 0
+	root := utils.ScalarToRoot(report.Root.Big())
+	report.BrokenNodes, err = dbSmt.CheckNodes(ctx, root, func(k utils.NodeKey, v utils.NodeValue8) error {
+		leaves++
+		e, err := skipTo(smt.SortedLeafKey(k))
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			report.Mismatches = append(report.Mismatches, SmtMismatch{Key: k, Field: "unknown key", Expected: big.NewInt(0), Actual: utils.ArrayBigToScalar(v[:])})
+			return nil
+		}
+		if e.value.ToUintArray() != v.ToUintArray() {
+			report.Mismatches = append(report.Mismatches, e.mismatch(utils.ArrayBigToScalar(v[:])))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the expected leaves after the last leaf of the tree
+	for {
+		e, err := expected.peek()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		expected.pop()
+		if err := collectExpectedSmtLeaf(missingCollector, e); err != nil {
+			return nil, err
+		}
+	}
+	log.Info(fmt.Sprintf("[%s] Checked the tree", logPrefix), "leaves", leaves)
+
+	// the leaves under a broken node weren't reached by the walk, they are only needed to rebuild its subtree
+	rebuild := make([][]smt.BatchChange, len(report.BrokenNodes))
+	err = loadExpectedSmtLeaves(missingCollector, ctx.Done(), func(e *expectedSmtLeaf) error {
+		if i := brokenNodeIndex(e.key, report.BrokenNodes); i >= 0 {
+			if repair {
+				rebuild[i] = append(rebuild[i], smt.BatchChange{Key: e.key, Value: e.value})
+			}
+			return nil
+		}
+		report.Mismatches = append(report.Mismatches, e.mismatch(big.NewInt(0)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !repair || report.Ok() {
+		return report, nil
+	}
+
+	for i, b := range report.BrokenNodes {
+		log.Info(fmt.Sprintf("[%s] Rebuilding subtree", logPrefix), "node", b.String(), "leaves", len(rebuild[i]))
+		if _, err := dbSmt.RebuildSubtree(ctx, b.Path, rebuild[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make([]smt.BatchChange, 0, len(report.Mismatches))
+	for _, m := range report.Mismatches {
+		value, err := utils.NodeValue8FromBigIntArray(utils.ScalarToArrayBig(m.Expected))
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, smt.BatchChange{Key: m.Key, Value: *value})
+	}
+	if _, err := dbSmt.InsertBatch(ctx, changes); err != nil {
+		return nil, err
+	}
+
+	report.RepairedRoot = libcommon.BigToHash(dbSmt.LastRoot())
+	if report.RepairedRoot != report.ExpectedRoot {
+		log.Warn(fmt.Sprintf("[%s] Root after repair doesn't match the header", logPrefix), "root", report.RepairedRoot, "expected", report.ExpectedRoot)
+	}
+
+	return report, nil
+}
+
+// brokenNodeIndex returns the index of the broken node k is under, -1 if there is none
+func brokenNodeIndex(k utils.NodeKey, broken []smt.BrokenNode) int {
+	path := k.GetPath()
+	for i, b := range broken {
+		under := true
+		for l, bit := range b.Path {
+			if path[l] != bit {
+				under = false
+				break
+			}
+		}
+		if under {
+			return i
+		}
+	}
+	return -1
+}
+
+// collectExpectedSmtLeaves derives every leaf of the tree from the plain state, the same way
+// regenerateIntermediateHashes does, and adds them to the collector
+func collectExpectedSmtLeaves(tx kv.Tx, collector *etl.Collector) error {
+	return walkPlainState(state2.NewPlainStateReader(tx), nil, func(addr libcommon.Address, k utils.NodeKey, v utils.NodeValue8, field string) error {
+		return collectExpectedSmtLeaf(collector, &expectedSmtLeaf{key: k, value: v, address: addr, field: field})
+	})
+}
+
+// collectExpectedSmtLeaf adds the leaf under its sorted key, the value is the key, the value, the address and the field
+func collectExpectedSmtLeaf(collector *etl.Collector, e *expectedSmtLeaf) error {
+	v := make([]byte, 0, 32+64+length.Addr+len(e.field))
+	for _, limb := range e.key {
+		v = binary.BigEndian.AppendUint64(v, limb)
+	}
+	for _, limb := range e.value.ToUintArray() {
+		v = binary.BigEndian.AppendUint64(v, limb)
+	}
+	v = append(v, e.address[:]...)
+	v = append(v, e.field...)
+
+	return collector.Collect(smt.SortedLeafKey(e.key), v)
+}
+
+func decodeExpectedSmtLeaf(k, v []byte) (*expectedSmtLeaf, error) {
+	if len(v) < 32+64+length.Addr {
+		return nil, fmt.Errorf("expected leaf is %d bytes, expected at least %d", len(v), 32+64+length.Addr)
+	}
+
+	e := &expectedSmtLeaf{sortKey: libcommon.Copy(k)}
+	for i := range e.key {
+		e.key[i] = binary.BigEndian.Uint64(v[i*8:])
+	}
+	for i := range e.value {
+		e.value[i] = new(big.Int).SetUint64(binary.BigEndian.Uint64(v[32+i*8:]))
+	}
+	copy(e.address[:], v[96:96+length.Addr])
+	e.field = string(v[96+length.Addr:])
+
+	return e, nil
+}
+
+func loadExpectedSmtLeaves(collector *etl.Collector, quit <-chan struct{}, f func(e *expectedSmtLeaf) error) error {
+	return collector.Load(nil, "", func(k, v []byte, _ etl.CurrentTableReader, _ etl.LoadNextFunc) error {
+		e, err := decodeExpectedSmtLeaf(k, v)
+		if err != nil {
+			return err
+		}
+		return f(e)
+	}, etl.TransformArgs{Quit: quit})
+}
+
+var errExpectedLeafStreamClosed = errors.New("expected leaf stream closed")
+
+// expectedLeafStream reads the leaves back from the collector one at a time, in the order of their paths which is the
+// order CheckNodes reaches the leaves of the tree in. The collector is loaded on its own goroutine, it doesn't use the tx.
+type expectedLeafStream struct {
+	leaves chan *expectedSmtLeaf
+	done   chan struct{}
+	// set before leaves is closed
+	err  error
+	head *expectedSmtLeaf
+}
+
+func newExpectedLeafStream(collector *etl.Collector, quit <-chan struct{}) *expectedLeafStream {
+	s := &expectedLeafStream{
+		leaves: make(chan *expectedSmtLeaf, 1024),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.leaves)
+		s.err = loadExpectedSmtLeaves(collector, quit, func(e *expectedSmtLeaf) error {
+			select {
+			case s.leaves <- e:
+				return nil
+			case <-s.done:
+				return errExpectedLeafStreamClosed
+			}
+		})
+	}()
+
+	return s
+}
+
+// peek returns the next leaf without taking it, nil once all the leaves were read
+func (s *expectedLeafStream) peek() (*expectedSmtLeaf, error) {
+	if s.head == nil {
+		e, ok := <-s.leaves
+		if !ok {
+			return nil, s.err
+		}
+		s.head = e
+	}
+	return s.head, nil
+}
+
+func (s *expectedLeafStream) pop() {
+	s.head = nil
+}
+
+// close stops the load if it is still running and waits for it to return
+func (s *expectedLeafStream) close() {
+	close(s.done)
+	for range s.leaves {
+	}
+}
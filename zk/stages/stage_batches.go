@@ -20,6 +20,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/zk/erigon_db"
 	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
+	zktypes "github.com/tenderly/zkevm-erigon/zk/types"
 
 	"github.com/ledgerwatch/log/v3"
 	"github.com/tenderly/zkevm-erigon/core/rawdb"
@@ -42,15 +43,19 @@ type HermezDb interface {
 	WriteBlockGlobalExitRoot(l2BlockNo uint64, ger common.Hash) error
 
 	WriteBatchGBatchGlobalExitRoot(batchNumber uint64, ger types.GerUpdate) error
+
+	GetBatchBlockRange(batchNo uint64) (first, last uint64, found bool, err error)
+	GetBatchHeader(batchNo uint64) (*zktypes.BatchHeader, error)
+	WriteBatchHeader(header *zktypes.BatchHeader) error
 }
 
 type BatchesCfg struct {
 	db                  kv.RwDB
 	blockRoutineStarted bool
-	dsClient            *dsclient.StreamClient
+	dsClient            dsclient.Source
 }
 
-func StageBatchesCfg(db kv.RwDB, dsClient *dsclient.StreamClient) BatchesCfg {
+func StageBatchesCfg(db kv.RwDB, dsClient dsclient.Source) BatchesCfg {
 	return BatchesCfg{
 		db:                  db,
 		blockRoutineStarted: false,
@@ -60,6 +65,9 @@ func StageBatchesCfg(db kv.RwDB, dsClient *dsclient.StreamClient) BatchesCfg {
 
 var emptyHash = common.Hash{0}
 
+// streamPollInterval is how long the stage waits for the next block from the datastream before checking if it is at the tip
+const streamPollInterval = 50 * time.Millisecond
+
 func SpawnStageBatches(
 	s *stagedsync.StageState,
 	u stagedsync.Unwinder,
@@ -139,6 +147,11 @@ func SpawnStageBatches(
 
 	highestSeenBatchNo := uint64(0)
 	highestHashableL2BlockNo := uint64(0)
+	// the highest batch the stream sent the end of
+	highestClosedBatchNo := uint64(0)
+	batchClosed := false
+	// set if the stream was unwound back before blocks written
+	var streamUnwind *types.Unwind
 
 	writeThreadFinished := false
 	lastGer := common.Hash{}
@@ -153,12 +166,57 @@ func SpawnStageBatches(
 
 	log.Info(fmt.Sprintf("[%s] Reading blocks from the datastream.", logPrefix))
 	for {
-		// get block
-		// if no blocks available should block
-		// if download routine finished, should continue to read from channel until it's empty
-		// if both download routine stopped and channel empty - stop loop
-		select {
-		case l2Block := <-cfg.dsClient.L2BlockChan:
+		// get the next block, end of batch or unwind
+		// if none is queued in the poll interval, check if the download routine finished or we are at the tip
+		// the download routine only reads the stream ahead as far as the source's queue allows
+		pollCtx, cancelPoll := context.WithTimeout(ctx, streamPollInterval)
+		item, err := cfg.dsClient.Next(pollCtx)
+		cancelPoll()
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			select {
+			case err := <-errChan:
+				if err != nil {
+					return fmt.Errorf("l2blocks download routine error: %v", err)
+				}
+				writeThreadFinished = true
+			default:
+			}
+
+			//wait at least one block to be written, before continuing
+			if atLeastOneBlockWritten {
+				// if no blocks available should and time since last block written is > 500ms
+				// consider that we are at the tip and blocks come in the datastream as they are produced
+				// stop the current iteration of the stage
+				lastWrittenTs := cfg.dsClient.GetLastWrittenTimeAtomic().Load()
+				timePassedAfterlastBlock := time.Since(time.Unix(0, lastWrittenTs))
+				if cfg.dsClient.GetStreamingAtomic().Load() && timePassedAfterlastBlock.Milliseconds() > 500 {
+					log.Info(fmt.Sprintf("[%s] No new blocks in %d miliseconds. Ending the stage.", logPrefix, timePassedAfterlastBlock.Milliseconds()), "lastBlockHeight", lastBlockHeight)
+					writeThreadFinished = true
+				}
+
+				if writeThreadFinished {
+					endLoop = true
+				}
+			} else {
+				timePassedAfterlastBlock := time.Since(startTime)
+				if timePassedAfterlastBlock.Seconds() > 10 {
+					log.Info(fmt.Sprintf("[%s] Waiting for at least one new block.", logPrefix))
+					startTime = time.Now()
+				}
+			}
+		case item.Block != nil:
+			// the ger updates before the block
+			for _, gerUpdate := range item.GerUpdates {
+				if err := hermezDb.WriteBatchGBatchGlobalExitRoot(gerUpdate.BatchNumber, gerUpdate); err != nil {
+					return fmt.Errorf("write batch global exit root error: %v", err)
+				}
+			}
+
+			l2Block := item.Block
 			atLeastOneBlockWritten = true
 			zeroHash := common.Hash{}
 			// skip if we already have this block
@@ -219,7 +277,7 @@ func SpawnStageBatches(
 				l2Block.ParentHash = genesisHash
 			}
 
-			if err := writeL2Block(eriDb, hermezDb, &l2Block); err != nil {
+			if err := writeL2Block(eriDb, hermezDb, l2Block); err != nil {
 				return fmt.Errorf("writeL2Block error: %v", err)
 			}
 
@@ -228,38 +286,23 @@ func SpawnStageBatches(
 			lastBlockHeight = l2Block.L2BlockNumber
 			blocksWritten++
 			progressChan <- blocksWritten
-		case gerUpdate := <-cfg.dsClient.GerUpdatesChan:
-			if err := hermezDb.WriteBatchGBatchGlobalExitRoot(gerUpdate.BatchNumber, gerUpdate); err != nil {
-				return fmt.Errorf("write batch global exit root error: %v", err)
+		case item.BatchEnd != nil:
+			if item.BatchEnd.BatchNumber > highestClosedBatchNo || !batchClosed {
+				highestClosedBatchNo = item.BatchEnd.BatchNumber
+				batchClosed = true
 			}
-		case err := <-errChan:
-			if err != nil {
-				return fmt.Errorf("l2blocks download routine error: %v", err)
+			if err := closeBatchHeader(hermezDb, item.BatchEnd); err != nil {
+				return fmt.Errorf("close batch header error: %v", err)
 			}
-			writeThreadFinished = true
-		default:
-			//wait at least one block to be written, before continuing
-			if atLeastOneBlockWritten {
-				// if no blocks available should and time since last block written is > 500ms
-				// consider that we are at the tip and blocks come in the datastream as they are produced
-				// stop the current iteration of the stage
-				lastWrittenTs := cfg.dsClient.LastWrittenTime.Load()
-				timePassedAfterlastBlock := time.Since(time.Unix(0, lastWrittenTs))
-				if cfg.dsClient.Streaming.Load() && timePassedAfterlastBlock.Milliseconds() > 500 {
-					log.Info(fmt.Sprintf("[%s] No new blocks in %d miliseconds. Ending the stage.", logPrefix, timePassedAfterlastBlock.Milliseconds()), "lastBlockHeight", lastBlockHeight)
-					writeThreadFinished = true
-				}
-
-				if writeThreadFinished {
-					endLoop = true
-				}
-			} else {
-				timePassedAfterlastBlock := time.Since(startTime)
-				if timePassedAfterlastBlock.Seconds() > 10 {
-					log.Info(fmt.Sprintf("[%s] Waiting for at least one new block.", logPrefix))
-					startTime = time.Now()
-				}
+		case item.Unwind != nil:
+			unwind := item.Unwind
+			if unwind.L2BlockNumber >= lastBlockHeight {
+				continue
 			}
+			// the blocks after it are no longer in the stream, they are unwound and downloaded again
+			log.Warn(fmt.Sprintf("[%s] The datastream was unwound, unwinding", logPrefix), "block", unwind.L2BlockNumber, "batch", unwind.BatchNumber, "lastBlockHeight", lastBlockHeight)
+			streamUnwind = unwind
+			endLoop = true
 		}
 
 		if endLoop {
@@ -268,10 +311,19 @@ func SpawnStageBatches(
 		}
 	}
 
+	if streamUnwind != nil {
+		return unwindToStream(tx, u, streamUnwind, lastBlockHeight, firstCycle, logPrefix)
+	}
+
 	if lastBlockHeight == batchesProgress {
 		return nil
 	}
 
+	// the last batch is known to be full without waiting for a block of the next one
+	if batchClosed && highestSeenBatchNo <= highestClosedBatchNo {
+		highestHashableL2BlockNo = lastBlockHeight
+	}
+
 	// store the highest hashable block number
 	if err := stages.SaveStageProgress(tx, stages.HighestHashableL2BlockNo, highestHashableL2BlockNo); err != nil {
 		return fmt.Errorf("save stage progress error: %v", err)
@@ -305,6 +357,33 @@ func SpawnStageBatches(
 	return nil
 }
 
+// unwindToStream requests an unwind to the last block left in the stream, the blocks written up to lastBlockHeight
+// are unwound along with the ones written before
+func unwindToStream(tx kv.RwTx, u stagedsync.Unwinder, unwind *types.Unwind, lastBlockHeight uint64, firstCycle bool, logPrefix string) error {
+	if err := stages.SaveStageProgress(tx, stages.Batches, lastBlockHeight); err != nil {
+		return fmt.Errorf("save stage progress error: %v", err)
+	}
+
+	// the batch of the last block left is closed, it is the end of a batch
+	if err := stages.SaveStageProgress(tx, stages.HighestSeenBatchNumber, unwind.BatchNumber); err != nil {
+		return fmt.Errorf("save stage progress error: %v", err)
+	}
+	if err := stages.SaveStageProgress(tx, stages.HighestHashableL2BlockNo, unwind.L2BlockNumber); err != nil {
+		return fmt.Errorf("save stage progress error: %v", err)
+	}
+
+	log.Info(fmt.Sprintf("[%s] Unwinding to the datastream", logPrefix), "block", unwind.L2BlockNumber, "lastBlockHeight", lastBlockHeight)
+	u.UnwindTo(unwind.L2BlockNumber, common.Hash{})
+
+	if firstCycle {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit tx, %w", err)
+		}
+	}
+
+	return nil
+}
+
 func UnwindBatchesStage(u *stagedsync.UnwindState, tx kv.RwTx, cfg BatchesCfg, ctx context.Context) (err error) {
 	logPrefix := u.LogPrefix()
 
@@ -333,6 +412,9 @@ func UnwindBatchesStage(u *stagedsync.UnwindState, tx kv.RwTx, cfg BatchesCfg, c
 	hermezDb.DeleteForkIds(fromBlock, toBlock)
 	hermezDb.DeleteBlockBatches(fromBlock, toBlock)
 	hermezDb.DeleteBlockGlobalExitRoots(fromBlock, toBlock)
+	if err := hermezDb.TruncateBatchIndex(fromBlock); err != nil {
+		return fmt.Errorf("truncate batch index error: %v", err)
+	}
 
 	log.Info(fmt.Sprintf("[%s] Deleted headers, bodies, forkIds and blockBatches.", logPrefix))
 	log.Info(fmt.Sprintf("[%s] Saving stage progress", logPrefix), "fromBlock", fromBlock)
@@ -383,8 +465,11 @@ func PruneBatchesStage(s *stagedsync.PruneState, tx kv.RwTx, cfg BatchesCfg, ctx
 	hermezDb.DeleteForkIds(0, toBlock)
 	hermezDb.DeleteBlockBatches(0, toBlock)
 	hermezDb.DeleteBlockGlobalExitRoots(0, toBlock)
+	if err := hermezDb.ClearBatchIndex(); err != nil {
+		return fmt.Errorf("clear batch index error: %v", err)
+	}
 
-	log.Info(fmt.Sprintf("[%s] Deleted headers, bodies, forkIds and blockBatches.", logPrefix))
+	log.Info(fmt.Sprintf("[%s] Deleted headers, bodies, forkIds, blockBatches and the batch index.", logPrefix))
 	log.Info(fmt.Sprintf("[%s] Saving stage progress", logPrefix), "stageProgress", 0)
 	if err := stages.SaveStageProgress(tx, stages.Batches, 0); err != nil {
 		return fmt.Errorf("save stage progress error: %v", err)
@@ -441,5 +526,71 @@ func writeL2Block(eriDb ErigonDb, hermezDb HermezDb, l2Block *types.FullL2Block)
 		return fmt.Errorf("write block batch error: %v", err)
 	}
 
+	if err := extendBatchHeader(hermezDb, l2Block, txs); err != nil {
+		return fmt.Errorf("write batch header error: %v", err)
+	}
+
 	return nil
 }
+
+// extendBatchHeader adds a block to the header of its batch, the header takes the state root of the block until the
+// end of the batch is read
+func extendBatchHeader(hermezDb HermezDb, l2Block *types.FullL2Block, txs []ethTypes.Transaction) error {
+	header, err := hermezDb.GetBatchHeader(l2Block.BatchNumber)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		// a batch whose earlier blocks have no header, written before the batch index or cut short by an unwind,
+		// is left without one
+		first, _, _, err := hermezDb.GetBatchBlockRange(l2Block.BatchNumber)
+		if err != nil {
+			return err
+		}
+		if first < l2Block.L2BlockNumber {
+			return nil
+		}
+		header = &zktypes.BatchHeader{
+			BatchNumber: l2Block.BatchNumber,
+			Timestamp:   uint64(l2Block.Timestamp),
+		}
+	}
+
+	header.Coinbase = l2Block.Coinbase
+	header.StateRoot = l2Block.StateRoot
+	if l2Block.GlobalExitRoot != (common.Hash{}) {
+		header.GlobalExitRoot = l2Block.GlobalExitRoot
+	}
+	// the changeL2Block entries between the blocks of an etrog batch aren't encoded, the l2 data is left out rather
+	// than written without them
+	if l2Block.ForkId >= txtype.ForkIDEtrog {
+		return hermezDb.WriteBatchHeader(header)
+	}
+	for i, ltx := range txs {
+		encoded, err := txtype.EncodeTx(ltx, l2Block.L2Txs[i].EffectiveGasPricePercentage, l2Block.ForkId)
+		if err != nil {
+			return fmt.Errorf("encode tx %s: %w", ltx.Hash(), err)
+		}
+		header.BatchL2Data = append(header.BatchL2Data, encoded...)
+	}
+
+	return hermezDb.WriteBatchHeader(header)
+}
+
+// closeBatchHeader sets the roots of a batch from the end of it in the datastream
+func closeBatchHeader(hermezDb HermezDb, batchEnd *types.BatchEnd) error {
+	header, err := hermezDb.GetBatchHeader(batchEnd.BatchNumber)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		// the blocks of the batch were written before the batch index
+		return nil
+	}
+
+	header.StateRoot = batchEnd.StateRoot
+	header.LocalExitRoot = batchEnd.LocalExitRoot
+	header.AccInputHash = batchEnd.AccInputHash
+	header.Closed = true
+	return hermezDb.WriteBatchHeader(header)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/tenderly/zkevm-erigon/eth/stagedsync"
+	"github.com/tenderly/zkevm-erigon/eth/stagedsync/stages"
 	"math"
 	"time"
 
@@ -33,7 +34,7 @@ func StageDataStreamCatchupCfg(stream *datastreamer.StreamServer, db kv.RwDB) Da
 func SpawnStageDataStreamCatchup(
 	s *stagedsync.StageState,
 	ctx context.Context,
-	tx kv.Tx,
+	tx kv.RwTx,
 	cfg DataStreamCatchupCfg,
 ) error {
 
@@ -61,6 +62,16 @@ func SpawnStageDataStreamCatchup(
 	srv := server.NewDataStreamServer(stream)
 	reader := hermez_db.NewHermezDbReader(tx)
 
+	// the stream is unwound ahead of the commit of the unwind, if that was interrupted the unwind entry is dropped
+	// here and the batches truncated are written again from the db below
+	finished, err := srv.FinishUnwind()
+	if err != nil {
+		return err
+	}
+	if finished {
+		log.Info(fmt.Sprintf("[%s] Finished an interrupted unwind of the data stream", logPrefix), "entries", stream.GetHeader().TotalEntries)
+	}
+
 	/* find out where we are at in the stream, compare with the DB/stage progress and catchup the entries */
 	header := stream.GetHeader()
 
@@ -88,6 +99,16 @@ func SpawnStageDataStreamCatchup(
 			return err
 		}
 
+		err = srv.AddBookmark(server.BatchBookmarkType, batch)
+		if err != nil {
+			return err
+		}
+
+		err = srv.AddBatchStart(batch, uint16(fork))
+		if err != nil {
+			return err
+		}
+
 		err = srv.AddBookmark(server.BlockBookmarkType, genesis.NumberU64())
 		if err != nil {
 			return err
@@ -98,7 +119,12 @@ func SpawnStageDataStreamCatchup(
 			return err
 		}
 
-		err = srv.AddBlockEnd(genesis.NumberU64(), genesis.Hash(), genesis.Root())
+		err = srv.AddBlockEnd(genesis)
+		if err != nil {
+			return err
+		}
+
+		_, err = srv.AddBatchEnd(batch, genesis.Root(), common.Hash{}, common.Hash{})
 		if err != nil {
 			return err
 		}
@@ -129,11 +155,22 @@ func SpawnStageDataStreamCatchup(
 		return err
 	}
 
+	// the state root at the end of the stream, it is also the root of the empty batches that follow
+	var stateRoot common.Hash
+
 	switch latest.Type {
+	case server.EntryTypeBatchEnd:
+		batchEnd, err := types.DecodeBatchEnd(latest.Data)
+		if err != nil {
+			return err
+		}
+		currentBatchNumber = batchEnd.BatchNumber
+		stateRoot = batchEnd.StateRoot
 	case server.EntryTypeUpdateGer:
 		currentBatchNumber = binary.LittleEndian.Uint64(latest.Data[0:8])
 	case server.EntryTypeL2BlockEnd:
 		currentL2Block = binary.LittleEndian.Uint64(latest.Data[0:8])
+		stateRoot = common.BytesToHash(latest.Data[40:72])
 		bookmark := types.Bookmark{
 			Type: types.BookmarkTypeStart,
 			From: currentL2Block,
@@ -175,6 +212,10 @@ func SpawnStageDataStreamCatchup(
 
 	// Start on the current batch number + 1
 	currentBatchNumber++
+	if currentBatchNumber > highestSeenBatchNumber {
+		log.Info(fmt.Sprintf("[%s]: nothing to catch up", logPrefix), "batch", currentBatchNumber-1)
+		return nil
+	}
 	target := highestSeenBatchNumber - currentBatchNumber
 
 	var currentGER = common.Hash{}
@@ -208,6 +249,22 @@ LOOP:
 		// get the blocks for this batch
 		blockNumbers, ok := batchToBlocks[currentBatchNumber]
 
+		batchFork, err := reader.GetForkId(currentBatchNumber)
+		if err != nil {
+			return err
+		}
+
+		// every batch starts with its bookmark so clients can start from it
+		err = srv.AddBookmark(server.BatchBookmarkType, currentBatchNumber)
+		if err != nil {
+			return err
+		}
+
+		err = srv.AddBatchStart(currentBatchNumber, uint16(batchFork))
+		if err != nil {
+			return err
+		}
+
 		// no block numbers means an empty batch so just skip it
 		if !ok || len(blockNumbers) == 0 {
 			// check for a ger update on the batch as it could have one
@@ -223,9 +280,21 @@ LOOP:
 				}
 			}
 
+			// the local exit root and acc input hash of a batch aren't stored by the node
+			entry, err = srv.AddBatchEnd(currentBatchNumber, stateRoot, common.Hash{}, common.Hash{})
+			if err != nil {
+				return err
+			}
+
 			skipped = true
 			currentBatchNumber++
 			log.Debug(fmt.Sprintf("[%s]: found batch with no blocks - skipping", logPrefix), "number", currentBatchNumber)
+			if currentBatchNumber > highestSeenBatchNumber {
+				if err := commitBatch(stream); err != nil {
+					return err
+				}
+				break LOOP
+			}
 			continue
 		}
 
@@ -274,10 +343,17 @@ LOOP:
 				}
 			}
 
-			err = srv.AddBlockEnd(block.NumberU64(), block.Hash(), block.Root())
+			err = srv.AddBlockEnd(block)
 			if err != nil {
 				return err
 			}
+			stateRoot = block.Root()
+		}
+
+		// the local exit root and acc input hash of a batch aren't stored by the node
+		entry, err = srv.AddBatchEnd(currentBatchNumber, stateRoot, common.Hash{}, common.Hash{})
+		if err != nil {
+			return err
 		}
 
 		currentBatchNumber++
@@ -297,6 +373,13 @@ LOOP:
 		}
 	}
 
+	// the stage is only unwound while its progress is past the unwind point
+	if currentBlock > 0 {
+		if err := s.Update(tx, currentBlock); err != nil {
+			return err
+		}
+	}
+
 	if createdTx {
 		err = tx.Commit()
 		if err != nil {
@@ -312,6 +395,45 @@ LOOP:
 	return err
 }
 
+// UnwindDataStreamCatchupStage truncates the stream back to the end of the batch before the first block unwound, the
+// connected clients are told to re-request it and the batches from it on are written again by the next run.
+// The stream can't be truncated within the db tx, so it is truncated before the tx commits. If the tx doesn't commit
+// the stream only ends early, and the next run writes the truncated batches again from the db since it always carries
+// on from the end of the stream.
+func UnwindDataStreamCatchupStage(u *stagedsync.UnwindState, tx kv.RwTx, cfg DataStreamCatchupCfg, ctx context.Context) (err error) {
+	logPrefix := u.LogPrefix()
+
+	useExternalTx := tx != nil
+	if !useExternalTx {
+		tx, err = cfg.db.BeginRw(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	if cfg.stream != nil {
+		srv := server.NewDataStreamServer(cfg.stream)
+		unwind, err := srv.UnwindToBlock(u.UnwindPoint)
+		if err != nil {
+			return fmt.Errorf("failed to unwind the data stream to block %d, %w", u.UnwindPoint, err)
+		}
+		if unwind != nil {
+			log.Info(fmt.Sprintf("[%s] Unwound the data stream", logPrefix), "unwindPoint", u.UnwindPoint, "lastBlock", unwind.L2BlockNumber, "lastBatch", unwind.BatchNumber, "entries", cfg.stream.GetHeader().TotalEntries)
+		}
+	}
+
+	if err := u.Done(tx); err != nil {
+		return err
+	}
+	if !useExternalTx {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func commitBatch(stream *datastreamer.StreamServer) error {
 	err := stream.CommitAtomicOp()
 	if err != nil {
@@ -8,6 +8,7 @@ import (
 	"github.com/ledgerwatch/log/v3"
 	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
 	"github.com/tenderly/zkevm-erigon-lib/common/length"
+	"github.com/tenderly/zkevm-erigon-lib/etl"
 	"github.com/tenderly/zkevm-erigon-lib/kv"
 	"github.com/tenderly/zkevm-erigon-lib/state"
 	state2 "github.com/tenderly/zkevm-erigon/core/state"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/status-im/keycard-go/hexutils"
 	"github.com/tenderly/zkevm-erigon/common/dbutils"
+	"github.com/tenderly/zkevm-erigon/core/rawdb"
 	"github.com/tenderly/zkevm-erigon/core/systemcontracts"
 	"github.com/tenderly/zkevm-erigon/core/types/accounts"
 	"github.com/tenderly/zkevm-erigon/eth/ethconfig"
@@ -36,6 +38,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/turbo/stages/headerdownload"
 	"github.com/tenderly/zkevm-erigon/turbo/trie"
 	"github.com/tenderly/zkevm-erigon/zk"
+	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
 )
 
 type ZkInterHashesCfg struct {
@@ -50,9 +53,10 @@ type ZkInterHashesCfg struct {
 	historyV3 bool
 	agg       *state.AggregatorV3
 	zk        *ethconfig.Zk
+	nodeCache *db2.NodeCache
 }
 
-func StageZkInterHashesCfg(db kv.RwDB, checkRoot, saveNewHashesToDB, badBlockHalt bool, tmpDir string, blockReader services.FullBlockReader, hd *headerdownload.HeaderDownload, historyV3 bool, agg *state.AggregatorV3, zk *ethconfig.Zk) ZkInterHashesCfg {
+func StageZkInterHashesCfg(db kv.RwDB, checkRoot, saveNewHashesToDB, badBlockHalt bool, tmpDir string, blockReader services.FullBlockReader, hd *headerdownload.HeaderDownload, historyV3 bool, agg *state.AggregatorV3, zk *ethconfig.Zk, nodeCache *db2.NodeCache) ZkInterHashesCfg {
 	return ZkInterHashesCfg{
 		db:                db,
 		checkRoot:         checkRoot,
@@ -65,18 +69,24 @@ func StageZkInterHashesCfg(db kv.RwDB, checkRoot, saveNewHashesToDB, badBlockHal
 		historyV3: historyV3,
 		agg:       agg,
 		zk:        zk,
+		nodeCache: nodeCache,
 	}
 }
 
-func SpawnZkIntermediateHashesStage(s *stagedsync.StageState, u stagedsync.Unwinder, tx kv.RwTx, cfg ZkInterHashesCfg, ctx context.Context, quiet bool) (libcommon.Hash, error) {
+func SpawnZkIntermediateHashesStage(s *stagedsync.StageState, u stagedsync.Unwinder, tx kv.RwTx, cfg ZkInterHashesCfg, ctx context.Context, quiet bool) (root libcommon.Hash, err error) {
 	logPrefix := s.LogPrefix()
+	// on an error the tx is rolled back, drop the nodes it may have cached
+	defer func() {
+		if err != nil {
+			cfg.nodeCache.Purge()
+		}
+	}()
 
 	quit := ctx.Done()
 	_ = quit
 
 	useExternalTx := tx != nil
 	if !useExternalTx {
-		var err error
 		tx, err = cfg.db.BeginRw(context.Background())
 		if err != nil {
 			return trie.EmptyRoot, err
@@ -113,11 +123,12 @@ func SpawnZkIntermediateHashesStage(s *stagedsync.StageState, u stagedsync.Unwin
 		log.Info(fmt.Sprintf("[%s] Generating intermediate hashes", logPrefix), "from", s.BlockNumber, "to", to)
 	}
 
-	var root libcommon.Hash
-
-	shouldRegenerate := to > s.BlockNumber && to-s.BlockNumber > cfg.zk.RebuildTreeAfter
+	// the roots of the blocks rebuilt from L1 are only known from the tree, it is regenerated once so that every block
+	// after the first generation has its root
+	shouldRegenerate := !cfg.zk.L1SyncOnly && to > s.BlockNumber && to-s.BlockNumber > cfg.zk.RebuildTreeAfter
 
 	eridb := db2.NewEriDb(tx)
+	eridb.SetNodeCache(cfg.nodeCache)
 	smt := smt.NewSMT(eridb)
 
 	if s.BlockNumber == 0 || shouldRegenerate {
@@ -140,19 +151,30 @@ func SpawnZkIntermediateHashesStage(s *stagedsync.StageState, u stagedsync.Unwin
 		}
 		expectedRootHash = syncHeadHeader.Root
 		headerHash = syncHeadHeader.Hash()
-		if root, err = zkIncrementIntermediateHashes(logPrefix, s, tx, eridb, smt, incrementTo, cfg, &expectedRootHash, quit); err != nil {
+		if root, err = zkIncrementIntermediateHashes(logPrefix, s, tx, eridb, smt, incrementTo, cfg, &expectedRootHash, ctx, quit); err != nil {
 			return trie.EmptyRoot, err
 		}
 	}
 
 	log.Info(fmt.Sprintf("[%s] Trie root", logPrefix), "hash", root.Hex())
 
-	hashErr := verifyStateRoot(smt, &expectedRootHash, &cfg, logPrefix, to, tx)
-	if hashErr != nil {
+	if err := keepComputedStateRoot(tx, to, root); err != nil {
+		return trie.EmptyRoot, err
+	}
+
+	// the blocks rebuilt from L1 have no root in their header until it is written here, they are checked against the
+	// L1 verifications instead
+	rebuiltFromL1 := cfg.zk.L1SyncOnly && expectedRootHash == (libcommon.Hash{})
+	if rebuiltFromL1 {
+		log.Info(fmt.Sprintf("[%s] Not checking the root of a block rebuilt from L1, it is checked against the L1 verifications", logPrefix), "block", to)
+		if err := writeComputedStateRoots(tx, s.BlockNumber+1, to, logPrefix); err != nil {
+			return trie.EmptyRoot, err
+		}
+	} else if hashErr := verifyStateRoot(ctx, smt, &expectedRootHash, &cfg, logPrefix, s.BlockNumber, to, tx); hashErr != nil {
 		panic(fmt.Errorf("state root mismatch (checking state and RPC): %w, %s", hashErr, root.Hex()))
 	}
 
-	if cfg.checkRoot && root != expectedRootHash {
+	if cfg.checkRoot && !rebuiltFromL1 && root != expectedRootHash {
 		log.Error(fmt.Sprintf("[%s] Wrong trie root of block %d: %x, expected (from header): %x. Block hash: %x", logPrefix, to, root, expectedRootHash, headerHash))
 		if cfg.badBlockHalt {
 			return trie.EmptyRoot, fmt.Errorf("wrong trie root")
@@ -180,6 +202,12 @@ func SpawnZkIntermediateHashesStage(s *stagedsync.StageState, u stagedsync.Unwin
 
 func UnwindZkIntermediateHashesStage(u *stagedsync.UnwindState, s *stagedsync.StageState, tx kv.RwTx, cfg ZkInterHashesCfg, ctx context.Context) (err error) {
 	quit := ctx.Done()
+	// on an error the tx is rolled back, drop the nodes it may have cached
+	defer func() {
+		if err != nil {
+			cfg.nodeCache.Purge()
+		}
+	}()
 	useExternalTx := tx != nil
 	if !useExternalTx {
 		tx, err = cfg.db.BeginRw(ctx)
@@ -215,6 +243,37 @@ func UnwindZkIntermediateHashesStage(u *stagedsync.UnwindState, s *stagedsync.St
 	return nil
 }
 
+// PruneZkIntermediateHashesStage drops the tree history of the blocks more than SmtHistoryBlocks behind the stage
+// progress, the tree can't be read at their state roots anymore
+func PruneZkIntermediateHashesStage(s *stagedsync.PruneState, tx kv.RwTx, cfg ZkInterHashesCfg, ctx context.Context) (err error) {
+	useExternalTx := tx != nil
+	if !useExternalTx {
+		tx, err = cfg.db.BeginRw(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+	}
+
+	if cfg.zk != nil && s.ForwardProgress > cfg.zk.SmtHistoryBlocks {
+		pruneTo := s.ForwardProgress - cfg.zk.SmtHistoryBlocks
+		if err := db2.NewEriDb(tx).PruneHistory(pruneTo); err != nil {
+			return fmt.Errorf("prune smt history: %w", err)
+		}
+		log.Debug(fmt.Sprintf("[%s] Pruned the state tree history", s.LogPrefix()), "before", pruneTo)
+	}
+
+	if err := s.Done(tx); err != nil {
+		return err
+	}
+	if !useExternalTx {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func regenerateIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb, smtIn *smt.SMT, cfg ZkInterHashesCfg, expectedRootHash *libcommon.Hash, ctx context.Context, quitCh <-chan struct{}) (libcommon.Hash, error) {
 	log.Info(fmt.Sprintf("[%s] Regeneration trie hashes started", logPrefix))
 	defer log.Info(fmt.Sprintf("[%s] Regeneration ended", logPrefix))
@@ -223,16 +282,21 @@ func regenerateIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb
 		log.Warn(fmt.Sprint("regenerate SaveStageProgress to zero error: ", err))
 	}
 
-	var a *accounts.Account
-	var addr libcommon.Address
-	var as map[string]string
-	var inc uint64
-
 	psr := state2.NewPlainStateReader(db)
 
 	log.Info(fmt.Sprintf("[%s] Collecting account data...", logPrefix))
 	dataCollectStartTime := time.Now()
-	keys := []utils.NodeKey{}
+
+	// the leaves are sorted by the collector, which keeps at most bufferSize of them in memory and spills the rest to tmpDir
+	bufferSize := etl.BufferOptimalSize
+	if cfg.zk != nil && cfg.zk.SmtRegenerateBufferSize > 0 {
+		bufferSize = cfg.zk.SmtRegenerateBufferSize
+	}
+	collector := etl.NewCollector(logPrefix, cfg.tmpDir, etl.NewSortableBuffer(bufferSize))
+	defer collector.Close()
+	collect := func(_ libcommon.Address, k utils.NodeKey, v utils.NodeValue8, _ string) error {
+		return smt.CollectLeaf(collector, k, v)
+	}
 
 	// get total accounts count for progress printer
 	total := uint64(0)
@@ -246,42 +310,10 @@ func regenerateIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb
 	progressChan, stopProgressPrinter := zk.ProgressPrinterWithoutValues(fmt.Sprintf("[%s] SMT regenerate progress", logPrefix), total*2)
 
 	progCt := uint64(0)
-	err := psr.ForEach(kv.PlainState, nil, func(k, acc []byte) error {
+	err := walkPlainState(psr, func() {
 		progCt++
 		progressChan <- progCt
-		var err error
-		if len(k) == 20 {
-			if a != nil { // don't run process on first loop for first account (or it will miss collecting storage)
-				keys, err = processAccount(eridb, a, as, inc, psr, addr, keys)
-				if err != nil {
-					return err
-				}
-			}
-
-			a = &accounts.Account{}
-
-			if err := a.DecodeForStorage(acc); err != nil {
-				// TODO: not an account?
-				as = make(map[string]string)
-				return nil
-			}
-			addr = libcommon.BytesToAddress(k)
-			inc = a.Incarnation
-			// empty storage of previous account
-			as = make(map[string]string)
-		} else { // otherwise we're reading storage
-			_, incarnation, key := dbutils.PlainParseCompositeStorageKey(k)
-			if incarnation != inc {
-				return nil
-			}
-
-			sk := fmt.Sprintf("0x%032x", key)
-			v := fmt.Sprintf("0x%032x", acc)
-
-			as[sk] = fmt.Sprint(TrimHexString(v))
-		}
-		return nil
-	})
+	}, collect)
 
 	stopProgressPrinter()
 
@@ -289,25 +321,14 @@ func regenerateIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb
 		return trie.EmptyRoot, err
 	}
 
-	// process the final account
-	keys, err = processAccount(eridb, a, as, inc, psr, addr, keys)
-	if err != nil {
-		return trie.EmptyRoot, err
-	}
-
 	dataCollectTime := time.Since(dataCollectStartTime)
 	log.Info(fmt.Sprintf("[%s] Collecting account data finished in %v", logPrefix, dataCollectTime))
 
 	// generate tree
-	if _, err := smtIn.GenerateFromKVBulk(logPrefix, keys); err != nil {
+	if _, err := smtIn.GenerateFromCollector(logPrefix, collector, quitCh); err != nil {
 		return trie.EmptyRoot, err
 	}
 
-	err2 := db.ClearBucket("HermezSmtAccountValues")
-	if err2 != nil {
-		log.Warn(fmt.Sprint("regenerate SaveStageProgress to zero error: ", err2))
-	}
-
 	root := smtIn.LastRoot()
 	err = eridb.CommitBatch()
 	if err != nil {
@@ -317,7 +338,7 @@ func regenerateIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb
 	return libcommon.BigToHash(root), nil
 }
 
-func zkIncrementIntermediateHashes(logPrefix string, s *stagedsync.StageState, db kv.RwTx, eridb *db2.EriDb, dbSmt *smt.SMT, to uint64, cfg ZkInterHashesCfg, expectedRootHash *libcommon.Hash, quit <-chan struct{}) (libcommon.Hash, error) {
+func zkIncrementIntermediateHashes(logPrefix string, s *stagedsync.StageState, db kv.RwTx, eridb *db2.EriDb, dbSmt *smt.SMT, to uint64, cfg ZkInterHashesCfg, expectedRootHash *libcommon.Hash, ctx context.Context, quit <-chan struct{}) (libcommon.Hash, error) {
 	log.Info(fmt.Sprintf("[%s] Increment trie hashes started", logPrefix), "previousRootHeight", s.BlockNumber, "calculatingRootHeight", to)
 	defer log.Info(fmt.Sprintf("[%s] Increment ended", logPrefix))
 
@@ -341,15 +362,15 @@ func zkIncrementIntermediateHashes(logPrefix string, s *stagedsync.StageState, d
 	progressChan, stopProgressPrinter := zk.ProgressPrinter(fmt.Sprintf("[%s] Progress inserting values", logPrefix), total)
 	defer stopProgressPrinter()
 
-	accChanges := make(map[libcommon.Address]*accounts.Account)
-	codeChanges := make(map[libcommon.Address]string)
-	storageChanges := make(map[libcommon.Address]map[string]string)
-
 	// NB: changeset tables are zero indexed
 	// changeset tables contain historical value at N-1, so we look up values from plainstate
 	for i := s.BlockNumber + 1; i <= to; i++ {
 		dupSortKey := dbutils.EncodeBlockNumber(i)
 
+		accChanges := make(map[libcommon.Address]*accounts.Account)
+		codeChanges := make(map[libcommon.Address]string)
+		storageChanges := make(map[libcommon.Address]map[string]string)
+
 		// i+1 to get state at the beginning of the next batch
 		psr := state2.NewPlainState(db, i+1, systemcontracts.SystemContractCodeLookup["Hermez"])
 
@@ -408,22 +429,17 @@ func zkIncrementIntermediateHashes(logPrefix string, s *stagedsync.StageState, d
 			return trie.EmptyRoot, err
 		}
 
-		// update the tree
-		for addr, acc := range accChanges {
-			if err := updateAccInTree(dbSmt, addr, acc); err != nil {
-				return trie.EmptyRoot, err
-			}
+		// update the tree with the whole block diff at once
+		changes, err := blockSmtChanges(accChanges, codeChanges, storageChanges)
+		if err != nil {
+			return trie.EmptyRoot, err
 		}
-		for addr, code := range codeChanges {
-			if err := dbSmt.SetContractBytecode(addr.String(), code); err != nil {
-				return trie.EmptyRoot, err
-			}
+		eridb.SetBlockNumber(i)
+		if _, err := dbSmt.InsertBatch(ctx, changes); err != nil {
+			return trie.EmptyRoot, err
 		}
-
-		for addr, storage := range storageChanges {
-			if _, err := dbSmt.SetContractStorage(addr.String(), storage); err != nil {
-				return trie.EmptyRoot, err
-			}
+		if err := keepComputedStateRoot(db, i, libcommon.BigToHash(dbSmt.LastRoot())); err != nil {
+			return trie.EmptyRoot, err
 		}
 
 		progressChan <- i - s.BlockNumber + 1
@@ -452,11 +468,14 @@ func unwindZkSMT(logPrefix string, from, to uint64, db kv.RwTx, cfg ZkInterHashe
 	defer log.Info(fmt.Sprintf("[%s] Unwind ended", logPrefix))
 
 	eridb := db2.NewEriDb(db)
+	eridb.SetNodeCache(cfg.nodeCache)
 	dbSmt := smt.NewSMT(eridb)
 
 	log.Info(fmt.Sprintf("[%s]", logPrefix), "last root", libcommon.BigToHash(dbSmt.LastRoot()))
 
 	eridb.OpenBatch(quit)
+	// nodes removed while unwinding are journaled under the block we unwind to
+	eridb.SetBlockNumber(to)
 
 	ac, err := db.CursorDupSort(kv.AccountChangeSet)
 	if err != nil {
@@ -620,6 +639,163 @@ func updateAccInTree(smt *smt.SMT, addr libcommon.Address, acc *accounts.Account
 	return err
 }
 
+// blockSmtChanges turns the state diff of a block into the changes to apply with SMT.InsertBatch
+func blockSmtChanges(accChanges map[libcommon.Address]*accounts.Account, codeChanges map[libcommon.Address]string, storageChanges map[libcommon.Address]map[string]string) ([]smt.BatchChange, error) {
+	changes := make([]smt.BatchChange, 0, len(accChanges)*2+len(codeChanges)*2)
+
+	for addr, acc := range accChanges {
+		balance, nonce := big.NewInt(0), big.NewInt(0)
+		if acc != nil {
+			balance, nonce = acc.Balance.ToBig(), new(big.Int).SetUint64(acc.Nonce)
+		}
+		c, err := smt.AccountStateChanges(addr.String(), balance, nonce)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+
+	for addr, code := range codeChanges {
+		c, err := smt.ContractBytecodeChanges(addr.String(), code)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+
+	for addr, storage := range storageChanges {
+		c, err := smt.ContractStorageChanges(addr.String(), storage)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c...)
+	}
+
+	return changes, nil
+}
+
+// keepComputedStateRoot stores the root of the tree after a block written without a state root, the blocks rebuilt
+// from L1 only have one once they are executed
+func keepComputedStateRoot(tx kv.RwTx, blockNo uint64, root libcommon.Hash) error {
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	if err != nil {
+		return err
+	}
+	stored, err := hermezDb.GetStateRoot(blockNo)
+	if err != nil {
+		return err
+	}
+	if stored != (libcommon.Hash{}) {
+		return nil
+	}
+	return hermezDb.WriteStateRoot(blockNo, root)
+}
+
+// writeComputedStateRoots writes the computed roots into the headers of the blocks rebuilt from L1, from and to
+// included, along with the receipts of the blocks, and chains every header onto the rewritten ones before it. Like
+// the execution stage does for the gas used, the header and body are written again under the new hash, the entries
+// under the old one are removed.
+func writeComputedStateRoots(tx kv.RwTx, from, to uint64, logPrefix string) error {
+	hermezDb := hermez_db.NewHermezDbReader(tx)
+	parentHash, err := rawdb.ReadCanonicalHash(tx, from-1)
+	if err != nil {
+		return err
+	}
+
+	// the blocks before the last one hashed by a generation of the tree have no computed root
+	unknownRoots := 0
+	for blockNo := from; blockNo <= to; blockNo++ {
+		hash, err := rawdb.ReadCanonicalHash(tx, blockNo)
+		if err != nil {
+			return err
+		}
+		header := rawdb.ReadHeader(tx, hash, blockNo)
+		if header == nil {
+			return fmt.Errorf("no header found with number %d", blockNo)
+		}
+
+		// a block with a root still has to be chained onto the rewritten block before it
+		header.ParentHash = parentHash
+		if header.Root == (libcommon.Hash{}) {
+			if header.Root, err = hermezDb.GetStateRoot(blockNo); err != nil {
+				return err
+			}
+			if header.Root == (libcommon.Hash{}) {
+				unknownRoots++
+			} else if err := writeReceiptsPostState(tx, header); err != nil {
+				return err
+			}
+		}
+		parentHash = header.Hash()
+		if parentHash == hash {
+			continue
+		}
+
+		body, err := rawdb.ReadStorageBody(tx, hash, blockNo)
+		if err != nil {
+			return fmt.Errorf("failed to read body of block %d: %w", blockNo, err)
+		}
+		senders, err := rawdb.ReadSenders(tx, hash, blockNo)
+		if err != nil {
+			return err
+		}
+		if err := deleteBlockEntries(tx, hash, blockNo); err != nil {
+			return err
+		}
+		rawdb.WriteHeader(tx, header)
+		if err := rawdb.WriteCanonicalHash(tx, parentHash, blockNo); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+		if err := rawdb.WriteBodyForStorage(tx, parentHash, blockNo, &body); err != nil {
+			return fmt.Errorf("failed to write body: %w", err)
+		}
+		if len(senders) > 0 {
+			if err := rawdb.WriteSenders(tx, parentHash, blockNo, senders); err != nil {
+				return err
+			}
+		}
+	}
+
+	if unknownRoots > 0 {
+		log.Warn(fmt.Sprintf("[%s] Blocks rebuilt from L1 left without a state root, the tree was generated at a later block", logPrefix), "count", unknownRoots, "to", to)
+	}
+	return nil
+}
+
+// writeReceiptsPostState sets the root of the header as the post state of the receipts of the block, as the sequencer
+// does, and the receipt hash of the header from them. The receipt hash is left when the receipts aren't kept.
+func writeReceiptsPostState(tx kv.RwTx, header *types.Header) error {
+	blockNo := header.Number.Uint64()
+	receipts := rawdb.ReadRawReceipts(tx, blockNo)
+	if receipts == nil {
+		return nil
+	}
+
+	for _, r := range receipts {
+		r.PostState = header.Root.Bytes()
+		// the bloom isn't stored with the receipt
+		r.Bloom = types.CreateBloom(types.Receipts{r})
+	}
+	header.ReceiptHash = types.DeriveSha(receipts)
+
+	return rawdb.WriteReceipts(tx, blockNo, receipts)
+}
+
+// deleteBlockEntries removes the header, body and senders of a block stored under a hash it no longer has, the
+// transactions of the body are kept for the body written under the new hash
+func deleteBlockEntries(tx kv.RwTx, hash libcommon.Hash, blockNo uint64) error {
+	if err := tx.Delete(kv.Headers, dbutils.HeaderKey(blockNo, hash)); err != nil {
+		return err
+	}
+	if err := tx.Delete(kv.HeaderNumber, hash[:]); err != nil {
+		return err
+	}
+	if err := tx.Delete(kv.BlockBody, dbutils.BlockBodyKey(blockNo, hash)); err != nil {
+		return err
+	}
+	return tx.Delete(kv.Senders, dbutils.BlockBodyKey(blockNo, hash))
+}
+
 func verifyLastHash(dbSmt *smt.SMT, expectedRootHash *libcommon.Hash, cfg *ZkInterHashesCfg, logPrefix string) error {
 	hash := libcommon.BigToHash(dbSmt.LastRoot())
 
@@ -631,53 +807,121 @@ func verifyLastHash(dbSmt *smt.SMT, expectedRootHash *libcommon.Hash, cfg *ZkInt
 	return nil
 }
 
-func processAccount(db smt.DB, a *accounts.Account, as map[string]string, inc uint64, psr *state2.PlainStateReader, addr libcommon.Address, keys []utils.NodeKey) ([]utils.NodeKey, error) {
+// collectLeafFunc receives the leaves of the tree derived from the plain state, with the account field they hold
+type collectLeafFunc func(k utils.NodeKey, v utils.NodeValue8, field string) error
+
+// collectAccountLeafFunc receives the leaves of the tree derived from the plain state, with the account and the field
+// they hold
+type collectAccountLeafFunc func(addr libcommon.Address, k utils.NodeKey, v utils.NodeValue8, field string) error
+
+// walkPlainState derives every leaf of the tree from the plain state and passes them to collect, the accounts are
+// processed once their storage is read. progress, when set, is called for every plain state entry.
+func walkPlainState(psr *state2.PlainStateReader, progress func(), collect collectAccountLeafFunc) error {
+	var a *accounts.Account
+	var addr libcommon.Address
+	var as map[string]string
+	var inc uint64
+
+	process := func() error {
+		return processAccount(func(k utils.NodeKey, v utils.NodeValue8, field string) error {
+			return collect(addr, k, v, field)
+		}, a, as, inc, psr, addr)
+	}
+
+	err := psr.ForEach(kv.PlainState, nil, func(k, acc []byte) error {
+		if progress != nil {
+			progress()
+		}
+		if len(k) == 20 {
+			if a != nil { // don't run process on first loop for first account (or it will miss collecting storage)
+				if err := process(); err != nil {
+					return err
+				}
+			}
+
+			a = &accounts.Account{}
+
+			if err := a.DecodeForStorage(acc); err != nil {
+				// TODO: not an account?
+				as = make(map[string]string)
+				return nil
+			}
+			addr = libcommon.BytesToAddress(k)
+			inc = a.Incarnation
+			// empty storage of previous account
+			as = make(map[string]string)
+		} else { // otherwise we're reading storage
+			_, incarnation, key := dbutils.PlainParseCompositeStorageKey(k)
+			if incarnation != inc {
+				return nil
+			}
+
+			sk := fmt.Sprintf("0x%032x", key)
+			v := fmt.Sprintf("0x%032x", acc)
+
+			as[sk] = fmt.Sprint(TrimHexString(v))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// process the final account
+	if a != nil {
+		return process()
+	}
+
+	return nil
+}
+
+func processAccount(collect collectLeafFunc, a *accounts.Account, as map[string]string, inc uint64, psr *state2.PlainStateReader, addr libcommon.Address) error {
 	// get the account balance and nonce
-	keys, err := insertAccountStateToKV(db, keys, addr.String(), a.Balance.ToBig(), new(big.Int).SetUint64(a.Nonce))
+	err := insertAccountStateToKV(collect, addr.String(), a.Balance.ToBig(), new(big.Int).SetUint64(a.Nonce))
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	// store the contract bytecode
 	cc, err := psr.ReadAccountCode(addr, inc, a.CodeHash)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	ach := hexutils.BytesToHex(cc)
 	if len(ach) > 0 {
 		hexcc := fmt.Sprintf("0x%s", ach)
-		keys, err = insertContractBytecodeToKV(db, keys, addr.String(), hexcc)
+		err = insertContractBytecodeToKV(collect, addr.String(), hexcc)
 		if err != nil {
-			return []utils.NodeKey{}, err
+			return err
 		}
 	}
 
 	if len(as) > 0 {
 		// store the account storage
-		keys, err = insertContractStorageToKV(db, keys, addr.String(), as)
+		err = insertContractStorageToKV(collect, addr.String(), as)
 		if err != nil {
-			return []utils.NodeKey{}, err
+			return err
 		}
 	}
 
-	return keys, nil
+	return nil
 }
 
-func insertContractBytecodeToKV(db smt.DB, keys []utils.NodeKey, ethAddr string, bytecode string) ([]utils.NodeKey, error) {
+func insertContractBytecodeToKV(collect collectLeafFunc, ethAddr string, bytecode string) error {
 	keyContractCode, err := utils.KeyContractCode(ethAddr)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	keyContractLength, err := utils.KeyContractLength(ethAddr)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	hashedBytecode, err := utils.HashContractBytecode(bytecode)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	parsedBytecode := strings.TrimPrefix(bytecode, "0x")
@@ -691,28 +935,30 @@ func insertContractBytecodeToKV(db smt.DB, keys []utils.NodeKey, ethAddr string,
 	x := utils.ScalarToArrayBig(bi)
 	valueContractCode, err := utils.NodeValue8FromBigIntArray(x)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	x = utils.ScalarToArrayBig(big.NewInt(int64(bytecodeLength)))
 	valueContractLength, err := utils.NodeValue8FromBigIntArray(x)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 	if !valueContractCode.IsZero() {
-		keys = append(keys, keyContractCode)
-		db.InsertAccountValue(keyContractCode, *valueContractCode)
+		if err := collect(keyContractCode, *valueContractCode, "code hash"); err != nil {
+			return err
+		}
 	}
 
 	if !valueContractLength.IsZero() {
-		keys = append(keys, keyContractLength)
-		db.InsertAccountValue(keyContractLength, *valueContractLength)
+		if err := collect(keyContractLength, *valueContractLength, "code length"); err != nil {
+			return err
+		}
 	}
 
-	return keys, nil
+	return nil
 }
 
-func insertContractStorageToKV(db smt.DB, keys []utils.NodeKey, ethAddr string, storage map[string]string) ([]utils.NodeKey, error) {
+func insertContractStorageToKV(collect collectLeafFunc, ethAddr string, storage map[string]string) error {
 	a := utils.ConvertHexToBigInt(ethAddr)
 	add := utils.ScalarToArrayBig(a)
 
@@ -723,7 +969,7 @@ func insertContractStorageToKV(db smt.DB, keys []utils.NodeKey, ethAddr string,
 
 		keyStoragePosition, err := utils.KeyContractStorage(add, k)
 		if err != nil {
-			return []utils.NodeKey{}, err
+			return err
 		}
 
 		base := 10
@@ -737,52 +983,57 @@ func insertContractStorageToKV(db smt.DB, keys []utils.NodeKey, ethAddr string,
 		x := utils.ScalarToArrayBig(val)
 		parsedValue, err := utils.NodeValue8FromBigIntArray(x)
 		if err != nil {
-			return []utils.NodeKey{}, err
+			return err
 		}
 		if !parsedValue.IsZero() {
-			keys = append(keys, keyStoragePosition)
-			db.InsertAccountValue(keyStoragePosition, *parsedValue)
+			if err := collect(keyStoragePosition, *parsedValue, "storage "+k); err != nil {
+				return err
+			}
 		}
 	}
 
-	return keys, nil
+	return nil
 }
 
-func insertAccountStateToKV(db smt.DB, keys []utils.NodeKey, ethAddr string, balance, nonce *big.Int) ([]utils.NodeKey, error) {
+func insertAccountStateToKV(collect collectLeafFunc, ethAddr string, balance, nonce *big.Int) error {
 	keyBalance, err := utils.KeyEthAddrBalance(ethAddr)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 	keyNonce, err := utils.KeyEthAddrNonce(ethAddr)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	x := utils.ScalarToArrayBig(balance)
 	valueBalance, err := utils.NodeValue8FromBigIntArray(x)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	x = utils.ScalarToArrayBig(nonce)
 	valueNonce, err := utils.NodeValue8FromBigIntArray(x)
 	if err != nil {
-		return []utils.NodeKey{}, err
+		return err
 	}
 
 	if !valueBalance.IsZero() {
-		keys = append(keys, keyBalance)
-		db.InsertAccountValue(keyBalance, *valueBalance)
+		if err := collect(keyBalance, *valueBalance, "balance"); err != nil {
+			return err
+		}
 	}
 	if !valueNonce.IsZero() {
-		keys = append(keys, keyNonce)
-		db.InsertAccountValue(keyNonce, *valueNonce)
+		if err := collect(keyNonce, *valueNonce, "nonce"); err != nil {
+			return err
+		}
 	}
-	return keys, nil
+	return nil
 }
 
 // RPC Debug
-func verifyStateRoot(dbSmt *smt.SMT, expectedRootHash *libcommon.Hash, cfg *ZkInterHashesCfg, logPrefix string, blockNo uint64, tx kv.RwTx) error {
+// verifyStateRoot checks the root against the one the rpc reports when it doesn't match the header, on a mismatch the
+// leaves differing from the expected root are logged
+func verifyStateRoot(ctx context.Context, dbSmt *smt.SMT, expectedRootHash *libcommon.Hash, cfg *ZkInterHashesCfg, logPrefix string, fromBlockNo, blockNo uint64, tx kv.RwTx) error {
 	hash := libcommon.BigToHash(dbSmt.LastRoot())
 	//psr := state2.NewPlainStateReader(tx)
 
@@ -812,6 +1063,9 @@ func verifyStateRoot(dbSmt *smt.SMT, expectedRootHash *libcommon.Hash, cfg *ZkIn
 
 		if hash != *sr {
 			log.Warn(fmt.Sprintf("[%s] Wrong trie root: %x, expected (from header): %x, from rpc: %x", logPrefix, hash, expectedRootHash, *sr))
+			if err := logSmtDiff(ctx, logPrefix, tx, fromBlockNo, blockNo, hash, *expectedRootHash); err != nil {
+				log.Warn(fmt.Sprintf("[%s] Failed to diff the tree", logPrefix), "err", err)
+			}
 			return fmt.Errorf("wrong trie root at %d: %x, expected (from header): %x, from rpc: %x", blockNo, hash, expectedRootHash, *sr)
 		}
 
@@ -9,6 +9,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/zk/sequencer"
 
 	"github.com/ledgerwatch/log/v3"
+	"github.com/tenderly/zkevm-erigon-lib/common"
 	"github.com/tenderly/zkevm-erigon-lib/kv"
 
 	"github.com/tenderly/zkevm-erigon/core/rawdb"
@@ -27,11 +28,21 @@ type IL1Syncer interface {
 	// Channels
 	GetVerificationsChan() chan types.L1BatchInfo
 	GetSequencesChan() chan types.L1BatchInfo
+	GetForcedBatchesChan() chan types.ForcedBatch
+	GetForkIdsChan() chan types.ForkIdUpdate
+	GetGlobalExitRootsChan() chan types.L1GlobalExitRoot
+	GetBatchDataChan() chan types.L1BatchData
+	GetBatchMetadataChan() chan types.L1BatchMetadata
+	GetBlockHashesChan() chan types.L1BlockHash
+	GetReorgsChan() chan types.L1Reorg
 	GetProgressMessageChan() chan string
 
-	Run(lastCheckedBlock uint64)
+	Run(lastCheckedBlock uint64, checkedHashes []types.L1BlockHash)
 }
 
+// checkedL1BlockHashes is how many of the hashes of the processed L1 blocks the syncer is started with
+const checkedL1BlockHashes = 1024
+
 var ErrStateRootMismatch = fmt.Errorf("state root mismatch")
 
 type L1SyncerCfg struct {
@@ -95,17 +106,33 @@ func SpawnStageL1Syncer(
 			l1BlockProgress = cfg.zkCfg.L1FirstBlock - 1
 		}
 
+		checkedHashes, err := hermezDb.GetLatestL1BlockHashes(l1BlockProgress, checkedL1BlockHashes)
+		if err != nil {
+			return fmt.Errorf("failed to get l1 block hashes, %w", err)
+		}
+
 		// start the syncer
-		cfg.syncer.Run(l1BlockProgress)
+		cfg.syncer.Run(l1BlockProgress, checkedHashes)
 	}
 
 	verificationsChan := cfg.syncer.GetVerificationsChan()
 	sequencesChan := cfg.syncer.GetSequencesChan()
+	forcedBatchesChan := cfg.syncer.GetForcedBatchesChan()
+	forkIdsChan := cfg.syncer.GetForkIdsChan()
+	globalExitRootsChan := cfg.syncer.GetGlobalExitRootsChan()
+	batchDataChan := cfg.syncer.GetBatchDataChan()
+	batchMetadataChan := cfg.syncer.GetBatchMetadataChan()
+	blockHashesChan := cfg.syncer.GetBlockHashesChan()
+	reorgsChan := cfg.syncer.GetReorgsChan()
 	progressMessageChan := cfg.syncer.GetProgressMessageChan()
 	highestVerification := types.L1BatchInfo{}
 
 	newVerificationsCount := 0
 	newSequencesCount := 0
+	// set once L1 was reorganised, the progress then goes back
+	reorged := false
+	// a reorg is handled once what was sent before it was taken, the syncer sends nothing more until it is done
+	var pendingReorg *types.L1Reorg
 Loop:
 	for {
 		select {
@@ -123,9 +150,51 @@ Loop:
 				return fmt.Errorf("failed to write batch info, %w", err)
 			}
 			newSequencesCount++
+		case forcedBatch := <-forcedBatchesChan:
+			if err := hermezDb.WriteForcedBatch(&forcedBatch); err != nil {
+				return fmt.Errorf("failed to write forced batch %d, %w", forcedBatch.ForcedBatchNumber, err)
+			}
+		case update := <-forkIdsChan:
+			// the fork applies from the batch after the last one of the previous fork
+			log.Info(fmt.Sprintf("[%s] Fork id update", logPrefix), "forkId", update.ForkId, "version", update.Version, "fromBatch", update.BatchNo+1)
+			if err := hermezDb.WriteL1ForkId(update.L1BlockNo, update.BatchNo+1, update.ForkId); err != nil {
+				return fmt.Errorf("failed to write fork id %d, %w", update.ForkId, err)
+			}
+		case ger := <-globalExitRootsChan:
+			if err := hermezDb.WriteL1GlobalExitRoot(&ger); err != nil {
+				return fmt.Errorf("failed to write l1 global exit root %s, %w", ger.GlobalExitRoot, err)
+			}
+		case batchData := <-batchDataChan:
+			if err := hermezDb.WriteL1BatchData(&batchData); err != nil {
+				return fmt.Errorf("failed to write l1 data of batch %d, %w", batchData.BatchNo, err)
+			}
+		case metadata := <-batchMetadataChan:
+			if err := hermezDb.WriteL1BatchMetadata(&metadata); err != nil {
+				return fmt.Errorf("failed to write l1 metadata of batch %d, %w", metadata.BatchNo, err)
+			}
+		case blockHash := <-blockHashesChan:
+			if err := hermezDb.WriteL1BlockHash(blockHash.BlockNo, blockHash.Hash); err != nil {
+				return fmt.Errorf("failed to write l1 block hash for block %d, %w", blockHash.BlockNo, err)
+			}
+		case reorg := <-reorgsChan:
+			pendingReorg = &reorg
 		case progressMessage := <-progressMessageChan:
 			log.Info(fmt.Sprintf("[%s] %s", logPrefix, progressMessage))
 		default:
+			if pendingReorg != nil {
+				ancestor := pendingReorg.Ancestor
+				log.Warn(fmt.Sprintf("[%s] L1 reorg, removing what was read after block", logPrefix), "block", ancestor)
+				if err := unwindL1Syncer(tx, u, hermezDb, ancestor, logPrefix); err != nil {
+					return fmt.Errorf("failed to unwind to l1 block %d, %w", ancestor, err)
+				}
+				if highestVerification.L1BlockNo > ancestor {
+					highestVerification = types.L1BatchInfo{}
+				}
+				reorged = true
+				close(pendingReorg.Done)
+				pendingReorg = nil
+				continue
+			}
 			if !cfg.syncer.IsDownloading() {
 				break Loop
 			}
@@ -133,7 +202,7 @@ Loop:
 	}
 
 	latestCheckedBlock := cfg.syncer.GetLastCheckedL1Block()
-	if latestCheckedBlock > l1BlockProgress {
+	if latestCheckedBlock > l1BlockProgress || reorged {
 		log.Info(fmt.Sprintf("[%s] Saving L1 syncer progress", logPrefix), "latestCheckedBlock", latestCheckedBlock, "newVerificationsCount", newVerificationsCount, "newSequencesCount", newSequencesCount)
 
 		if err := stages.SaveStageProgress(tx, stages.L1Syncer, latestCheckedBlock); err != nil {
@@ -168,6 +237,67 @@ Loop:
 	return nil
 }
 
+// unwindL1Syncer removes what was read from the L1 blocks after the ancestor and takes the progress back to it. The
+// L2 blocks built from the batches sequenced after it are unwound, and are checked against the verifications again.
+func unwindL1Syncer(tx kv.RwTx, u stagedsync.Unwinder, hermezDb *hermez_db.HermezDb, ancestor uint64, logPrefix string) error {
+	// the batch data is removed along with the blocks, the batches built from it are found first
+	reorgedBatchNo, reorgedBatch, err := hermezDb.GetFirstL1BatchDataAfterL1Block(ancestor)
+	if err != nil {
+		return err
+	}
+
+	if err := hermezDb.TruncateL1Blocks(ancestor); err != nil {
+		return err
+	}
+
+	progress, err := stages.GetStageProgress(tx, stages.L1Syncer)
+	if err != nil {
+		return err
+	}
+	if progress > ancestor {
+		if err := stages.SaveStageProgress(tx, stages.L1Syncer, ancestor); err != nil {
+			return err
+		}
+	}
+
+	highestVerifiedBatchNo := uint64(0)
+	verification, err := hermezDb.GetLatestVerification()
+	if err != nil {
+		return err
+	}
+	if verification != nil {
+		highestVerifiedBatchNo = verification.BatchNo
+	}
+	if err := stages.SaveStageProgress(tx, stages.L1VerificationsBatchNo, highestVerifiedBatchNo); err != nil {
+		return err
+	}
+
+	// the blocks were checked against verifications that may have been reorganised away
+	checkedBlockNo, err := stages.GetStageProgress(tx, stages.VerificationsStateRootCheck)
+	if err != nil {
+		return err
+	}
+	verifiedBlockNo, err := hermezDb.GetHighestVerifiedBlockNo()
+	if err != nil {
+		return err
+	}
+	checkedBlockNo = min(checkedBlockNo, verifiedBlockNo)
+
+	if reorgedBatch {
+		firstBlockNo, _, found, err := hermezDb.GetBatchBlockRange(reorgedBatchNo)
+		if err != nil {
+			return err
+		}
+		if found && firstBlockNo > 0 {
+			log.Warn(fmt.Sprintf("[%s] Unwinding the L2 blocks built from the reorganised L1 batches", logPrefix), "batch", reorgedBatchNo, "block", firstBlockNo-1)
+			u.UnwindTo(firstBlockNo-1, common.Hash{})
+			checkedBlockNo = min(checkedBlockNo, firstBlockNo-1)
+		}
+	}
+
+	return stages.SaveStageProgress(tx, stages.VerificationsStateRootCheck, checkedBlockNo)
+}
+
 func UnwindL1SyncerStage(u *stagedsync.UnwindState, tx kv.RwTx, cfg L1SyncerCfg, ctx context.Context) (err error) {
 	useExternalTx := tx != nil
 	if !useExternalTx {
@@ -277,7 +407,15 @@ func blockComparison(tx kv.RwTx, hermezDb *hermez_db.HermezDb, blockNo uint64, l
 		return nil
 	}
 
-	if v.StateRoot != block.Root() {
+	// the blocks rebuilt from L1 have no root in their header, the one computed when they were hashed is kept instead
+	root := block.Root()
+	if root == (common.Hash{}) {
+		if root, err = hermezDb.GetStateRoot(blockNo); err != nil {
+			return fmt.Errorf("failed to get state root, %w", err)
+		}
+	}
+
+	if v.StateRoot != root {
 		log.Error(fmt.Sprintf("[%s] State root mismatch in block %d", logPrefix, blockNo))
 		return ErrStateRootMismatch
 	}
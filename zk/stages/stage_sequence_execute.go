@@ -34,6 +34,7 @@ import (
 	"github.com/tenderly/zkevm-erigon/eth/tracers/logger"
 	"github.com/tenderly/zkevm-erigon/zk/erigon_db"
 	"github.com/tenderly/zkevm-erigon/zk/hermez_db"
+	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
 
 	"github.com/tenderly/zkevm-erigon/common/changeset"
 	"github.com/tenderly/zkevm-erigon/common/dbutils"
@@ -495,6 +496,37 @@ func SpawnSequencingStage(
 		return fmt.Errorf("write block batch error: %v", err)
 	}
 
+	// the batch of the block is closed along with it
+	forkId, err := hermezDb.GetForkId(newNum.Uint64())
+	if err != nil {
+		return fmt.Errorf("get fork id error: %v", err)
+	}
+	// as in the batches stage, the l2 data of an etrog batch is left out
+	var batchL2Data []byte
+	if forkId < txtype.ForkIDEtrog {
+		batchL2Data = make([]byte, 0)
+		for _, transaction := range finalTransactions {
+			encoded, err := txtype.EncodeTx(transaction, zktypes.EFFECTIVE_GAS_PRICE_PERCENTAGE_DISABLED, uint16(forkId))
+			if err != nil {
+				// the l2 data is left out of the header rather than written without some of its transactions
+				log.Warn(fmt.Sprintf("[%s] Batch l2 data left out of the batch header", logPrefix), "batch", newNum.Uint64(), "tx", transaction.Hash(), "err", err)
+				batchL2Data = nil
+				break
+			}
+			batchL2Data = append(batchL2Data, encoded...)
+		}
+	}
+	if err := hermezDb.WriteBatchHeader(&zktypes.BatchHeader{
+		BatchNumber: newNum.Uint64(),
+		Coinbase:    fixedMiner,
+		StateRoot:   newHeader.Root,
+		Timestamp:   newHeader.Time,
+		Closed:      true,
+		BatchL2Data: batchL2Data,
+	}); err != nil {
+		return fmt.Errorf("write batch header error: %v", err)
+	}
+
 	if err = stages.SaveStageProgress(tx, stages.Execution, newNum.Uint64()); err != nil {
 		return err
 	}
@@ -686,6 +718,13 @@ func UnwindSequenceExecutionStage(u *stagedsync.UnwindState, s *stagedsync.Stage
 	if err = unwindExecutionStage(u, s, tx, ctx, cfg, initialCycle); err != nil {
 		return err
 	}
+	hermezDb, err := hermez_db.NewHermezDb(tx)
+	if err != nil {
+		return err
+	}
+	if err = hermezDb.TruncateBatchIndex(u.UnwindPoint); err != nil {
+		return fmt.Errorf("truncate batch index: %w", err)
+	}
 	if err = u.Done(tx); err != nil {
 		return err
 	}
@@ -5,12 +5,11 @@ import (
 	"fmt"
 	"github.com/ledgerwatch/log/v3"
 	libcommon "github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon-lib/etl"
 	"github.com/tenderly/zkevm-erigon-lib/kv"
-	"github.com/tenderly/zkevm-erigon/common/dbutils"
 	"github.com/tenderly/zkevm-erigon/core/rawdb"
 	state2 "github.com/tenderly/zkevm-erigon/core/state"
 	"github.com/tenderly/zkevm-erigon/core/types"
-	"github.com/tenderly/zkevm-erigon/core/types/accounts"
 	"github.com/tenderly/zkevm-erigon/eth/stagedsync"
 	db2 "github.com/tenderly/zkevm-erigon/smt/pkg/db"
 	"github.com/tenderly/zkevm-erigon/smt/pkg/smt"
@@ -21,12 +20,16 @@ import (
 )
 
 type SequencerInterhashesCfg struct {
-	db kv.RwDB
+	db        kv.RwDB
+	tmpDir    string
+	nodeCache *db2.NodeCache
 }
 
-func StageSequencerInterhashesCfg(db kv.RwDB) SequencerInterhashesCfg {
+func StageSequencerInterhashesCfg(db kv.RwDB, tmpDir string, nodeCache *db2.NodeCache) SequencerInterhashesCfg {
 	return SequencerInterhashesCfg{
-		db: db,
+		db:        db,
+		tmpDir:    tmpDir,
+		nodeCache: nodeCache,
 	}
 }
 
@@ -38,8 +41,14 @@ func SpawnSequencerInterhashesStage(
 	cfg SequencerInterhashesCfg,
 	initialCycle bool,
 	quiet bool,
-) error {
-	var err error
+) (err error) {
+	// on an error the tx is rolled back, drop the nodes it may have cached
+	defer func() {
+		if err != nil {
+			cfg.nodeCache.Purge()
+		}
+	}()
+
 	freshTx := tx == nil
 	if freshTx {
 		tx, err = cfg.db.BeginRw(ctx)
@@ -56,12 +65,14 @@ func SpawnSequencerInterhashesStage(
 
 	erigonDb := erigon_db.NewErigonDb(tx)
 	eridb := db2.NewEriDb(tx)
+	eridb.SetNodeCache(cfg.nodeCache)
+	eridb.SetBlockNumber(to)
 	smt := smt.NewSMT(eridb)
 
 	// if we are at block 1 then just regenerate the whole thing otherwise take an incremental approach
 	var newRoot libcommon.Hash
 	if to == 1 {
-		newRoot, err = regenerateSequencerIntermediateHashes(s.LogPrefix(), tx, eridb, smt)
+		newRoot, err = regenerateSequencerIntermediateHashes(s.LogPrefix(), tx, eridb, smt, cfg.tmpDir, ctx.Done())
 	} else {
 		// incremental change
 	}
@@ -132,71 +143,19 @@ func PruneSequencerInterhashesStage(
 	return nil
 }
 
-func regenerateSequencerIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb, smtIn *smt.SMT) (libcommon.Hash, error) {
-	var a *accounts.Account
-	var addr libcommon.Address
-	var as map[string]string
-	var inc uint64
-
+func regenerateSequencerIntermediateHashes(logPrefix string, db kv.RwTx, eridb *db2.EriDb, smtIn *smt.SMT, tmpDir string, quit <-chan struct{}) (libcommon.Hash, error) {
 	psr := state2.NewPlainStateReader(db)
 
 	log.Info(fmt.Sprintf("[%s] Collecting account data...", logPrefix))
 	dataCollectStartTime := time.Now()
-	keys := []utils.NodeKey{}
-
-	// get total accounts count for progress printer
-	total := uint64(0)
-	if err := psr.ForEach(kv.PlainState, nil, func(k, acc []byte) error {
-		total++
-		return nil
-	}); err != nil {
-		return trie.EmptyRoot, err
-	}
 
-	progCt := uint64(0)
-	err := psr.ForEach(kv.PlainState, nil, func(k, acc []byte) error {
-		progCt++
-		var err error
-		if len(k) == 20 {
-			if a != nil { // don't run process on first loop for first account (or it will miss collecting storage)
-				keys, err = processAccount(eridb, a, as, inc, psr, addr, keys)
-				if err != nil {
-					return err
-				}
-			}
-
-			a = &accounts.Account{}
-
-			if err := a.DecodeForStorage(acc); err != nil {
-				// TODO: not an account?
-				as = make(map[string]string)
-				return nil
-			}
-			addr = libcommon.BytesToAddress(k)
-			inc = a.Incarnation
-			// empty storage of previous account
-			as = make(map[string]string)
-		} else { // otherwise we're reading storage
-			_, incarnation, key := dbutils.PlainParseCompositeStorageKey(k)
-			if incarnation != inc {
-				return nil
-			}
-
-			sk := fmt.Sprintf("0x%032x", key)
-			v := fmt.Sprintf("0x%032x", acc)
-
-			as[sk] = fmt.Sprint(TrimHexString(v))
-		}
-		return nil
-	})
-
-	if err != nil {
-		return trie.EmptyRoot, err
+	collector := etl.NewCollector(logPrefix, tmpDir, etl.NewSortableBuffer(etl.BufferOptimalSize))
+	defer collector.Close()
+	collect := func(_ libcommon.Address, k utils.NodeKey, v utils.NodeValue8, _ string) error {
+		return smt.CollectLeaf(collector, k, v)
 	}
 
-	// process the final account
-	keys, err = processAccount(eridb, a, as, inc, psr, addr, keys)
-	if err != nil {
+	if err := walkPlainState(psr, nil, collect); err != nil {
 		return trie.EmptyRoot, err
 	}
 
@@ -204,17 +163,12 @@ func regenerateSequencerIntermediateHashes(logPrefix string, db kv.RwTx, eridb *
 	log.Info(fmt.Sprintf("[%s] Collecting account data finished in %v", logPrefix, dataCollectTime))
 
 	// generate tree
-	if _, err := smtIn.GenerateFromKVBulk(logPrefix, keys); err != nil {
+	if _, err := smtIn.GenerateFromCollector(logPrefix, collector, quit); err != nil {
 		return trie.EmptyRoot, err
 	}
 
-	err2 := db.ClearBucket("HermezSmtAccountValues")
-	if err2 != nil {
-		log.Warn(fmt.Sprint("regenerate SaveStageProgress to zero error: ", err2))
-	}
-
 	root := smtIn.LastRoot()
-	err = eridb.CommitBatch()
+	err := eridb.CommitBatch()
 	if err != nil {
 		return trie.EmptyRoot, err
 	}
@@ -189,7 +189,7 @@ func SequencerZkStages(
 				return SpawnStageDataStreamCatchup(s, ctx, tx, dataStreamCatchupCfg)
 			},
 			Unwind: func(firstCycle bool, u *stages.UnwindState, s *stages.StageState, tx kv.RwTx) error {
-				return nil
+				return UnwindDataStreamCatchupStage(u, tx, dataStreamCatchupCfg, ctx)
 			},
 			Prune: func(firstCycle bool, p *stages.PruneState, tx kv.RwTx) error {
 				return nil
@@ -345,8 +345,7 @@ func DefaultZkStages(
 				return UnwindZkIntermediateHashesStage(u, s, tx, zkInterHashesCfg, ctx)
 			},
 			Prune: func(firstCycle bool, p *stages.PruneState, tx kv.RwTx) error {
-				// TODO: implement this in zk interhashes
-				return nil
+				return PruneZkIntermediateHashesStage(p, tx, zkInterHashesCfg, ctx)
 			},
 		},
 		{
@@ -427,7 +426,7 @@ func DefaultZkStages(
 				return SpawnStageDataStreamCatchup(s, ctx, tx, dataStreamCatchupCfg)
 			},
 			Unwind: func(firstCycle bool, u *stages.UnwindState, s *stages.StageState, tx kv.RwTx) error {
-				return nil
+				return UnwindDataStreamCatchupStage(u, tx, dataStreamCatchupCfg, ctx)
 			},
 			Prune: func(firstCycle bool, p *stages.PruneState, tx kv.RwTx) error {
 				return nil
@@ -0,0 +1,355 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ledgerwatch/log/v3"
+	ethereum "github.com/tenderly/zkevm-erigon"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"golang.org/x/time/rate"
+
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/rpc"
+)
+
+const (
+	// how long an endpoint that failed is only tried after the others
+	defaultL1EndpointCooldown = 30 * time.Second
+	// how many blocks below the head a block must be to be cached, L1 isn't reorganised as deep
+	l1BlockCacheDepth = 64
+	l1BlockCacheSize  = 1024
+)
+
+// the errors providers reject the logs queries of too many blocks or results with, the range is split on them
+var logsRangeErrors = []string{
+	"block range",
+	"range too large",
+	"range is too large",
+	"query returned more than",
+	"response size exceeded",
+	"too many blocks",
+}
+
+// errL1EndpointBehind is returned for an endpoint that doesn't have the blocks asked for yet, it isn't a failure
+var errL1EndpointBehind = errors.New("endpoint behind the blocks asked for")
+
+// L1Endpoint is one of the L1 RPC endpoints of an L1Client
+type L1Endpoint struct {
+	// Name identifies the endpoint in the logs and errors, its url
+	Name   string
+	Client IEtherman
+	// Weight is the share of the requests sent to the endpoint against the others, 1 if not set
+	Weight uint64
+	// RateLimit is how many requests per second are sent to the endpoint at most, no limit if not set
+	RateLimit float64
+}
+
+type l1Endpoint struct {
+	L1Endpoint
+
+	// nil without a rate limit
+	limiter *rate.Limiter
+	// weight of the smooth weighted round robin the endpoint requests are first sent to is picked by
+	current int64
+	// when the endpoint last failed, it is tried after the others for the cooldown
+	failedAt time.Time
+	// the latest block the endpoint returned
+	head uint64
+	// the most blocks a logs query sent to the endpoint spans, no limit until it rejected a range
+	maxLogsRange uint64
+}
+
+// L1Client is an IEtherman over several L1 RPC endpoints. The requests are spread over the endpoints by their weight
+// and within their rate limits, and fail over to the other endpoints on errors. The logs queries are split into
+// ranges an endpoint accepts once it rejected one, and the blocks deep enough below the head are cached.
+type L1Client struct {
+	mu        sync.Mutex
+	endpoints []*l1Endpoint
+	cooldown  time.Duration
+
+	blocks *lru.Cache[uint64, *ethTypes.Block]
+	// the highest latest and finalized blocks returned, the blocks cached are below them
+	head      uint64
+	finalized uint64
+}
+
+func NewL1Client(endpoints []L1Endpoint) *L1Client {
+	blocks, err := lru.New[uint64, *ethTypes.Block](l1BlockCacheSize)
+	if err != nil {
+		panic(err)
+	}
+
+	c := &L1Client{
+		endpoints: make([]*l1Endpoint, 0, len(endpoints)),
+		cooldown:  defaultL1EndpointCooldown,
+		blocks:    blocks,
+	}
+	for _, e := range endpoints {
+		if e.Weight == 0 {
+			e.Weight = 1
+		}
+		endpoint := &l1Endpoint{L1Endpoint: e}
+		if e.RateLimit > 0 {
+			endpoint.limiter = rate.NewLimiter(rate.Limit(e.RateLimit), int(math.Max(1, math.Ceil(e.RateLimit))))
+		}
+		c.endpoints = append(c.endpoints, endpoint)
+	}
+	return c
+}
+
+// BlockByNumber returns a block from the cache if it is deep enough below the head, or from an endpoint
+func (c *L1Client) BlockByNumber(ctx context.Context, blockNumber *big.Int) (*ethTypes.Block, error) {
+	if blockNumber != nil && blockNumber.Sign() >= 0 {
+		if block, ok := c.blocks.Get(blockNumber.Uint64()); ok {
+			return block, nil
+		}
+	}
+
+	var block *ethTypes.Block
+	if err := c.do(ctx, "BlockByNumber", func(e *l1Endpoint) (err error) {
+		block, err = e.Client.BlockByNumber(ctx, blockNumber)
+		if err == nil && blockNumber == nil {
+			c.setHead(e, block.NumberU64())
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if blockNumber == nil && block.NumberU64() > c.head {
+		c.head = block.NumberU64()
+	}
+	if blockNumber != nil && blockNumber.Cmp(big.NewInt(int64(rpc.FinalizedBlockNumber))) == 0 && block.NumberU64() > c.finalized {
+		c.finalized = block.NumberU64()
+	}
+	if n := block.NumberU64(); n <= c.finalized || n+l1BlockCacheDepth <= c.head {
+		c.blocks.Add(n, block)
+	}
+	return block, nil
+}
+
+// HeaderByHash is not cached, it is used to follow the chain where the blocks cached by number may be reorganised away
+func (c *L1Client) HeaderByHash(ctx context.Context, hash common.Hash) (header *ethTypes.Header, err error) {
+	err = c.do(ctx, "HeaderByHash", func(e *l1Endpoint) (err error) {
+		header, err = e.Client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+func (c *L1Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx ethTypes.Transaction, isPending bool, err error) {
+	err = c.do(ctx, "TransactionByHash", func(e *l1Endpoint) (err error) {
+		tx, isPending, err = e.Client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+func (c *L1Client) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) (out []byte, err error) {
+	err = c.do(ctx, "CallContract", func(e *l1Endpoint) (err error) {
+		out, err = e.Client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return out, err
+}
+
+// FilterLogs returns the logs of a range of blocks from an endpoint that has all of them, split into the ranges the
+// endpoint accepts. The queries without a range of block numbers are sent as they are.
+func (c *L1Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]ethTypes.Log, error) {
+	var logs []ethTypes.Log
+	if query.BlockHash != nil || query.FromBlock == nil || query.ToBlock == nil || query.FromBlock.Sign() < 0 || query.ToBlock.Sign() < 0 {
+		err := c.do(ctx, "FilterLogs", func(e *l1Endpoint) (err error) {
+			logs, err = e.Client.FilterLogs(ctx, query)
+			return err
+		})
+		return logs, err
+	}
+
+	err := c.do(ctx, "FilterLogs", func(e *l1Endpoint) (err error) {
+		logs, err = c.endpointLogs(ctx, e, query)
+		return err
+	})
+	return logs, err
+}
+
+// endpointLogs queries the logs of a range of blocks from an endpoint, halving the ranges it rejects
+func (c *L1Client) endpointLogs(ctx context.Context, e *l1Endpoint, query ethereum.FilterQuery) ([]ethTypes.Log, error) {
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	if ok, err := c.hasBlock(ctx, e, to); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errL1EndpointBehind
+	}
+
+	logs := make([]ethTypes.Log, 0)
+	// the request budget was taken for the first query, the others wait for theirs
+	first := true
+	for from <= to {
+		end := to
+		c.mu.Lock()
+		if e.maxLogsRange > 0 && end-from+1 > e.maxLogsRange {
+			end = from + e.maxLogsRange - 1
+		}
+		c.mu.Unlock()
+
+		if !first && e.limiter != nil {
+			if err := e.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		first = false
+
+		q := query
+		q.FromBlock, q.ToBlock = new(big.Int).SetUint64(from), new(big.Int).SetUint64(end)
+		part, err := e.Client.FilterLogs(ctx, q)
+		if err != nil {
+			if !isLogsRangeError(err) || end == from {
+				return nil, err
+			}
+			blocks := (end - from + 1) / 2
+			log.Debug("L1 endpoint rejected the logs range, splitting it", "endpoint", e.Name, "from", from, "to", end, "blocks", blocks, "err", err)
+			c.mu.Lock()
+			if e.maxLogsRange == 0 || blocks < e.maxLogsRange {
+				e.maxLogsRange = blocks
+			}
+			c.mu.Unlock()
+			continue
+		}
+		logs = append(logs, part...)
+		from = end + 1
+	}
+	return logs, nil
+}
+
+// hasBlock checks that an endpoint has a block before its logs are asked for, an endpoint behind returns no logs for
+// the blocks it doesn't have rather than an error
+func (c *L1Client) hasBlock(ctx context.Context, e *l1Endpoint, blockNo uint64) (bool, error) {
+	c.mu.Lock()
+	head := e.head
+	c.mu.Unlock()
+	if head >= blockNo {
+		return true, nil
+	}
+
+	if e.limiter != nil {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+	}
+	latest, err := e.Client.BlockByNumber(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	c.setHead(e, latest.NumberU64())
+	return latest.NumberU64() >= blockNo, nil
+}
+
+func (c *L1Client) setHead(e *l1Endpoint, blockNo uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if blockNo > e.head {
+		e.head = blockNo
+	}
+}
+
+// do sends a request to the endpoints in order until one succeeds. The endpoints within their rate limit are tried
+// first, then the others once they are.
+func (c *L1Client) do(ctx context.Context, method string, request func(e *l1Endpoint) error) error {
+	endpoints := c.order()
+	tried := make([]bool, len(endpoints))
+	errs := make([]error, 0)
+	for _, wait := range []bool{false, true} {
+		for i, e := range endpoints {
+			if tried[i] {
+				continue
+			}
+			if e.limiter != nil {
+				if !wait && !e.limiter.Allow() {
+					continue
+				}
+				if wait {
+					if err := e.limiter.Wait(ctx); err != nil {
+						return err
+					}
+				}
+			}
+			tried[i] = true
+
+			err := request(e)
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name, err))
+			if errors.Is(err, errL1EndpointBehind) {
+				continue
+			}
+			log.Debug("L1 endpoint failed, failing over", "endpoint", e.Name, "method", method, "err", err)
+			c.mu.Lock()
+			e.failedAt = time.Now()
+			c.mu.Unlock()
+		}
+	}
+	return fmt.Errorf("%s failed on all the L1 endpoints: %w", method, errors.Join(errs...))
+}
+
+// order returns the endpoints in the order a request is tried on them: the one picked by their weights, the others
+// that didn't fail recently by weight, then the ones that did from the one that failed the longest ago
+func (c *L1Client) order() []*l1Endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*l1Endpoint, 0, len(c.endpoints))
+	failed := make([]*l1Endpoint, 0)
+	for _, e := range c.endpoints {
+		if !e.failedAt.IsZero() && now.Sub(e.failedAt) < c.cooldown {
+			failed = append(failed, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	sort.SliceStable(healthy, func(i, j int) bool { return healthy[i].Weight > healthy[j].Weight })
+	sort.SliceStable(failed, func(i, j int) bool { return failed[i].failedAt.Before(failed[j].failedAt) })
+
+	if len(healthy) > 0 {
+		// smooth weighted round robin
+		var total int64
+		picked := 0
+		for i, e := range healthy {
+			e.current += int64(e.Weight)
+			total += int64(e.Weight)
+			if e.current > healthy[picked].current {
+				picked = i
+			}
+		}
+		healthy[picked].current -= total
+		healthy[0], healthy[picked] = healthy[picked], healthy[0]
+		others := healthy[1:]
+		sort.SliceStable(others, func(i, j int) bool { return others[i].Weight > others[j].Weight })
+	}
+	return append(healthy, failed...)
+}
+
+func isLogsRangeError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range logsRangeErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	ethereum "github.com/tenderly/zkevm-erigon"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+)
+
+// fakeL1 is an L1 endpoint with a log in each block, that rejects the logs queries over maxRange blocks
+type fakeL1 struct {
+	mu       sync.Mutex
+	blocks   []*ethTypes.Block
+	maxRange uint64
+	fail     bool
+	calls    map[string]int
+}
+
+func newFakeL1(length uint64) *fakeL1 {
+	return &fakeL1{blocks: testChain(nil, 0, length), calls: map[string]int{}}
+}
+
+func (f *fakeL1) call(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	if f.fail {
+		return errors.New("429 Too Many Requests")
+	}
+	return nil
+}
+
+func (f *fakeL1) callCount(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (f *fakeL1) BlockByNumber(_ context.Context, blockNumber *big.Int) (*ethTypes.Block, error) {
+	if err := f.call("BlockByNumber"); err != nil {
+		return nil, err
+	}
+	if blockNumber == nil {
+		return f.blocks[len(f.blocks)-1], nil
+	}
+	if n := blockNumber.Uint64(); n < uint64(len(f.blocks)) {
+		return f.blocks[n], nil
+	}
+	return nil, ethereum.NotFound
+}
+
+func (f *fakeL1) HeaderByHash(_ context.Context, hash common.Hash) (*ethTypes.Header, error) {
+	if err := f.call("HeaderByHash"); err != nil {
+		return nil, err
+	}
+	for _, block := range f.blocks {
+		if block.Hash() == hash {
+			return block.Header(), nil
+		}
+	}
+	return nil, ethereum.NotFound
+}
+
+func (f *fakeL1) FilterLogs(_ context.Context, query ethereum.FilterQuery) ([]ethTypes.Log, error) {
+	if err := f.call("FilterLogs"); err != nil {
+		return nil, err
+	}
+	from, to := query.FromBlock.Uint64(), query.ToBlock.Uint64()
+	if f.maxRange > 0 && to-from+1 > f.maxRange {
+		return nil, fmt.Errorf("query exceeds max block range %d", f.maxRange)
+	}
+	logs := make([]ethTypes.Log, 0)
+	for n := from; n <= to && n < uint64(len(f.blocks)); n++ {
+		logs = append(logs, ethTypes.Log{BlockNumber: n, BlockHash: f.blocks[n].Hash()})
+	}
+	return logs, nil
+}
+
+func (f *fakeL1) TransactionByHash(context.Context, common.Hash) (ethTypes.Transaction, bool, error) {
+	if err := f.call("TransactionByHash"); err != nil {
+		return nil, false, err
+	}
+	return ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, nil), false, nil
+}
+
+func (f *fakeL1) CallContract(context.Context, ethereum.CallMsg, *big.Int) ([]byte, error) {
+	if err := f.call("CallContract"); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func logsQuery(from, to uint64) ethereum.FilterQuery {
+	return ethereum.FilterQuery{FromBlock: new(big.Int).SetUint64(from), ToBlock: new(big.Int).SetUint64(to)}
+}
+
+func requireLogs(t *testing.T, logs []ethTypes.Log, from, to uint64) {
+	require.Len(t, logs, int(to-from+1))
+	for i, l := range logs {
+		require.Equal(t, from+uint64(i), l.BlockNumber)
+	}
+}
+
+func TestL1Client_Weights(t *testing.T) {
+	a, b := newFakeL1(10), newFakeL1(10)
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a, Weight: 3}, {Name: "b", Client: b}})
+
+	for i := 0; i < 8; i++ {
+		_, _, err := c.TransactionByHash(context.Background(), common.Hash{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 6, a.callCount("TransactionByHash"))
+	require.Equal(t, 2, b.callCount("TransactionByHash"))
+}
+
+func TestL1Client_Failover(t *testing.T) {
+	a, b := newFakeL1(10), newFakeL1(10)
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a, Weight: 10}, {Name: "b", Client: b}})
+
+	a.fail = true
+	_, _, err := c.TransactionByHash(context.Background(), common.Hash{})
+	require.NoError(t, err)
+	require.Equal(t, 1, a.callCount("TransactionByHash"))
+	require.Equal(t, 1, b.callCount("TransactionByHash"))
+
+	// the endpoint that failed is only tried after the others for the cooldown
+	a.fail = false
+	_, _, err = c.TransactionByHash(context.Background(), common.Hash{})
+	require.NoError(t, err)
+	require.Equal(t, 1, a.callCount("TransactionByHash"))
+	require.Equal(t, 2, b.callCount("TransactionByHash"))
+
+	c.cooldown = 0
+	_, _, err = c.TransactionByHash(context.Background(), common.Hash{})
+	require.NoError(t, err)
+	require.Equal(t, 2, a.callCount("TransactionByHash"))
+
+	// all the endpoints failing is an error
+	a.fail, b.fail = true, true
+	_, _, err = c.TransactionByHash(context.Background(), common.Hash{})
+	require.ErrorContains(t, err, "TransactionByHash failed on all the L1 endpoints")
+}
+
+func TestL1Client_RateLimit(t *testing.T) {
+	a, b := newFakeL1(10), newFakeL1(10)
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a, Weight: 10, RateLimit: 0.001}, {Name: "b", Client: b}})
+
+	// the budget of a is a single request, the others go to b
+	for i := 0; i < 3; i++ {
+		_, _, err := c.TransactionByHash(context.Background(), common.Hash{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, a.callCount("TransactionByHash"))
+	require.Equal(t, 2, b.callCount("TransactionByHash"))
+}
+
+func TestL1Client_FilterLogs(t *testing.T) {
+	a := newFakeL1(1000)
+	a.maxRange = 100
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a}})
+
+	logs, err := c.FilterLogs(context.Background(), logsQuery(0, 999))
+	require.NoError(t, err)
+	requireLogs(t, logs, 0, 999)
+	require.Equal(t, uint64(62), c.endpoints[0].maxLogsRange)
+
+	// the range the endpoint accepts is kept for the next queries
+	calls := a.callCount("FilterLogs")
+	logs, err = c.FilterLogs(context.Background(), logsQuery(0, 619))
+	require.NoError(t, err)
+	requireLogs(t, logs, 0, 619)
+	require.Equal(t, calls+10, a.callCount("FilterLogs"))
+
+	// a single block rejected is an error
+	c = NewL1Client([]L1Endpoint{{Name: "b", Client: &rejectingL1{newFakeL1(1)}}})
+	_, err = c.FilterLogs(context.Background(), logsQuery(0, 0))
+	require.ErrorContains(t, err, "query returned more than 10000 results")
+}
+
+// rejectingL1 rejects all the logs queries for their results
+type rejectingL1 struct {
+	*fakeL1
+}
+
+func (r *rejectingL1) FilterLogs(context.Context, ethereum.FilterQuery) ([]ethTypes.Log, error) {
+	return nil, errors.New("query returned more than 10000 results")
+}
+
+func TestL1Client_FilterLogsBehind(t *testing.T) {
+	a, b := newFakeL1(50), newFakeL1(100)
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a, Weight: 10}, {Name: "b", Client: b}})
+
+	// a doesn't have the blocks yet, the logs come from b without a failing
+	logs, err := c.FilterLogs(context.Background(), logsQuery(60, 99))
+	require.NoError(t, err)
+	requireLogs(t, logs, 60, 99)
+	require.Equal(t, 0, a.callCount("FilterLogs"))
+	require.True(t, c.endpoints[0].failedAt.IsZero())
+
+	logs, err = c.FilterLogs(context.Background(), logsQuery(10, 20))
+	require.NoError(t, err)
+	requireLogs(t, logs, 10, 20)
+	require.Equal(t, 1, a.callCount("FilterLogs"))
+}
+
+func TestL1Client_BlockCache(t *testing.T) {
+	a := newFakeL1(200)
+	c := NewL1Client([]L1Endpoint{{Name: "a", Client: a}})
+
+	latest, err := c.BlockByNumber(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(199), latest.NumberU64())
+
+	// the blocks deep below the head are cached, the ones near it can be reorganised
+	for i := 0; i < 2; i++ {
+		block, err := c.BlockByNumber(context.Background(), big.NewInt(10))
+		require.NoError(t, err)
+		require.Equal(t, a.blocks[10].Hash(), block.Hash())
+		_, err = c.BlockByNumber(context.Background(), big.NewInt(190))
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, a.callCount("BlockByNumber"))
+}
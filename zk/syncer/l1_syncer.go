@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"sort"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -11,20 +13,74 @@ import (
 	ethereum "github.com/tenderly/zkevm-erigon"
 	"github.com/tenderly/zkevm-erigon-lib/common"
 
+	"github.com/tenderly/zkevm-erigon/accounts/abi"
 	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/crypto"
+	"github.com/tenderly/zkevm-erigon/rpc"
+	txtype "github.com/tenderly/zkevm-erigon/zk/tx"
 	"github.com/tenderly/zkevm-erigon/zk/types"
+	"github.com/tenderly/zkevm-erigon/zk/utils"
+	"github.com/tenderly/zkevm-erigon/zkevm/etherman/smartcontracts/polygonzkevm"
+	"github.com/tenderly/zkevm-erigon/zkevm/etherman/smartcontracts/polygonzkevmglobalexitroot"
 )
 
 var (
-	sequencedBatchTopic = common.HexToHash("0x303446e6a8cb73c83dff421c0b1d5e5ce0719dab1bff13660fc254e58cc17fce")
-	verificationTopic   = common.HexToHash("0xcb339b570a7f0b25afa7333371ff11192092a0aeace12b671f4c212f2815c6fe")
+	sequencedBatchTopic       = common.HexToHash("0x303446e6a8cb73c83dff421c0b1d5e5ce0719dab1bff13660fc254e58cc17fce")
+	sequencedBatchEtrogTopic  = common.HexToHash("0x3e54d0825ed78523037d00a81759237eb436ce774bd546993ee67a1b67b6e766")
+	verificationTopic         = common.HexToHash("0xcb339b570a7f0b25afa7333371ff11192092a0aeace12b671f4c212f2815c6fe")
+	forceBatchTopic           = common.HexToHash("0xf94bb37db835f1ab585ee00041849a09b12cd081d77fa15ca070757619cbc931")
+	sequenceForceBatchTopic   = common.HexToHash("0x648a61dd2438f072f5a1960939abd30f37aea80d2e94c9792ad142d3e0a490a4")
+	updateZkEVMVersionTopic   = common.HexToHash("0xed7be53c9f1a96a481223b15568a5b1a475e01a74b347d6ca187c8bf0c078cd6")
+	updateGlobalExitRootTopic = common.HexToHash("0x61014378f82a0d809aefaf87a8ac9505b89c321808287a6e7810f29304c1fce3")
 
 	batchWorkers = 2
+
+	// how many of the hashes of the processed L1 blocks are kept to find the block L1 was reorganised from
+	maxCheckedHashes = 1024
+)
+
+// L1 finality the syncer can be set to, the blocks are only consumed once they reach it
+const (
+	L1FinalityLatest    = "latest"
+	L1FinalitySafe      = "safe"
+	L1FinalityFinalized = "finalized"
 )
 
+// L1FinalityBlockNumber returns the block number the latest block of the given finality is requested with
+func L1FinalityBlockNumber(finality string) (*big.Int, error) {
+	switch finality {
+	case L1FinalityLatest, "":
+		return nil, nil
+	case L1FinalitySafe:
+		return big.NewInt(int64(rpc.SafeBlockNumber)), nil
+	case L1FinalityFinalized:
+		return big.NewInt(int64(rpc.FinalizedBlockNumber)), nil
+	default:
+		return nil, fmt.Errorf("unknown L1 finality %q, expected %s, %s or %s", finality, L1FinalityLatest, L1FinalitySafe, L1FinalityFinalized)
+	}
+}
+
 type IEtherman interface {
 	BlockByNumber(ctx context.Context, blockNumber *big.Int) (*ethTypes.Block, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*ethTypes.Header, error)
 	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]ethTypes.Log, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx ethTypes.Transaction, isPending bool, err error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// the events are decoded with the contract bindings, which only need the abi for it
+var (
+	zkevmEvents      = mustFilterer(polygonzkevm.NewPolygonzkevmFilterer(common.Address{}, nil))
+	gerManagerEvents = mustFilterer(polygonzkevmglobalexitroot.NewPolygonzkevmglobalexitrootFilterer(common.Address{}, nil))
+	zkevmABI         = mustFilterer(abi.JSON(strings.NewReader(polygonzkevm.PolygonzkevmABI)))
+	etrogABI         = mustFilterer(abi.JSON(strings.NewReader(rollupEtrogABI)))
+)
+
+func mustFilterer[T any](f T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return f
 }
 
 type fetchJob struct {
@@ -39,12 +95,29 @@ type jobResult struct {
 }
 
 type L1Syncer struct {
-	em                IEtherman
-	l1ContractAddress common.Address
-	blockRange        uint64
-	queryDelay        uint64
-
-	latestL1Block uint64
+	em                  IEtherman
+	l1ContractAddress   common.Address
+	l1GERManagerAddress common.Address
+	blockRange          uint64
+	queryDelay          uint64
+	// the block number the latest block is requested with, nil for the head of L1
+	finality *big.Int
+
+	latestL1Block     uint64
+	latestL1BlockHash common.Hash
+
+	// the hashes of the processed blocks, lowest first, only read and written by the syncer thread
+	checkedHashes []types.L1BlockHash
+
+	// set to read the data of the batches from the sequencing txs, for rebuilding the L2 blocks from L1
+	readBatchData bool
+	// set to read what the batches were sequenced with and their accumulated input hashes
+	readBatchMetadata bool
+
+	// the rollup manager the sequences from the etrog fork on are kept by under the id of the rollup, found with the
+	// first of them, only used by the syncer thread
+	rollupManager *common.Address
+	rollupId      uint32
 
 	// atomic
 	isSyncStarted      atomic.Bool
@@ -54,17 +127,35 @@ type L1Syncer struct {
 	// Channels
 	verificationsChan   chan types.L1BatchInfo
 	sequencesChan       chan types.L1BatchInfo
+	forcedBatchesChan   chan types.ForcedBatch
+	forkIdsChan         chan types.ForkIdUpdate
+	globalExitRootsChan chan types.L1GlobalExitRoot
+	batchDataChan       chan types.L1BatchData
+	batchMetadataChan   chan types.L1BatchMetadata
+	blockHashesChan     chan types.L1BlockHash
+	reorgsChan          chan types.L1Reorg
 	progressMessageChan chan string
 }
 
-func NewL1Syncer(em IEtherman, l1ContractAddress common.Address, blockRange, queryDelay uint64) *L1Syncer {
+func NewL1Syncer(em IEtherman, l1ContractAddress, l1GERManagerAddress common.Address, blockRange, queryDelay uint64, finality *big.Int, readBatchData, readBatchMetadata bool) *L1Syncer {
 	return &L1Syncer{
 		em:                  em,
 		l1ContractAddress:   l1ContractAddress,
+		l1GERManagerAddress: l1GERManagerAddress,
 		blockRange:          blockRange,
 		queryDelay:          queryDelay,
+		finality:            finality,
+		readBatchData:       readBatchData,
+		readBatchMetadata:   readBatchMetadata,
 		verificationsChan:   make(chan types.L1BatchInfo, 1000),
 		sequencesChan:       make(chan types.L1BatchInfo, 1000),
+		forcedBatchesChan:   make(chan types.ForcedBatch, 1000),
+		forkIdsChan:         make(chan types.ForkIdUpdate, 1000),
+		globalExitRootsChan: make(chan types.L1GlobalExitRoot, 1000),
+		batchDataChan:       make(chan types.L1BatchData, 1000),
+		batchMetadataChan:   make(chan types.L1BatchMetadata, 1000),
+		blockHashesChan:     make(chan types.L1BlockHash, 1000),
+		reorgsChan:          make(chan types.L1Reorg, 1),
 		progressMessageChan: make(chan string),
 	}
 }
@@ -90,11 +181,47 @@ func (s *L1Syncer) GetSequencesChan() chan types.L1BatchInfo {
 	return s.sequencesChan
 }
 
+func (s *L1Syncer) GetForcedBatchesChan() chan types.ForcedBatch {
+	return s.forcedBatchesChan
+}
+
+func (s *L1Syncer) GetForkIdsChan() chan types.ForkIdUpdate {
+	return s.forkIdsChan
+}
+
+func (s *L1Syncer) GetGlobalExitRootsChan() chan types.L1GlobalExitRoot {
+	return s.globalExitRootsChan
+}
+
+// GetBatchDataChan returns the data the batches were sequenced with, only sent when the syncer reads it
+func (s *L1Syncer) GetBatchDataChan() chan types.L1BatchData {
+	return s.batchDataChan
+}
+
+// GetBatchMetadataChan returns what the batches were sequenced with along with their accumulated input hashes, only
+// sent when the syncer reads it
+func (s *L1Syncer) GetBatchMetadataChan() chan types.L1BatchMetadata {
+	return s.batchMetadataChan
+}
+
+// GetBlockHashesChan returns the hashes of the processed blocks, to be kept along with what was found in them
+func (s *L1Syncer) GetBlockHashesChan() chan types.L1BlockHash {
+	return s.blockHashesChan
+}
+
+// GetReorgsChan returns the reorganisations of L1. A reorg is to be handled once everything sent before it was taken,
+// nothing more is sent until it is done. What was found after its ancestor is then found again.
+func (s *L1Syncer) GetReorgsChan() chan types.L1Reorg {
+	return s.reorgsChan
+}
+
 func (s *L1Syncer) GetProgressMessageChan() chan string {
 	return s.progressMessageChan
 }
 
-func (s *L1Syncer) Run(lastCheckedBlock uint64) {
+// Run starts the syncer from the block after lastCheckedBlock, checkedHashes are the hashes of the blocks processed
+// before, lowest first
+func (s *L1Syncer) Run(lastCheckedBlock uint64, checkedHashes []types.L1BlockHash) {
 	//if already started, don't start another thread
 	if s.isSyncStarted.Load() {
 		return
@@ -103,6 +230,12 @@ func (s *L1Syncer) Run(lastCheckedBlock uint64) {
 	// set it to true to catch the first cycle run case where the check can pass before the latest block is checked
 	s.isDownloading.Store(true)
 	s.lastCheckedL1Block.Store(lastCheckedBlock)
+	s.checkedHashes = s.checkedHashes[:0]
+	for _, h := range checkedHashes {
+		if h.BlockNo <= lastCheckedBlock {
+			s.checkedHashes = append(s.checkedHashes, h)
+		}
+	}
 
 	//start a thread to cheack for new l1 block in interval
 	go func() {
@@ -121,10 +254,20 @@ func (s *L1Syncer) Run(lastCheckedBlock uint64) {
 
 			if latestL1Block > s.lastCheckedL1Block.Load() {
 				s.isDownloading.Store(true)
+				ancestor, reorged, err := s.findReorg()
+				if err != nil {
+					log.Error("Error checking for an L1 reorg", "err", err)
+					continue
+				}
+				if reorged {
+					s.unwind(ancestor)
+					continue
+				}
 				if err := s.queryBlocks(); err != nil {
 					log.Error("Error querying blocks", "err", err)
 					continue
 				}
+				s.checkBlock(types.L1BlockHash{BlockNo: latestL1Block, Hash: s.latestL1BlockHash})
 				s.lastCheckedL1Block.Store(latestL1Block)
 			}
 
@@ -135,17 +278,103 @@ func (s *L1Syncer) Run(lastCheckedBlock uint64) {
 }
 
 func (s *L1Syncer) getLatestL1Block() (uint64, error) {
-	latestBlock, err := s.em.BlockByNumber(context.Background(), nil)
+	latestBlock, err := s.em.BlockByNumber(context.Background(), s.finality)
 	if err != nil {
 		return 0, err
 	}
 
 	latest := latestBlock.NumberU64()
 	s.latestL1Block = latest
+	// the hash is read before the logs, a reorg after it is found by the next check
+	s.latestL1BlockHash = latestBlock.Hash()
 
 	return latest, nil
 }
 
+// checkBlock records the hash of a processed block and sends it to be kept
+func (s *L1Syncer) checkBlock(h types.L1BlockHash) {
+	if n := len(s.checkedHashes); n > 0 && s.checkedHashes[n-1].BlockNo >= h.BlockNo {
+		if s.checkedHashes[n-1].BlockNo == h.BlockNo {
+			return
+		}
+		// the blocks of the logs come in any order
+		i := sort.Search(n, func(i int) bool { return s.checkedHashes[i].BlockNo >= h.BlockNo })
+		if s.checkedHashes[i].BlockNo == h.BlockNo {
+			return
+		}
+		s.checkedHashes = append(s.checkedHashes[:i], append([]types.L1BlockHash{h}, s.checkedHashes[i:]...)...)
+	} else {
+		s.checkedHashes = append(s.checkedHashes, h)
+	}
+	if len(s.checkedHashes) > maxCheckedHashes {
+		s.checkedHashes = s.checkedHashes[len(s.checkedHashes)-maxCheckedHashes:]
+	}
+	s.blockHashesChan <- h
+}
+
+// findReorg checks the block after the highest processed one still has it as its parent. If not it walks back the
+// parents of that block to the highest processed block still among them, to go back to.
+func (s *L1Syncer) findReorg() (uint64, bool, error) {
+	if len(s.checkedHashes) == 0 {
+		return 0, false, nil
+	}
+
+	highest := s.checkedHashes[len(s.checkedHashes)-1]
+	next, err := s.em.BlockByNumber(context.Background(), new(big.Int).SetUint64(highest.BlockNo+1))
+	if err != nil {
+		return 0, false, err
+	}
+	if next.ParentHash() == highest.Hash {
+		return 0, false, nil
+	}
+
+	log.Warn("L1 reorg found", "block", highest.BlockNo, "hash", highest.Hash, "parentHash", next.ParentHash())
+	// the chain is followed by hash, the blocks read by number can be cached from before the reorg
+	hash := next.ParentHash()
+	i := len(s.checkedHashes) - 1
+	for blockNo := highest.BlockNo; ; blockNo-- {
+		for i >= 0 && s.checkedHashes[i].BlockNo > blockNo {
+			i--
+		}
+		if i < 0 {
+			break
+		}
+		if s.checkedHashes[i].BlockNo == blockNo && s.checkedHashes[i].Hash == hash {
+			return blockNo, true, nil
+		}
+		if blockNo == 0 {
+			break
+		}
+		header, err := s.em.HeaderByHash(context.Background(), hash)
+		if err != nil {
+			return 0, false, fmt.Errorf("L1 block %d %s: %w", blockNo, hash, err)
+		}
+		hash = header.ParentHash
+	}
+
+	// none of the blocks kept is still on L1, go back to before the lowest
+	lowest := s.checkedHashes[0].BlockNo
+	log.Error("L1 reorg deeper than the processed blocks kept", "lowestBlock", lowest)
+	if lowest == 0 {
+		return 0, true, nil
+	}
+	return lowest - 1, true, nil
+}
+
+// unwind goes back to the ancestor and waits for what was found after it to be removed, nothing found in the blocks
+// reorganised away is sent after the reorg
+func (s *L1Syncer) unwind(ancestor uint64) {
+	i := sort.Search(len(s.checkedHashes), func(i int) bool { return s.checkedHashes[i].BlockNo > ancestor })
+	s.checkedHashes = s.checkedHashes[:i]
+	if ancestor < s.lastCheckedL1Block.Load() {
+		s.lastCheckedL1Block.Store(ancestor)
+	}
+	log.Warn("Unwinding the L1 syncer", "block", ancestor)
+	reorg := types.L1Reorg{Ancestor: ancestor, Done: make(chan struct{})}
+	s.reorgsChan <- reorg
+	<-reorg.Done
+}
+
 func (s *L1Syncer) queryBlocks() error {
 	startBlock := s.lastCheckedL1Block.Load()
 
@@ -185,7 +414,11 @@ func (s *L1Syncer) queryBlocks() error {
 	}
 	close(jobs)
 
+	// the timestamps of the blocks of the events that need them
+	blockTimes := make(map[uint64]uint64)
+
 	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
 	var progress uint64 = 0
 	aimingFor := s.latestL1Block - startBlock
 	complete := 0
@@ -199,20 +432,11 @@ loop:
 				return res.Error
 			}
 			progress += res.Size
-			if len(res.Logs) > 0 {
-				for _, l := range res.Logs {
-					info := convertResultToBatchInfo(&l)
-					if l.Topics[0] == sequencedBatchTopic {
-						s.sequencesChan <- info
-					} else if l.Topics[0] == verificationTopic {
-
-						stateRootData := l.Data[:32]
-						stateRoot := common.BytesToHash(stateRootData)
-						info.StateRoot = stateRoot
-						s.verificationsChan <- info
-					} else {
-						log.Warn("L1 Syncer unknown topic", "topic", l.Topics[0])
-					}
+			for _, l := range res.Logs {
+				s.checkBlock(types.L1BlockHash{BlockNo: l.BlockNumber, Hash: l.BlockHash})
+				if err := s.processLog(l, blockTimes); err != nil {
+					close(stop)
+					return err
 				}
 			}
 
@@ -232,6 +456,449 @@ loop:
 	return nil
 }
 
+// processLog sends what an event is about to be kept
+func (s *L1Syncer) processLog(l ethTypes.Log, blockTimes map[uint64]uint64) error {
+	switch l.Topics[0] {
+	case sequencedBatchTopic, sequencedBatchEtrogTopic:
+		info := convertResultToBatchInfo(&l)
+		if err := s.processSequence(l, info.BatchNo, blockTimes); err != nil {
+			return err
+		}
+		s.sequencesChan <- info
+	case sequenceForceBatchTopic:
+		info := convertResultToBatchInfo(&l)
+		if s.readBatchMetadata {
+			// the forced batches are sequenced without their data, only the hash of the sequence is known. The event
+			// is the same from the etrog fork on, the contract tells which side of it the sequence is.
+			s.sendBatchMetadata(l, info.BatchNo, nil, s.isEtrogAt(l.BlockNumber))
+		}
+		s.sequencesChan <- info
+	case verificationTopic:
+		info := convertResultToBatchInfo(&l)
+		info.StateRoot = common.BytesToHash(l.Data[:32])
+		s.verificationsChan <- info
+	case forceBatchTopic:
+		forcedBatch, err := s.decodeForcedBatch(l, blockTimes)
+		if err != nil {
+			return fmt.Errorf("forced batch in L1 tx %s: %w", l.TxHash, err)
+		}
+		s.forcedBatchesChan <- *forcedBatch
+	case updateZkEVMVersionTopic:
+		update, err := zkevmEvents.ParseUpdateZkEVMVersion(l)
+		if err != nil {
+			return fmt.Errorf("fork id update in L1 tx %s: %w", l.TxHash, err)
+		}
+		s.forkIdsChan <- types.ForkIdUpdate{
+			BatchNo:   update.NumBatch,
+			ForkId:    update.ForkID,
+			Version:   update.Version,
+			L1BlockNo: l.BlockNumber,
+		}
+	case updateGlobalExitRootTopic:
+		update, err := gerManagerEvents.ParseUpdateGlobalExitRoot(l)
+		if err != nil {
+			return fmt.Errorf("global exit root update in L1 tx %s: %w", l.TxHash, err)
+		}
+		timestamp, err := s.blockTime(l.BlockNumber, blockTimes)
+		if err != nil {
+			return err
+		}
+		s.globalExitRootsChan <- types.L1GlobalExitRoot{
+			GlobalExitRoot:  crypto.Keccak256Hash(update.MainnetExitRoot[:], update.RollupExitRoot[:]),
+			MainnetExitRoot: update.MainnetExitRoot,
+			RollupExitRoot:  update.RollupExitRoot,
+			L1BlockNo:       l.BlockNumber,
+			Timestamp:       timestamp,
+		}
+	default:
+		log.Warn("L1 Syncer unknown topic", "topic", l.Topics[0])
+	}
+	return nil
+}
+
+// decodeForcedBatch reads a forced batch from its event, the transactions are left out of it when forced by an
+// account and are read from the tx then
+func (s *L1Syncer) decodeForcedBatch(l ethTypes.Log, blockTimes map[uint64]uint64) (*types.ForcedBatch, error) {
+	event, err := zkevmEvents.ParseForceBatch(l)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := s.blockTime(l.BlockNumber, blockTimes)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := event.Transactions
+	if len(transactions) == 0 {
+		args, err := s.txArgs(l.TxHash, "forceBatch")
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if transactions, ok = args[0].([]byte); !ok {
+			return nil, fmt.Errorf("unexpected transactions of forceBatch call")
+		}
+	}
+
+	return &types.ForcedBatch{
+		ForcedBatchNumber: event.ForceBatchNum,
+		L1BlockNo:         l.BlockNumber,
+		L1TxHash:          l.TxHash,
+		GlobalExitRoot:    event.LastGlobalExitRoot,
+		Sequencer:         event.Sequencer,
+		Timestamp:         timestamp,
+		Transactions:      transactions,
+	}, nil
+}
+
+// sequencedBatchInput is a batch as its sequence tx has it, with what its accumulated input hash is chained with
+type sequencedBatchInput struct {
+	types.L1BatchData
+	forcedBlockHashL1 common.Hash
+	etrog             bool
+}
+
+// accInputHash chains the batch onto the accumulated input hash of the batch before it
+func (b *sequencedBatchInput) accInputHash(oldAccInputHash common.Hash) common.Hash {
+	transactionsHash := crypto.Keccak256Hash(b.Transactions)
+	if b.etrog {
+		return utils.CalculateEtrogAccInputHash(oldAccInputHash, transactionsHash, b.GlobalExitRoot, b.Timestamp, b.Coinbase, b.forcedBlockHashL1)
+	}
+	return utils.CalculateAccInputHash(oldAccInputHash, transactionsHash, b.GlobalExitRoot, b.Timestamp, b.Coinbase)
+}
+
+// processSequence sends the data and the metadata of the batches of a sequence, the tx is only read when either is
+// asked for. Only the batches sequenced before the etrog fork are rebuilt from L1, a sequence after it whose tx can't
+// be read is still kept.
+func (s *L1Syncer) processSequence(l ethTypes.Log, lastBatchNo uint64, blockTimes map[uint64]uint64) error {
+	// the results of the block ranges are processed out of order, each sequence is of the fork its event is
+	etrog := l.Topics[0] == sequencedBatchEtrogTopic
+	readBatchData := s.readBatchData && !etrog
+	if !readBatchData && !s.readBatchMetadata {
+		return nil
+	}
+
+	batches, err := s.decodeSequencedBatches(l, lastBatchNo, blockTimes)
+	if err != nil {
+		if readBatchData {
+			return fmt.Errorf("sequence in L1 tx %s: %w", l.TxHash, err)
+		}
+		log.Warn("L1 Syncer failed to read the batches of a sequence", "tx", l.TxHash, "err", err)
+	}
+	if readBatchData {
+		for _, batch := range batches {
+			s.batchDataChan <- batch.L1BatchData
+		}
+	}
+	if s.readBatchMetadata {
+		s.sendBatchMetadata(l, lastBatchNo, batches, etrog)
+	}
+	return nil
+}
+
+// sendBatchMetadata sends the metadata of the batches of a sequence, of the etrog fork onwards if etrog is set. The
+// accumulated input hash of the last batch is the one the rollup contract keeps for the sequence, the ones of the
+// batches before it are chained onto the hash of the sequence before it and are only kept when the chain ends with the
+// hash of the contract.
+func (s *L1Syncer) sendBatchMetadata(l ethTypes.Log, lastBatchNo uint64, batches []sequencedBatchInput, etrog bool) {
+	sequence, err := s.sequencedBatch(lastBatchNo, l.BlockNumber, etrog)
+	if err != nil {
+		log.Warn("L1 Syncer failed to read the accumulated input hash of a sequence", "batch", lastBatchNo, "err", err)
+	}
+
+	metadata := make([]types.L1BatchMetadata, 0, len(batches))
+	for _, batch := range batches {
+		metadata = append(metadata, types.L1BatchMetadata{
+			BatchNo:           batch.BatchNo,
+			L1BlockNo:         batch.L1BlockNo,
+			L1TxHash:          batch.L1TxHash,
+			Sequencer:         batch.Coinbase,
+			GlobalExitRoot:    batch.GlobalExitRoot,
+			Timestamp:         batch.Timestamp,
+			TransactionsHash:  crypto.Keccak256Hash(batch.Transactions),
+			ForcedBlockHashL1: batch.forcedBlockHashL1,
+			Etrog:             batch.etrog,
+		})
+	}
+	if sequence == nil {
+		for _, m := range metadata {
+			s.batchMetadataChan <- m
+		}
+		return
+	}
+
+	// without the batches of the tx, the sequence is only known from the contract
+	if len(metadata) == 0 {
+		firstBatchNo := lastBatchNo
+		if sequence.PreviousLastBatchSequenced < lastBatchNo {
+			firstBatchNo = sequence.PreviousLastBatchSequenced + 1
+		}
+		for batchNo := firstBatchNo; batchNo <= lastBatchNo; batchNo++ {
+			metadata = append(metadata, types.L1BatchMetadata{BatchNo: batchNo, L1BlockNo: l.BlockNumber, L1TxHash: l.TxHash, Etrog: etrog})
+		}
+	}
+
+	if !s.chainAccInputHashes(metadata, batches, sequence, l.BlockNumber, etrog) {
+		for i := range metadata {
+			metadata[i].AccInputHash = common.Hash{}
+		}
+		metadata[len(metadata)-1].AccInputHash = sequence.AccInputHash
+	}
+	for _, m := range metadata {
+		s.batchMetadataChan <- m
+	}
+}
+
+// chainAccInputHashes chains the accumulated input hashes of the batches of a sequence onto the hash of the sequence
+// before it, it returns whether the chain ends with the hash the contract keeps for the sequence
+func (s *L1Syncer) chainAccInputHashes(metadata []types.L1BatchMetadata, batches []sequencedBatchInput, sequence *sequencedBatch, l1BlockNo uint64, etrog bool) bool {
+	if len(batches) == 0 || batches[0].BatchNo != sequence.PreviousLastBatchSequenced+1 {
+		return false
+	}
+	previous, err := s.sequencedBatch(sequence.PreviousLastBatchSequenced, l1BlockNo, etrog)
+	if err != nil {
+		log.Warn("L1 Syncer failed to read the accumulated input hash of a sequence", "batch", sequence.PreviousLastBatchSequenced, "err", err)
+		return false
+	}
+
+	accInputHash := common.Hash(previous.AccInputHash)
+	for i := range batches {
+		accInputHash = batches[i].accInputHash(accInputHash)
+		metadata[i].AccInputHash = accInputHash
+	}
+	if accInputHash != sequence.AccInputHash {
+		log.Warn("L1 Syncer chained accumulated input hash differs from the rollup contract", "batch", batches[len(batches)-1].BatchNo, "chained", accInputHash, "contract", common.Hash(sequence.AccInputHash))
+		return false
+	}
+	return true
+}
+
+// decodeSequencedBatches reads the batches of a sequence from its tx, the last of them is lastBatchNo. From the etrog
+// fork on the batches that weren't forced are chained with the l1 info root of the sequence and its timestamp limit,
+// the time of its L1 block before elderberry.
+func (s *L1Syncer) decodeSequencedBatches(l ethTypes.Log, lastBatchNo uint64, blockTimes map[uint64]uint64) ([]sequencedBatchInput, error) {
+	method, args, err := s.txCall(l.TxHash)
+	if err != nil {
+		return nil, err
+	}
+	if method.RawName != "sequenceBatches" {
+		return nil, fmt.Errorf("expected a sequenceBatches call, got %s", method.RawName)
+	}
+	coinbase, ok := args[len(args)-1].(common.Address)
+	if !ok {
+		return nil, fmt.Errorf("unexpected coinbase of sequenceBatches call")
+	}
+
+	var batches []sequencedBatchInput
+	if l.Topics[0] == sequencedBatchTopic {
+		for _, b := range *abi.ConvertType(args[0], new([]polygonzkevm.PolygonZkEVMBatchData)).(*[]polygonzkevm.PolygonZkEVMBatchData) {
+			batches = append(batches, sequencedBatchInput{L1BatchData: types.L1BatchData{
+				GlobalExitRoot: b.GlobalExitRoot,
+				Timestamp:      b.Timestamp,
+				Transactions:   b.Transactions,
+			}})
+		}
+	} else {
+		if len(l.Data) < 32 {
+			return nil, fmt.Errorf("no l1 info root in the sequence event")
+		}
+		l1InfoRoot := common.BytesToHash(l.Data[:32])
+		var timestampLimit uint64
+		if maxSequenceTimestamp, ok := args[1].(uint64); ok && len(args) == 4 {
+			timestampLimit = maxSequenceTimestamp
+		} else if timestampLimit, err = s.blockTime(l.BlockNumber, blockTimes); err != nil {
+			return nil, err
+		}
+
+		for _, b := range *abi.ConvertType(args[0], new([]etrogBatchData)).(*[]etrogBatchData) {
+			batch := sequencedBatchInput{L1BatchData: types.L1BatchData{Transactions: b.Transactions}, etrog: true}
+			if b.ForcedTimestamp > 0 {
+				batch.GlobalExitRoot, batch.Timestamp, batch.forcedBlockHashL1 = b.ForcedGlobalExitRoot, b.ForcedTimestamp, b.ForcedBlockHashL1
+			} else {
+				batch.GlobalExitRoot, batch.Timestamp = l1InfoRoot, timestampLimit
+			}
+			batches = append(batches, batch)
+		}
+	}
+	if uint64(len(batches)) > lastBatchNo {
+		return nil, fmt.Errorf("%d batches sequenced up to batch %d", len(batches), lastBatchNo)
+	}
+
+	firstBatchNo := lastBatchNo - uint64(len(batches)) + 1
+	for i := range batches {
+		batches[i].BatchNo = firstBatchNo + uint64(i)
+		batches[i].L1BlockNo = l.BlockNumber
+		batches[i].L1TxHash = l.TxHash
+		batches[i].Coinbase = coinbase
+	}
+	return batches, nil
+}
+
+// etrogBatchData is a batch of a sequenceBatches call from the etrog fork on
+type etrogBatchData struct {
+	Transactions         []byte
+	ForcedGlobalExitRoot [32]byte
+	ForcedTimestamp      uint64
+	ForcedBlockHashL1    [32]byte
+}
+
+// txArgs reads the arguments of the call of the rollup contract a tx made, it has to be a call of the given method
+func (s *L1Syncer) txArgs(txHash common.Hash, methodName string) ([]interface{}, error) {
+	method, args, err := s.txCall(txHash)
+	if err != nil {
+		return nil, err
+	}
+	if method.Name != methodName {
+		return nil, fmt.Errorf("expected a %s call, got %s", methodName, method.Name)
+	}
+	return args, nil
+}
+
+// txCall reads the method of the rollup contract a tx called and its arguments, of the contract before or from the
+// etrog fork on
+func (s *L1Syncer) txCall(txHash common.Hash) (*abi.Method, []interface{}, error) {
+	tx, _, err := s.em.TransactionByHash(context.Background(), txHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := tx.GetData()
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("no call data")
+	}
+	method, err := zkevmABI.MethodById(data[:4])
+	if err != nil {
+		if method, err = etrogABI.MethodById(data[:4]); err != nil {
+			return nil, nil, err
+		}
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return method, args, nil
+}
+
+// sequencedBatch is what the rollup contract keeps of a sequence, by its last batch
+type sequencedBatch struct {
+	AccInputHash               [32]byte
+	SequencedTimestamp         uint64
+	PreviousLastBatchSequenced uint64
+}
+
+// sequencedBatch reads what the rollup contract kept of the sequence ending with a batch at an L1 block, from the
+// rollup manager for a sequence of the etrog fork onwards
+func (s *L1Syncer) sequencedBatch(batchNo, l1BlockNo uint64, etrog bool) (*sequencedBatch, error) {
+	blockNumber := new(big.Int).SetUint64(l1BlockNo)
+	contract, contractABI, method := s.l1ContractAddress, zkevmABI, "sequencedBatches"
+	args := []interface{}{batchNo}
+	if etrog {
+		if s.rollupManager == nil {
+			if err := s.findRollupManager(blockNumber); err != nil {
+				return nil, err
+			}
+		}
+		contract, contractABI, method = *s.rollupManager, etrogABI, "getRollupSequencedBatches"
+		args = []interface{}{s.rollupId, batchNo}
+	}
+
+	out, err := s.call(contract, contractABI, method, blockNumber, args...)
+	if err != nil {
+		return nil, err
+	}
+	sequence := new(sequencedBatch)
+	if err := contractABI.UnpackIntoInterface(sequence, method, out); err != nil {
+		return nil, err
+	}
+	return sequence, nil
+}
+
+// isEtrogAt tells whether the rollup contract is past the etrog fork at an L1 block, only the rollup contracts from
+// the etrog fork on have a rollup manager
+func (s *L1Syncer) isEtrogAt(l1BlockNo uint64) bool {
+	_, err := s.call(s.l1ContractAddress, etrogABI, "rollupManager", new(big.Int).SetUint64(l1BlockNo))
+	return err == nil
+}
+
+// findRollupManager reads the rollup manager of the rollup contract and the id of the rollup on it
+func (s *L1Syncer) findRollupManager(blockNumber *big.Int) error {
+	out, err := s.call(s.l1ContractAddress, etrogABI, "rollupManager", blockNumber)
+	if err != nil {
+		return fmt.Errorf("rollup manager: %w", err)
+	}
+	var rollupManager common.Address
+	if err := etrogABI.UnpackIntoInterface(&rollupManager, "rollupManager", out); err != nil {
+		return fmt.Errorf("rollup manager: %w", err)
+	}
+
+	if out, err = s.call(rollupManager, etrogABI, "rollupAddressToID", blockNumber, s.l1ContractAddress); err != nil {
+		return fmt.Errorf("rollup id: %w", err)
+	}
+	if err := etrogABI.UnpackIntoInterface(&s.rollupId, "rollupAddressToID", out); err != nil {
+		return fmt.Errorf("rollup id: %w", err)
+	}
+	s.rollupManager = &rollupManager
+	return nil
+}
+
+// CheckL1SyncOnly returns why the blocks of the chain can't be rebuilt from the batches sequenced on L1, if they
+// can't. The batches of the etrog fork onwards and the forced batches aren't decoded, the rollup contract tells if
+// there are any of them.
+func (s *L1Syncer) CheckL1SyncOnly() error {
+	// only the rollup contracts from the etrog fork on have a rollup manager, the call reverts on the ones before
+	if out, err := s.call(s.l1ContractAddress, etrogABI, "rollupManager", nil); err == nil {
+		var rollupManager common.Address
+		if err := etrogABI.UnpackIntoInterface(&rollupManager, "rollupManager", out); err == nil && rollupManager != (common.Address{}) {
+			return fmt.Errorf("the rollup contract is past the etrog fork, its batches can't be rebuilt from L1")
+		}
+	}
+
+	out, err := s.call(s.l1ContractAddress, zkevmABI, "forkID", nil)
+	if err != nil {
+		return fmt.Errorf("fork id: %w", err)
+	}
+	var forkId uint64
+	if err := zkevmABI.UnpackIntoInterface(&forkId, "forkID", out); err != nil {
+		return fmt.Errorf("fork id: %w", err)
+	}
+	if forkId >= txtype.ForkIDEtrog {
+		return fmt.Errorf("the rollup contract is at fork id %d, the batches of the etrog fork onwards can't be rebuilt from L1", forkId)
+	}
+
+	if out, err = s.call(s.l1ContractAddress, zkevmABI, "lastForceBatch", nil); err != nil {
+		return fmt.Errorf("last forced batch: %w", err)
+	}
+	var lastForceBatch uint64
+	if err := zkevmABI.UnpackIntoInterface(&lastForceBatch, "lastForceBatch", out); err != nil {
+		return fmt.Errorf("last forced batch: %w", err)
+	}
+	if lastForceBatch > 0 {
+		return fmt.Errorf("%d batches were forced on the rollup contract, forced batches can't be rebuilt from L1", lastForceBatch)
+	}
+
+	return nil
+}
+
+// call calls a view method of an L1 contract at a block
+func (s *L1Syncer) call(contract common.Address, contractABI abi.ABI, method string, blockNumber *big.Int, args ...interface{}) ([]byte, error) {
+	data, err := contractABI.Pack(method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return s.em.CallContract(context.Background(), ethereum.CallMsg{To: &contract, Data: data}, blockNumber)
+}
+
+func (s *L1Syncer) blockTime(blockNo uint64, blockTimes map[uint64]uint64) (uint64, error) {
+	if t, ok := blockTimes[blockNo]; ok {
+		return t, nil
+	}
+	block, err := s.em.BlockByNumber(context.Background(), new(big.Int).SetUint64(blockNo))
+	if err != nil {
+		return 0, fmt.Errorf("L1 block %d: %w", blockNo, err)
+	}
+	blockTimes[blockNo] = block.Time()
+	return block.Time(), nil
+}
+
 func convertResultToBatchInfo(log *ethTypes.Log) types.L1BatchInfo {
 	batchNumber := new(big.Int).SetBytes(log.Topics[1].Bytes())
 	l1TxHash := common.BytesToHash(log.TxHash.Bytes())
@@ -255,8 +922,16 @@ func (s *L1Syncer) getSequencedLogs(jobs <-chan fetchJob, results chan jobResult
 			query := ethereum.FilterQuery{
 				FromBlock: big.NewInt(int64(j.From)),
 				ToBlock:   big.NewInt(int64(j.To)),
-				Addresses: []common.Address{s.l1ContractAddress},
-				Topics:    [][]common.Hash{{sequencedBatchTopic, verificationTopic}},
+				Addresses: []common.Address{s.l1ContractAddress, s.l1GERManagerAddress},
+				Topics: [][]common.Hash{{
+					sequencedBatchTopic,
+					sequencedBatchEtrogTopic,
+					verificationTopic,
+					forceBatchTopic,
+					sequenceForceBatchTopic,
+					updateZkEVMVersionTopic,
+					updateGlobalExitRootTopic,
+				}},
 			}
 
 			var logs []ethTypes.Log
@@ -0,0 +1,577 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	ethereum "github.com/tenderly/zkevm-erigon"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	ethTypes "github.com/tenderly/zkevm-erigon/core/types"
+	"github.com/tenderly/zkevm-erigon/crypto"
+	"github.com/tenderly/zkevm-erigon/rpc"
+	"github.com/tenderly/zkevm-erigon/zk/types"
+	"github.com/tenderly/zkevm-erigon/zk/utils"
+	"github.com/tenderly/zkevm-erigon/zkevm/etherman/smartcontracts/polygonzkevm"
+)
+
+// testEtherman serves the blocks of a chain, the latest one for a nil number. The cached blocks are served by
+// number instead of the ones of the chain. The rollup contract keeps the sequences by their last batch, through the
+// rollup manager once it is set.
+type testEtherman struct {
+	blocks        []*ethTypes.Block
+	cached        map[uint64]*ethTypes.Block
+	txs           map[common.Hash]ethTypes.Transaction
+	sequences     map[uint64]sequencedBatch
+	rollupManager *common.Address
+	// the L1 block the rollup contract was upgraded to the etrog fork at, it has the rollup manager from then on
+	etrogFrom uint64
+	// the fork id and the last forced batch of a rollup contract before the etrog fork
+	forkId         uint64
+	lastForceBatch uint64
+}
+
+func (em *testEtherman) BlockByNumber(_ context.Context, blockNumber *big.Int) (*ethTypes.Block, error) {
+	if blockNumber == nil {
+		return em.blocks[len(em.blocks)-1], nil
+	}
+	n := blockNumber.Uint64()
+	if block, ok := em.cached[n]; ok {
+		return block, nil
+	}
+	if n >= uint64(len(em.blocks)) {
+		return nil, fmt.Errorf("block %d not found", n)
+	}
+	return em.blocks[n], nil
+}
+
+func (em *testEtherman) HeaderByHash(_ context.Context, hash common.Hash) (*ethTypes.Header, error) {
+	for _, block := range em.blocks {
+		if block.Hash() == hash {
+			return block.Header(), nil
+		}
+	}
+	return nil, fmt.Errorf("block %s not found", hash)
+}
+
+func (em *testEtherman) FilterLogs(context.Context, ethereum.FilterQuery) ([]ethTypes.Log, error) {
+	return nil, nil
+}
+
+func (em *testEtherman) TransactionByHash(_ context.Context, hash common.Hash) (ethTypes.Transaction, bool, error) {
+	tx, ok := em.txs[hash]
+	if !ok {
+		return nil, false, fmt.Errorf("tx %s not found", hash)
+	}
+	return tx, false, nil
+}
+
+func (em *testEtherman) CallContract(_ context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	etrog := em.rollupManager != nil && (blockNumber == nil || blockNumber.Uint64() >= em.etrogFrom)
+
+	contractABI := zkevmABI
+	method, err := contractABI.MethodById(msg.Data[:4])
+	if err != nil {
+		contractABI = etrogABI
+		if method, err = contractABI.MethodById(msg.Data[:4]); err != nil {
+			return nil, err
+		}
+	}
+	args, err := method.Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	var batchNo uint64
+	switch method.Name {
+	case "rollupManager":
+		if !etrog {
+			return nil, fmt.Errorf("execution reverted")
+		}
+		return method.Outputs.Pack(*em.rollupManager)
+	case "rollupAddressToID":
+		return method.Outputs.Pack(uint32(1))
+	case "forkID", "lastForceBatch":
+		if etrog {
+			return nil, fmt.Errorf("execution reverted")
+		}
+		if method.Name == "forkID" {
+			return method.Outputs.Pack(em.forkId)
+		}
+		return method.Outputs.Pack(em.lastForceBatch)
+	case "sequencedBatches":
+		if etrog {
+			return nil, fmt.Errorf("execution reverted")
+		}
+		batchNo = args[0].(uint64)
+	case "getRollupSequencedBatches":
+		if !etrog || *msg.To != *em.rollupManager || args[0].(uint32) != 1 {
+			return nil, fmt.Errorf("execution reverted")
+		}
+		batchNo = args[1].(uint64)
+	default:
+		return nil, fmt.Errorf("unexpected call of %s", method.Name)
+	}
+	sequence, ok := em.sequences[batchNo]
+	if !ok {
+		return nil, fmt.Errorf("no sequence ending with batch %d", batchNo)
+	}
+	return method.Outputs.Pack(sequence.AccInputHash, sequence.SequencedTimestamp, sequence.PreviousLastBatchSequenced)
+}
+
+// testChain builds a chain of length blocks, forking from base after its block forkAt
+func testChain(base []*ethTypes.Block, forkAt uint64, length uint64) []*ethTypes.Block {
+	blocks := make([]*ethTypes.Block, 0, length)
+	for i := uint64(0); i < length; i++ {
+		if base != nil && i <= forkAt {
+			blocks = append(blocks, base[i])
+			continue
+		}
+		header := &ethTypes.Header{Number: new(big.Int).SetUint64(i), Time: i * 12, Extra: []byte(fmt.Sprintf("fork %d", forkAt))}
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		}
+		blocks = append(blocks, ethTypes.NewBlockWithHeader(header))
+	}
+	return blocks
+}
+
+func checked(blocks []*ethTypes.Block, blockNos ...uint64) []types.L1BlockHash {
+	hashes := make([]types.L1BlockHash, 0, len(blockNos))
+	for _, n := range blockNos {
+		hashes = append(hashes, types.L1BlockHash{BlockNo: n, Hash: blocks[n].Hash()})
+	}
+	return hashes
+}
+
+func TestL1Syncer_FindReorg(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	em := &testEtherman{blocks: chain}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, false, false)
+	s.checkedHashes = checked(chain, 5, 8, 12)
+
+	_, reorged, err := s.findReorg()
+	require.NoError(t, err)
+	require.False(t, reorged)
+
+	// L1 forks after block 9, the highest block processed before it is 8
+	em.blocks = testChain(chain, 9, 25)
+	ancestor, reorged, err := s.findReorg()
+	require.NoError(t, err)
+	require.True(t, reorged)
+	require.Equal(t, uint64(8), ancestor)
+
+	// the blocks read by number can be from before the reorg, the ancestor is found along the parents
+	em.blocks = testChain(chain, 6, 25)
+	em.cached = map[uint64]*ethTypes.Block{8: chain[8]}
+	ancestor, reorged, err = s.findReorg()
+	require.NoError(t, err)
+	require.True(t, reorged)
+	require.Equal(t, uint64(5), ancestor)
+	em.cached = nil
+
+	// a fork below all the blocks kept goes back to before the lowest
+	em.blocks = testChain(chain, 2, 25)
+	ancestor, reorged, err = s.findReorg()
+	require.NoError(t, err)
+	require.True(t, reorged)
+	require.Equal(t, uint64(4), ancestor)
+}
+
+func TestL1Syncer_Unwind(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	s := NewL1Syncer(&testEtherman{blocks: chain}, common.Address{}, common.Address{}, 10, 0, nil, false, false)
+	s.checkedHashes = checked(chain, 5, 8, 12)
+	s.lastCheckedL1Block.Store(12)
+	s.sequencesChan <- types.L1BatchInfo{BatchNo: 1, L1BlockNo: 12}
+
+	unwound := make(chan struct{})
+	go func() {
+		s.unwind(8)
+		close(unwound)
+	}()
+
+	// the reorg comes after what was found before it, the syncer waits for it to be done
+	reorg := <-s.reorgsChan
+	require.Equal(t, uint64(8), reorg.Ancestor)
+	require.Equal(t, uint64(1), (<-s.sequencesChan).BatchNo)
+	select {
+	case <-unwound:
+		t.Fatal("unwound before the reorg was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(reorg.Done)
+	<-unwound
+
+	require.Equal(t, uint64(8), s.GetLastCheckedL1Block())
+	require.Equal(t, checked(chain, 5, 8), s.checkedHashes)
+}
+
+func TestL1Syncer_CheckBlock(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	s := NewL1Syncer(&testEtherman{blocks: chain}, common.Address{}, common.Address{}, 10, 0, nil, false, false)
+
+	// the blocks of the logs come in any order
+	for _, h := range checked(chain, 10, 4, 7, 10, 15) {
+		s.checkBlock(h)
+	}
+	require.Equal(t, checked(chain, 4, 7, 10, 15), s.checkedHashes)
+
+	sent := make([]types.L1BlockHash, 0)
+	for len(s.blockHashesChan) > 0 {
+		sent = append(sent, <-s.blockHashesChan)
+	}
+	require.Equal(t, checked(chain, 10, 4, 7, 15), sent)
+}
+
+func TestL1Syncer_ProcessLog(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	em := &testEtherman{blocks: chain, txs: make(map[common.Hash]ethTypes.Transaction)}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, false, false)
+	blockTimes := map[uint64]uint64{3: 1000}
+
+	ger := common.HexToHash("0x1")
+	sequencer := common.HexToAddress("0x2")
+	forceBatch := zkevmABI.Events["ForceBatch"].Inputs.NonIndexed()
+
+	// forced by the sequencer, the transactions are in the event
+	data, err := forceBatch.Pack(ger, sequencer, []byte{0xaa})
+	require.NoError(t, err)
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{forceBatchTopic, common.BigToHash(big.NewInt(1))},
+		Data:        data,
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x10"),
+	}, blockTimes))
+	require.Equal(t, types.ForcedBatch{
+		ForcedBatchNumber: 1,
+		L1BlockNo:         3,
+		L1TxHash:          common.HexToHash("0x10"),
+		GlobalExitRoot:    ger,
+		Sequencer:         sequencer,
+		Timestamp:         1000,
+		Transactions:      []byte{0xaa},
+	}, <-s.GetForcedBatchesChan())
+
+	// forced by an account, the transactions are read from the tx
+	data, err = forceBatch.Pack(ger, sequencer, []byte{})
+	require.NoError(t, err)
+	callData, err := zkevmABI.Pack("forceBatch", []byte{0xbb, 0xcc}, big.NewInt(0))
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x11")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{forceBatchTopic, common.BigToHash(big.NewInt(2))},
+		Data:        data,
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x11"),
+	}, blockTimes))
+	require.Equal(t, []byte{0xbb, 0xcc}, (<-s.GetForcedBatchesChan()).Transactions)
+
+	data, err = zkevmABI.Events["UpdateZkEVMVersion"].Inputs.Pack(uint64(100), uint64(6), "v1")
+	require.NoError(t, err)
+	require.NoError(t, s.processLog(ethTypes.Log{Topics: []common.Hash{updateZkEVMVersionTopic}, Data: data, BlockNumber: 4}, blockTimes))
+	require.Equal(t, types.ForkIdUpdate{BatchNo: 100, ForkId: 6, Version: "v1", L1BlockNo: 4}, <-s.GetForkIdsChan())
+
+	// the timestamp of a block not seen yet is read from L1
+	mainnet, rollup := common.HexToHash("0x3"), common.HexToHash("0x4")
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{updateGlobalExitRootTopic, mainnet, rollup},
+		BlockNumber: 5,
+	}, blockTimes))
+	require.Equal(t, types.L1GlobalExitRoot{
+		GlobalExitRoot:  crypto.Keccak256Hash(mainnet[:], rollup[:]),
+		MainnetExitRoot: mainnet,
+		RollupExitRoot:  rollup,
+		L1BlockNo:       5,
+		Timestamp:       chain[5].Time(),
+	}, <-s.GetGlobalExitRootsChan())
+	require.Equal(t, chain[5].Time(), blockTimes[5])
+
+	require.NoError(t, s.processLog(ethTypes.Log{Topics: []common.Hash{sequenceForceBatchTopic, common.BigToHash(big.NewInt(7))}, BlockNumber: 6}, blockTimes))
+	require.Equal(t, uint64(7), (<-s.GetSequencesChan()).BatchNo)
+}
+
+func TestL1Syncer_SequencedBatchData(t *testing.T) {
+	em := &testEtherman{blocks: testChain(nil, 0, 20), txs: make(map[common.Hash]ethTypes.Transaction)}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, true, false)
+
+	coinbase := common.HexToAddress("0x2")
+	callData, err := zkevmABI.Pack("sequenceBatches", []polygonzkevm.PolygonZkEVMBatchData{
+		{Transactions: []byte{0xaa}, GlobalExitRoot: common.HexToHash("0x1"), Timestamp: 100},
+		{Transactions: []byte{0xbb}, Timestamp: 200},
+	}, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x10")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+
+	// the event has the last batch of the sequence
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{sequencedBatchTopic, common.BigToHash(big.NewInt(8))},
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x10"),
+	}, map[uint64]uint64{}))
+	require.Equal(t, types.L1BatchData{
+		BatchNo:        7,
+		L1BlockNo:      3,
+		L1TxHash:       common.HexToHash("0x10"),
+		Coinbase:       coinbase,
+		GlobalExitRoot: common.HexToHash("0x1"),
+		Timestamp:      100,
+		Transactions:   []byte{0xaa},
+	}, <-s.GetBatchDataChan())
+	require.Equal(t, uint64(8), (<-s.GetBatchDataChan()).BatchNo)
+	require.Equal(t, uint64(8), (<-s.GetSequencesChan()).BatchNo)
+	// the metadata is only read when asked for
+	require.Empty(t, s.GetBatchMetadataChan())
+
+	// a sequence of forced batches has no data to read
+	require.NoError(t, s.processLog(ethTypes.Log{Topics: []common.Hash{sequenceForceBatchTopic, common.BigToHash(big.NewInt(9))}, BlockNumber: 4}, map[uint64]uint64{}))
+	require.Equal(t, uint64(9), (<-s.GetSequencesChan()).BatchNo)
+	require.Empty(t, s.GetBatchDataChan())
+}
+
+func TestL1Syncer_SequencedBatchMetadata(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	em := &testEtherman{blocks: chain, txs: make(map[common.Hash]ethTypes.Transaction), sequences: make(map[uint64]sequencedBatch)}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, false, true)
+	blockTimes := map[uint64]uint64{}
+	coinbase := common.HexToAddress("0x2")
+
+	sequence := func(topic common.Hash, lastBatchNo uint64, txHash common.Hash, blockNo uint64, data []byte) {
+		require.NoError(t, s.processLog(ethTypes.Log{
+			Topics:      []common.Hash{topic, common.BigToHash(new(big.Int).SetUint64(lastBatchNo))},
+			Data:        data,
+			BlockNumber: blockNo,
+			TxHash:      txHash,
+		}, blockTimes))
+		require.Equal(t, lastBatchNo, (<-s.GetSequencesChan()).BatchNo)
+	}
+	metadata := func() types.L1BatchMetadata {
+		return <-s.GetBatchMetadataChan()
+	}
+
+	// the hashes of a sequence are chained onto the one the contract has for the sequence before it
+	batches := []polygonzkevm.PolygonZkEVMBatchData{
+		{Transactions: []byte{0xaa}, GlobalExitRoot: common.HexToHash("0x1"), Timestamp: 100},
+		{Transactions: []byte{0xbb}, Timestamp: 200},
+	}
+	callData, err := zkevmABI.Pack("sequenceBatches", batches, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x10")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash7 := utils.CalculateAccInputHash(common.HexToHash("0x6"), crypto.Keccak256Hash([]byte{0xaa}), common.HexToHash("0x1"), 100, coinbase)
+	hash8 := utils.CalculateAccInputHash(hash7, crypto.Keccak256Hash([]byte{0xbb}), common.Hash{}, 200, coinbase)
+	em.sequences[6] = sequencedBatch{AccInputHash: common.HexToHash("0x6"), PreviousLastBatchSequenced: 4}
+	em.sequences[8] = sequencedBatch{AccInputHash: hash8, PreviousLastBatchSequenced: 6}
+	sequence(sequencedBatchTopic, 8, common.HexToHash("0x10"), 3, nil)
+	require.Equal(t, types.L1BatchMetadata{
+		BatchNo:          7,
+		L1BlockNo:        3,
+		L1TxHash:         common.HexToHash("0x10"),
+		Sequencer:        coinbase,
+		GlobalExitRoot:   common.HexToHash("0x1"),
+		Timestamp:        100,
+		TransactionsHash: crypto.Keccak256Hash([]byte{0xaa}),
+		AccInputHash:     hash7,
+	}, metadata())
+	require.Equal(t, hash8, metadata().AccInputHash)
+	require.Empty(t, s.GetBatchDataChan())
+
+	// a chain that doesn't end with the hash of the contract is only kept for the last batch, as the contract has it
+	callData, err = zkevmABI.Pack("sequenceBatches", batches, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x11")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	em.sequences[10] = sequencedBatch{AccInputHash: common.HexToHash("0xa"), PreviousLastBatchSequenced: 8}
+	sequence(sequencedBatchTopic, 10, common.HexToHash("0x11"), 3, nil)
+	require.Equal(t, common.Hash{}, metadata().AccInputHash)
+	require.Equal(t, common.HexToHash("0xa"), metadata().AccInputHash)
+
+	// the batches after a sequence of forced batches are chained onto the hash the contract has for it
+	em.sequences[11] = sequencedBatch{AccInputHash: common.HexToHash("0xb"), PreviousLastBatchSequenced: 10}
+	sequence(sequenceForceBatchTopic, 11, common.HexToHash("0x12"), 4, nil)
+	require.Equal(t, types.L1BatchMetadata{BatchNo: 11, L1BlockNo: 4, L1TxHash: common.HexToHash("0x12"), AccInputHash: common.HexToHash("0xb")}, metadata())
+
+	callData, err = zkevmABI.Pack("sequenceBatches", batches[:1], coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x13")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash12 := utils.CalculateAccInputHash(common.HexToHash("0xb"), crypto.Keccak256Hash([]byte{0xaa}), common.HexToHash("0x1"), 100, coinbase)
+	em.sequences[12] = sequencedBatch{AccInputHash: hash12, PreviousLastBatchSequenced: 11}
+	sequence(sequencedBatchTopic, 12, common.HexToHash("0x13"), 4, nil)
+	require.Equal(t, hash12, metadata().AccInputHash)
+
+	// a sequence whose tx can't be read still has the hash of the contract
+	em.sequences[13] = sequencedBatch{AccInputHash: common.HexToHash("0xd"), PreviousLastBatchSequenced: 12}
+	sequence(sequencedBatchTopic, 13, common.HexToHash("0x14"), 4, nil)
+	require.Equal(t, types.L1BatchMetadata{BatchNo: 13, L1BlockNo: 4, L1TxHash: common.HexToHash("0x14"), AccInputHash: common.HexToHash("0xd")}, metadata())
+
+	// from the etrog fork on the sequences are kept by the rollup manager, the batches are chained with the l1 info root
+	// and the time of the block of the sequence unless they were forced
+	rollupManager := common.HexToAddress("0x100")
+	em.rollupManager = &rollupManager
+	l1InfoRoot := common.HexToHash("0x20")
+	forcedBlockHash := common.HexToHash("0x21")
+	etrogBatches := []etrogBatchData{
+		{Transactions: []byte{0xcc}},
+		{Transactions: []byte{0xdd}, ForcedGlobalExitRoot: common.HexToHash("0x22"), ForcedTimestamp: 300, ForcedBlockHashL1: forcedBlockHash},
+	}
+	callData, err = etrogABI.Pack("sequenceBatches", etrogBatches, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x15")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash14 := utils.CalculateEtrogAccInputHash(common.HexToHash("0xd"), crypto.Keccak256Hash([]byte{0xcc}), l1InfoRoot, chain[5].Time(), coinbase, common.Hash{})
+	hash15 := utils.CalculateEtrogAccInputHash(hash14, crypto.Keccak256Hash([]byte{0xdd}), common.HexToHash("0x22"), 300, coinbase, forcedBlockHash)
+	em.sequences[15] = sequencedBatch{AccInputHash: hash15, PreviousLastBatchSequenced: 13}
+	sequence(sequencedBatchEtrogTopic, 15, common.HexToHash("0x15"), 5, l1InfoRoot[:])
+	require.Equal(t, types.L1BatchMetadata{
+		BatchNo:          14,
+		L1BlockNo:        5,
+		L1TxHash:         common.HexToHash("0x15"),
+		Sequencer:        coinbase,
+		GlobalExitRoot:   l1InfoRoot,
+		Timestamp:        chain[5].Time(),
+		TransactionsHash: crypto.Keccak256Hash([]byte{0xcc}),
+		AccInputHash:     hash14,
+		Etrog:            true,
+	}, metadata())
+	require.Equal(t, types.L1BatchMetadata{
+		BatchNo:           15,
+		L1BlockNo:         5,
+		L1TxHash:          common.HexToHash("0x15"),
+		Sequencer:         coinbase,
+		GlobalExitRoot:    common.HexToHash("0x22"),
+		Timestamp:         300,
+		TransactionsHash:  crypto.Keccak256Hash([]byte{0xdd}),
+		AccInputHash:      hash15,
+		ForcedBlockHashL1: forcedBlockHash,
+		Etrog:             true,
+	}, metadata())
+
+	// from elderberry on with the timestamp limit of the sequence
+	callData, err = etrogABI.Pack("sequenceBatches0", etrogBatches[:1], uint64(400), uint64(15), coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x16")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash16 := utils.CalculateEtrogAccInputHash(hash15, crypto.Keccak256Hash([]byte{0xcc}), l1InfoRoot, 400, coinbase, common.Hash{})
+	em.sequences[16] = sequencedBatch{AccInputHash: hash16, PreviousLastBatchSequenced: 15}
+	sequence(sequencedBatchEtrogTopic, 16, common.HexToHash("0x16"), 6, l1InfoRoot[:])
+	batch16 := metadata()
+	require.Equal(t, uint64(400), batch16.Timestamp)
+	require.Equal(t, hash16, batch16.AccInputHash)
+
+	// nothing is known of a sequence neither its tx nor the contract can be read for
+	sequence(sequencedBatchEtrogTopic, 17, common.HexToHash("0x17"), 6, l1InfoRoot[:])
+	require.Empty(t, s.GetBatchMetadataChan())
+	require.Empty(t, s.GetBatchDataChan())
+}
+
+func TestL1Syncer_SequencedBatchMetadataAcrossEtrog(t *testing.T) {
+	chain := testChain(nil, 0, 20)
+	rollupManager := common.HexToAddress("0x100")
+	em := &testEtherman{blocks: chain, txs: make(map[common.Hash]ethTypes.Transaction), sequences: make(map[uint64]sequencedBatch), rollupManager: &rollupManager, etrogFrom: 5}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, false, true)
+	blockTimes := map[uint64]uint64{}
+	coinbase := common.HexToAddress("0x2")
+	l1InfoRoot := common.HexToHash("0x20")
+
+	sequence := func(topic common.Hash, lastBatchNo uint64, txHash common.Hash, blockNo uint64, data []byte) types.L1BatchMetadata {
+		require.NoError(t, s.processLog(ethTypes.Log{
+			Topics:      []common.Hash{topic, common.BigToHash(new(big.Int).SetUint64(lastBatchNo))},
+			Data:        data,
+			BlockNumber: blockNo,
+			TxHash:      txHash,
+		}, blockTimes))
+		require.Equal(t, lastBatchNo, (<-s.GetSequencesChan()).BatchNo)
+		return <-s.GetBatchMetadataChan()
+	}
+
+	callData, err := zkevmABI.Pack("sequenceBatches", []polygonzkevm.PolygonZkEVMBatchData{{Transactions: []byte{0xaa}, GlobalExitRoot: common.HexToHash("0x1"), Timestamp: 100}}, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x10")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash2 := utils.CalculateAccInputHash(common.HexToHash("0x1"), crypto.Keccak256Hash([]byte{0xaa}), common.HexToHash("0x1"), 100, coinbase)
+	em.sequences[1] = sequencedBatch{AccInputHash: common.HexToHash("0x1")}
+	em.sequences[2] = sequencedBatch{AccInputHash: hash2, PreviousLastBatchSequenced: 1}
+
+	callData, err = etrogABI.Pack("sequenceBatches", []etrogBatchData{{Transactions: []byte{0xcc}}}, coinbase)
+	require.NoError(t, err)
+	em.txs[common.HexToHash("0x11")] = ethTypes.NewTransaction(0, common.Address{}, nil, 0, nil, callData)
+	hash3 := utils.CalculateEtrogAccInputHash(hash2, crypto.Keccak256Hash([]byte{0xcc}), l1InfoRoot, chain[6].Time(), coinbase, common.Hash{})
+	em.sequences[3] = sequencedBatch{AccInputHash: hash3, PreviousLastBatchSequenced: 2}
+	em.sequences[4] = sequencedBatch{AccInputHash: common.HexToHash("0x4"), PreviousLastBatchSequenced: 3}
+	em.sequences[5] = sequencedBatch{AccInputHash: common.HexToHash("0x5"), PreviousLastBatchSequenced: 4}
+
+	// the block ranges are processed out of order, a sequence of the etrog fork can come before the ones before it
+	batch3 := sequence(sequencedBatchEtrogTopic, 3, common.HexToHash("0x11"), 6, l1InfoRoot[:])
+	require.True(t, batch3.Etrog)
+	require.Equal(t, hash3, batch3.AccInputHash)
+
+	batch2 := sequence(sequencedBatchTopic, 2, common.HexToHash("0x10"), 3, nil)
+	require.False(t, batch2.Etrog)
+	require.Equal(t, hash2, batch2.AccInputHash)
+
+	// the sequences of forced batches have the same event on both sides of the fork
+	require.Equal(t, types.L1BatchMetadata{BatchNo: 4, L1BlockNo: 7, L1TxHash: common.HexToHash("0x12"), AccInputHash: common.HexToHash("0x4"), Etrog: true},
+		sequence(sequenceForceBatchTopic, 4, common.HexToHash("0x12"), 7, nil))
+	require.Equal(t, types.L1BatchMetadata{BatchNo: 5, L1BlockNo: 4, L1TxHash: common.HexToHash("0x13"), AccInputHash: common.HexToHash("0x5")},
+		sequence(sequenceForceBatchTopic, 5, common.HexToHash("0x13"), 4, nil))
+}
+
+func TestL1Syncer_SequencedBatchesNotRead(t *testing.T) {
+	em := &testEtherman{blocks: testChain(nil, 0, 20), txs: make(map[common.Hash]ethTypes.Transaction)}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, false, false)
+
+	// neither the tx nor the contract are read without the data or the metadata being asked for
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{sequencedBatchTopic, common.BigToHash(big.NewInt(1))},
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x10"),
+	}, map[uint64]uint64{}))
+	require.Equal(t, uint64(1), (<-s.GetSequencesChan()).BatchNo)
+	require.Empty(t, s.GetBatchMetadataChan())
+
+	// only the batches sequenced before etrog are rebuilt from L1, an etrog sequence whose tx can't be read is kept
+	s = NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, true, false)
+	require.NoError(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{sequencedBatchEtrogTopic, common.BigToHash(big.NewInt(2))},
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x11"),
+	}, map[uint64]uint64{}))
+	require.Equal(t, uint64(2), (<-s.GetSequencesChan()).BatchNo)
+	require.Empty(t, s.GetBatchDataChan())
+	require.Error(t, s.processLog(ethTypes.Log{
+		Topics:      []common.Hash{sequencedBatchTopic, common.BigToHash(big.NewInt(3))},
+		BlockNumber: 3,
+		TxHash:      common.HexToHash("0x12"),
+	}, map[uint64]uint64{}))
+}
+
+func TestL1FinalityBlockNumber(t *testing.T) {
+	for finality, expected := range map[string]*big.Int{
+		"":                  nil,
+		L1FinalityLatest:    nil,
+		L1FinalitySafe:      big.NewInt(int64(rpc.SafeBlockNumber)),
+		L1FinalityFinalized: big.NewInt(int64(rpc.FinalizedBlockNumber)),
+	} {
+		n, err := L1FinalityBlockNumber(finality)
+		require.NoError(t, err)
+		require.Equal(t, expected, n, finality)
+	}
+
+	_, err := L1FinalityBlockNumber("pending")
+	require.Error(t, err)
+}
+
+func TestL1Syncer_CheckL1SyncOnly(t *testing.T) {
+	em := &testEtherman{forkId: 6}
+	s := NewL1Syncer(em, common.Address{}, common.Address{}, 10, 0, nil, true, false)
+	require.NoError(t, s.CheckL1SyncOnly())
+
+	em.lastForceBatch = 1
+	require.ErrorContains(t, s.CheckL1SyncOnly(), "forced batches")
+
+	em.lastForceBatch, em.forkId = 0, 7
+	require.ErrorContains(t, s.CheckL1SyncOnly(), "fork id 7")
+
+	rollupManager := common.HexToAddress("0x1")
+	em.rollupManager = &rollupManager
+	require.ErrorContains(t, s.CheckL1SyncOnly(), "past the etrog fork")
+}
@@ -0,0 +1,75 @@
+package syncer
+
+// rollupEtrogABI has the calls of the rollup contract from the etrog fork on, which the bindings of the contract
+// before it don't have, and the ones of the rollup manager the contract is sequenced through. The sequenceBatches of
+// elderberry is overloaded as sequenceBatches0.
+const rollupEtrogABI = `[
+	{
+		"name": "sequenceBatches",
+		"type": "function",
+		"stateMutability": "nonpayable",
+		"inputs": [
+			{
+				"name": "batches",
+				"type": "tuple[]",
+				"components": [
+					{"name": "transactions", "type": "bytes"},
+					{"name": "forcedGlobalExitRoot", "type": "bytes32"},
+					{"name": "forcedTimestamp", "type": "uint64"},
+					{"name": "forcedBlockHashL1", "type": "bytes32"}
+				]
+			},
+			{"name": "l2Coinbase", "type": "address"}
+		],
+		"outputs": []
+	},
+	{
+		"name": "sequenceBatches",
+		"type": "function",
+		"stateMutability": "nonpayable",
+		"inputs": [
+			{
+				"name": "batches",
+				"type": "tuple[]",
+				"components": [
+					{"name": "transactions", "type": "bytes"},
+					{"name": "forcedGlobalExitRoot", "type": "bytes32"},
+					{"name": "forcedTimestamp", "type": "uint64"},
+					{"name": "forcedBlockHashL1", "type": "bytes32"}
+				]
+			},
+			{"name": "maxSequenceTimestamp", "type": "uint64"},
+			{"name": "initSequencedBatch", "type": "uint64"},
+			{"name": "l2Coinbase", "type": "address"}
+		],
+		"outputs": []
+	},
+	{
+		"name": "rollupManager",
+		"type": "function",
+		"stateMutability": "view",
+		"inputs": [],
+		"outputs": [{"name": "", "type": "address"}]
+	},
+	{
+		"name": "rollupAddressToID",
+		"type": "function",
+		"stateMutability": "view",
+		"inputs": [{"name": "rollupAddress", "type": "address"}],
+		"outputs": [{"name": "", "type": "uint32"}]
+	},
+	{
+		"name": "getRollupSequencedBatches",
+		"type": "function",
+		"stateMutability": "view",
+		"inputs": [
+			{"name": "rollupID", "type": "uint32"},
+			{"name": "batchNum", "type": "uint64"}
+		],
+		"outputs": [
+			{"name": "accInputHash", "type": "bytes32"},
+			{"name": "sequencedTimestamp", "type": "uint64"},
+			{"name": "previousLastBatchSequenced", "type": "uint64"}
+		]
+	}
+]`
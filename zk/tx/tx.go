@@ -22,6 +22,9 @@ const (
 	double       = 2
 	ether155V    = 27
 	etherPre155V = 35
+	// ForkIDEtrog is the fork from which the l2 data of a batch has a changeL2Block entry before the transactions of
+	// each of its blocks, which EncodeTx doesn't write
+	ForkIDEtrog = 7
 	// MaxEffectivePercentage is the maximum value that can be used as effective percentage
 	MaxEffectivePercentage = uint8(255)
 	// Decoding constants
@@ -231,3 +234,47 @@ func rlpFieldsToLegacyTx(fields [][]byte, v, r, s []byte) (tx *types.LegacyTx, e
 		GasPrice: gasPriceI,
 	}, nil
 }
+
+// EncodeTx encodes a transaction as in the l2 data of a batch, the inverse of DecodeTxs: the rlp of its fields without
+// the signature, with the chain id for an eip155 one, followed by r, s, v and from fork id 5 the effective gas price
+// percentage. Only legacy transactions are found in batches.
+func EncodeTx(tx types.Transaction, efficiencyPercentage uint8, forkId uint16) ([]byte, error) {
+	legacyTx, ok := tx.(*types.LegacyTx)
+	if !ok {
+		return nil, types.ErrTxTypeNotSupported
+	}
+
+	var to []byte
+	if legacyTx.To != nil {
+		to = legacyTx.To.Bytes()
+	}
+	fields := []interface{}{
+		legacyTx.Nonce,
+		legacyTx.GasPrice.ToBig(),
+		legacyTx.Gas,
+		to,
+		legacyTx.Value.ToBig(),
+		legacyTx.Data,
+	}
+
+	v := legacyTx.V.ToBig()
+	if legacyTx.Protected() {
+		// v = chainId*2+35 + v-27, as in rlpFieldsToLegacyTx
+		chainID := new(big.Int).Div(new(big.Int).Sub(v, big.NewInt(etherPre155V)), big.NewInt(double))
+		v = new(big.Int).Sub(v, new(big.Int).Add(new(big.Int).Mul(chainID, big.NewInt(double)), big.NewInt(etherPre155V-ether155V)))
+		fields = append(fields, chainID, uint(0), uint(0))
+	}
+
+	encoded, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, err
+	}
+	r, s := legacyTx.R.Bytes32(), legacyTx.S.Bytes32()
+	encoded = append(encoded, r[:]...)
+	encoded = append(encoded, s[:]...)
+	encoded = append(encoded, byte(v.Uint64()))
+	if forkId >= forkID5 {
+		encoded = append(encoded, efficiencyPercentage)
+	}
+	return encoded, nil
+}
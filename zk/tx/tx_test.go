@@ -6,7 +6,9 @@ import (
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
 	"github.com/tenderly/zkevm-erigon/core/types"
+	"math/big"
 	"testing"
 )
 
@@ -65,7 +67,7 @@ func TestDecodePre155Tx(t *testing.T) {
 	pre155 := "0xf86780843b9aca00826163941275fbb540c8efc58b812ba83b0d0b8b9917ae98808464fbb77c1ba0b7d2a666860f3c6b8f5ef96f86c7ec5562e97fd04c2e10f3755ff3a0456f9feba0246df95217bf9082f84f9e40adb0049c6664a5bb4c9cbe34ab1a73e77bab26ed"
 	pre155Bytes, err := hex.DecodeString(pre155[2:])
 	require.NoError(t, err)
-	tx, _, err := DecodeTx(pre155Bytes, forkID4)
+	tx, _, err := DecodeTx(pre155Bytes, 0, forkID4)
 	require.NoError(t, err)
 	v, r, s := tx.RawSignatureValues()
 	assert.Equal(t, "0x1275fbb540c8efC58b812ba83B0D0B8b9917AE98", tx.GetTo().String())
@@ -82,8 +84,7 @@ func TestDecodePost155Tx(t *testing.T) {
 	post155 := "0xf86780843b9aca00826163941275fbb540c8efc58b812ba83b0d0b8b9917ae98808464fbb77c1ba0b7d2a666860f3c6b8f5ef96f86c7ec5562e97fd04c2e10f3755ff3a0456f9feba0246df95217bf9082f84f9e40adb0049c6664a5bb4c9cbe34ab1a73e77bab26ed"
 	post155Bytes, err := hex.DecodeString(post155[2:])
 	require.NoError(t, err)
-	post155Bytes = append(post155Bytes, 75)
-	tx, pct, err := DecodeTx(post155Bytes, forkID5)
+	tx, pct, err := DecodeTx(post155Bytes, 75, forkID5)
 	require.NoError(t, err)
 	v, r, s := tx.RawSignatureValues()
 	assert.Equal(t, "0x1275fbb540c8efC58b812ba83B0D0B8b9917AE98", tx.GetTo().String())
@@ -94,7 +95,7 @@ func TestDecodePost155Tx(t *testing.T) {
 	assert.Equal(t, "64fbb77c", hex.EncodeToString(tx.GetData()))
 	assert.Equal(t, uint64(0), tx.GetNonce())
 	assert.Equal(t, uint256.NewInt(1000000000), tx.GetPrice())
-	assert.Equal(t, pct, 75)
+	assert.Equal(t, uint8(75), pct)
 }
 
 func TestDecodePre155BatchL2DataForkID5(t *testing.T) {
@@ -127,3 +128,46 @@ func TestDecodePre155BatchL2DataForkID5(t *testing.T) {
 	assert.Equal(t, uint64(100000), txs[0].GetGas())
 	assert.Equal(t, uint256.NewInt(1000000000), txs[0].GetPrice())
 }
+
+func TestEncodeTx(t *testing.T) {
+	// a pre eip155 transaction, as in the l2 data of a fork id 5 batch
+	batchL2Data, err := hex.DecodeString("e480843b9aca00826163941275fbb540c8efc58b812ba83b0d0b8b9917ae98808464fbb77cb7d2a666860f3c6b8f5ef96f86c7ec5562e97fd04c2e10f3755ff3a0456f9feb246df95217bf9082f84f9e40adb0049c6664a5bb4c9cbe34ab1a73e77bab26ed1bff")
+	require.NoError(t, err)
+	txs, _, percentages, err := DecodeTxs(batchL2Data, forkID5)
+	require.NoError(t, err)
+	encoded, err := EncodeTx(txs[0], percentages[0], forkID5)
+	require.NoError(t, err)
+	require.Equal(t, batchL2Data, encoded)
+
+	// without the percentage before fork id 5
+	encoded, err = EncodeTx(txs[0], percentages[0], forkID4)
+	require.NoError(t, err)
+	require.Equal(t, batchL2Data[:len(batchL2Data)-1], encoded)
+
+	// an eip155 transaction keeps its chain id
+	to := common.HexToAddress("0x1275fbb540c8efc58b812ba83b0d0b8b9917ae98")
+	chainID := big.NewInt(1101)
+	eip155 := &types.LegacyTx{
+		CommonTx: types.CommonTx{
+			Nonce: 3,
+			Gas:   21000,
+			To:    &to,
+			Value: uint256.NewInt(5),
+			Data:  []byte{},
+			V:     *uint256.MustFromBig(new(big.Int).Add(new(big.Int).Mul(chainID, big.NewInt(2)), big.NewInt(36))),
+			R:     *uint256.NewInt(1),
+			S:     *uint256.NewInt(2),
+		},
+		GasPrice: uint256.NewInt(1000000000),
+	}
+	encoded, err = EncodeTx(eip155, 128, forkID5)
+	require.NoError(t, err)
+	txs, _, percentages, err = DecodeTxs(encoded, forkID5)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	require.Equal(t, eip155, txs[0])
+	require.Equal(t, []uint8{128}, percentages)
+
+	_, err = EncodeTx(&types.DynamicFeeTransaction{}, 255, forkID5)
+	require.ErrorIs(t, err, types.ErrTxTypeNotSupported)
+}
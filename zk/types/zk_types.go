@@ -20,6 +20,78 @@ type L1BatchInfo struct {
 	StateRoot common.Hash
 }
 
+// L1BlockHash is the hash of an L1 block the syncer processed, used to find where L1 was reorganised
+type L1BlockHash struct {
+	BlockNo uint64
+	Hash    common.Hash
+}
+
+// L1Reorg is a reorganisation of L1 from the block after Ancestor. The syncer waits for Done to be closed once what
+// was read from the blocks after it was removed.
+type L1Reorg struct {
+	Ancestor uint64
+	Done     chan struct{}
+}
+
+// ForcedBatch is a batch of transactions forced into the rollup on L1, Timestamp is the one of its L1 block
+type ForcedBatch struct {
+	ForcedBatchNumber uint64
+	L1BlockNo         uint64
+	L1TxHash          common.Hash
+	GlobalExitRoot    common.Hash
+	Sequencer         common.Address
+	Timestamp         uint64
+	Transactions      []byte
+}
+
+// ForkIdUpdate is a fork id activated on L1, the batches after BatchNo are executed with it
+type ForkIdUpdate struct {
+	BatchNo   uint64
+	ForkId    uint64
+	Version   string
+	L1BlockNo uint64
+}
+
+// L1GlobalExitRoot is a global exit root updated on L1 from its mainnet and rollup exit roots, Timestamp is the one
+// of its L1 block
+type L1GlobalExitRoot struct {
+	GlobalExitRoot  common.Hash
+	MainnetExitRoot common.Hash
+	RollupExitRoot  common.Hash
+	L1BlockNo       uint64
+	Timestamp       uint64
+}
+
+// L1BatchData is a batch as it was sequenced on L1, what the L2 blocks of the batch are rebuilt from without a
+// datastream
+type L1BatchData struct {
+	BatchNo        uint64
+	L1BlockNo      uint64
+	L1TxHash       common.Hash
+	Coinbase       common.Address
+	GlobalExitRoot common.Hash
+	Timestamp      uint64
+	Transactions   []byte
+}
+
+// L1BatchMetadata is what a batch was sequenced on L1 with, the sequencer being the coinbase of the sequence. A batch
+// sequenced from the etrog fork on has the l1 info root of its sequence as its global exit root and the timestamp limit
+// of the sequence, unless it was forced. AccInputHash is the accumulated input hash of the batch on the rollup contract,
+// empty when it couldn't be found.
+type L1BatchMetadata struct {
+	BatchNo          uint64
+	L1BlockNo        uint64
+	L1TxHash         common.Hash
+	Sequencer        common.Address
+	GlobalExitRoot   common.Hash
+	Timestamp        uint64
+	TransactionsHash common.Hash
+	AccInputHash     common.Hash
+	// the hash of the L1 block before a batch was forced, from the etrog fork on
+	ForcedBlockHashL1 common.Hash
+	Etrog             bool
+}
+
 // Batch struct
 type Batch struct {
 	BatchNumber    uint64
@@ -33,3 +105,18 @@ type Batch struct {
 	GlobalExitRoot common.Hash
 	ForcedBatchNum *uint64
 }
+
+// BatchHeader is what the batch index keeps of a batch besides the span of its blocks
+type BatchHeader struct {
+	BatchNumber    uint64
+	Coinbase       common.Address
+	StateRoot      common.Hash
+	LocalExitRoot  common.Hash
+	AccInputHash   common.Hash
+	GlobalExitRoot common.Hash
+	Timestamp      uint64
+	ForcedBatchNum *uint64
+	// Closed is set once the end of the batch is known, until then the state root is the one of its last block
+	Closed      bool
+	BatchL2Data []byte
+}
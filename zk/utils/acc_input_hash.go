@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"encoding/binary"
+
+	"github.com/tenderly/zkevm-erigon-lib/common"
+	"github.com/tenderly/zkevm-erigon/crypto"
+)
+
+// CalculateAccInputHash chains a batch onto the accumulated input hash of the batches before it, as the rollup
+// contract does when the batch is sequenced before the etrog fork
+func CalculateAccInputHash(oldAccInputHash, transactionsHash, globalExitRoot common.Hash, timestamp uint64, sequencer common.Address) common.Hash {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, timestamp)
+	return crypto.Keccak256Hash(oldAccInputHash[:], transactionsHash[:], globalExitRoot[:], ts, sequencer[:])
+}
+
+// CalculateEtrogAccInputHash chains a batch sequenced from the etrog fork on. The batch is chained with the l1 info
+// root of its sequence and the timestamp limit of the sequence, a forced batch with its own global exit root, timestamp
+// and the hash of the L1 block before it was forced.
+func CalculateEtrogAccInputHash(oldAccInputHash, transactionsHash, l1InfoRoot common.Hash, timestamp uint64, sequencer common.Address, forcedBlockHashL1 common.Hash) common.Hash {
+	ts := make([]byte, 8)
+	binary.BigEndian.PutUint64(ts, timestamp)
+	return crypto.Keccak256Hash(oldAccInputHash[:], transactionsHash[:], l1InfoRoot[:], ts, sequencer[:], forcedBlockHashL1[:])
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tenderly/zkevm-erigon-lib/common"
+
+	"github.com/tenderly/zkevm-erigon/accounts/abi"
+	"github.com/tenderly/zkevm-erigon/crypto"
+)
+
+var (
+	testOldAccInputHash  = common.HexToHash("0x01")
+	testTransactionsHash = crypto.Keccak256Hash([]byte{0xee, 0x80})
+	testGlobalExitRoot   = common.HexToHash("0x02")
+	testSequencer        = common.HexToAddress("0x03")
+	testForcedBlockHash  = common.HexToHash("0x04")
+)
+
+// packed encodes the values as solidity's abi.encodePacked does
+func packed(t *testing.T, types []string, values ...interface{}) []byte {
+	args := make(abi.Arguments, 0, len(types))
+	for _, typ := range types {
+		abiType, err := abi.NewType(typ, "", nil)
+		require.NoError(t, err)
+		args = append(args, abi.Argument{Type: abiType})
+	}
+	encoded, err := args.Pack(values...)
+	require.NoError(t, err)
+
+	// abi encoding pads every value to 32 bytes, on the left for the numbers and addresses
+	out := make([]byte, 0)
+	for i, typ := range types {
+		word := encoded[i*32 : (i+1)*32]
+		switch typ {
+		case "uint64":
+			out = append(out, word[24:]...)
+		case "address":
+			out = append(out, word[12:]...)
+		default:
+			out = append(out, word...)
+		}
+	}
+	return out
+}
+
+func TestCalculateAccInputHash(t *testing.T) {
+	expected := crypto.Keccak256Hash(packed(t, []string{"bytes32", "bytes32", "bytes32", "uint64", "address"},
+		testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, uint64(1700000000), testSequencer))
+	require.Equal(t, expected, CalculateAccInputHash(testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, 1700000000, testSequencer))
+
+	// every input is chained
+	require.NotEqual(t, expected, CalculateAccInputHash(common.Hash{}, testTransactionsHash, testGlobalExitRoot, 1700000000, testSequencer))
+	require.NotEqual(t, expected, CalculateAccInputHash(testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, 1700000001, testSequencer))
+}
+
+func TestCalculateEtrogAccInputHash(t *testing.T) {
+	expected := crypto.Keccak256Hash(packed(t, []string{"bytes32", "bytes32", "bytes32", "uint64", "address", "bytes32"},
+		testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, uint64(1700000000), testSequencer, testForcedBlockHash))
+	require.Equal(t, expected, CalculateEtrogAccInputHash(testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, 1700000000, testSequencer, testForcedBlockHash))
+
+	// a batch that wasn't forced is chained with an empty block hash, unlike before etrog
+	require.NotEqual(t,
+		CalculateAccInputHash(testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, 1700000000, testSequencer),
+		CalculateEtrogAccInputHash(testOldAccInputHash, testTransactionsHash, testGlobalExitRoot, 1700000000, testSequencer, common.Hash{}))
+}